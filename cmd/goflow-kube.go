@@ -1,15 +1,21 @@
 package main
 
 import (
+	"compress/gzip"
 	"context"
+	"crypto/tls"
 	"flag"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 
 	"github.com/sirupsen/logrus"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
@@ -17,16 +23,24 @@ import (
 	"github.com/netobserv/goflow2-kube-enricher/pkg/config"
 	"github.com/netobserv/goflow2-kube-enricher/pkg/export"
 	"github.com/netobserv/goflow2-kube-enricher/pkg/format"
+	"github.com/netobserv/goflow2-kube-enricher/pkg/format/deadletter"
 	jsonFormat "github.com/netobserv/goflow2-kube-enricher/pkg/format/json"
+	kafkaFormat "github.com/netobserv/goflow2-kube-enricher/pkg/format/kafka"
 	nfFormat "github.com/netobserv/goflow2-kube-enricher/pkg/format/netflow"
 	pbFormat "github.com/netobserv/goflow2-kube-enricher/pkg/format/pb"
 	"github.com/netobserv/goflow2-kube-enricher/pkg/health"
+	"github.com/netobserv/goflow2-kube-enricher/pkg/meta"
 	"github.com/netobserv/goflow2-kube-enricher/pkg/reader"
 )
 
 const netflowScheme = "netflow"
 const legacyScheme = "nfl"
 const app = "goflow-kube"
+const snapshotEndpoint = "/debug/snapshot"
+const tailEndpoint = "/flows/tail"
+
+// replayCommand feeds the records of the given dead-letter files back through the pipeline
+const replayCommand = "replay"
 
 var (
 	version        = "unknown"
@@ -40,6 +54,11 @@ var (
 )
 
 func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [%s [-exporter <name>] <dead-letter files>...]\n",
+			os.Args[0], replayCommand)
+		flag.PrintDefaults()
+	}
 	flag.Parse()
 
 	if *versionFlag {
@@ -58,23 +77,69 @@ func main() {
 
 	cfg := loadMainConfig()
 
+	var replayFiles []string
+	var replayExporter string
+	if flag.Arg(0) == replayCommand {
+		replayFiles, replayExporter = parseReplayArgs(flag.Args()[1:])
+		// the records that only an exporter failed to deliver are replayed to that exporter only
+		if replayExporter != "" {
+			if err := cfg.SelectExporter(replayExporter); err != nil {
+				log.WithError(err).Fatal("Can't replay dead-letter files")
+			}
+		}
+	}
+
+	if err := cfg.ValidateEndpoints(); err != nil {
+		log.WithError(err).Fatal("Invalid debug endpoints configuration")
+	}
+
 	log.Info("Creating health HTTP endpoint...")
 	healthReporter := health.NewReporter(health.Starting)
 	httpHealth := health.NewHTTPReporter(healthReporter)
+	serverTLS, err := health.ServerTLS(cfg.ServerTLS, false)
+	if err != nil {
+		log.WithError(err).Fatal("Can't load server TLS configuration")
+	}
 	go func() {
-		// TODO: allow configuring TLS
-		err := http.ListenAndServe(fmt.Sprintf(":%d", *healthPort), httpHealth.Handler())
+		server := http.Server{
+			Addr:      fmt.Sprintf(":%d", *healthPort),
+			Handler:   httpHealth.Handler(),
+			TLSConfig: serverTLS,
+		}
+		var err error
+		if serverTLS != nil {
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
 		log.WithError(err).Info("interrupted HTTP health service")
 	}()
 
-	log.Info("Creating loki exporter...")
-	loki, err := export.NewLoki(&cfg.Loki)
+	log.Info("Creating exporters...")
+	exporter, err := export.NewExporter(cfg, healthReporter)
+	if err != nil {
+		log.WithError(err).Fatal("Can't create exporters")
+	}
+	if cfg.Tail.Enabled {
+		tail, err := export.NewTail(&cfg.Tail, exporter, healthReporter)
+		if err != nil {
+			log.WithError(err).Fatal("Can't create flows live tail")
+		}
+		httpHealth.Handle(tailEndpoint, tail)
+		exporter = tail
+	}
+
+	deadLetter, err := export.NewDeadLetter(cfg, healthReporter)
 	if err != nil {
-		log.WithError(err).Fatal("Can't create Loki exporter")
+		log.WithError(err).Fatal("Can't create dead-letter sink")
 	}
 
 	var in format.Format
-	if cfg.Listen == "" {
+	if flag.Arg(0) == replayCommand {
+		in = openDeadLetterFiles(cfg, replayFiles, replayExporter)
+	} else if flag.NArg() > 0 {
+		log.Fatalf("Unknown command %q", flag.Arg(0))
+	} else if cfg.Listen == "" {
 		switch cfg.StdinFormat {
 		case config.JSONFlagName:
 			in = jsonFormat.NewScanner(os.Stdin)
@@ -97,20 +162,127 @@ func main() {
 			log.Infof("Start listening on %s", cfg.Listen)
 			ctx := context.Background()
 			in = nfFormat.StartDriver(ctx, hostname, int(port), listenAddrURL.Scheme == legacyScheme)
+		} else if listenAddrURL.Scheme == kafkaFormat.Scheme {
+			brokers, topic, err := kafkaFormat.ParseAddress(listenAddrURL)
+			if err != nil {
+				log.Fatal("Failed reading Kafka address: ", err)
+			}
+			log.WithFields(logrus.Fields{"brokers": brokers, "topic": topic}).Info("Start consuming from Kafka")
+			in, err = kafkaFormat.NewConsumer(brokers, topic, &cfg.KafkaInput, healthReporter)
+			if err != nil {
+				log.Fatal("Can't create Kafka consumer: ", err)
+			}
 		} else {
 			log.Fatal("Unknown listening protocol")
 		}
 	}
 
-	clientset, err := kubernetes.NewForConfig(loadKubeConfig())
-	if err != nil {
-		log.Fatal(err)
+	var clientset kubernetes.Interface
+	var dynClient dynamic.Interface
+	// when enriching from a static inventory, no connection to the cluster is needed
+	if !cfg.Inventory.Enabled() {
+		kubeConfig := loadKubeConfig()
+		clientset, err = kubernetes.NewForConfig(kubeConfig)
+		if err != nil {
+			log.Fatal(err)
+		}
+		dynClient, err = dynamic.NewForConfig(kubeConfig)
+		if err != nil {
+			log.Fatal(err)
+		}
 	}
 
-	r := reader.NewReader(in, log, cfg, healthReporter, clientset)
+	r := reader.NewReader(in, log, cfg, healthReporter, clientset, dynClient)
+	if deadLetter != nil {
+		r.SetDeadLetter(deadLetter)
+	}
+	if cfg.SnapshotEndpoint {
+		if snapshotter, ok := r.Snapshotter(); ok {
+			serveSnapshot(cfg, &httpHealth, serverTLS, meta.SnapshotHandler(snapshotter))
+		}
+	}
 	log.Info("Starting reader...")
 	//TODO : implements context cancellation scenario
-	r.Start(context.TODO(), &loki)
+	r.Start(context.TODO(), exporter)
+	if err := exporter.Close(); err != nil {
+		log.WithError(err).Warn("Can't close exporters")
+	}
+	if deadLetter != nil {
+		if err := deadLetter.Close(); err != nil {
+			log.WithError(err).Warn("Can't close dead-letter sink")
+		}
+	}
+}
+
+// serveSnapshot adds the snapshot endpoint to the health service or, if a snapshot address is
+// provided, to a dedicated server listening on that address
+func serveSnapshot(cfg *config.Config, httpHealth *health.HTTPReporter, serverTLS *tls.Config, handler http.Handler) {
+	if cfg.SnapshotAddress == "" {
+		httpHealth.Handle(snapshotEndpoint, handler)
+		return
+	}
+	mux := http.NewServeMux()
+	mux.Handle(snapshotEndpoint, handler)
+	go func() {
+		server := http.Server{
+			Addr:      cfg.SnapshotAddress,
+			Handler:   mux,
+			TLSConfig: serverTLS,
+		}
+		var err error
+		if serverTLS != nil {
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
+		log.WithError(err).Info("interrupted HTTP snapshot service")
+	}()
+}
+
+// parseReplayArgs returns the dead-letter files and the exporter, if any, of the replay command
+func parseReplayArgs(args []string) ([]string, string) {
+	flags := flag.NewFlagSet(replayCommand, flag.ExitOnError)
+	exporter := flags.String("exporter", "", "replay only the records that the exporter with this name failed"+
+		" to deliver, while the other exporters delivered them, and send them to that exporter only")
+	_ = flags.Parse(args)
+	return flags.Args(), *exporter
+}
+
+// openDeadLetterFiles returns a format that reads the records of the given dead-letter files, in
+// order. The files compressed by the rotation of the file exporter (.gz) are also supported. If
+// an exporter is given, only the records that it failed to deliver are read
+func openDeadLetterFiles(cfg *config.Config, paths []string, exporter string) format.Format {
+	if len(paths) == 0 {
+		log.Fatal("The replay command needs at least a dead-letter file")
+	}
+	var sinkPath string
+	if cfg.DeadLetter != nil && cfg.DeadLetter.Type == config.FileExporter {
+		sinkPath, _ = filepath.Abs(cfg.DeadLetter.File.Path)
+	}
+	readers := make([]io.Reader, 0, len(paths))
+	for _, path := range paths {
+		flog := log.WithField("file", path)
+		// the records that fail again are appended to the dead-letter sink, so it can't be
+		// replayed while it is being written
+		if abs, _ := filepath.Abs(path); abs == sinkPath {
+			flog.Fatal("Can't replay the active dead-letter file. Move it before replaying it")
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			flog.WithError(err).Fatal("Can't open dead-letter file")
+		}
+		var reader io.Reader = file
+		if strings.HasSuffix(path, ".gz") {
+			if reader, err = gzip.NewReader(file); err != nil {
+				flog.WithError(err).Fatal("Can't decompress dead-letter file")
+			}
+		}
+		readers = append(readers, reader)
+	}
+	log.WithField("files", paths).WithField("exporter", exporter).Info("Replaying dead-letter files")
+	in := deadletter.NewScanner(io.MultiReader(readers...))
+	in.SetExporter(exporter)
+	return in
 }
 
 // loadKubeConfig fetches a given kubernetes configuration in the following order
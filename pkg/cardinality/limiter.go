@@ -0,0 +1,51 @@
+// Package cardinality provides tools to keep bounded the number of distinct values that
+// are used as metric or stream labels
+package cardinality
+
+import "sync"
+
+// Overflow is the value that replaces any label value exceeding the configured limits
+const Overflow = "other"
+
+// Limiter accepts up to a maximum number of distinct values. Once the limit is reached,
+// any new value is replaced by Overflow, while the already accepted values are kept.
+type Limiter struct {
+	max  int
+	mt   sync.Mutex
+	seen map[string]struct{}
+}
+
+// NewLimiter returns a Limiter that accepts up to max distinct values. A max <= 0 means
+// that the number of distinct values is unlimited
+func NewLimiter(max int) *Limiter {
+	return &Limiter{
+		max:  max,
+		seen: map[string]struct{}{},
+	}
+}
+
+// Value returns the passed value if it has been previously accepted or if the limit has
+// not yet been reached. Otherwise it returns Overflow. The empty value, which stands for an
+// unknown value, is always returned as is and doesn't count towards the limit
+func (l *Limiter) Value(v string) string {
+	if l.max <= 0 || v == "" {
+		return v
+	}
+	l.mt.Lock()
+	defer l.mt.Unlock()
+	if _, ok := l.seen[v]; ok {
+		return v
+	}
+	if len(l.seen) >= l.max {
+		return Overflow
+	}
+	l.seen[v] = struct{}{}
+	return v
+}
+
+// Size returns the number of distinct values that have been accepted
+func (l *Limiter) Size() int {
+	l.mt.Lock()
+	defer l.mt.Unlock()
+	return len(l.seen)
+}
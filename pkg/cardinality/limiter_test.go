@@ -0,0 +1,33 @@
+package cardinality
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimiter(t *testing.T) {
+	l := NewLimiter(2)
+	assert.Equal(t, "foo", l.Value("foo"))
+	assert.Equal(t, "bar", l.Value("bar"))
+	assert.Equal(t, Overflow, l.Value("baz"))
+	// already accepted values are still accepted after the limit is reached
+	assert.Equal(t, "foo", l.Value("foo"))
+	assert.Equal(t, 2, l.Size())
+}
+
+func TestLimiter_EmptyValue(t *testing.T) {
+	l := NewLimiter(1)
+	// the empty value doesn't take the only slot
+	assert.Equal(t, "", l.Value(""))
+	assert.Equal(t, "foo", l.Value("foo"))
+	assert.Equal(t, "", l.Value(""))
+	assert.Equal(t, 1, l.Size())
+}
+
+func TestLimiter_Unlimited(t *testing.T) {
+	l := NewLimiter(0)
+	for _, v := range []string{"a", "b", "c", "d"} {
+		assert.Equal(t, v, l.Value(v))
+	}
+}
@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
+	"net/url"
 	"os"
 	"time"
 
@@ -19,14 +21,132 @@ var clog = logrus.WithField("module", "config")
 
 const JSONFlagName = "json"
 const PBFlagName = "pb"
+const CSVFlagName = "csv"
+
+// Types of exporters
+const (
+	LokiExporter       = "loki"
+	StdoutExporter     = "stdout"
+	KafkaExporter      = "kafka"
+	IPFIXExporter      = "ipfix"
+	PrometheusExporter = "prometheus"
+	OpenSearchExporter = "opensearch"
+	OTLPExporter       = "otlp"
+	FileExporter       = "file"
+	ParquetExporter    = "parquet"
+	GRPCExporter       = "grpc"
+)
+
+// Compression codecs of the Parquet exporter
+const (
+	ParquetUncompressed = "none"
+	ParquetSnappy       = "snappy"
+	ParquetGzip         = "gzip"
+	ParquetZstd         = "zstd"
+)
+
+// Transport protocols of the OTLP exporter
+const (
+	OTLPGRPC         = "grpc"
+	OTLPHTTPProtobuf = "http/protobuf"
+)
+
+// Actions for the records that are too old for Loki
+const (
+	OutOfOrderClamp = "clamp"
+	OutOfOrderDrop  = "drop"
+	OutOfOrderRoute = "route"
+)
+
+// Line formats of the Loki exporter
+const (
+	LokiLineJSON     = "json"
+	LokiLineLogfmt   = "logfmt"
+	LokiLineTemplate = "template"
+)
+
+// Modes of sending the records to multiple Loki endpoints
+const (
+	LokiMirror   = "mirror"
+	LokiFailover = "failover"
+)
+
+// Policies for the flows whose source and destination belong to different Loki tenants
+const (
+	CrossTenantDuplicate   = "duplicate"
+	CrossTenantSource      = "source"
+	CrossTenantDestination = "destination"
+)
+
+// Values accounted by the flow metrics
+const (
+	FlowMetricBytes   = "bytes"
+	FlowMetricPackets = "packets"
+	FlowMetricFlows   = "flows"
+)
 
 type Config struct {
 	Listen      string            `yaml:"listen"`
 	StdinFormat string            `yaml:"stdinFormat"`
 	Loki        LokiConfig        `yaml:"loki"`
 	IPFields    map[string]string `yaml:"ipFields"`
+	MACFields   map[string]string `yaml:"macFields"`
 	PrintInput  bool              `yaml:"printInput"`
+	// PrintOutput writes the enriched records as JSON lines to the standard output. It is
+	// equivalent to adding a stdout exporter
 	PrintOutput bool              `yaml:"printOutput"`
+	ZoneMetrics ZoneMetricsConfig `yaml:"zoneMetrics"`
+	// NamespaceLabels maps labels of the namespaces to the names of the record fields where
+	// their values are added, after the prefix of the endpoint (e.g. 'team: Team' adds the
+	// SrcTeam and DstTeam fields from the 'team' label of the source and destination namespaces)
+	NamespaceLabels map[string]string `yaml:"namespaceLabels"`
+	// Exporters lists the sinks where the enriched records are forwarded. If empty, the records
+	// are only forwarded to the Loki instance defined in the Loki property
+	Exporters []ExporterConfig `yaml:"exporters"`
+	// CustomResources to be watched for enrichment, in addition to Pods and Services
+	CustomResources []CustomResourceConfig `yaml:"customResources"`
+	Inventory       InventoryConfig        `yaml:"inventory"`
+	// KafkaInput configures the Kafka consumer, when the Listen address has the kafka:// scheme
+	KafkaInput KafkaInputConfig `yaml:"kafkaInput"`
+	// SnapshotEndpoint enables the /debug/snapshot endpoint, which provides the state of all the
+	// kubernetes objects that are used for the enrichment. Since it exposes the metadata of the
+	// cluster without authentication, it requires either ServerTLS or a loopback SnapshotAddress
+	SnapshotEndpoint bool `yaml:"snapshotEndpoint"`
+	// SnapshotAddress, if provided, is the address where a dedicated server listens for the
+	// /debug/snapshot requests (e.g. 127.0.0.1:8081), instead of the health service
+	SnapshotAddress string `yaml:"snapshotAddress"`
+	// Tail configures the /flows/tail endpoint in the health service, which streams the
+	// enriched records as Server-Sent Events. Since it exposes the records without
+	// authentication, it requires ServerTLS
+	Tail TailConfig `yaml:"tail"`
+	// ServerTLS enables TLS in the servers of goflow-kube: the health service and the gRPC
+	// exporters, if provided
+	ServerTLS *ServerTLSConfig `yaml:"serverTLS"`
+	// DeadLetter, if provided, is the sink of the records that couldn't be enriched or exported,
+	// along with the error and the stage where they failed, so they can be replayed later
+	DeadLetter *ExporterConfig `yaml:"deadLetter"`
+}
+
+// TailConfig defines the live tail of the enriched records
+type TailConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// MaxSubscribers is the maximum number of concurrent clients. 0 means unlimited
+	MaxSubscribers int `yaml:"maxSubscribers"`
+	// RateLimit is the maximum number of records per second that are sent to each client,
+	// which can request a lower rate with the "rate" query parameter. 0 means unlimited
+	RateLimit float64 `yaml:"rateLimit"`
+	// BufferSize is the maximum number of records waiting to be sent to each client. When
+	// the buffer is full, the new records are dropped for that client
+	BufferSize int `yaml:"bufferSize"`
+}
+
+// ServerTLSConfig defines the certificates of the servers
+type ServerTLSConfig struct {
+	CertFile string `yaml:"certFile"`
+	KeyFile  string `yaml:"keyFile"`
+	// ClientCAFile, if provided, enables the authentication of the clients of the gRPC
+	// exporters, which must present a certificate signed by any of the CAs in this file
+	ClientCAFile string `yaml:"clientCAFile"`
 }
 
 type LokiConfig struct {
@@ -48,6 +168,622 @@ type LokiConfig struct {
 	// scales of '1ms' (one millisecond) or just '1' (one nanosecond)
 	// Default value is '1s'
 	TimestampScale time.Duration `yaml:"timestampScale"`
+	// TimestampFields is an ordered list of fields where the timestamp is looked for. The
+	// first field with a valid value is used. If empty, TimestampLabel and TimestampScale are
+	// used. If no field has a valid value, the local time is used
+	TimestampFields []TimestampFieldConfig `yaml:"timestampFields"`
+	// MaxClockSkew is the maximum difference, in the future or the past, between the timestamp
+	// of a field and the local time. Timestamps beyond it are ignored. 0 means no limit
+	MaxClockSkew time.Duration `yaml:"maxClockSkew"`
+	// OutOfOrder defines what to do with the records that are too old to be accepted by Loki
+	OutOfOrder LokiOutOfOrderConfig `yaml:"outOfOrder"`
+	// WAL persists the records in disk before they are sent to Loki, so they aren't lost
+	// during Loki outages or restarts
+	WAL LokiWALConfig `yaml:"wal"`
+	// Tenant derives the tenant of each record from its fields. The records whose tenant
+	// can't be derived are sent to the TenantID tenant
+	Tenant LokiTenantConfig `yaml:"tenant"`
+	// Cardinality bounds the number of Loki streams that are created from the Labels
+	Cardinality LokiCardinalityConfig `yaml:"cardinality"`
+	// LineFormat of the log lines: json (default), logfmt or template
+	LineFormat string `yaml:"lineFormat"`
+	// LineTemplate is the Go text/template that writes the log lines when the LineFormat is
+	// template. It is executed with the record as data (e.g. '{{.SrcPod}} -> {{.DstPod}}')
+	LineTemplate string `yaml:"lineTemplate"`
+	// Fields, if not empty, are the only record fields that are kept in the log lines
+	Fields []string `yaml:"fields"`
+	// OmitEmpty removes from the log lines the fields with zero, false or empty values
+	OmitEmpty bool `yaml:"omitEmpty"`
+	// Endpoints, if provided, replace the URL by several Loki instances, which receive the
+	// records according to the EndpointsMode
+	Endpoints []LokiEndpointConfig `yaml:"endpoints"`
+	// EndpointsMode is mirror (all the endpoints receive every record) or failover (default: the
+	// records are sent to the first endpoint that is healthy, in order)
+	EndpointsMode string `yaml:"endpointsMode"`
+	// HealthCheckInterval is the period of the checks of the /ready endpoint of each Loki
+	// instance, when multiple endpoints are provided
+	HealthCheckInterval time.Duration `yaml:"healthCheckInterval"`
+	// MirrorQueueSize is the number of records that are queued for each endpoint in mirror mode
+	// when the WAL is disabled. The records of an endpoint whose queue is full are dropped, so
+	// an unavailable endpoint doesn't block the others
+	MirrorQueueSize int `yaml:"mirrorQueueSize"`
+}
+
+// LokiEndpointConfig defines a Loki instance where the records are sent
+type LokiEndpointConfig struct {
+	// Name identifies the endpoint in logs, metrics and health checks. Defaults to the host
+	// of the URL
+	Name string `yaml:"name"`
+	URL  string `yaml:"url"`
+	// ClientConfig, if provided, overrides the clientConfig of the Loki configuration (e.g.
+	// the credentials of each instance)
+	ClientConfig *promconf.HTTPClientConfig `yaml:"clientConfig"`
+}
+
+// LokiEndpoints returns the configured endpoints, or the URL as the only endpoint. The names
+// of the endpoints default to the host of their URL
+func (c *LokiConfig) LokiEndpoints() []LokiEndpointConfig {
+	endpoints := c.Endpoints
+	if len(endpoints) == 0 {
+		endpoints = []LokiEndpointConfig{{URL: c.URL}}
+	}
+	named := make([]LokiEndpointConfig, 0, len(endpoints))
+	for _, ep := range endpoints {
+		if ep.Name == "" {
+			if u, err := url.Parse(ep.URL); err == nil {
+				ep.Name = u.Host
+			}
+		}
+		named = append(named, ep)
+	}
+	return named
+}
+
+func (c *LokiConfig) validateEndpoints() error {
+	if len(c.Endpoints) == 0 {
+		return nil
+	}
+	switch c.EndpointsMode {
+	case "", LokiMirror, LokiFailover:
+	default:
+		return fmt.Errorf("invalid endpointsMode: %q. Accepted values: %s, %s", c.EndpointsMode, LokiMirror, LokiFailover)
+	}
+	if len(c.Endpoints) > 1 && c.HealthCheckInterval <= 0 {
+		return fmt.Errorf("invalid healthCheckInterval: %v. Required > 0", c.HealthCheckInterval)
+	}
+	if len(c.Endpoints) > 1 && c.EndpointsMode == LokiMirror && c.WAL.Dir == "" && c.MirrorQueueSize <= 0 {
+		return fmt.Errorf("invalid mirrorQueueSize: %v. Required > 0", c.MirrorQueueSize)
+	}
+	names := map[string]struct{}{}
+	for _, ep := range c.LokiEndpoints() {
+		if ep.URL == "" {
+			return errors.New("the url of the endpoints can't be empty")
+		}
+		if _, err := url.Parse(ep.URL); err != nil {
+			return fmt.Errorf("invalid endpoint url %q: %w", ep.URL, err)
+		}
+		if _, ok := names[ep.Name]; ok {
+			return fmt.Errorf("duplicate endpoint name %q. Provide a distinct name for each endpoint", ep.Name)
+		}
+		names[ep.Name] = struct{}{}
+	}
+	return nil
+}
+
+// LokiCardinalityConfig limits the distinct values of the labels of the active Loki streams.
+// The values exceeding the limits are replaced by "other". A stream is active until it doesn't
+// receive records for the StreamTTL. The limits are disabled by default
+type LokiCardinalityConfig struct {
+	// MaxLabelValues is the maximum number of distinct values of each label. 0 means no limit
+	MaxLabelValues int `yaml:"maxLabelValues"`
+	// LabelLimits overrides the MaxLabelValues of the given labels
+	LabelLimits map[string]int `yaml:"labelLimits"`
+	// MaxStreams is the maximum number of active streams. Once reached, all the labels of the
+	// records of new streams are replaced by "other". 0 means no limit
+	MaxStreams int `yaml:"maxStreams"`
+	// StreamTTL is the time after which a stream that hasn't received records is removed,
+	// freeing its label values. 0 means that the streams never expire
+	StreamTTL time.Duration `yaml:"streamTTL"`
+}
+
+// LokiTenantConfig defines how the Loki tenant is derived from the source and destination of
+// each record. It is disabled if both SrcField and DstField are empty
+type LokiTenantConfig struct {
+	// SrcField and DstField are the record fields that identify the tenant of the source and
+	// destination endpoints (e.g. SrcNamespace and DstNamespace, or namespace label fields)
+	SrcField string `yaml:"srcField"`
+	DstField string `yaml:"dstField"`
+	// Mapping translates the values of the fields into tenants. Values that aren't in the
+	// mapping are used as tenants as they are
+	Mapping map[string]string `yaml:"mapping"`
+	// CrossTenant decides where the records between different tenants are sent: "duplicate"
+	// (to both tenants), "source" or "destination". Default: duplicate
+	CrossTenant string `yaml:"crossTenant"`
+}
+
+// Enabled returns whether the tenant is derived per record
+func (c *LokiTenantConfig) Enabled() bool {
+	return c.SrcField != "" || c.DstField != ""
+}
+
+// TimestampFieldConfig defines a record field that contains a timestamp
+type TimestampFieldConfig struct {
+	Name string `yaml:"name"`
+	// Scale of the units of the field values (e.g. '1s' for UNIX time or '1ms'). Default: 1s
+	Scale time.Duration `yaml:"scale"`
+}
+
+// UnmarshalYAML sets the default scale of the field, before it is overridden by the YAML
+// properties
+func (c *TimestampFieldConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain TimestampFieldConfig
+	p := plain{Scale: time.Second}
+	if err := unmarshal(&p); err != nil {
+		return err
+	}
+	*c = TimestampFieldConfig(p)
+	return nil
+}
+
+// LokiOutOfOrderConfig defines the handling of the records whose timestamp is older than the
+// local time minus MaxAge, as Loki rejects the entries that are older than its out-of-order
+// window (by default, one hour older than the newest entry of the stream)
+type LokiOutOfOrderConfig struct {
+	// MaxAge of the record timestamps. 0 means no limit
+	MaxAge time.Duration `yaml:"maxAge"`
+	// Action for the records older than MaxAge: "clamp" (default) sets their timestamp to the
+	// oldest accepted time, "drop" discards them, and "route" sends them with the local time
+	// as timestamp and the RouteLabels as additional labels
+	Action      string         `yaml:"action"`
+	RouteLabels model.LabelSet `yaml:"routeLabels"`
+}
+
+// LokiWALConfig defines the write-ahead log of the Loki exporter. Each segment of the log
+// contains a batch of records, whose size and wait time are defined by the BatchSize and
+// BatchWait properties of the Loki exporter
+type LokiWALConfig struct {
+	// Dir where the segment files are stored. The WAL is disabled if empty
+	Dir string `yaml:"dir"`
+	// MaxSize is the maximum size, in bytes, of all the segments. When it is exceeded, the
+	// oldest segments are dropped
+	MaxSize int64 `yaml:"maxSize"`
+}
+
+// ExporterConfig defines a sink for the enriched records. Only the property matching the Type
+// is taken into account (e.g. Loki for the "loki" type)
+type ExporterConfig struct {
+	Type string `yaml:"type"`
+	// Name identifies the exporter in logs and metrics. Defaults to the type
+	Name  string      `yaml:"name"`
+	Loki  LokiConfig  `yaml:"loki"`
+	Kafka KafkaConfig `yaml:"kafka"`
+	IPFIX IPFIXConfig `yaml:"ipfix"`
+	// Prometheus defines the metrics to be derived from the records
+	Prometheus PrometheusConfig `yaml:"prometheus"`
+	OpenSearch OpenSearchConfig `yaml:"opensearch"`
+	OTLP       OTLPConfig       `yaml:"otlp"`
+	File       FileConfig       `yaml:"file"`
+	Parquet    ParquetConfig    `yaml:"parquet"`
+	GRPC       GRPCConfig       `yaml:"grpc"`
+}
+
+// GRPCConfig defines an exporter that serves the records to the subscribers of the
+// goflowkube.v1.FlowService gRPC service. The server uses the root ServerTLS settings
+type GRPCConfig struct {
+	// Listen is the address of the gRPC server. Since it streams the enriched flows, it must be
+	// a loopback address unless ServerTLS is provided or Insecure is set
+	Listen string `yaml:"listen"`
+	// Insecure allows listening on any address without TLS
+	Insecure bool `yaml:"insecure"`
+	// BufferSize is the maximum number of records waiting to be sent to each subscriber.
+	// When the buffer is full, the new records are dropped for that subscriber
+	BufferSize int `yaml:"bufferSize"`
+	// MaxSubscribers is the maximum number of concurrent subscriptions. 0 means unlimited
+	MaxSubscribers int `yaml:"maxSubscribers"`
+	// SrcPrefix and DstPrefix are the output prefixes (as defined in the IPFields values)
+	// of the source and destination endpoints of the EnrichedFlow messages
+	SrcPrefix string `yaml:"srcPrefix"`
+	DstPrefix string `yaml:"dstPrefix"`
+}
+
+// ParquetConfig defines an exporter that archives the records into Parquet files, partitioned
+// by hour as <path>/date=YYYY-MM-DD/hour=HH[/namespace=NS]/flows-<timestamp>.parquet
+type ParquetConfig struct {
+	// Path is the root directory of the archive
+	Path string `yaml:"path"`
+	// PartitionByNamespace adds a namespace partition from the NamespaceField of the record
+	PartitionByNamespace bool   `yaml:"partitionByNamespace"`
+	NamespaceField       string `yaml:"namespaceField"`
+	// RowGroupSize is the approximate size, in bytes, of each row group
+	RowGroupSize int64 `yaml:"rowGroupSize"`
+	// Compression codec of the column chunks: none, snappy (default), gzip or zstd
+	Compression string `yaml:"compression"`
+	// MaxLateness is the time that the file of a partition is kept open after the end of its
+	// hour, or after its last record, waiting for delayed records. Records arriving later are
+	// written into a new file
+	MaxLateness time.Duration `yaml:"maxLateness"`
+	// MaxOpenFiles is the maximum number of files being written at the same time. When a new
+	// partition exceeds it, the file that has been idle for the longest time is closed
+	MaxOpenFiles int `yaml:"maxOpenFiles"`
+	// TimestampLabel and TimestampScale have the same meaning as in the LokiConfig
+	TimestampLabel model.LabelName `yaml:"timestampLabel"`
+	TimestampScale time.Duration   `yaml:"timestampScale"`
+}
+
+// FileConfig defines an exporter that writes the records into a local file, which is rotated
+// by size and time
+type FileConfig struct {
+	Path string `yaml:"path"`
+	// Format is json (default), csv or pb (length-delimited protobuf messages)
+	Format string `yaml:"format"`
+	// Columns are the record fields that are written, in order, in the csv format
+	Columns []string `yaml:"columns"`
+	// MaxSize is the size, in bytes, after which the file is rotated
+	MaxSize int64 `yaml:"maxSize"`
+	// RotateInterval is the maximum age of the file before it is rotated. 0 means that the
+	// file is only rotated by size
+	RotateInterval time.Duration `yaml:"rotateInterval"`
+	// Compress the rotated files with gzip
+	Compress bool `yaml:"compress"`
+	// MaxBackups is the number of rotated files that are kept. 0 means that all the rotated
+	// files are kept
+	MaxBackups int `yaml:"maxBackups"`
+	// SrcPrefix and DstPrefix are the output prefixes (as defined in the IPFields values)
+	// of the source and destination endpoints of the EnrichedFlow messages
+	SrcPrefix string `yaml:"srcPrefix"`
+	DstPrefix string `yaml:"dstPrefix"`
+}
+
+// OTLPConfig defines an exporter that sends the records as OpenTelemetry log records
+type OTLPConfig struct {
+	// Endpoint is the host:port of the collector for the grpc protocol, or its base URL
+	// (e.g. http://otel-collector:4318) for the http/protobuf protocol
+	Endpoint string `yaml:"endpoint"`
+	// Protocol is grpc (default) or http/protobuf
+	Protocol string `yaml:"protocol"`
+	// Headers are sent with each export request (e.g. for authentication)
+	Headers map[string]string `yaml:"headers"`
+	// TLS enables encrypted connections to the collector, if provided
+	TLS *promconf.TLSConfig `yaml:"tls"`
+	// BatchWait is the maximum time to wait before sending a batch
+	BatchWait time.Duration `yaml:"batchWait"`
+	// BatchSize is the maximum number of records of each batch
+	BatchSize  int           `yaml:"batchSize"`
+	Timeout    time.Duration `yaml:"timeout"`
+	MinBackoff time.Duration `yaml:"minBackoff"`
+	MaxBackoff time.Duration `yaml:"maxBackoff"`
+	MaxRetries int           `yaml:"maxRetries"`
+	// TimestampLabel and TimestampScale have the same meaning as in the LokiConfig
+	TimestampLabel model.LabelName `yaml:"timestampLabel"`
+	TimestampScale time.Duration   `yaml:"timestampScale"`
+	// ResourcePrefix is the output prefix (as defined in the IPFields values) of the endpoint
+	// whose kubernetes fields are sent as resource attributes (e.g. k8s.pod.name)
+	ResourcePrefix string `yaml:"resourcePrefix"`
+	// ResourceAttributes are static attributes added to the resource of all the records
+	ResourceAttributes map[string]string `yaml:"resourceAttributes"`
+}
+
+// OpenSearchConfig defines an exporter that indexes the records in OpenSearch or Elasticsearch
+// through the _bulk API
+type OpenSearchConfig struct {
+	URL string `yaml:"url"`
+	// Index is the prefix of the daily indices, which are named as <index>-YYYY.MM.DD from the
+	// timestamp of the flow
+	Index string `yaml:"index"`
+	// TemplateFile is the path of a JSON file with an index template (e.g. to define the
+	// mappings of the fields), which is installed as <index> before sending any record
+	TemplateFile string `yaml:"templateFile"`
+	// BatchWait is the maximum time to wait before sending a batch
+	BatchWait time.Duration `yaml:"batchWait"`
+	// BatchSize is the maximum size, in bytes, of the batches
+	BatchSize    int                       `yaml:"batchSize"`
+	Timeout      time.Duration             `yaml:"timeout"`
+	MinBackoff   time.Duration             `yaml:"minBackoff"`
+	MaxBackoff   time.Duration             `yaml:"maxBackoff"`
+	MaxRetries   int                       `yaml:"maxRetries"`
+	ClientConfig promconf.HTTPClientConfig `yaml:"clientConfig"`
+	// TimestampLabel and TimestampScale have the same meaning as in the LokiConfig
+	TimestampLabel model.LabelName `yaml:"timestampLabel"`
+	TimestampScale time.Duration   `yaml:"timestampScale"`
+}
+
+// PrometheusConfig defines the Prometheus counters that aggregate the enriched records. The
+// metrics are exposed in the /metrics endpoint of the health service
+type PrometheusConfig struct {
+	Metrics []FlowMetricConfig `yaml:"metrics"`
+}
+
+// FlowMetricConfig defines a counter whose labels are taken from the record fields
+type FlowMetricConfig struct {
+	Name string `yaml:"name"`
+	Help string `yaml:"help"`
+	// Value is the accounted value: bytes (default), packets or flows
+	Value string `yaml:"value"`
+	// Labels are the record fields (e.g. SrcNamespace or Proto) that are used as metric labels
+	Labels []string `yaml:"labels"`
+	// MaxLabelValues is the maximum number of distinct values of each label. Extra values are
+	// reported as "other". 0 means no limit
+	MaxLabelValues int `yaml:"maxLabelValues"`
+	// TTL is the time after which a series that hasn't been updated is removed, freeing its
+	// label values. 0 means that the series never expire
+	TTL time.Duration `yaml:"ttl"`
+}
+
+// UnmarshalYAML sets the default values of the metric, before they are overridden by the
+// YAML properties
+func (c *FlowMetricConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain FlowMetricConfig
+	p := plain{
+		Value:          FlowMetricBytes,
+		MaxLabelValues: 100,
+		TTL:            5 * time.Minute,
+	}
+	if err := unmarshal(&p); err != nil {
+		return err
+	}
+	*c = FlowMetricConfig(p)
+	return nil
+}
+
+// IPFIXConfig defines an IPFIX exporting process that re-emits the flows to a collector. The
+// kubernetes fields are sent as enterprise-specific information elements
+type IPFIXConfig struct {
+	// Address of the collector, as host:port
+	Address string `yaml:"address"`
+	// Protocol of the connection to the collector: udp (default) or tcp
+	Protocol string `yaml:"protocol"`
+	// EnterpriseID is the Private Enterprise Number (PEN) of the kubernetes information elements
+	EnterpriseID        uint32 `yaml:"enterpriseID"`
+	ObservationDomainID uint32 `yaml:"observationDomainID"`
+	// TemplateRefresh is the interval between template retransmissions, when using UDP
+	TemplateRefresh time.Duration `yaml:"templateRefresh"`
+	// SrcPrefix and DstPrefix are the output prefixes (as defined in the IPFields values)
+	// of the source and destination endpoints of the flow
+	SrcPrefix string `yaml:"srcPrefix"`
+	DstPrefix string `yaml:"dstPrefix"`
+}
+
+// KafkaConfig defines a Kafka producer that writes the enriched records into a topic
+type KafkaConfig struct {
+	Brokers []string `yaml:"brokers"`
+	Topic   string   `yaml:"topic"`
+	// KeyFields are the record fields whose values compose the message key (e.g. SrcNamespace),
+	// so the records with the same values are sent to the same partition. If empty, the records
+	// are evenly distributed across all the partitions
+	KeyFields []string `yaml:"keyFields"`
+	// Encoding of the messages: json (default) or pb
+	Encoding     string        `yaml:"encoding"`
+	BatchSize    int           `yaml:"batchSize"`
+	BatchBytes   int64         `yaml:"batchBytes"`
+	BatchTimeout time.Duration `yaml:"batchTimeout"`
+	// Compression codec of the messages: none (default), gzip, snappy, lz4 or zstd
+	Compression string `yaml:"compression"`
+	MaxAttempts int    `yaml:"maxAttempts"`
+	// Timeout of the requests to the brokers
+	Timeout time.Duration `yaml:"timeout"`
+	// TLS enables encrypted connections to the brokers, if provided
+	TLS  *promconf.TLSConfig `yaml:"tls"`
+	SASL *KafkaSASLConfig    `yaml:"sasl"`
+	// SrcPrefix and DstPrefix are the output prefixes (as defined in the IPFields values)
+	// of the source and destination endpoints of the EnrichedFlow messages
+	SrcPrefix string `yaml:"srcPrefix"`
+	DstPrefix string `yaml:"dstPrefix"`
+}
+
+// KafkaInputConfig defines a Kafka consumer that reads the goflow2 FlowMessage protobufs that
+// are published by the goflow2 Kafka transport. The brokers and the topic are provided in the
+// listen address (e.g. kafka://broker1:9092,broker2:9092/flows)
+type KafkaInputConfig struct {
+	// GroupID of the consumer. The enricher instances with the same group share the partitions
+	// of the topic
+	GroupID string `yaml:"groupID"`
+	// CommitInterval is the frequency of the offset commits. Before committing, the exporters
+	// send their buffered records. If 0, the exporters are flushed and the offset is committed
+	// after each record
+	CommitInterval time.Duration `yaml:"commitInterval"`
+	MinBytes       int           `yaml:"minBytes"`
+	MaxBytes       int           `yaml:"maxBytes"`
+	MaxWait        time.Duration `yaml:"maxWait"`
+	// LengthPrefixed must be true if each message is prefixed by its varint-encoded length (e.g.
+	// if goflow2 is run with the -format.protobuf.fixedlen flag)
+	LengthPrefixed bool `yaml:"lengthPrefixed"`
+	// TLS enables encrypted connections to the brokers, if provided
+	TLS  *promconf.TLSConfig `yaml:"tls"`
+	SASL *KafkaSASLConfig    `yaml:"sasl"`
+}
+
+// KafkaSASLConfig enables SASL authentication with the Kafka brokers
+type KafkaSASLConfig struct {
+	// Mechanism is one of: plain, scram-sha-256 or scram-sha-512
+	Mechanism string          `yaml:"mechanism"`
+	Username  string          `yaml:"username"`
+	Password  promconf.Secret `yaml:"password"`
+}
+
+// UnmarshalYAML sets the default values of the exporter configurations, before they are
+// overridden by the YAML properties
+func (c *ExporterConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain ExporterConfig
+	p := plain{
+		Loki:  defaultLokiConfig(),
+		Kafka: defaultKafkaConfig(),
+		OTLP: OTLPConfig{
+			Endpoint:       "otel-collector:4317",
+			Protocol:       OTLPGRPC,
+			BatchWait:      1 * time.Second,
+			BatchSize:      100,
+			Timeout:        10 * time.Second,
+			MinBackoff:     1 * time.Second,
+			MaxBackoff:     5 * time.Minute,
+			MaxRetries:     10,
+			TimestampLabel: "TimeReceived",
+			TimestampScale: time.Second,
+			ResourcePrefix: "Src",
+			ResourceAttributes: map[string]string{
+				"service.name": "goflow-kube",
+			},
+		},
+		File: FileConfig{
+			Format:         JSONFlagName,
+			MaxSize:        100 * 1024 * 1024,
+			RotateInterval: 24 * time.Hour,
+			Compress:       true,
+			MaxBackups:     10,
+			SrcPrefix:      "Src",
+			DstPrefix:      "Dst",
+		},
+		GRPC: GRPCConfig{
+			Listen:         ":9999",
+			BufferSize:     1000,
+			MaxSubscribers: 20,
+			SrcPrefix:      "Src",
+			DstPrefix:      "Dst",
+		},
+		Parquet: ParquetConfig{
+			NamespaceField: "SrcNamespace",
+			RowGroupSize:   8 * 1024 * 1024,
+			Compression:    ParquetSnappy,
+			MaxLateness:    5 * time.Minute,
+			MaxOpenFiles:   16,
+			TimestampLabel: "TimeReceived",
+			TimestampScale: time.Second,
+		},
+		OpenSearch: OpenSearchConfig{
+			URL:            "http://opensearch:9200/",
+			Index:          "flows",
+			BatchWait:      1 * time.Second,
+			BatchSize:      5 * 1024 * 1024,
+			Timeout:        10 * time.Second,
+			MinBackoff:     1 * time.Second,
+			MaxBackoff:     5 * time.Minute,
+			MaxRetries:     10,
+			TimestampLabel: "TimeReceived",
+			TimestampScale: time.Second,
+		},
+		IPFIX: IPFIXConfig{
+			Protocol:        "udp",
+			TemplateRefresh: 1 * time.Minute,
+			SrcPrefix:       "Src",
+			DstPrefix:       "Dst",
+		},
+	}
+	if err := unmarshal(&p); err != nil {
+		return err
+	}
+	*c = ExporterConfig(p)
+	return nil
+}
+
+// Validate checks the exporter type and sets its name, if not provided
+func (c *ExporterConfig) Validate() error {
+	if c.Name == "" {
+		c.Name = c.Type
+	}
+	switch c.Type {
+	case LokiExporter, StdoutExporter:
+	case KafkaExporter:
+		if err := c.Kafka.Validate(); err != nil {
+			return fmt.Errorf("exporter %q: %w", c.Name, err)
+		}
+	case IPFIXExporter:
+		if err := c.IPFIX.Validate(); err != nil {
+			return fmt.Errorf("exporter %q: %w", c.Name, err)
+		}
+	case PrometheusExporter:
+		if err := c.Prometheus.Validate(); err != nil {
+			return fmt.Errorf("exporter %q: %w", c.Name, err)
+		}
+	case OpenSearchExporter:
+		if err := c.OpenSearch.Validate(); err != nil {
+			return fmt.Errorf("exporter %q: %w", c.Name, err)
+		}
+	case OTLPExporter:
+		if err := c.OTLP.Validate(); err != nil {
+			return fmt.Errorf("exporter %q: %w", c.Name, err)
+		}
+	case FileExporter:
+		if err := c.File.Validate(); err != nil {
+			return fmt.Errorf("exporter %q: %w", c.Name, err)
+		}
+	case ParquetExporter:
+		if err := c.Parquet.Validate(); err != nil {
+			return fmt.Errorf("exporter %q: %w", c.Name, err)
+		}
+	case GRPCExporter:
+		if err := c.GRPC.Validate(); err != nil {
+			return fmt.Errorf("exporter %q: %w", c.Name, err)
+		}
+	default:
+		return fmt.Errorf("unknown exporter type: %q", c.Type)
+	}
+	return nil
+}
+
+// ValidateDeadLetter validates the exporter and checks that it can be used as dead-letter sink,
+// which must keep the original record as a nested field
+func (c *ExporterConfig) ValidateDeadLetter() error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	switch c.Type {
+	case FileExporter:
+		if c.File.Format != JSONFlagName {
+			return fmt.Errorf("dead-letter exporter %q: only the %s file format is supported", c.Name, JSONFlagName)
+		}
+	case KafkaExporter:
+		if c.Kafka.Encoding == PBFlagName {
+			return fmt.Errorf("dead-letter exporter %q: only the %s encoding is supported", c.Name, JSONFlagName)
+		}
+	case IPFIXExporter, PrometheusExporter, ParquetExporter, GRPCExporter, OTLPExporter:
+		return fmt.Errorf("dead-letter exporter %q: type %s is not supported", c.Name, c.Type)
+	}
+	return nil
+}
+
+// ZoneMetricsConfig enables aggregating the traffic between topology zones and workloads
+// into Prometheus metrics, using the topology.kubernetes.io/{zone,region} node labels
+type ZoneMetricsConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// SrcPrefix and DstPrefix are the output prefixes (as defined in the IPFields values)
+	// of the source and destination endpoints of the flow
+	SrcPrefix string `yaml:"srcPrefix"`
+	DstPrefix string `yaml:"dstPrefix"`
+	// MaxZones is the maximum number of distinct zone and region values. Extra values are
+	// reported as "other". 0 means no limit
+	MaxZones int `yaml:"maxZones"`
+	// MaxWorkloads is the maximum number of distinct namespace/workload values. Extra values
+	// are reported as "other". 0 means no limit
+	MaxWorkloads int `yaml:"maxWorkloads"`
+}
+
+// CustomResourceConfig describes a custom resource whose objects own IPs that are used
+// to enrich the flows. The IPs, Name, Namespace and Kind are JSONPath expressions
+// (e.g. '{.status.interfaces[*].ipAddress}') to be evaluated against each object
+type CustomResourceConfig struct {
+	Group     string `yaml:"group"`
+	Version   string `yaml:"version"`
+	Resource  string `yaml:"resource"`
+	IPs       string `yaml:"ips"`
+	Name      string `yaml:"name"`
+	Namespace string `yaml:"namespace"`
+	Kind      string `yaml:"kind"`
+}
+
+// InventoryConfig allows enriching the flows from static kubernetes manifests instead of
+// connecting to the API server (e.g. for offline enrichment of archived flows)
+type InventoryConfig struct {
+	// Paths of the YAML or JSON files (or directories containing them) with the Pods, Services,
+	// ReplicaSets and Nodes, as provided by 'kubectl get -o yaml'. If empty, the flows are
+	// enriched from the live cluster
+	Paths []string `yaml:"paths"`
+	// Snapshot is the path of a snapshot file, as provided by the /debug/snapshot endpoint, to
+	// be replayed instead of the Paths
+	Snapshot string `yaml:"snapshot"`
+}
+
+// Enabled returns whether the flows must be enriched from the static inventory
+func (c *InventoryConfig) Enabled() bool {
+	return len(c.Paths) > 0 || c.Snapshot != ""
 }
 
 // Load loads the YAML configuration from the file path passed as argument
@@ -82,21 +818,353 @@ func Default() *Config {
 			"SrcAddr": "Src",
 			"DstAddr": "Dst",
 		},
-		Loki: LokiConfig{
-			URL:        "http://loki:3100/",
-			BatchWait:  1 * time.Second,
-			BatchSize:  100 * 1024,
-			Timeout:    10 * time.Second,
-			MinBackoff: 1 * time.Second,
-			MaxBackoff: 5 * time.Minute,
-			MaxRetries: 10,
-			StaticLabels: model.LabelSet{
-				"app": "goflow-kube",
-			},
-			TimestampLabel: "TimeReceived",
-			TimestampScale: time.Second,
+		MACFields: map[string]string{
+			"SrcMac": "Src",
+			"DstMac": "Dst",
+		},
+		Loki: defaultLokiConfig(),
+		KafkaInput: KafkaInputConfig{
+			GroupID:        "goflow-kube",
+			CommitInterval: 1 * time.Second,
+			MinBytes:       1,
+			MaxBytes:       10 * 1024 * 1024,
+			MaxWait:        1 * time.Second,
+		},
+		Tail: TailConfig{
+			MaxSubscribers: 5,
+			RateLimit:      100,
+			BufferSize:     100,
+		},
+		ZoneMetrics: ZoneMetricsConfig{
+			SrcPrefix:    "Src",
+			DstPrefix:    "Dst",
+			MaxZones:     100,
+			MaxWorkloads: 500,
+		},
+	}
+}
+
+// Validate checks the mandatory fields and sets the default JSONPath for the name,
+// namespace and kind of the objects, if not provided
+func (c *CustomResourceConfig) Validate() error {
+	if c.Version == "" || c.Resource == "" {
+		return errors.New("custom resources must specify at least a version and a resource")
+	}
+	if c.IPs == "" {
+		return fmt.Errorf("custom resource %s: ips can't be empty", c.Resource)
+	}
+	if c.Name == "" {
+		c.Name = "{.metadata.name}"
+	}
+	if c.Namespace == "" {
+		c.Namespace = "{.metadata.namespace}"
+	}
+	if c.Kind == "" {
+		c.Kind = "{.kind}"
+	}
+	return nil
+}
+
+func defaultLokiConfig() LokiConfig {
+	return LokiConfig{
+		URL:        "http://loki:3100/",
+		BatchWait:  1 * time.Second,
+		BatchSize:  100 * 1024,
+		Timeout:    10 * time.Second,
+		MinBackoff: 1 * time.Second,
+		MaxBackoff: 5 * time.Minute,
+		MaxRetries: 10,
+		StaticLabels: model.LabelSet{
+			"app": "goflow-kube",
+		},
+		TimestampLabel: "TimeReceived",
+		TimestampScale: time.Second,
+		WAL: LokiWALConfig{
+			MaxSize: 1024 * 1024 * 1024,
+		},
+		Tenant: LokiTenantConfig{
+			CrossTenant: CrossTenantDuplicate,
+		},
+		Cardinality: LokiCardinalityConfig{
+			StreamTTL: time.Hour,
 		},
+		OutOfOrder: LokiOutOfOrderConfig{
+			Action: OutOfOrderClamp,
+		},
+		EndpointsMode:       LokiFailover,
+		HealthCheckInterval: 10 * time.Second,
+		MirrorQueueSize:     10000,
+	}
+}
+
+func defaultKafkaConfig() KafkaConfig {
+	return KafkaConfig{
+		Encoding:     JSONFlagName,
+		BatchSize:    100,
+		BatchBytes:   1024 * 1024,
+		BatchTimeout: 1 * time.Second,
+		Compression:  "none",
+		MaxAttempts:  10,
+		Timeout:      10 * time.Second,
+		SrcPrefix:    "Src",
+		DstPrefix:    "Dst",
+	}
+}
+
+func (c *KafkaConfig) Validate() error {
+	if len(c.Brokers) == 0 {
+		return errors.New("brokers can't be empty")
+	}
+	if c.Topic == "" {
+		return errors.New("topic can't be empty")
+	}
+	if c.Encoding != JSONFlagName && c.Encoding != PBFlagName {
+		return fmt.Errorf("invalid encoding: %q. Required %q or %q", c.Encoding, JSONFlagName, PBFlagName)
+	}
+	switch c.Compression {
+	case "", "none", "gzip", "snappy", "lz4", "zstd":
+	default:
+		return fmt.Errorf("unknown compression codec: %q", c.Compression)
+	}
+	if c.SASL != nil {
+		switch c.SASL.Mechanism {
+		case "plain", "scram-sha-256", "scram-sha-512":
+		default:
+			return fmt.Errorf("unknown SASL mechanism: %q", c.SASL.Mechanism)
+		}
+	}
+	return nil
+}
+
+func (c *IPFIXConfig) Validate() error {
+	if c.Address == "" {
+		return errors.New("address can't be empty")
+	}
+	if c.Protocol != "udp" && c.Protocol != "tcp" {
+		return fmt.Errorf("invalid protocol: %q. Required udp or tcp", c.Protocol)
+	}
+	if c.EnterpriseID == 0 {
+		return errors.New("enterpriseID must be a valid Private Enterprise Number")
+	}
+	if c.TemplateRefresh < time.Second {
+		return fmt.Errorf("invalid templateRefresh: %v. Required >= 1s", c.TemplateRefresh)
+	}
+	return nil
+}
+
+func (c *PrometheusConfig) Validate() error {
+	if len(c.Metrics) == 0 {
+		return errors.New("at least one metric must be defined")
+	}
+	names := map[string]struct{}{}
+	for i := range c.Metrics {
+		m := &c.Metrics[i]
+		if !model.IsValidMetricName(model.LabelValue(m.Name)) {
+			return fmt.Errorf("invalid metric name: %q", m.Name)
+		}
+		if _, ok := names[m.Name]; ok {
+			return fmt.Errorf("duplicate metric name: %q", m.Name)
+		}
+		names[m.Name] = struct{}{}
+		switch m.Value {
+		case FlowMetricBytes, FlowMetricPackets, FlowMetricFlows:
+		default:
+			return fmt.Errorf("metric %q: invalid value: %q. Required bytes, packets or flows",
+				m.Name, m.Value)
+		}
+		labels := map[string]struct{}{}
+		for _, label := range m.Labels {
+			if !model.LabelName(label).IsValid() {
+				return fmt.Errorf("metric %q: invalid label name: %q", m.Name, label)
+			}
+			if _, ok := labels[label]; ok {
+				return fmt.Errorf("metric %q: duplicate label: %q", m.Name, label)
+			}
+			labels[label] = struct{}{}
+		}
+	}
+	return nil
+}
+
+func (c *OpenSearchConfig) Validate() error {
+	if c.URL == "" {
+		return errors.New("url can't be empty")
+	}
+	if c.Index == "" {
+		return errors.New("index can't be empty")
+	}
+	if c.TimestampScale == 0 {
+		return errors.New("timestampScale must be a valid Duration > 0 (e.g. 1m, 1s or 1ms)")
+	}
+	if c.BatchSize <= 0 {
+		return fmt.Errorf("invalid batchSize: %v. Required > 0", c.BatchSize)
+	}
+	if c.BatchWait <= 0 {
+		return fmt.Errorf("invalid batchWait: %v. Required > 0", c.BatchWait)
+	}
+	return nil
+}
+
+func (c *OTLPConfig) Validate() error {
+	if c.Endpoint == "" {
+		return errors.New("endpoint can't be empty")
+	}
+	if c.Protocol != OTLPGRPC && c.Protocol != OTLPHTTPProtobuf {
+		return fmt.Errorf("invalid protocol: %q. Required %s or %s", c.Protocol, OTLPGRPC, OTLPHTTPProtobuf)
+	}
+	if c.TimestampScale == 0 {
+		return errors.New("timestampScale must be a valid Duration > 0 (e.g. 1m, 1s or 1ms)")
+	}
+	if c.BatchSize <= 0 {
+		return fmt.Errorf("invalid batchSize: %v. Required > 0", c.BatchSize)
+	}
+	if c.BatchWait <= 0 {
+		return fmt.Errorf("invalid batchWait: %v. Required > 0", c.BatchWait)
+	}
+	return nil
+}
+
+func (c *FileConfig) Validate() error {
+	if c.Path == "" {
+		return errors.New("path can't be empty")
+	}
+	switch c.Format {
+	case JSONFlagName, PBFlagName:
+	case CSVFlagName:
+		if len(c.Columns) == 0 {
+			return errors.New("columns must be provided for the csv format")
+		}
+	default:
+		return fmt.Errorf("invalid format: %q. Required json, csv or pb", c.Format)
+	}
+	if c.MaxSize <= 0 {
+		return fmt.Errorf("invalid maxSize: %v. Required > 0", c.MaxSize)
+	}
+	if c.RotateInterval < 0 {
+		return fmt.Errorf("invalid rotateInterval: %v. Required >= 0", c.RotateInterval)
+	}
+	return nil
+}
+
+func (c *ParquetConfig) Validate() error {
+	if c.Path == "" {
+		return errors.New("path can't be empty")
+	}
+	if c.PartitionByNamespace && c.NamespaceField == "" {
+		return errors.New("namespaceField can't be empty when partitioning by namespace")
+	}
+	switch c.Compression {
+	case ParquetUncompressed, ParquetSnappy, ParquetGzip, ParquetZstd:
+	default:
+		return fmt.Errorf("invalid compression: %q. Required %s, %s, %s or %s", c.Compression,
+			ParquetUncompressed, ParquetSnappy, ParquetGzip, ParquetZstd)
+	}
+	if c.RowGroupSize <= 0 {
+		return fmt.Errorf("invalid rowGroupSize: %v. Required > 0", c.RowGroupSize)
+	}
+	if c.MaxLateness < 0 {
+		return fmt.Errorf("invalid maxLateness: %v. Required >= 0", c.MaxLateness)
+	}
+	if c.MaxOpenFiles <= 0 {
+		return fmt.Errorf("invalid maxOpenFiles: %v. Required > 0", c.MaxOpenFiles)
+	}
+	if c.TimestampScale == 0 {
+		return errors.New("timestampScale must be a valid Duration > 0 (e.g. 1m, 1s or 1ms)")
+	}
+	return nil
+}
+
+func (c *GRPCConfig) Validate() error {
+	if c.Listen == "" {
+		return errors.New("listen can't be empty")
+	}
+	if c.BufferSize <= 0 {
+		return fmt.Errorf("invalid bufferSize: %v. Required > 0", c.BufferSize)
+	}
+	if c.MaxSubscribers < 0 {
+		return fmt.Errorf("invalid maxSubscribers: %v. Required >= 0", c.MaxSubscribers)
+	}
+	return nil
+}
+
+// ValidateExposure checks that the gRPC server doesn't stream the flows in plain text and without
+// authentication, unless it only listens on a loopback address or it is explicitly allowed
+func (c *GRPCConfig) ValidateExposure(serverTLS *ServerTLSConfig) error {
+	if serverTLS == nil && !c.Insecure && !isLoopback(c.Listen) {
+		return fmt.Errorf("listen %q requires either serverTLS, a loopback address or insecure, since the"+
+			" server streams the enriched flows", c.Listen)
+	}
+	return nil
+}
+
+// isLoopback returns whether the host of the address is a loopback IP or localhost, so the
+// address is only reachable from the pod
+func isLoopback(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// SelectExporter keeps only the exporter with the given name, so the records are only sent to
+// it (e.g. when replaying the records that it failed to deliver)
+func (c *Config) SelectExporter(name string) error {
+	if name == StdoutExporter && c.PrintOutput {
+		c.Exporters = []ExporterConfig{{Type: StdoutExporter, Name: StdoutExporter}}
+		c.PrintOutput = false
+		return nil
+	}
+	if len(c.Exporters) == 0 && name == LokiExporter {
+		c.PrintOutput = false
+		return nil
+	}
+	for _, ecfg := range c.Exporters {
+		if ecfg.Name == name || (ecfg.Name == "" && ecfg.Type == name) {
+			c.Exporters = []ExporterConfig{ecfg}
+			c.PrintOutput = false
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown exporter: %q", name)
+}
+
+// ValidateEndpoints checks that the debug endpoints that are enabled, which expose the metadata
+// of the cluster or the enriched flows without authentication, are not served in plain text by
+// the health service
+func (c *Config) ValidateEndpoints() error {
+	if c.SnapshotEndpoint && c.ServerTLS == nil && !isLoopback(c.SnapshotAddress) {
+		return errors.New("snapshotEndpoint requires either serverTLS or a loopback snapshotAddress, since it" +
+			" exposes the metadata of the cluster")
+	}
+	if c.Tail.Enabled && c.ServerTLS == nil {
+		return errors.New("tail requires serverTLS, since it exposes the enriched flows")
+	}
+	return nil
+}
+
+func (c *TailConfig) Validate() error {
+	if c.MaxSubscribers < 0 {
+		return fmt.Errorf("invalid maxSubscribers: %v. Required >= 0", c.MaxSubscribers)
+	}
+	if c.RateLimit < 0 {
+		return fmt.Errorf("invalid rateLimit: %v. Required >= 0", c.RateLimit)
+	}
+	if c.BufferSize <= 0 {
+		return fmt.Errorf("invalid bufferSize: %v. Required > 0", c.BufferSize)
 	}
+	return nil
+}
+
+func (c *ServerTLSConfig) Validate() error {
+	if c.CertFile == "" || c.KeyFile == "" {
+		return errors.New("certFile and keyFile must be provided")
+	}
+	return nil
 }
 
 func (c *LokiConfig) Validate() error {
@@ -106,11 +1174,88 @@ func (c *LokiConfig) Validate() error {
 	if c.TimestampScale == 0 {
 		return errors.New("timestampUnit must be a valid Duration > 0 (e.g. 1m, 1s or 1ms)")
 	}
-	if c.URL == "" {
+	if c.URL == "" && len(c.Endpoints) == 0 {
 		return errors.New("url can't be empty")
 	}
+	if err := c.validateEndpoints(); err != nil {
+		return err
+	}
 	if c.BatchSize <= 0 {
 		return fmt.Errorf("invalid batchSize: %v. Required > 0", c.BatchSize)
 	}
+	if c.WAL.Dir != "" {
+		if c.BatchWait <= 0 {
+			return fmt.Errorf("invalid batchWait: %v. Required > 0 when the WAL is enabled", c.BatchWait)
+		}
+		if c.WAL.MaxSize < int64(c.BatchSize) {
+			return fmt.Errorf("invalid wal.maxSize: %v. Required >= batchSize", c.WAL.MaxSize)
+		}
+	}
+	for i := range c.TimestampFields {
+		if c.TimestampFields[i].Name == "" {
+			return errors.New("the timestampFields must have a name")
+		}
+		if c.TimestampFields[i].Scale <= 0 {
+			return fmt.Errorf("timestampFields %q: scale must be a valid Duration > 0 (e.g. 1s or 1ms)",
+				c.TimestampFields[i].Name)
+		}
+	}
+	if c.MaxClockSkew < 0 {
+		return fmt.Errorf("invalid maxClockSkew: %v. Required >= 0", c.MaxClockSkew)
+	}
+	if err := c.OutOfOrder.Validate(); err != nil {
+		return err
+	}
+	switch c.LineFormat {
+	case "", LokiLineJSON, LokiLineLogfmt:
+	case LokiLineTemplate:
+		if c.LineTemplate == "" {
+			return errors.New("lineTemplate can't be empty when the lineFormat is template")
+		}
+	default:
+		return fmt.Errorf("invalid lineFormat: %q. Accepted values: %s, %s, %s", c.LineFormat,
+			LokiLineJSON, LokiLineLogfmt, LokiLineTemplate)
+	}
+	switch c.Tenant.CrossTenant {
+	case "", CrossTenantDuplicate, CrossTenantSource, CrossTenantDestination:
+	default:
+		return fmt.Errorf("invalid tenant.crossTenant: %q. Accepted values: %s, %s, %s", c.Tenant.CrossTenant,
+			CrossTenantDuplicate, CrossTenantSource, CrossTenantDestination)
+	}
+	return c.Cardinality.Validate()
+}
+
+func (c *LokiOutOfOrderConfig) Validate() error {
+	if c.MaxAge < 0 {
+		return fmt.Errorf("invalid outOfOrder.maxAge: %v. Required >= 0", c.MaxAge)
+	}
+	switch c.Action {
+	case "", OutOfOrderClamp, OutOfOrderDrop:
+	case OutOfOrderRoute:
+		if len(c.RouteLabels) == 0 {
+			return errors.New("outOfOrder.routeLabels can't be empty when the action is route")
+		}
+	default:
+		return fmt.Errorf("invalid outOfOrder.action: %q. Accepted values: %s, %s, %s", c.Action,
+			OutOfOrderClamp, OutOfOrderDrop, OutOfOrderRoute)
+	}
+	return nil
+}
+
+func (c *LokiCardinalityConfig) Validate() error {
+	if c.MaxLabelValues < 0 {
+		return fmt.Errorf("invalid cardinality.maxLabelValues: %v. Required >= 0", c.MaxLabelValues)
+	}
+	for label, limit := range c.LabelLimits {
+		if limit < 0 {
+			return fmt.Errorf("invalid cardinality.labelLimits for %q: %v. Required >= 0", label, limit)
+		}
+	}
+	if c.MaxStreams < 0 {
+		return fmt.Errorf("invalid cardinality.maxStreams: %v. Required >= 0", c.MaxStreams)
+	}
+	if c.StreamTTL < 0 {
+		return fmt.Errorf("invalid cardinality.streamTTL: %v. Required >= 0", c.StreamTTL)
+	}
 	return nil
 }
@@ -2,7 +2,9 @@ package config
 
 import (
 	"io/ioutil"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -40,3 +42,267 @@ printInput: true
 	assert.EqualValues(t, "bae", cfg.Loki.StaticLabels["baz"])
 	assert.EqualValues(t, "taka", cfg.Loki.StaticLabels["tiki"])
 }
+
+func TestConfig_SnapshotEndpoint(t *testing.T) {
+	cfg := Default()
+	require.NoError(t, cfg.ValidateEndpoints())
+	// the snapshot can't be served in plain text by the health service
+	cfg.SnapshotEndpoint = true
+	assert.Error(t, cfg.ValidateEndpoints())
+	cfg.SnapshotAddress = "127.0.0.1:8081"
+	assert.NoError(t, cfg.ValidateEndpoints())
+	// nor by a dedicated server listening on all the interfaces
+	cfg.SnapshotAddress = "0.0.0.0:8081"
+	assert.Error(t, cfg.ValidateEndpoints())
+	cfg.SnapshotAddress = ":8081"
+	assert.Error(t, cfg.ValidateEndpoints())
+	cfg.SnapshotAddress = ""
+	cfg.ServerTLS = &ServerTLSConfig{CertFile: "tls.crt", KeyFile: "tls.key"}
+	assert.NoError(t, cfg.ValidateEndpoints())
+}
+
+func TestConfig_TailEndpoint(t *testing.T) {
+	cfg := Default()
+	// the flows can't be tailed in plain text from the health service
+	cfg.Tail.Enabled = true
+	assert.Error(t, cfg.ValidateEndpoints())
+	cfg.ServerTLS = &ServerTLSConfig{CertFile: "tls.crt", KeyFile: "tls.key"}
+	assert.NoError(t, cfg.ValidateEndpoints())
+}
+
+func TestConfig_SelectExporter(t *testing.T) {
+	// GIVEN a configuration with several exporters
+	cfg, err := Read(strings.NewReader(`
+printOutput: true
+exporters:
+  - type: loki
+  - type: opensearch
+    name: archive
+`))
+	require.NoError(t, err)
+
+	// WHEN an exporter is selected
+	require.NoError(t, cfg.SelectExporter("archive"))
+
+	// THEN it is the only exporter
+	require.Len(t, cfg.Exporters, 1)
+	assert.Equal(t, OpenSearchExporter, cfg.Exporters[0].Type)
+	assert.False(t, cfg.PrintOutput)
+
+	// AND the exporters are also identified by their type, if they have no name
+	cfg, err = Read(strings.NewReader(`
+exporters:
+  - type: loki
+  - type: opensearch
+`))
+	require.NoError(t, err)
+	require.NoError(t, cfg.SelectExporter(LokiExporter))
+	require.Len(t, cfg.Exporters, 1)
+	assert.Equal(t, LokiExporter, cfg.Exporters[0].Type)
+	assert.Error(t, cfg.SelectExporter("archive"))
+
+	// AND the default Loki exporter can be selected
+	cfg = Default()
+	assert.NoError(t, cfg.SelectExporter(LokiExporter))
+	assert.Error(t, cfg.SelectExporter(KafkaExporter))
+}
+
+func TestConfig_GRPCExposure(t *testing.T) {
+	// the flows can't be streamed in plain text on all the interfaces
+	cfg := GRPCConfig{Listen: ":9999"}
+	assert.Error(t, cfg.ValidateExposure(nil))
+	cfg.Listen = "0.0.0.0:9999"
+	assert.Error(t, cfg.ValidateExposure(nil))
+	// unless TLS is enabled, the address is a loopback address, or it is explicitly allowed
+	assert.NoError(t, cfg.ValidateExposure(&ServerTLSConfig{CertFile: "tls.crt", KeyFile: "tls.key"}))
+	for _, listen := range []string{"127.0.0.1:9999", "localhost:9999", "[::1]:9999"} {
+		cfg.Listen = listen
+		assert.NoError(t, cfg.ValidateExposure(nil), listen)
+	}
+	cfg.Listen = ":9999"
+	cfg.Insecure = true
+	assert.NoError(t, cfg.ValidateExposure(nil))
+}
+
+func TestConfig_ExporterDefaults(t *testing.T) {
+	// GIVEN a configuration that only sets the mandatory properties of each exporter
+	cfg, err := Read(strings.NewReader(`
+exporters:
+  - type: loki
+    loki:
+      url: "http://my-loki:3100"
+  - type: kafka
+    kafka:
+      brokers: ["kafka:9092"]
+      topic: flows
+  - type: ipfix
+    ipfix:
+      address: 127.0.0.1:4739
+      enterpriseID: 12345
+  - type: prometheus
+    prometheus:
+      metrics:
+        - name: workload_bytes_total
+          labels: [SrcNamespace, SrcWorkload, DstNamespace, DstWorkload]
+  - type: opensearch
+    opensearch:
+      index: security-flows
+  - type: otlp
+    otlp:
+      endpoint: http://otel-collector:4318
+  - type: file
+    file:
+      path: /tmp/flows.log
+  - type: parquet
+    parquet:
+      path: /tmp/flows
+  - type: grpc
+tail:
+  enabled: true
+  rateLimit: 10
+`))
+	require.NoError(t, err)
+	exporters := cfg.Exporters
+	require.Len(t, exporters, 9)
+	for i := range exporters {
+		require.NoError(t, exporters[i].Validate(), exporters[i].Type)
+	}
+
+	// THEN the non-specified properties get the default values
+	loki := exporters[0].Loki
+	assert.Equal(t, "http://my-loki:3100", loki.URL)
+	assert.Equal(t, Default().Loki.BatchSize, loki.BatchSize)
+	assert.Equal(t, CrossTenantDuplicate, loki.Tenant.CrossTenant)
+	assert.False(t, loki.Tenant.Enabled())
+	assert.Equal(t, LokiCardinalityConfig{StreamTTL: time.Hour}, loki.Cardinality)
+	assert.Equal(t, LokiFailover, loki.EndpointsMode)
+	assert.EqualValues(t, 1024*1024*1024, loki.WAL.MaxSize)
+
+	assert.Equal(t, JSONFlagName, exporters[1].Kafka.Encoding)
+	assert.Equal(t, time.Second, exporters[1].Kafka.BatchTimeout)
+	assert.Equal(t, "Src", exporters[1].Kafka.SrcPrefix)
+
+	assert.Equal(t, "udp", exporters[2].IPFIX.Protocol)
+	assert.Equal(t, time.Minute, exporters[2].IPFIX.TemplateRefresh)
+
+	metric := exporters[3].Prometheus.Metrics[0]
+	assert.Equal(t, FlowMetricBytes, metric.Value)
+	assert.Equal(t, 100, metric.MaxLabelValues)
+	assert.Equal(t, 5*time.Minute, metric.TTL)
+
+	assert.Equal(t, "security-flows", exporters[4].OpenSearch.Index)
+	assert.Equal(t, time.Second, exporters[4].OpenSearch.BatchWait)
+	assert.Equal(t, time.Second, exporters[4].OpenSearch.MinBackoff)
+	assert.EqualValues(t, "TimeReceived", exporters[4].OpenSearch.TimestampLabel)
+
+	assert.Equal(t, OTLPGRPC, exporters[5].OTLP.Protocol)
+	assert.Equal(t, 100, exporters[5].OTLP.BatchSize)
+	assert.Equal(t, "Src", exporters[5].OTLP.ResourcePrefix)
+
+	assert.Equal(t, JSONFlagName, exporters[6].File.Format)
+	assert.Equal(t, 24*time.Hour, exporters[6].File.RotateInterval)
+	assert.True(t, exporters[6].File.Compress)
+
+	assert.Equal(t, "SrcNamespace", exporters[7].Parquet.NamespaceField)
+	assert.Equal(t, ParquetSnappy, exporters[7].Parquet.Compression)
+	assert.EqualValues(t, "TimeReceived", exporters[7].Parquet.TimestampLabel)
+	assert.Equal(t, 16, exporters[7].Parquet.MaxOpenFiles)
+
+	assert.Equal(t, ":9999", exporters[8].GRPC.Listen)
+	assert.Equal(t, 1000, exporters[8].GRPC.BufferSize)
+	assert.Equal(t, "Dst", exporters[8].GRPC.DstPrefix)
+
+	assert.Equal(t, TailConfig{Enabled: true, MaxSubscribers: 5, RateLimit: 10, BufferSize: 100}, cfg.Tail)
+}
+
+func TestConfig_InvalidExporters(t *testing.T) {
+	for _, exporter := range []string{
+		"{type: foo}",
+		"{type: kafka, kafka: {brokers: [kafka:9092]}}",
+		"{type: ipfix, ipfix: {address: 127.0.0.1:4739}}",
+		"{type: prometheus, prometheus: {metrics: [{name: bytes_total, labels: [Src-Namespace]}]}}",
+		"{type: opensearch, opensearch: {index: ''}}",
+		"{type: otlp, otlp: {protocol: http/json}}",
+		"{type: file, file: {path: /tmp/flows.csv, format: csv}}",
+		"{type: parquet, parquet: {path: /tmp/flows, compression: lzo}}",
+		"{type: grpc, grpc: {bufferSize: 0}}",
+	} {
+		cfg, err := Read(strings.NewReader("exporters: [" + exporter + "]"))
+		require.NoError(t, err)
+		assert.Error(t, cfg.Exporters[0].Validate(), exporter)
+	}
+}
+
+func TestConfig_InvalidLoki(t *testing.T) {
+	for _, lokiCfg := range []string{
+		"{lineFormat: xml}",
+		"{lineFormat: template}",
+		"{timestampFields: [{scale: 1s}]}",
+		"{timestampFields: [{name: TimeReceived, scale: 0s}]}",
+		"{maxClockSkew: -1s}",
+		"{outOfOrder: {maxAge: 1h, action: ignore}}",
+		"{outOfOrder: {maxAge: 1h, action: route}}",
+		"{tenant: {crossTenant: both}}",
+		"{cardinality: {labelLimits: {DstPort: -1}}}",
+		"{batchSize: 200, wal: {dir: /tmp/wal, maxSize: 199}}",
+		"{endpoints: [{url: 'http://loki-a:3100'}], endpointsMode: roundrobin}",
+		"{endpoints: [{url: 'http://loki-a:3100'}, {url: 'http://loki-a:3100/'}]}",
+		"{endpoints: [{url: 'http://loki-a:3100'}, {url: 'http://loki-b:3100'}], healthCheckInterval: 0s}",
+		"{endpoints: [{url: 'http://loki-a:3100'}, {url: 'http://loki-b:3100'}], endpointsMode: mirror, mirrorQueueSize: 0}",
+	} {
+		cfg, err := Read(strings.NewReader("loki: " + lokiCfg))
+		require.NoError(t, err)
+		assert.Error(t, cfg.Loki.Validate(), lokiCfg)
+	}
+}
+
+func TestConfig_LokiEndpoints(t *testing.T) {
+	cfg, err := Read(strings.NewReader(`
+loki:
+  endpoints:
+    - url: "http://loki-a:3100"
+    - url: "http://loki-b:3100/"
+    - url: "http://loki-b:3100/"
+      name: other
+  endpointsMode: mirror
+`))
+	require.NoError(t, err)
+	require.NoError(t, cfg.Loki.Validate())
+	// the endpoints are named after their host by default
+	endpoints := cfg.Loki.LokiEndpoints()
+	assert.Equal(t, "loki-a:3100", endpoints[0].Name)
+	assert.Equal(t, "loki-b:3100", endpoints[1].Name)
+	assert.Equal(t, "other", endpoints[2].Name)
+	// the URL is the only endpoint if none is provided
+	assert.Equal(t, []LokiEndpointConfig{{Name: "loki:3100", URL: "http://loki:3100/"}},
+		Default().Loki.LokiEndpoints())
+}
+
+func TestConfig_DeadLetter(t *testing.T) {
+	// the sinks that can't store the original record are rejected
+	for _, exporter := range []string{
+		"{type: file, file: {path: /tmp/dlq.csv, format: csv, columns: [SrcPod]}}",
+		"{type: kafka, kafka: {brokers: [kafka:9092], topic: dlq, encoding: pb}}",
+		"{type: prometheus, prometheus: {metrics: [{name: bytes_total}]}}",
+	} {
+		cfg, err := Read(strings.NewReader("deadLetter: " + exporter))
+		require.NoError(t, err)
+		require.NotNil(t, cfg.DeadLetter)
+		assert.Error(t, cfg.DeadLetter.ValidateDeadLetter(), exporter)
+	}
+	cfg, err := Read(strings.NewReader("deadLetter: {type: file, file: {path: /tmp/dlq.log}}"))
+	require.NoError(t, err)
+	assert.NoError(t, cfg.DeadLetter.ValidateDeadLetter())
+}
+
+func TestConfig_InvalidTail(t *testing.T) {
+	for _, tailCfg := range []string{
+		"{maxSubscribers: -1}",
+		"{rateLimit: -1}",
+		"{bufferSize: 0}",
+	} {
+		cfg, err := Read(strings.NewReader("tail: " + tailCfg))
+		require.NoError(t, err)
+		assert.Error(t, cfg.Tail.Validate(), tailCfg)
+	}
+}
@@ -0,0 +1,114 @@
+package export
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+
+	"github.com/netobserv/goflow2-kube-enricher/pkg/config"
+	"github.com/netobserv/goflow2-kube-enricher/pkg/format/deadletter"
+	"github.com/netobserv/goflow2-kube-enricher/pkg/health"
+)
+
+var dlog = logrus.WithField("module", "export/deadletter")
+
+// DeadLetter forwards the records that couldn't be enriched or exported to a sink exporter
+// (e.g. a local file), wrapped in an entry with the error and the stage where they failed.
+// The entries can be read back with the deadletter format. It is safe for concurrent use, since
+// the asynchronous exporters send the records that they fail to deliver from their own goroutines
+type DeadLetter struct {
+	lock    sync.Mutex
+	sink    Exporter
+	timeNow func() time.Time
+	sent    *prometheus.CounterVec
+	failed  *prometheus.CounterVec
+}
+
+// NewDeadLetter creates the dead-letter sink defined in the configuration, or returns nil if
+// none is defined
+func NewDeadLetter(cfg *config.Config, reporter *health.Reporter) (*DeadLetter, error) {
+	if cfg.DeadLetter == nil {
+		return nil, nil
+	}
+	if err := cfg.DeadLetter.ValidateDeadLetter(); err != nil {
+		return nil, err
+	}
+	sink, err := newExporter(cfg, cfg.DeadLetter, reporter)
+	if err != nil {
+		return nil, err
+	}
+	return newDeadLetter(sink, reporter)
+}
+
+func newDeadLetter(sink Exporter, reporter *health.Reporter) (*DeadLetter, error) {
+	sent, err := reporter.Register(prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "dead_letter_record_sent",
+			Help: "Number of failed records that have been sent to the dead-letter sink, by stage.",
+		},
+		[]string{"stage"},
+	))
+	if err != nil {
+		return nil, err
+	}
+	failed, err := reporter.Register(prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "dead_letter_record_failed",
+			Help: "Number of failed records that couldn't be sent to the dead-letter sink, by stage.",
+		},
+		[]string{"stage"},
+	))
+	if err != nil {
+		return nil, err
+	}
+	return &DeadLetter{
+		sink:    sink,
+		timeNow: time.Now,
+		sent:    sent.(*prometheus.CounterVec),
+		failed:  failed.(*prometheus.CounterVec),
+	}, nil
+}
+
+// FailureHandler returns a handler that sends the records that an asynchronous exporter
+// couldn't deliver to the sink, at the export stage
+func (d *DeadLetter) FailureHandler() FailureHandler {
+	return func(records []map[string]interface{}, err error) {
+		for _, record := range records {
+			d.Send(record, deadletter.StageExport, err)
+		}
+	}
+}
+
+// Send forwards the original record to the sink, along with the error and the stage where it
+// failed. If the error is an ExporterError, the name of the exporter that failed is stored too,
+// so the record is only replayed to it. If the sink fails too, the record is lost
+func (d *DeadLetter) Send(record map[string]interface{}, stage string, cause error) {
+	entry := map[string]interface{}{
+		deadletter.FieldTime:   d.timeNow().Unix(),
+		deadletter.FieldStage:  stage,
+		deadletter.FieldError:  cause.Error(),
+		deadletter.FieldRecord: record,
+	}
+	var exporterErr *ExporterError
+	if errors.As(cause, &exporterErr) {
+		entry[deadletter.FieldExporter] = exporterErr.Exporter
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if err := d.sink.ProcessRecord(entry); err != nil {
+		dlog.WithError(err).WithField("stage", stage).Error("can't send record to the dead-letter sink. Dropping it")
+		d.failed.WithLabelValues(stage).Inc()
+		return
+	}
+	d.sent.WithLabelValues(stage).Inc()
+}
+
+// Close flushes the pending entries of the sink
+func (d *DeadLetter) Close() error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.sink.Close()
+}
@@ -0,0 +1,105 @@
+package export
+
+import (
+	"errors"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/netobserv/goflow2-kube-enricher/pkg/config"
+	"github.com/netobserv/goflow2-kube-enricher/pkg/format/deadletter"
+	"github.com/netobserv/goflow2-kube-enricher/pkg/health"
+)
+
+func TestDeadLetter_File(t *testing.T) {
+	// GIVEN a dead-letter sink that writes to a file
+	fcfg, cleanup := testFileConfig(t, config.JSONFlagName)
+	defer cleanup()
+	reporter := health.NewReporter(health.Ready)
+	cfg := config.Default()
+	cfg.DeadLetter = &config.ExporterConfig{Type: config.FileExporter, File: fcfg}
+	dl, err := NewDeadLetter(cfg, reporter)
+	require.NoError(t, err)
+
+	// WHEN some failed records are sent
+	dl.Send(map[string]interface{}{"SrcAddr": "10.0.0.1", "Bytes": 10}, deadletter.StageExport,
+		errors.New("loki: server returned HTTP status 400"))
+	dl.Send(map[string]interface{}{"SrcAddr": "10.0.0.2"}, deadletter.StageEnrich, errors.New("boom"))
+	require.NoError(t, dl.Close())
+
+	// THEN they are stored along with the error and the stage
+	content := readFile(t, fcfg.Path)
+	assert.Contains(t, content, `"Error":"loki: server returned HTTP status 400"`)
+	assert.Contains(t, content, `"Stage":"export"`)
+	assert.Contains(t, content, `"Stage":"enrich"`)
+	metrics := getMetrics(t, reporter)
+	assert.Contains(t, metrics, `dead_letter_record_sent{stage="export"} 1`)
+	assert.Contains(t, metrics, `dead_letter_record_sent{stage="enrich"} 1`)
+
+	// AND the original records can be read back for replaying
+	file, err := os.Open(fcfg.Path)
+	require.NoError(t, err)
+	defer file.Close()
+	in := deadletter.NewScanner(file)
+	record, err := in.Next()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"SrcAddr": "10.0.0.1", "Bytes": float64(10)}, record)
+	record, err = in.Next()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"SrcAddr": "10.0.0.2"}, record)
+	_, err = in.Next()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestDeadLetter_Exporter(t *testing.T) {
+	// GIVEN a dead-letter sink that writes to a file
+	fcfg, cleanup := testFileConfig(t, config.JSONFlagName)
+	defer cleanup()
+	cfg := config.Default()
+	cfg.DeadLetter = &config.ExporterConfig{Type: config.FileExporter, File: fcfg}
+	dl, err := NewDeadLetter(cfg, health.NewReporter(health.Ready))
+	require.NoError(t, err)
+
+	// WHEN records fail in all the exporters, or in one of them
+	dl.Send(map[string]interface{}{"SrcAddr": "10.0.0.1"}, deadletter.StageExport, errors.New("boom"))
+	dl.Send(map[string]interface{}{"SrcAddr": "10.0.0.2"}, deadletter.StageExport,
+		&ExporterError{Exporter: "opensearch", Err: errors.New("bang")})
+	require.NoError(t, dl.Close())
+
+	// THEN the exporter that failed is stored
+	content := readFile(t, fcfg.Path)
+	assert.Contains(t, content, `"Exporter":"opensearch"`)
+	assert.Contains(t, content, `"Error":"opensearch: bang"`)
+
+	// AND by default, only the records that failed in all the exporters are read back
+	file, err := os.Open(fcfg.Path)
+	require.NoError(t, err)
+	defer file.Close()
+	in := deadletter.NewScanner(file)
+	record, err := in.Next()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"SrcAddr": "10.0.0.1"}, record)
+	_, err = in.Next()
+	assert.Equal(t, io.EOF, err)
+
+	// AND the records of an exporter can be read back to replay them to that exporter only
+	_, err = file.Seek(0, io.SeekStart)
+	require.NoError(t, err)
+	in = deadletter.NewScanner(file)
+	in.SetExporter("opensearch")
+	record, err = in.Next()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"SrcAddr": "10.0.0.2"}, record)
+	_, err = in.Next()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestDeadLetter_Config(t *testing.T) {
+	// a dead-letter sink is optional
+	dl, err := NewDeadLetter(config.Default(), health.NewReporter(health.Ready))
+	require.NoError(t, err)
+	assert.Nil(t, dl)
+}
@@ -0,0 +1,129 @@
+package export
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"reflect"
+
+	flowpb "github.com/netsampler/goflow2/pb"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/netobserv/goflow2-kube-enricher/pkg/fields"
+	goflowkubev1 "github.com/netobserv/goflow2-kube-enricher/pkg/pb/goflowkube/v1"
+	"github.com/netobserv/goflow2-kube-enricher/pkg/topology"
+)
+
+// enrichedEndpoints converts the records into EnrichedFlow messages, whose src and dst
+// endpoints are taken from the record fields with the given prefixes
+type enrichedEndpoints struct {
+	srcPrefix string
+	dstPrefix string
+}
+
+// endpointFields returns the Endpoint properties that are filled from each record field suffix
+func endpointFields(ep *goflowkubev1.Endpoint) map[string]*string {
+	return map[string]*string{
+		"Pod":                &ep.Pod,
+		"Namespace":          &ep.Namespace,
+		"HostIP":             &ep.HostIp,
+		"Workload":           &ep.Workload,
+		"WorkloadKind":       &ep.WorkloadKind,
+		"Network":            &ep.Network,
+		topology.FieldZone:   &ep.Zone,
+		topology.FieldRegion: &ep.Region,
+		"Warn":               &ep.Warn,
+	}
+}
+
+// encodeProtobuf encodes the record as a goflowkube.v1.EnrichedFlow message
+func (e enrichedEndpoints) encodeProtobuf(record map[string]interface{}) ([]byte, error) {
+	return proto.Marshal(e.toEnrichedFlow(record))
+}
+
+// toEnrichedFlow converts a record into an EnrichedFlow message. The goflow2 fields are
+// restored into the FlowMessage (e.g. the IP and MAC addresses are parsed back), the
+// kubernetes fields are set into the endpoints, and any other field, or any field whose
+// value can't be converted, is kept as an extra field
+func (e enrichedEndpoints) toEnrichedFlow(record map[string]interface{}) *goflowkubev1.EnrichedFlow {
+	msg := &goflowkubev1.EnrichedFlow{
+		Flow:        &flowpb.FlowMessage{},
+		ExtraFields: map[string]string{},
+	}
+	consumed := map[string]struct{}{}
+	for i, prefix := range []string{e.srcPrefix, e.dstPrefix} {
+		ep := &goflowkubev1.Endpoint{}
+		found := false
+		for suffix, field := range endpointFields(ep) {
+			if value, ok := record[prefix+suffix]; ok && value != nil {
+				*field = fmt.Sprint(value)
+				consumed[prefix+suffix] = struct{}{}
+				found = true
+			}
+		}
+		if !found {
+			continue
+		}
+		if i == 0 {
+			msg.Src = ep
+		} else {
+			msg.Dst = ep
+		}
+	}
+	flow := reflect.ValueOf(msg.Flow).Elem()
+	for key, value := range record {
+		if _, ok := consumed[key]; ok || value == nil {
+			continue
+		}
+		field, ok := flow.Type().FieldByName(key)
+		if !ok || field.PkgPath != "" || !setFlowField(flow.FieldByIndex(field.Index), value) {
+			msg.ExtraFields[key] = fmt.Sprint(value)
+		}
+	}
+	return msg
+}
+
+// setFlowField sets the value into a FlowMessage field, returning false if the value
+// can't be converted to the type of the field
+func setFlowField(field reflect.Value, value interface{}) bool {
+	switch field.Kind() {
+	case reflect.Uint32, reflect.Uint64:
+		if num, ok := fields.Uint64(value); ok {
+			field.SetUint(num)
+			return true
+		}
+		// MAC addresses are rendered as strings
+		if str, ok := value.(string); ok {
+			if mac, err := net.ParseMAC(str); err == nil && len(mac) == 6 {
+				field.SetUint(binary.BigEndian.Uint64(append([]byte{0, 0}, mac...)))
+				return true
+			}
+		}
+	case reflect.Int32:
+		if num, ok := fields.Int64(value); ok {
+			field.SetInt(num)
+			return true
+		}
+	case reflect.Bool:
+		if b, ok := value.(bool); ok {
+			field.SetBool(b)
+			return true
+		}
+	case reflect.Slice:
+		switch v := value.(type) {
+		case []byte:
+			field.SetBytes(v)
+			return true
+		case string:
+			// IP addresses are rendered as strings
+			if ip := net.ParseIP(v); ip != nil {
+				if ip4 := ip.To4(); ip4 != nil {
+					ip = ip4
+				}
+				field.SetBytes(ip)
+				return true
+			}
+		}
+	}
+	return false
+}
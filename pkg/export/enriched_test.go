@@ -0,0 +1,108 @@
+package export
+
+import (
+	"net"
+	"testing"
+
+	flowpb "github.com/netsampler/goflow2/pb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/netobserv/goflow2-kube-enricher/pkg/format/pb"
+	goflowkubev1 "github.com/netobserv/goflow2-kube-enricher/pkg/pb/goflowkube/v1"
+)
+
+func TestEnrichedFlow(t *testing.T) {
+	// GIVEN a record that has been decoded from a goflow2 message
+	flow := &flowpb.FlowMessage{
+		Type:          flowpb.FlowMessage_IPFIX,
+		TimeReceived:  1637501829,
+		Bytes:         123,
+		Packets:       2,
+		SrcAddr:       net.ParseIP("10.0.0.1").To4(),
+		DstAddr:       net.ParseIP("fd00::1"),
+		SrcMac:        0x0a580a800001,
+		Proto:         6,
+		SrcPort:       8080,
+		HasMPLS:       true,
+		NextHop:       net.ParseIP("10.0.0.254").To4(),
+		IPv6FlowLabel: 7,
+	}
+	record, err := pb.RenderMessage(flow)
+	require.NoError(t, err)
+	// AND enriched with the kubernetes fields
+	record["SrcPod"] = "pod-1"
+	record["SrcNamespace"] = "ns-1"
+	record["SrcWorkload"] = "api"
+	record["SrcWorkloadKind"] = "Deployment"
+	record["SrcZone"] = "zone-a"
+	record["DstWorkload"] = "db"
+	record["DstWorkloadKind"] = "Service"
+	record["Interface"] = "eth0"
+
+	// WHEN it is encoded as an EnrichedFlow message
+	encoded, err := enrichedEndpoints{srcPrefix: "Src", dstPrefix: "Dst"}.encodeProtobuf(record)
+	require.NoError(t, err)
+	msg := &goflowkubev1.EnrichedFlow{}
+	require.NoError(t, proto.Unmarshal(encoded, msg))
+
+	// THEN the original goflow2 message is restored
+	assert.True(t, proto.Equal(flow, msg.Flow), "expected %v\n got %v", flow, msg.Flow)
+	// AND the kubernetes fields are set in the endpoints
+	assert.True(t, proto.Equal(&goflowkubev1.Endpoint{
+		Pod: "pod-1", Namespace: "ns-1", Workload: "api", WorkloadKind: "Deployment", Zone: "zone-a",
+	}, msg.Src), msg.Src.String())
+	assert.True(t, proto.Equal(&goflowkubev1.Endpoint{
+		Workload: "db", WorkloadKind: "Service",
+	}, msg.Dst), msg.Dst.String())
+	// AND the rest of fields are kept as extra fields
+	assert.Equal(t, map[string]string{"Interface": "eth0"}, msg.ExtraFields)
+}
+
+func TestEnrichedFlow_JSONRecord(t *testing.T) {
+	// GIVEN a record from the JSON input, with missing and mistyped fields
+	record := map[string]interface{}{
+		"TimeReceived": float64(1637501829),
+		"SrcAddr":      "10.0.0.1",
+		"DstAddr":      "not-an-ip",
+		"Bytes":        "123",
+		"DstPod":       "pod-2",
+	}
+
+	// WHEN it is converted into an EnrichedFlow message
+	msg := enrichedEndpoints{srcPrefix: "Src", dstPrefix: "Dst"}.toEnrichedFlow(record)
+
+	// THEN the convertible fields are set in the flow
+	assert.Equal(t, uint64(1637501829), msg.Flow.TimeReceived)
+	assert.Equal(t, []byte{10, 0, 0, 1}, msg.Flow.SrcAddr)
+	// AND the missing endpoints are not set
+	assert.Nil(t, msg.Src)
+	assert.Equal(t, "pod-2", msg.Dst.Pod)
+	// AND the mistyped fields are kept as extra fields
+	assert.Empty(t, msg.Flow.DstAddr)
+	assert.Zero(t, msg.Flow.Bytes)
+	assert.Equal(t, map[string]string{"DstAddr": "not-an-ip", "Bytes": "123"}, msg.ExtraFields)
+}
+
+func TestEnrichedFlow_Prefixes(t *testing.T) {
+	// GIVEN a record whose kubernetes fields have custom prefixes
+	record := map[string]interface{}{
+		"SourcePod":       "pod-1",
+		"DestWorkload":    "db",
+		"DstWorkload":     "ignored",
+		"SrcAddr":         "10.0.0.1",
+		"DestNamespace":   "ns-2",
+		"SourceNamespace": "ns-1",
+	}
+
+	// WHEN it is converted with the configured prefixes
+	msg := enrichedEndpoints{srcPrefix: "Source", dstPrefix: "Dest"}.toEnrichedFlow(record)
+
+	// THEN the endpoints are taken from the prefixed fields
+	assert.True(t, proto.Equal(&goflowkubev1.Endpoint{Pod: "pod-1", Namespace: "ns-1"}, msg.Src), msg.Src.String())
+	assert.True(t, proto.Equal(&goflowkubev1.Endpoint{Workload: "db", Namespace: "ns-2"}, msg.Dst), msg.Dst.String())
+	// AND the fields with other prefixes are kept as extra fields
+	assert.Equal(t, []byte{10, 0, 0, 1}, msg.Flow.SrcAddr)
+	assert.Equal(t, map[string]string{"DstWorkload": "ignored"}, msg.ExtraFields)
+}
@@ -0,0 +1,154 @@
+package export
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/netobserv/goflow2-kube-enricher/pkg/config"
+	"github.com/netobserv/goflow2-kube-enricher/pkg/health"
+)
+
+// Exporter forwards the enriched records to a given sink. Exporters might modify the
+// passed records
+type Exporter interface {
+	ProcessRecord(record map[string]interface{}) error
+	// Close flushes any pending record and releases the exporter resources
+	Close() error
+}
+
+// Flusher is implemented by the exporters that buffer the records and deliver them
+// asynchronously, so the input can acknowledge the records (e.g. commit their Kafka offsets)
+// only after they have been delivered
+type Flusher interface {
+	// Flush blocks until the records passed to ProcessRecord have been delivered or dropped. It
+	// returns an error if any record couldn't be delivered since the previous Flush because the
+	// sink was unavailable, so processing the record again might succeed. The records rejected
+	// by the sink are not reported, since processing them again would fail too. If a
+	// FailureHandler is set, the records that couldn't be delivered are passed to it instead
+	Flush() error
+}
+
+// FailureHandler receives the records that an asynchronous exporter couldn't deliver, as they
+// were passed to ProcessRecord, along with the delivery error. It might be invoked from any
+// goroutine
+type FailureHandler func(records []map[string]interface{}, err error)
+
+// FailureNotifier is implemented by the asynchronous exporters that can report the records
+// that fail after ProcessRecord has returned (e.g. to send them to the dead-letter sink)
+type FailureNotifier interface {
+	// SetFailureHandler must be invoked before processing any record
+	SetFailureHandler(handler FailureHandler)
+}
+
+// SetFailureHandler sets the handler of the delivery failures, if the exporter reports them
+func SetFailureHandler(exporter Exporter, handler FailureHandler) {
+	if notifier, ok := exporter.(FailureNotifier); ok {
+		notifier.SetFailureHandler(handler)
+	}
+}
+
+// Flush flushes the exporter, if it buffers the records
+func Flush(exporter Exporter) error {
+	if flusher, ok := exporter.(Flusher); ok {
+		return flusher.Flush()
+	}
+	return nil
+}
+
+// undelivered accounts the records that an asynchronous exporter couldn't deliver since the
+// last Flush, because the sink was unavailable
+type undelivered struct {
+	mt      sync.Mutex
+	records int
+	err     error
+}
+
+func (u *undelivered) add(records int, err error) {
+	u.mt.Lock()
+	defer u.mt.Unlock()
+	u.records += records
+	u.err = err
+}
+
+// take returns an error if any record couldn't be delivered since the last invocation
+func (u *undelivered) take() error {
+	u.mt.Lock()
+	defer u.mt.Unlock()
+	if u.records == 0 {
+		return nil
+	}
+	err := fmt.Errorf("%d records couldn't be delivered: %w", u.records, u.err)
+	u.records, u.err = 0, nil
+	return err
+}
+
+// NewExporter creates the exporters defined in the configuration. If no exporters are
+// defined, it creates a Loki exporter from the root Loki configuration. If more than one
+// exporter is defined, it returns a Fanout exporter that forwards the records to all of them.
+// If PrintOutput is set, the records are also written to the standard output
+func NewExporter(cfg *config.Config, reporter *health.Reporter) (Exporter, error) {
+	ecfgs := cfg.Exporters
+	if len(ecfgs) == 0 {
+		ecfgs = []config.ExporterConfig{{Type: config.LokiExporter, Name: "loki", Loki: cfg.Loki}}
+	}
+	if cfg.PrintOutput {
+		ecfgs = append([]config.ExporterConfig{{Type: config.StdoutExporter, Name: "stdout"}}, ecfgs...)
+	}
+	exporters := make([]Exporter, 0, len(ecfgs))
+	names := make([]string, 0, len(ecfgs))
+	for i := range ecfgs {
+		ecfg := &ecfgs[i]
+		if err := ecfg.Validate(); err != nil {
+			return nil, err
+		}
+		exporter, err := newExporter(cfg, ecfg, reporter)
+		if err != nil {
+			return nil, fmt.Errorf("creating %s exporter: %w", ecfg.Name, err)
+		}
+		exporters = append(exporters, exporter)
+		names = append(names, ecfg.Name)
+	}
+	if len(exporters) == 1 {
+		return exporters[0], nil
+	}
+	return NewFanout(exporters, names, reporter), nil
+}
+
+func newExporter(root *config.Config, cfg *config.ExporterConfig, reporter *health.Reporter) (Exporter, error) {
+	switch cfg.Type {
+	case config.LokiExporter:
+		loki, err := NewLoki(&cfg.Loki, cfg.Name, reporter)
+		if err != nil {
+			return nil, err
+		}
+		return &loki, nil
+	case config.StdoutExporter:
+		return NewWriter(os.Stdout), nil
+	case config.KafkaExporter:
+		return NewKafka(&cfg.Kafka, cfg.Name, reporter)
+	case config.IPFIXExporter:
+		return NewIPFIX(&cfg.IPFIX)
+	case config.PrometheusExporter:
+		return NewPrometheus(&cfg.Prometheus, cfg.Name, reporter)
+	case config.OpenSearchExporter:
+		return NewOpenSearch(&cfg.OpenSearch, cfg.Name, reporter)
+	case config.OTLPExporter:
+		return NewOTLP(&cfg.OTLP, cfg.Name, reporter)
+	case config.FileExporter:
+		return NewFile(&cfg.File)
+	case config.ParquetExporter:
+		return NewParquet(&cfg.Parquet, cfg.Name, reporter)
+	case config.GRPCExporter:
+		if err := cfg.GRPC.ValidateExposure(root.ServerTLS); err != nil {
+			return nil, err
+		}
+		tlsConfig, err := health.ServerTLS(root.ServerTLS, true)
+		if err != nil {
+			return nil, err
+		}
+		return NewGRPC(&cfg.GRPC, tlsConfig, cfg.Name, reporter)
+	default:
+		return nil, fmt.Errorf("unknown exporter type: %q", cfg.Type)
+	}
+}
@@ -0,0 +1,130 @@
+package export
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/netobserv/goflow2-kube-enricher/pkg/config"
+	"github.com/netobserv/goflow2-kube-enricher/pkg/health"
+)
+
+func TestNewExporter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "exporters")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	for _, testCase := range []struct {
+		name      string
+		yaml      string
+		exporters []Exporter
+		names     []string
+	}{
+		{name: "default", yaml: `loki: {url: "http://my-loki:3100"}`, exporters: []Exporter{&Loki{}}},
+		{name: "print output", yaml: `
+printOutput: true
+loki: {url: "http://my-loki:3100"}`,
+			exporters: []Exporter{&Writer{}, &Loki{}}, names: []string{"stdout", "loki"}},
+		{name: "fanout", yaml: `
+exporters:
+  - type: loki
+  - type: stdout
+    name: console`,
+			exporters: []Exporter{&Loki{}, &Writer{}}, names: []string{"loki", "console"}},
+		// two exporters of the same type can share the metrics
+		{name: "kafka", yaml: `
+exporters:
+  - type: kafka
+    kafka: {brokers: ["kafka:9092"], topic: flows}
+  - type: kafka
+    name: other
+    kafka: {brokers: ["kafka:9092"], topic: flows, encoding: pb}`,
+			exporters: []Exporter{&Kafka{}, &Kafka{}}, names: []string{"kafka", "other"}},
+		{name: "ipfix", yaml: `
+exporters:
+  - {type: ipfix, ipfix: {address: "127.0.0.1:4739", enterpriseID: 12345}}`,
+			exporters: []Exporter{&IPFIX{}}},
+		{name: "prometheus", yaml: `
+exporters:
+  - {type: prometheus, prometheus: {metrics: [{name: workload_bytes_total, labels: [SrcNamespace]}]}}`,
+			exporters: []Exporter{&Prometheus{}}},
+		{name: "opensearch", yaml: `
+exporters:
+  - {type: opensearch, opensearch: {url: "https://opensearch:9200"}}`,
+			exporters: []Exporter{&OpenSearch{}}},
+		{name: "otlp", yaml: `
+exporters:
+  - {type: otlp, otlp: {endpoint: "http://otel-collector:4318", protocol: http/protobuf}}`,
+			exporters: []Exporter{&OTLP{}}},
+		{name: "file", yaml: `
+exporters:
+  - {type: file, file: {path: ` + path.Join(dir, "flows.log") + `}}`,
+			exporters: []Exporter{&File{}}},
+		{name: "parquet", yaml: `
+exporters:
+  - {type: parquet, parquet: {path: ` + path.Join(dir, "flows") + `}}`,
+			exporters: []Exporter{&Parquet{}}},
+		{name: "grpc", yaml: `
+exporters:
+  - {type: grpc, grpc: {listen: "127.0.0.1:0"}}`,
+			exporters: []Exporter{&GRPC{}}},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			// GIVEN a configuration
+			cfg, err := config.Read(strings.NewReader(testCase.yaml))
+			require.NoError(t, err)
+
+			// WHEN the exporter is created
+			exporter, err := NewExporter(cfg, health.NewReporter(health.Starting))
+			require.NoError(t, err)
+			defer exporter.Close()
+
+			// THEN it is of the configured type
+			if len(testCase.exporters) == 1 {
+				assert.IsType(t, testCase.exporters[0], exporter)
+				return
+			}
+			// OR it fans out the records to all the configured exporters
+			require.IsType(t, &Fanout{}, exporter)
+			fanout := exporter.(*Fanout)
+			assert.Equal(t, testCase.names, fanout.names)
+			require.Len(t, fanout.exporters, len(testCase.exporters))
+			for i := range testCase.exporters {
+				assert.IsType(t, testCase.exporters[i], fanout.exporters[i])
+			}
+		})
+	}
+}
+
+func TestNewExporter_Invalid(t *testing.T) {
+	for _, exporter := range []string{
+		// unknown type
+		"{type: foo}",
+		// invalid configuration
+		"{type: kafka, kafka: {brokers: [kafka:9092]}}",
+		// exposed without TLS
+		"{type: grpc, grpc: {listen: ':9999'}}",
+	} {
+		cfg, err := config.Read(strings.NewReader("exporters: [" + exporter + "]"))
+		require.NoError(t, err)
+		_, err = NewExporter(cfg, health.NewReporter(health.Starting))
+		assert.Error(t, err, exporter)
+	}
+
+	// the metrics can't be registered twice
+	cfg, err := config.Read(strings.NewReader(`
+exporters:
+  - {type: prometheus, prometheus: {metrics: [{name: workload_bytes_total, labels: [SrcNamespace]}]}}`))
+	require.NoError(t, err)
+	reporter := health.NewReporter(health.Starting)
+	exporter, err := NewExporter(cfg, reporter)
+	require.NoError(t, err)
+	defer exporter.Close()
+	_, err = NewExporter(cfg, reporter)
+	assert.Error(t, err)
+}
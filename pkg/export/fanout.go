@@ -0,0 +1,138 @@
+package export
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/netobserv/goflow2-kube-enricher/pkg/health"
+)
+
+var flog = logrus.WithField("module", "export/fanout")
+
+// Fanout forwards each record to multiple exporters. Since exporters might modify the records
+// (e.g. Loki removes the fields that are used as labels), each exporter receives its own copy.
+// A failing exporter does not prevent the record from being forwarded to the rest
+type Fanout struct {
+	exporters []Exporter
+	names     []string
+	reporter  *health.Reporter
+	failures  FailureHandler
+}
+
+// ExporterError is the error of one of the exporters of a Fanout, identified by its name, so
+// the record can be sent again to that exporter only
+type ExporterError struct {
+	Exporter string
+	Err      error
+}
+
+func (e *ExporterError) Error() string {
+	return e.Exporter + ": " + e.Err.Error()
+}
+
+func (e *ExporterError) Unwrap() error {
+	return e.Err
+}
+
+// NewFanout creates a Fanout exporter. The names are used to identify each exporter in the
+// logs and metrics
+func NewFanout(exporters []Exporter, names []string, reporter *health.Reporter) *Fanout {
+	return &Fanout{
+		exporters: exporters,
+		names:     names,
+		reporter:  reporter,
+	}
+}
+
+// ProcessRecord forwards the record to all the exporters. It only returns an error if the
+// record couldn't be forwarded to any of them. If only some of them failed, the record is
+// passed to the failure handler, if set, once per failed exporter along with an ExporterError
+func (f *Fanout) ProcessRecord(record map[string]interface{}) error {
+	var errs []error
+	for i, exporter := range f.exporters {
+		rec := record
+		// the last exporter can get the original record, since nobody else is going to use it,
+		// unless the original might be passed to the failure handler
+		if i < len(f.exporters)-1 || f.failures != nil {
+			rec = CopyRecord(record)
+		}
+		if err := exporter.ProcessRecord(rec); err != nil {
+			flog.WithError(err).WithField("exporter", f.names[i]).Debug("can't export record")
+			f.reporter.RecordExportFailed(f.names[i])
+			errs = append(errs, &ExporterError{Exporter: f.names[i], Err: err})
+		}
+	}
+	if len(errs) == len(f.exporters) {
+		return joinErrors(errs)
+	}
+	if f.failures != nil {
+		for _, err := range errs {
+			f.failures([]map[string]interface{}{record}, err)
+		}
+	}
+	return nil
+}
+
+// Flush flushes all the exporters. Unlike ProcessRecord, it returns an error if the records
+// couldn't be delivered by any of them, since the records aren't known anymore and can't be
+// passed to the failure handler: they are processed again by all the exporters instead of
+// being lost for some of them
+func (f *Fanout) Flush() error {
+	var errs []error
+	for i, exporter := range f.exporters {
+		if err := Flush(exporter); err != nil {
+			errs = append(errs, &ExporterError{Exporter: f.names[i], Err: err})
+		}
+	}
+	if len(errs) > 0 {
+		return joinErrors(errs)
+	}
+	return nil
+}
+
+// SetFailureHandler sets the handler of the records that some of the exporters failed to
+// process or deliver. The errors are ExporterErrors, identifying the exporter that failed
+func (f *Fanout) SetFailureHandler(handler FailureHandler) {
+	f.failures = handler
+	for i, exporter := range f.exporters {
+		name := f.names[i]
+		SetFailureHandler(exporter, func(records []map[string]interface{}, err error) {
+			handler(records, &ExporterError{Exporter: name, Err: err})
+		})
+	}
+}
+
+func joinErrors(errs []error) error {
+	msgs := make([]string, 0, len(errs))
+	for _, err := range errs {
+		msgs = append(msgs, err.Error())
+	}
+	return errors.New(strings.Join(msgs, "; "))
+}
+
+// Close all the exporters
+func (f *Fanout) Close() error {
+	var errs []string
+	for i, exporter := range f.exporters {
+		if err := exporter.Close(); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", f.names[i], err.Error()))
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// CopyRecord returns a shallow copy of the record. The records' values are scalars, or
+// slices that aren't modified by the exporters
+func CopyRecord(record map[string]interface{}) map[string]interface{} {
+	cp := make(map[string]interface{}, len(record))
+	for k, v := range record {
+		cp[k] = v
+	}
+	return cp
+}
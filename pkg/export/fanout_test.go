@@ -0,0 +1,129 @@
+package export
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/netobserv/goflow2-kube-enricher/pkg/health"
+)
+
+// fakeExporter stores the received records and removes the configured fields from them
+type fakeExporter struct {
+	records []map[string]interface{}
+	remove  string
+	err     error
+	closed  bool
+}
+
+func (f *fakeExporter) ProcessRecord(record map[string]interface{}) error {
+	if f.err != nil {
+		return f.err
+	}
+	delete(record, f.remove)
+	f.records = append(f.records, record)
+	return nil
+}
+
+func (f *fakeExporter) Close() error {
+	f.closed = true
+	return nil
+}
+
+func TestFanout_IndependentCopies(t *testing.T) {
+	first := &fakeExporter{remove: "foo"}
+	second := &fakeExporter{remove: "bar"}
+	fanout := NewFanout([]Exporter{first, second}, []string{"first", "second"}, health.NewReporter(health.Ready))
+
+	require.NoError(t, fanout.ProcessRecord(map[string]interface{}{"foo": 1, "bar": 2, "baz": 3}))
+
+	require.Len(t, first.records, 1)
+	assert.Equal(t, map[string]interface{}{"bar": 2, "baz": 3}, first.records[0])
+	require.Len(t, second.records, 1)
+	assert.Equal(t, map[string]interface{}{"foo": 1, "baz": 3}, second.records[0])
+
+	require.NoError(t, fanout.Close())
+	assert.True(t, first.closed)
+	assert.True(t, second.closed)
+}
+
+func TestFanout_IndependentFailures(t *testing.T) {
+	failing := &fakeExporter{err: errors.New("boom")}
+	working := &fakeExporter{}
+	fanout := NewFanout([]Exporter{failing, working}, []string{"failing", "working"}, health.NewReporter(health.Ready))
+
+	// a failing exporter does not prevent the others from receiving the records
+	require.NoError(t, fanout.ProcessRecord(map[string]interface{}{"foo": 1}))
+	assert.Len(t, working.records, 1)
+
+	// an error is only returned when all the exporters fail
+	working.err = errors.New("bang")
+	err := fanout.ProcessRecord(map[string]interface{}{"foo": 1})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failing: boom")
+	assert.Contains(t, err.Error(), "working: bang")
+}
+
+// flushingExporter fails to flush if err is set
+type flushingExporter struct {
+	fakeExporter
+	flushErr error
+}
+
+func (f *flushingExporter) Flush() error {
+	return f.flushErr
+}
+
+func TestFanout_FailureHandler(t *testing.T) {
+	// GIVEN a fanout with a failing exporter and a handler of the failures
+	failing := &fakeExporter{err: errors.New("boom"), remove: "foo"}
+	working := &fakeExporter{remove: "foo"}
+	fanout := NewFanout([]Exporter{working, failing}, []string{"working", "failing"}, health.NewReporter(health.Ready))
+	var failed []map[string]interface{}
+	var failures []error
+	fanout.SetFailureHandler(func(records []map[string]interface{}, err error) {
+		failed = append(failed, records...)
+		failures = append(failures, err)
+	})
+
+	// WHEN a record is delivered by some exporters only
+	require.NoError(t, fanout.ProcessRecord(map[string]interface{}{"foo": 1}))
+
+	// THEN the original record is passed to the handler, with the exporter that failed
+	assert.Equal(t, []map[string]interface{}{{"foo": 1}}, failed)
+	require.Len(t, failures, 1)
+	var exporterErr *ExporterError
+	require.True(t, errors.As(failures[0], &exporterErr))
+	assert.Equal(t, "failing", exporterErr.Exporter)
+	assert.EqualError(t, failures[0], "failing: boom")
+
+	// AND the records that failed in all the exporters are returned as errors instead
+	working.err = errors.New("bang")
+	require.Error(t, fanout.ProcessRecord(map[string]interface{}{"foo": 2}))
+	assert.Len(t, failed, 1)
+}
+
+func TestFanout_Flush(t *testing.T) {
+	failing := &flushingExporter{flushErr: errors.New("loki is unavailable")}
+	working := &flushingExporter{}
+	fanout := NewFanout([]Exporter{failing, working}, []string{"failing", "working"}, health.NewReporter(health.Ready))
+
+	// the records that an exporter couldn't deliver are reported, even if the others delivered them
+	err := fanout.Flush()
+	require.Error(t, err)
+	assert.Equal(t, "failing: loki is unavailable", err.Error())
+
+	failing.flushErr = nil
+	assert.NoError(t, fanout.Flush())
+}
+
+func TestWriter(t *testing.T) {
+	out := bytes.Buffer{}
+	w := NewWriter(&out)
+	require.NoError(t, w.ProcessRecord(map[string]interface{}{"foo": "bar", "baz": 1}))
+	require.NoError(t, w.ProcessRecord(map[string]interface{}{"foo": "bae"}))
+	assert.Equal(t, "{\"baz\":1,\"foo\":\"bar\"}\n{\"foo\":\"bae\"}\n", out.String())
+}
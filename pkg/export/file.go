@@ -0,0 +1,115 @@
+package export
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"sync"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/netobserv/goflow2-kube-enricher/pkg/config"
+)
+
+var filelog = logrus.WithField("module", "export/file")
+
+// File exports the records into a local file that is rotated by size and time. The records
+// are written as JSON lines, CSV rows or length-delimited protobuf messages
+type File struct {
+	config *config.FileConfig
+	mt     sync.Mutex
+	out    *rotatingFile
+	encode func(record map[string]interface{}) ([]byte, error)
+}
+
+func NewFile(cfg *config.FileConfig) (*File, error) {
+	f := &File{
+		config: cfg,
+		out: &rotatingFile{
+			path:       cfg.Path,
+			maxSize:    cfg.MaxSize,
+			interval:   cfg.RotateInterval,
+			compress:   cfg.Compress,
+			maxBackups: cfg.MaxBackups,
+			timeNow:    time.Now,
+		},
+	}
+	switch cfg.Format {
+	case config.JSONFlagName:
+		f.encode = encodeJSONLine
+	case config.CSVFlagName:
+		header, err := encodeCSV(cfg.Columns)
+		if err != nil {
+			return nil, err
+		}
+		f.out.header = header
+		f.encode = f.encodeCSVRow
+	case config.PBFlagName:
+		f.encode = enrichedEndpoints{srcPrefix: cfg.SrcPrefix, dstPrefix: cfg.DstPrefix}.encodeDelimitedProtobuf
+	default:
+		return nil, fmt.Errorf("unknown format: %q", cfg.Format)
+	}
+	// opening the file in advance, to fail early if it is not writable
+	if err := f.out.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *File) ProcessRecord(record map[string]interface{}) error {
+	data, err := f.encode(record)
+	if err != nil {
+		return err
+	}
+	f.mt.Lock()
+	defer f.mt.Unlock()
+	_, err = f.out.Write(data)
+	return err
+}
+
+// Close syncs the file to disk and waits for the rotated files to be compressed
+func (f *File) Close() error {
+	f.mt.Lock()
+	defer f.mt.Unlock()
+	return f.out.Close()
+}
+
+func (f *File) encodeCSVRow(record map[string]interface{}) ([]byte, error) {
+	row := make([]string, len(f.config.Columns))
+	for i, column := range f.config.Columns {
+		if val, ok := record[column]; ok && val != nil {
+			row[i] = fmt.Sprint(val)
+		}
+	}
+	return encodeCSV(row)
+}
+
+func encodeCSV(row []string) ([]byte, error) {
+	buf := bytes.Buffer{}
+	w := csv.NewWriter(&buf)
+	if err := w.Write(row); err != nil {
+		return nil, err
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+func encodeJSONLine(record map[string]interface{}) ([]byte, error) {
+	js, err := jsoniter.ConfigCompatibleWithStandardLibrary.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	return append(js, '\n'), nil
+}
+
+// encodeDelimitedProtobuf prefixes the protobuf message with its varint-encoded length
+func (e enrichedEndpoints) encodeDelimitedProtobuf(record map[string]interface{}) ([]byte, error) {
+	msg, err := e.encodeProtobuf(record)
+	if err != nil {
+		return nil, err
+	}
+	return append(protowire.AppendVarint(nil, uint64(len(msg))), msg...), nil
+}
@@ -0,0 +1,213 @@
+package export
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/netobserv/goflow2-kube-enricher/pkg/config"
+	goflowkubev1 "github.com/netobserv/goflow2-kube-enricher/pkg/pb/goflowkube/v1"
+)
+
+func testFileConfig(t *testing.T, format string) (config.FileConfig, func()) {
+	dir, err := ioutil.TempDir("", "file-exporter")
+	require.NoError(t, err)
+	return config.FileConfig{
+		Path:       path.Join(dir, "flows.log"),
+		Format:     format,
+		MaxSize:    1024 * 1024,
+		MaxBackups: 10,
+		SrcPrefix:  "Src",
+		DstPrefix:  "Dst",
+	}, func() { os.RemoveAll(dir) }
+}
+
+func readFile(t *testing.T, file string) string {
+	content, err := ioutil.ReadFile(file)
+	require.NoError(t, err)
+	return string(content)
+}
+
+func TestFile_JSON(t *testing.T) {
+	// GIVEN a file exporter with the JSON format
+	cfg, cleanup := testFileConfig(t, config.JSONFlagName)
+	defer cleanup()
+	exporter, err := NewFile(&cfg)
+	require.NoError(t, err)
+
+	// WHEN some records are exported
+	require.NoError(t, exporter.ProcessRecord(map[string]interface{}{"foo": "bar", "baz": 1}))
+	require.NoError(t, exporter.ProcessRecord(map[string]interface{}{"foo": "bae"}))
+	require.NoError(t, exporter.Close())
+
+	// THEN they are written as JSON lines
+	assert.Equal(t, "{\"baz\":1,\"foo\":\"bar\"}\n{\"foo\":\"bae\"}\n", readFile(t, cfg.Path))
+
+	// AND new records are appended to the existing file after a restart
+	exporter, err = NewFile(&cfg)
+	require.NoError(t, err)
+	require.NoError(t, exporter.ProcessRecord(map[string]interface{}{"foo": "bai"}))
+	require.NoError(t, exporter.Close())
+	assert.Equal(t, "{\"baz\":1,\"foo\":\"bar\"}\n{\"foo\":\"bae\"}\n{\"foo\":\"bai\"}\n",
+		readFile(t, cfg.Path))
+}
+
+func TestFile_CSV(t *testing.T) {
+	// GIVEN a file exporter with the CSV format
+	cfg, cleanup := testFileConfig(t, config.CSVFlagName)
+	defer cleanup()
+	cfg.Columns = []string{"SrcPod", "DstPod", "Bytes"}
+	exporter, err := NewFile(&cfg)
+	require.NoError(t, err)
+
+	// WHEN some records are exported
+	require.NoError(t, exporter.ProcessRecord(map[string]interface{}{
+		"SrcPod": "pod-1", "DstPod": "pod-2", "Bytes": 123, "Packets": 1,
+	}))
+	require.NoError(t, exporter.ProcessRecord(map[string]interface{}{
+		"SrcPod": "pod,3", "Bytes": float64(456),
+	}))
+	require.NoError(t, exporter.Close())
+
+	// THEN the file starts with a header
+	// AND only the configured columns are written, leaving the missing fields empty
+	assert.Equal(t, "SrcPod,DstPod,Bytes\npod-1,pod-2,123\n\"pod,3\",,456\n", readFile(t, cfg.Path))
+}
+
+func TestFile_Protobuf(t *testing.T) {
+	// GIVEN a file exporter with the protobuf format
+	cfg, cleanup := testFileConfig(t, config.PBFlagName)
+	defer cleanup()
+	exporter, err := NewFile(&cfg)
+	require.NoError(t, err)
+
+	// WHEN some records are exported
+	require.NoError(t, exporter.ProcessRecord(map[string]interface{}{"SrcPod": "pod-1", "Bytes": 123}))
+	require.NoError(t, exporter.ProcessRecord(map[string]interface{}{"SrcPod": "pod-2"}))
+	require.NoError(t, exporter.Close())
+
+	// THEN they are written as length-delimited EnrichedFlow messages
+	content := []byte(readFile(t, cfg.Path))
+	var records []*goflowkubev1.EnrichedFlow
+	for len(content) > 0 {
+		length, n := protowire.ConsumeVarint(content)
+		require.Greater(t, n, 0)
+		content = content[n:]
+		msg := &goflowkubev1.EnrichedFlow{}
+		require.NoError(t, proto.Unmarshal(content[:length], msg))
+		records = append(records, msg)
+		content = content[length:]
+	}
+	require.Len(t, records, 2)
+	assert.Equal(t, "pod-1", records[0].Src.Pod)
+	assert.Equal(t, uint64(123), records[0].Flow.Bytes)
+	assert.Equal(t, "pod-2", records[1].Src.Pod)
+	assert.Zero(t, records[1].Flow.Bytes)
+}
+
+func TestFile_SizeRotation(t *testing.T) {
+	// GIVEN a file exporter that rotates each 2 records, keeping 2 compressed backups
+	cfg, cleanup := testFileConfig(t, config.JSONFlagName)
+	defer cleanup()
+	cfg.MaxSize = 25
+	cfg.Compress = true
+	cfg.MaxBackups = 2
+	exporter, err := NewFile(&cfg)
+	require.NoError(t, err)
+	now := time.Date(2021, 11, 21, 13, 37, 0, 0, time.UTC)
+	exporter.out.timeNow = func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	}
+
+	// WHEN 7 records are exported
+	for i := 0; i < 7; i++ {
+		require.NoError(t, exporter.ProcessRecord(map[string]interface{}{"Bytes": i}))
+	}
+	require.NoError(t, exporter.Close())
+
+	// THEN the current file contains the last record
+	assert.Equal(t, "{\"Bytes\":6}\n", readFile(t, cfg.Path))
+	// AND only the newest 2 rotated files are kept, gzipped
+	entries, err := ioutil.ReadDir(path.Dir(cfg.Path))
+	require.NoError(t, err)
+	var backups []string
+	for _, e := range entries {
+		if e.Name() != "flows.log" {
+			backups = append(backups, e.Name())
+		}
+	}
+	sort.Strings(backups)
+	require.Len(t, backups, 2)
+	for _, b := range backups {
+		assert.Regexp(t, `^flows-20211121T1337\d\d\.000\.log\.gz$`, b)
+	}
+	in, err := os.Open(path.Join(path.Dir(cfg.Path), backups[1]))
+	require.NoError(t, err)
+	defer in.Close()
+	gz, err := gzip.NewReader(in)
+	require.NoError(t, err)
+	content, err := ioutil.ReadAll(gz)
+	require.NoError(t, err)
+	assert.Equal(t, "{\"Bytes\":4}\n{\"Bytes\":5}\n", string(content))
+}
+
+func TestFile_TimeRotation(t *testing.T) {
+	// GIVEN a file exporter with CSV format that rotates every hour
+	cfg, cleanup := testFileConfig(t, config.CSVFlagName)
+	defer cleanup()
+	cfg.Columns = []string{"Bytes"}
+	cfg.RotateInterval = time.Hour
+	now := time.Date(2021, 11, 21, 13, 37, 0, 0, time.UTC)
+	exporter, err := NewFile(&cfg)
+	require.NoError(t, err)
+	exporter.out.timeNow = func() time.Time { return now }
+	exporter.out.opened = now
+
+	// WHEN a record is exported before and after the rotation interval
+	require.NoError(t, exporter.ProcessRecord(map[string]interface{}{"Bytes": 1}))
+	now = now.Add(time.Hour)
+	require.NoError(t, exporter.ProcessRecord(map[string]interface{}{"Bytes": 2}))
+	require.NoError(t, exporter.Close())
+
+	// THEN each record is written in a different file, each one with its own header
+	assert.Equal(t, "Bytes\n2\n", readFile(t, cfg.Path))
+	rotated := path.Join(path.Dir(cfg.Path), "flows-20211121T143700.000.log")
+	assert.Equal(t, "Bytes\n1\n", readFile(t, rotated))
+}
+
+func TestFile_RotationWithinSameMillisecond(t *testing.T) {
+	// GIVEN a file exporter that rotates on each record, and whose clock doesn't advance
+	cfg, cleanup := testFileConfig(t, config.JSONFlagName)
+	defer cleanup()
+	cfg.MaxSize = 1
+	cfg.MaxBackups = 2
+	exporter, err := NewFile(&cfg)
+	require.NoError(t, err)
+	now := time.Date(2021, 11, 21, 13, 37, 0, 0, time.UTC)
+	exporter.out.timeNow = func() time.Time { return now }
+
+	// WHEN the file is rotated several times back to back
+	for i := 0; i < 4; i++ {
+		require.NoError(t, exporter.ProcessRecord(map[string]interface{}{"Bytes": i}))
+	}
+	require.NoError(t, exporter.Close())
+
+	// THEN no rotated file is overwritten, and the newest ones are kept
+	dir := path.Dir(cfg.Path)
+	assert.Equal(t, "{\"Bytes\":3}\n", readFile(t, cfg.Path))
+	assert.Equal(t, "{\"Bytes\":1}\n", readFile(t, path.Join(dir, "flows-20211121T133700.000-1.log")))
+	assert.Equal(t, "{\"Bytes\":2}\n", readFile(t, path.Join(dir, "flows-20211121T133700.000-2.log")))
+	entries, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 3)
+}
@@ -0,0 +1,273 @@
+package export
+
+import (
+	"crypto/tls"
+	"net"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/netobserv/goflow2-kube-enricher/pkg/config"
+	"github.com/netobserv/goflow2-kube-enricher/pkg/fields"
+	"github.com/netobserv/goflow2-kube-enricher/pkg/health"
+	goflowkubev1 "github.com/netobserv/goflow2-kube-enricher/pkg/pb/goflowkube/v1"
+)
+
+var glog = logrus.WithField("module", "export/grpc")
+
+// GRPC exporter serves the records to the subscribers of the goflowkube.v1.FlowService. Each
+// subscriber has a bounded buffer, so slow subscribers never block the processing of the
+// records: the records that don't fit in the buffer are dropped for that subscriber
+type GRPC struct {
+	goflowkubev1.UnimplementedFlowServiceServer
+	config    *config.GRPCConfig
+	endpoints enrichedEndpoints
+	server    *grpc.Server
+	listener  net.Listener
+	metrics   *grpcMetrics
+	mt        sync.RWMutex
+	subs      map[*grpcSubscriber]struct{}
+}
+
+type grpcMetrics struct {
+	subscribers prometheus.Gauge
+	recordsSent prometheus.Counter
+	dropped     prometheus.Counter
+}
+
+type grpcSubscriber struct {
+	filter  *goflowkubev1.FlowFilter
+	records chan *goflowkubev1.EnrichedFlow
+	// dropped records for this subscriber. It is only modified while holding the exporter lock
+	dropped uint64
+}
+
+// NewGRPC starts listening for subscriptions in the configured address. If tlsConfig is not
+// nil, the connections are encrypted and, if it requires client certificates, authenticated
+func NewGRPC(cfg *config.GRPCConfig, tlsConfig *tls.Config, name string, reporter *health.Reporter) (*GRPC, error) {
+	metrics, err := newGRPCMetrics(name, reporter)
+	if err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("tcp", cfg.Listen)
+	if err != nil {
+		return nil, err
+	}
+	var opts []grpc.ServerOption
+	if tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	g := &GRPC{
+		config:    cfg,
+		endpoints: enrichedEndpoints{srcPrefix: cfg.SrcPrefix, dstPrefix: cfg.DstPrefix},
+		server:    grpc.NewServer(opts...),
+		listener:  listener,
+		metrics:   metrics,
+		subs:      map[*grpcSubscriber]struct{}{},
+	}
+	goflowkubev1.RegisterFlowServiceServer(g.server, g)
+	go func() {
+		if err := g.server.Serve(listener); err != nil {
+			glog.WithError(err).Warn("gRPC server stopped")
+		}
+	}()
+	glog.WithField("address", listener.Addr().String()).Info("gRPC flows service started")
+	return g, nil
+}
+
+func newGRPCMetrics(name string, reporter *health.Reporter) (*grpcMetrics, error) {
+	subscribers, err := reporter.Register(prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "exporter_grpc_subscribers",
+			Help: "Number of active subscribers of the gRPC flows service.",
+		},
+		[]string{"exporter"},
+	))
+	if err != nil {
+		return nil, err
+	}
+	recordsSent, err := reporter.Register(prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "exporter_grpc_record_sent",
+			Help: "Number of records that have been sent to the gRPC subscribers.",
+		},
+		[]string{"exporter"},
+	))
+	if err != nil {
+		return nil, err
+	}
+	dropped, err := reporter.Register(prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "exporter_grpc_record_dropped",
+			Help: "Number of records that have been dropped because the buffer of a gRPC subscriber was full.",
+		},
+		[]string{"exporter"},
+	))
+	if err != nil {
+		return nil, err
+	}
+	return &grpcMetrics{
+		subscribers: subscribers.(*prometheus.GaugeVec).WithLabelValues(name),
+		recordsSent: recordsSent.(*prometheus.CounterVec).WithLabelValues(name),
+		dropped:     dropped.(*prometheus.CounterVec).WithLabelValues(name),
+	}, nil
+}
+
+// Addr returns the address where the gRPC server is listening
+func (g *GRPC) Addr() net.Addr {
+	return g.listener.Addr()
+}
+
+// ProcessRecord forwards the record to the buffers of the matching subscribers, without
+// blocking
+func (g *GRPC) ProcessRecord(record map[string]interface{}) error {
+	g.mt.Lock()
+	defer g.mt.Unlock()
+	var flow *goflowkubev1.EnrichedFlow
+	for sub := range g.subs {
+		if !matchesFilter(sub.filter, record) {
+			continue
+		}
+		if flow == nil {
+			// converting the record only once, and only if anybody is interested in it
+			flow = g.endpoints.toEnrichedFlow(record)
+		}
+		select {
+		case sub.records <- flow:
+		default:
+			sub.dropped++
+			g.metrics.dropped.Inc()
+		}
+	}
+	return nil
+}
+
+// Subscribe implements the FlowService. It streams the matching records until the client
+// cancels the subscription or the exporter is closed
+func (g *GRPC) Subscribe(req *goflowkubev1.SubscribeRequest, stream goflowkubev1.FlowService_SubscribeServer) error {
+	sub, err := g.subscribe(req.Filter)
+	if err != nil {
+		return err
+	}
+	defer g.unsubscribe(sub)
+	sublog := glog
+	if p, ok := peer.FromContext(stream.Context()); ok {
+		sublog = glog.WithField("client", p.Addr.String())
+	}
+	sublog.WithField("filter", req.Filter.String()).Debug("new subscription")
+	for {
+		select {
+		case <-stream.Context().Done():
+			g.mt.RLock()
+			sublog.WithField("dropped", sub.dropped).Debug("subscription finished")
+			g.mt.RUnlock()
+			return nil
+		case flow, ok := <-sub.records:
+			if !ok {
+				return status.Error(codes.Unavailable, "the flows service is shutting down")
+			}
+			g.mt.RLock()
+			dropped := sub.dropped
+			g.mt.RUnlock()
+			if err := stream.Send(&goflowkubev1.SubscribeResponse{Flow: flow, Dropped: dropped}); err != nil {
+				sublog.WithError(err).Debug("can't send flow. Closing subscription")
+				return err
+			}
+			g.metrics.recordsSent.Inc()
+		}
+	}
+}
+
+func (g *GRPC) subscribe(filter *goflowkubev1.FlowFilter) (*grpcSubscriber, error) {
+	g.mt.Lock()
+	defer g.mt.Unlock()
+	if g.subs == nil {
+		return nil, status.Error(codes.Unavailable, "the flows service is shutting down")
+	}
+	if g.config.MaxSubscribers > 0 && len(g.subs) >= g.config.MaxSubscribers {
+		return nil, status.Errorf(codes.ResourceExhausted,
+			"maximum number of subscribers reached: %d", g.config.MaxSubscribers)
+	}
+	sub := &grpcSubscriber{
+		filter:  filter,
+		records: make(chan *goflowkubev1.EnrichedFlow, g.config.BufferSize),
+	}
+	g.subs[sub] = struct{}{}
+	g.metrics.subscribers.Inc()
+	return sub, nil
+}
+
+func (g *GRPC) unsubscribe(sub *grpcSubscriber) {
+	g.mt.Lock()
+	defer g.mt.Unlock()
+	if _, ok := g.subs[sub]; ok {
+		delete(g.subs, sub)
+		g.metrics.subscribers.Dec()
+	}
+}
+
+// Close finishes all the subscriptions and stops the gRPC server
+func (g *GRPC) Close() error {
+	g.mt.Lock()
+	for sub := range g.subs {
+		close(sub.records)
+		g.metrics.subscribers.Dec()
+	}
+	g.subs = nil
+	g.mt.Unlock()
+	g.server.Stop()
+	return nil
+}
+
+// matchesFilter returns whether the record matches all the non-empty lists of the filter
+func matchesFilter(filter *goflowkubev1.FlowFilter, record map[string]interface{}) bool {
+	if filter == nil {
+		return true
+	}
+	return matchesAnyString(filter.Namespaces, record, "SrcNamespace", "DstNamespace") &&
+		matchesAnyString(filter.Workloads, record, "SrcWorkload", "DstWorkload") &&
+		matchesAnyNumber(filter.Protos, record, "Proto") &&
+		matchesAnyNumber(filter.Ports, record, "SrcPort", "DstPort")
+}
+
+func matchesAnyString(values []string, record map[string]interface{}, fields ...string) bool {
+	if len(values) == 0 {
+		return true
+	}
+	for _, field := range fields {
+		str, ok := record[field].(string)
+		if !ok {
+			continue
+		}
+		for _, v := range values {
+			if str == v {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func matchesAnyNumber(values []uint32, record map[string]interface{}, names ...string) bool {
+	if len(values) == 0 {
+		return true
+	}
+	for _, field := range names {
+		num, ok := fields.Int64(record[field])
+		if !ok {
+			continue
+		}
+		for _, v := range values {
+			if num == int64(v) {
+				return true
+			}
+		}
+	}
+	return false
+}
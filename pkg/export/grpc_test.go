@@ -0,0 +1,223 @@
+package export
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+
+	"github.com/netobserv/goflow2-kube-enricher/pkg/config"
+	"github.com/netobserv/goflow2-kube-enricher/pkg/health"
+	"github.com/netobserv/goflow2-kube-enricher/pkg/internal/mock"
+	goflowkubev1 "github.com/netobserv/goflow2-kube-enricher/pkg/pb/goflowkube/v1"
+)
+
+func testGRPCConfig() config.GRPCConfig {
+	return config.GRPCConfig{
+		Listen: "127.0.0.1:0", BufferSize: 10, MaxSubscribers: 2, SrcPrefix: "Src", DstPrefix: "Dst",
+	}
+}
+
+func subscribeGRPC(t *testing.T, conn *grpc.ClientConn, filter *goflowkubev1.FlowFilter) (
+	goflowkubev1.FlowService_SubscribeClient, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := goflowkubev1.NewFlowServiceClient(conn).
+		Subscribe(ctx, &goflowkubev1.SubscribeRequest{Filter: filter})
+	require.NoError(t, err)
+	return stream, cancel
+}
+
+// waitSubscribers waits until the exporter has the given number of subscribers, since the
+// subscriptions are registered asynchronously
+func waitSubscribers(t *testing.T, exporter *GRPC, subscribers int) {
+	require.Eventually(t, func() bool {
+		exporter.mt.RLock()
+		defer exporter.mt.RUnlock()
+		return len(exporter.subs) == subscribers
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestGRPC_Subscribe(t *testing.T) {
+	// GIVEN a gRPC exporter
+	reporter := health.NewReporter(health.Ready)
+	cfg := testGRPCConfig()
+	exporter, err := NewGRPC(&cfg, nil, "grpc", reporter)
+	require.NoError(t, err)
+	defer exporter.Close()
+	conn, err := grpc.Dial(exporter.Addr().String(), grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// AND a subscriber to the TCP flows of the ns-1 namespace
+	nsStream, cancel := subscribeGRPC(t, conn, &goflowkubev1.FlowFilter{
+		Namespaces: []string{"ns-1"}, Protos: []uint32{6},
+	})
+	defer cancel()
+	// AND a subscriber to any flow on port 53
+	portStream, cancel := subscribeGRPC(t, conn, &goflowkubev1.FlowFilter{Ports: []uint32{53}})
+	defer cancel()
+	waitSubscribers(t, exporter, 2)
+
+	// WHEN some records are exported
+	for _, record := range []map[string]interface{}{
+		{"SrcNamespace": "ns-2", "DstNamespace": "ns-1", "Proto": uint32(6), "Bytes": 1},
+		{"SrcNamespace": "ns-1", "Proto": uint32(17), "DstPort": uint32(53), "Bytes": 2},
+		{"SrcNamespace": "ns-2", "Proto": uint32(6), "Bytes": 3},
+		{"DstNamespace": "ns-1", "Proto": float64(6), "Bytes": 4},
+	} {
+		require.NoError(t, exporter.ProcessRecord(record))
+	}
+
+	// THEN each subscriber receives the records matching its filter
+	resp, err := nsStream.Recv()
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, resp.Flow.Flow.Bytes)
+	assert.Equal(t, "ns-1", resp.Flow.Dst.Namespace)
+	assert.Zero(t, resp.Dropped)
+	resp, err = nsStream.Recv()
+	require.NoError(t, err)
+	assert.EqualValues(t, 4, resp.Flow.Flow.Bytes)
+
+	resp, err = portStream.Recv()
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, resp.Flow.Flow.Bytes)
+	assert.EqualValues(t, 53, resp.Flow.Flow.DstPort)
+
+	// AND the subscriptions are reported in the metrics
+	hr := health.NewHTTPReporter(reporter)
+	metrics := scrapeMetrics(t, hr)
+	assert.Contains(t, metrics, `exporter_grpc_subscribers{exporter="grpc"} 2`)
+	assert.Contains(t, metrics, `exporter_grpc_record_sent{exporter="grpc"} 3`)
+
+	// AND WHEN a subscriber cancels its subscription
+	cancel()
+	// THEN it is removed from the exporter
+	waitSubscribers(t, exporter, 1)
+	assert.Contains(t, scrapeMetrics(t, hr), `exporter_grpc_subscribers{exporter="grpc"} 1`)
+}
+
+func TestGRPC_SlowSubscriber(t *testing.T) {
+	// GIVEN a gRPC exporter whose subscribers can buffer 2 records
+	reporter := health.NewReporter(health.Ready)
+	cfg := testGRPCConfig()
+	cfg.BufferSize = 2
+	exporter, err := NewGRPC(&cfg, nil, "grpc", reporter)
+	require.NoError(t, err)
+	defer exporter.Close()
+
+	// AND a subscriber that doesn't consume its records
+	sub, err := exporter.subscribe(nil)
+	require.NoError(t, err)
+
+	// WHEN more records than the buffer size are exported
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 5; i++ {
+			require.NoError(t, exporter.ProcessRecord(map[string]interface{}{"Bytes": i}))
+		}
+		close(done)
+	}()
+
+	// THEN the export is not blocked
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "the exporter should not block on slow subscribers")
+	}
+	// AND the records that don't fit in the buffer are dropped
+	assert.Len(t, sub.records, 2)
+	assert.EqualValues(t, 3, sub.dropped)
+	assert.Contains(t, getMetrics(t, reporter), `exporter_grpc_record_dropped{exporter="grpc"} 3`)
+}
+
+func TestGRPC_MaxSubscribers(t *testing.T) {
+	// GIVEN a gRPC exporter that accepts up to 2 subscribers
+	cfg := testGRPCConfig()
+	exporter, err := NewGRPC(&cfg, nil, "grpc", health.NewReporter(health.Ready))
+	require.NoError(t, err)
+	defer exporter.Close()
+	conn, err := grpc.Dial(exporter.Addr().String(), grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+	for i := 0; i < 2; i++ {
+		_, cancel := subscribeGRPC(t, conn, nil)
+		defer cancel()
+	}
+	waitSubscribers(t, exporter, 2)
+
+	// WHEN a third client subscribes
+	stream, cancel := subscribeGRPC(t, conn, nil)
+	defer cancel()
+
+	// THEN the subscription is rejected
+	_, err = stream.Recv()
+	require.Error(t, err)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+}
+
+func TestGRPC_TLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grpc-tls")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	certs, err := mock.GenerateCertificates(dir)
+	require.NoError(t, err)
+
+	// GIVEN a gRPC exporter that requires client certificates
+	tlsConfig, err := health.ServerTLS(&config.ServerTLSConfig{
+		CertFile:     certs.ServerCertFile,
+		KeyFile:      certs.ServerKeyFile,
+		ClientCAFile: certs.CAFile,
+	}, true)
+	require.NoError(t, err)
+	cfg := testGRPCConfig()
+	exporter, err := NewGRPC(&cfg, tlsConfig, "grpc", health.NewReporter(health.Ready))
+	require.NoError(t, err)
+	defer exporter.Close()
+	caPEM, err := ioutil.ReadFile(certs.CAFile)
+	require.NoError(t, err)
+	roots := x509.NewCertPool()
+	require.True(t, roots.AppendCertsFromPEM(caPEM))
+
+	// WHEN a client without certificate subscribes
+	conn, err := grpc.Dial(exporter.Addr().String(),
+		grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{RootCAs: roots})))
+	require.NoError(t, err)
+	defer conn.Close()
+	stream, err := goflowkubev1.NewFlowServiceClient(conn).
+		Subscribe(context.Background(), &goflowkubev1.SubscribeRequest{})
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	// THEN the subscription fails
+	require.Error(t, err)
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+
+	// AND WHEN a client with a valid certificate subscribes
+	clientCert, err := tls.LoadX509KeyPair(certs.ClientCertFile, certs.ClientKeyFile)
+	require.NoError(t, err)
+	authConn, err := grpc.Dial(exporter.Addr().String(),
+		grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{
+			RootCAs: roots, Certificates: []tls.Certificate{clientCert},
+		})))
+	require.NoError(t, err)
+	defer authConn.Close()
+	stream, cancel := subscribeGRPC(t, authConn, nil)
+	defer cancel()
+	waitSubscribers(t, exporter, 1)
+	require.NoError(t, exporter.ProcessRecord(map[string]interface{}{"SrcPod": "pod-1"}))
+
+	// THEN it receives the flows
+	resp, err := stream.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, "pod-1", resp.Flow.Src.Pod)
+}
@@ -0,0 +1,266 @@
+package export
+
+import (
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"github.com/vmware/go-ipfix/pkg/entities"
+	ipfixExporter "github.com/vmware/go-ipfix/pkg/exporter"
+
+	"github.com/netobserv/goflow2-kube-enricher/pkg/config"
+	"github.com/netobserv/goflow2-kube-enricher/pkg/fields"
+)
+
+var ilog = logrus.WithField("module", "export/ipfix")
+
+// Values taken from https://www.iana.org/assignments/ipfix/ipfix.xhtml
+var (
+	octetDeltaCount          = entities.NewInfoElement("octetDeltaCount", 1, entities.Unsigned64, 0, 8)
+	packetDeltaCount         = entities.NewInfoElement("packetDeltaCount", 2, entities.Unsigned64, 0, 8)
+	protocolIdentifier       = entities.NewInfoElement("protocolIdentifier", 4, entities.Unsigned8, 0, 1)
+	ipClassOfService         = entities.NewInfoElement("ipClassOfService", 5, entities.Unsigned8, 0, 1)
+	tcpControlBits           = entities.NewInfoElement("tcpControlBits", 6, entities.Unsigned16, 0, 2)
+	sourceTransportPort      = entities.NewInfoElement("sourceTransportPort", 7, entities.Unsigned16, 0, 2)
+	sourceIPv4Address        = entities.NewInfoElement("sourceIPv4Address", 8, entities.Ipv4Address, 0, 4)
+	ingressInterface         = entities.NewInfoElement("ingressInterface", 10, entities.Unsigned32, 0, 4)
+	destinationTransportPort = entities.NewInfoElement("destinationTransportPort", 11, entities.Unsigned16, 0, 2)
+	destinationIPv4Address   = entities.NewInfoElement("destinationIPv4Address", 12, entities.Ipv4Address, 0, 4)
+	egressInterface          = entities.NewInfoElement("egressInterface", 14, entities.Unsigned32, 0, 4)
+	bgpSourceAsNumber        = entities.NewInfoElement("bgpSourceAsNumber", 16, entities.Unsigned32, 0, 4)
+	bgpDestinationAsNumber   = entities.NewInfoElement("bgpDestinationAsNumber", 17, entities.Unsigned32, 0, 4)
+	sourceIPv6Address        = entities.NewInfoElement("sourceIPv6Address", 27, entities.Ipv6Address, 0, 16)
+	destinationIPv6Address   = entities.NewInfoElement("destinationIPv6Address", 28, entities.Ipv6Address, 0, 16)
+	sourceMacAddress         = entities.NewInfoElement("sourceMacAddress", 56, entities.MacAddress, 0, 6)
+	flowDirection            = entities.NewInfoElement("flowDirection", 61, entities.Unsigned8, 0, 1)
+	destinationMacAddress    = entities.NewInfoElement("destinationMacAddress", 80, entities.MacAddress, 0, 6)
+	flowStartSeconds         = entities.NewInfoElement("flowStartSeconds", 150, entities.DateTimeSeconds, 0, 4)
+	flowEndSeconds           = entities.NewInfoElement("flowEndSeconds", 151, entities.DateTimeSeconds, 0, 4)
+	ethernetType             = entities.NewInfoElement("ethernetType", 256, entities.Unsigned16, 0, 2)
+)
+
+// ipfixField maps a record field to the information element where its value is sent
+type ipfixField struct {
+	name    string
+	element *entities.InfoElement
+}
+
+var ipfixIPv4Fields = []ipfixField{
+	{name: "SrcAddr", element: sourceIPv4Address},
+	{name: "DstAddr", element: destinationIPv4Address},
+}
+
+var ipfixIPv6Fields = []ipfixField{
+	{name: "SrcAddr", element: sourceIPv6Address},
+	{name: "DstAddr", element: destinationIPv6Address},
+}
+
+var ipfixFlowFields = []ipfixField{
+	{name: "Bytes", element: octetDeltaCount},
+	{name: "Packets", element: packetDeltaCount},
+	{name: "Proto", element: protocolIdentifier},
+	{name: "IPTos", element: ipClassOfService},
+	{name: "TCPFlags", element: tcpControlBits},
+	{name: "SrcPort", element: sourceTransportPort},
+	{name: "DstPort", element: destinationTransportPort},
+	{name: "InIf", element: ingressInterface},
+	{name: "OutIf", element: egressInterface},
+	{name: "SrcAS", element: bgpSourceAsNumber},
+	{name: "DstAS", element: bgpDestinationAsNumber},
+	{name: "SrcMac", element: sourceMacAddress},
+	{name: "DstMac", element: destinationMacAddress},
+	{name: "FlowDirection", element: flowDirection},
+	{name: "TimeFlowStart", element: flowStartSeconds},
+	{name: "TimeFlowEnd", element: flowEndSeconds},
+	{name: "Etype", element: ethernetType},
+}
+
+// kubeIPFIXFields returns the enterprise-specific information elements of the kubernetes fields
+func kubeIPFIXFields(cfg *config.IPFIXConfig) []ipfixField {
+	kubeElement := func(name string, id uint16) *entities.InfoElement {
+		return entities.NewInfoElement(name, id, entities.String, cfg.EnterpriseID, entities.VariableLength)
+	}
+	return []ipfixField{
+		{name: cfg.SrcPrefix + "Pod", element: kubeElement("sourcePodName", 1)},
+		{name: cfg.SrcPrefix + "Namespace", element: kubeElement("sourceNamespace", 2)},
+		{name: cfg.SrcPrefix + "Workload", element: kubeElement("sourceWorkloadName", 3)},
+		{name: cfg.SrcPrefix + "WorkloadKind", element: kubeElement("sourceWorkloadKind", 4)},
+		{name: cfg.DstPrefix + "Pod", element: kubeElement("destinationPodName", 5)},
+		{name: cfg.DstPrefix + "Namespace", element: kubeElement("destinationNamespace", 6)},
+		{name: cfg.DstPrefix + "Workload", element: kubeElement("destinationWorkloadName", 7)},
+		{name: cfg.DstPrefix + "WorkloadKind", element: kubeElement("destinationWorkloadKind", 8)},
+	}
+}
+
+// ipfixTemplate groups the fields that are sent for a given address family
+type ipfixTemplate struct {
+	id     uint16
+	fields []ipfixField
+}
+
+// IPFIX record exporter. It re-emits the flows to an IPFIX collector, with the kubernetes
+// fields as enterprise-specific information elements
+type IPFIX struct {
+	config  config.IPFIXConfig
+	mt      sync.Mutex
+	process *ipfixExporter.ExportingProcess
+	ipv4    ipfixTemplate
+	ipv6    ipfixTemplate
+}
+
+// NewIPFIX creates an IPFIX flow exporter from a given configuration. The connection to the
+// collector is established when the first record is exported
+func NewIPFIX(cfg *config.IPFIXConfig) (*IPFIX, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("the provided config is not valid: %w", err)
+	}
+	kubeFields := kubeIPFIXFields(cfg)
+	templateFields := func(addrFields []ipfixField) []ipfixField {
+		fields := append([]ipfixField{}, addrFields...)
+		fields = append(fields, ipfixFlowFields...)
+		return append(fields, kubeFields...)
+	}
+	return &IPFIX{
+		config: *cfg,
+		ipv4:   ipfixTemplate{fields: templateFields(ipfixIPv4Fields)},
+		ipv6:   ipfixTemplate{fields: templateFields(ipfixIPv6Fields)},
+	}, nil
+}
+
+// ProcessRecord sends the record to the collector as an IPFIX data set
+func (i *IPFIX) ProcessRecord(record map[string]interface{}) error {
+	i.mt.Lock()
+	defer i.mt.Unlock()
+	if err := i.connect(); err != nil {
+		return err
+	}
+	template := &i.ipv4
+	if addr, ok := record["SrcAddr"].(string); ok {
+		if ip := net.ParseIP(addr); ip != nil && ip.To4() == nil {
+			template = &i.ipv6
+		}
+	}
+	elements := make([]entities.InfoElementWithValue, 0, len(template.fields))
+	for _, field := range template.fields {
+		elements = append(elements, ipfixValue(field.element, record[field.name]))
+	}
+	if err := i.send(entities.Data, template.id, elements); err != nil {
+		// the connection will be restored for the next record
+		i.disconnect()
+		return err
+	}
+	return nil
+}
+
+// connect to the collector, if not yet connected, and send the templates. The go-ipfix
+// exporting process periodically refreshes the templates when using UDP
+func (i *IPFIX) connect() error {
+	if i.process != nil {
+		return nil
+	}
+	process, err := ipfixExporter.InitExportingProcess(ipfixExporter.ExporterInput{
+		CollectorAddress:    i.config.Address,
+		CollectorProtocol:   i.config.Protocol,
+		ObservationDomainID: i.config.ObservationDomainID,
+		TempRefTimeout:      uint32(i.config.TemplateRefresh.Seconds()),
+	})
+	if err != nil {
+		return fmt.Errorf("can't connect to IPFIX collector: %w", err)
+	}
+	i.process = process
+	for _, template := range []*ipfixTemplate{&i.ipv4, &i.ipv6} {
+		template.id = process.NewTemplateID()
+		elements := make([]entities.InfoElementWithValue, 0, len(template.fields))
+		for _, field := range template.fields {
+			element, err := entities.DecodeAndCreateInfoElementWithValue(field.element, nil)
+			if err != nil {
+				i.disconnect()
+				return err
+			}
+			elements = append(elements, element)
+		}
+		if err := i.send(entities.Template, template.id, elements); err != nil {
+			i.disconnect()
+			return fmt.Errorf("can't send IPFIX template: %w", err)
+		}
+	}
+	ilog.WithField("address", i.config.Address).Debug("connected to IPFIX collector")
+	return nil
+}
+
+func (i *IPFIX) send(setType entities.ContentType, templateID uint16,
+	elements []entities.InfoElementWithValue) error {
+	set := entities.NewSet(false)
+	if err := set.PrepareSet(setType, templateID); err != nil {
+		return err
+	}
+	if err := set.AddRecord(elements, templateID); err != nil {
+		return err
+	}
+	_, err := i.process.SendSet(set)
+	return err
+}
+
+func (i *IPFIX) disconnect() {
+	if i.process != nil {
+		i.process.CloseConnToCollector()
+		i.process = nil
+	}
+}
+
+// Close the connection to the collector
+func (i *IPFIX) Close() error {
+	i.mt.Lock()
+	defer i.mt.Unlock()
+	i.disconnect()
+	return nil
+}
+
+// ipfixValue converts a record value to the type of the information element. Missing or
+// invalid values are sent as zero values
+func ipfixValue(element *entities.InfoElement, value interface{}) entities.InfoElementWithValue {
+	number, _ := fields.Uint64(value)
+	switch element.DataType {
+	case entities.Unsigned8:
+		return entities.NewUnsigned8InfoElement(element, uint8(number))
+	case entities.Unsigned16:
+		return entities.NewUnsigned16InfoElement(element, uint16(number))
+	case entities.Unsigned32:
+		return entities.NewUnsigned32InfoElement(element, uint32(number))
+	case entities.Unsigned64:
+		return entities.NewUnsigned64InfoElement(element, number)
+	case entities.DateTimeSeconds:
+		return entities.NewDateTimeSecondsInfoElement(element, uint32(number))
+	case entities.Ipv4Address:
+		ip := net.IPv4zero.To4()
+		if str, ok := value.(string); ok {
+			if parsed := net.ParseIP(str).To4(); parsed != nil {
+				ip = parsed
+			}
+		}
+		return entities.NewIPAddressInfoElement(element, ip)
+	case entities.Ipv6Address:
+		ip := net.IPv6zero
+		if str, ok := value.(string); ok {
+			if parsed := net.ParseIP(str); parsed != nil {
+				ip = parsed.To16()
+			}
+		}
+		return entities.NewIPAddressInfoElement(element, ip)
+	case entities.MacAddress:
+		mac := make(net.HardwareAddr, 6)
+		if str, ok := value.(string); ok {
+			if parsed, err := net.ParseMAC(str); err == nil && len(parsed) == 6 {
+				mac = parsed
+			}
+		}
+		return entities.NewMacAddressInfoElement(element, mac)
+	default:
+		str := ""
+		if value != nil {
+			str = fmt.Sprint(value)
+		}
+		return entities.NewStringInfoElement(element, str)
+	}
+}
@@ -0,0 +1,155 @@
+package export
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/netsampler/goflow2/decoders/netflow"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/netobserv/goflow2-kube-enricher/pkg/config"
+)
+
+const testEnterpriseID = 12345
+
+// ipfixCollector decodes the IPFIX messages received in a local UDP port
+type ipfixCollector struct {
+	conn      *net.UDPConn
+	templates *netflow.BasicTemplateSystem
+}
+
+func startIPFIXCollector(t *testing.T) *ipfixCollector {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	return &ipfixCollector{conn: conn, templates: netflow.CreateTemplateSystem()}
+}
+
+// readRecord returns the fields of the next received data record, indexed by PEN and type
+func (c *ipfixCollector) readRecord(t *testing.T) map[uint32]map[uint16][]byte {
+	buf := make([]byte, 65535)
+	require.NoError(t, c.conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	for {
+		n, err := c.conn.Read(buf)
+		require.NoError(t, err)
+		msg, err := netflow.DecodeMessage(bytes.NewBuffer(buf[:n]), c.templates)
+		require.NoError(t, err)
+		packet, ok := msg.(netflow.IPFIXPacket)
+		require.True(t, ok, "expected IPFIX message. Got: %T", msg)
+		for _, fs := range packet.FlowSets {
+			data, ok := fs.(netflow.DataFlowSet)
+			if !ok || len(data.Records) == 0 {
+				continue
+			}
+			fields := map[uint32]map[uint16][]byte{}
+			for _, value := range data.Records[0].Values {
+				if _, ok := fields[value.Pen]; !ok {
+					fields[value.Pen] = map[uint16][]byte{}
+				}
+				// the enterprise bit might be set in the decoded field types
+				fields[value.Pen][value.Type&^0x8000] = value.Value.([]byte)
+			}
+			return fields
+		}
+	}
+}
+
+func testIPFIXConfig(c *ipfixCollector) config.IPFIXConfig {
+	return config.IPFIXConfig{
+		Address:         c.conn.LocalAddr().String(),
+		Protocol:        "udp",
+		EnterpriseID:    testEnterpriseID,
+		TemplateRefresh: time.Minute,
+		SrcPrefix:       "Src",
+		DstPrefix:       "Dst",
+	}
+}
+
+func TestIPFIX_IPv4(t *testing.T) {
+	collector := startIPFIXCollector(t)
+	defer collector.conn.Close()
+
+	// GIVEN an IPFIX exporter
+	cfg := testIPFIXConfig(collector)
+	exporter, err := NewIPFIX(&cfg)
+	require.NoError(t, err)
+	defer exporter.Close()
+
+	// WHEN an enriched IPv4 record is exported
+	require.NoError(t, exporter.ProcessRecord(map[string]interface{}{
+		"SrcAddr":         "10.0.0.1",
+		"DstAddr":         "10.0.0.2",
+		"SrcPort":         uint32(1234),
+		"DstPort":         uint32(80),
+		"Proto":           uint32(6),
+		"Bytes":           uint64(4567),
+		"Packets":         uint64(12),
+		"SrcMac":          "0a:58:0a:80:00:01",
+		"TimeFlowStart":   uint64(1637501829),
+		"SrcPod":          "pod-1",
+		"SrcNamespace":    "ns-1",
+		"SrcWorkload":     "deployment-1",
+		"SrcWorkloadKind": "Deployment",
+		"DstPod":          "pod-2",
+		"DstNamespace":    "ns-2",
+	}))
+
+	// THEN the collector receives the IANA information elements
+	fields := collector.readRecord(t)
+	iana := fields[0]
+	assert.Equal(t, []byte{10, 0, 0, 1}, iana[8])
+	assert.Equal(t, []byte{10, 0, 0, 2}, iana[12])
+	assert.EqualValues(t, 1234, binary.BigEndian.Uint16(iana[7]))
+	assert.EqualValues(t, 80, binary.BigEndian.Uint16(iana[11]))
+	assert.Equal(t, []byte{6}, iana[4])
+	assert.EqualValues(t, 4567, binary.BigEndian.Uint64(iana[1]))
+	assert.EqualValues(t, 12, binary.BigEndian.Uint64(iana[2]))
+	assert.Equal(t, []byte{0x0a, 0x58, 0x0a, 0x80, 0x00, 0x01}, iana[56])
+	assert.EqualValues(t, 1637501829, binary.BigEndian.Uint32(iana[150]))
+	// AND missing values are sent as zero
+	assert.EqualValues(t, 0, binary.BigEndian.Uint32(iana[151]))
+
+	// AND the kubernetes fields as enterprise-specific information elements
+	kube := fields[testEnterpriseID]
+	require.NotNil(t, kube)
+	assert.Equal(t, "pod-1", string(kube[1]))
+	assert.Equal(t, "ns-1", string(kube[2]))
+	assert.Equal(t, "deployment-1", string(kube[3]))
+	assert.Equal(t, "Deployment", string(kube[4]))
+	assert.Equal(t, "pod-2", string(kube[5]))
+	assert.Equal(t, "ns-2", string(kube[6]))
+	assert.Empty(t, kube[7])
+	assert.Empty(t, kube[8])
+}
+
+func TestIPFIX_IPv6(t *testing.T) {
+	collector := startIPFIXCollector(t)
+	defer collector.conn.Close()
+
+	// GIVEN an IPFIX exporter with custom prefixes for the kubernetes fields
+	cfg := testIPFIXConfig(collector)
+	cfg.SrcPrefix = "Source"
+	cfg.DstPrefix = "Destination"
+	exporter, err := NewIPFIX(&cfg)
+	require.NoError(t, err)
+	defer exporter.Close()
+
+	// WHEN an enriched IPv6 record is exported
+	require.NoError(t, exporter.ProcessRecord(map[string]interface{}{
+		"SrcAddr":        "fd00::1",
+		"DstAddr":        "fd00::2",
+		"SourcePod":      "pod-1",
+		"DestinationPod": "pod-2",
+	}))
+
+	// THEN the collector receives the record with the IPv6 information elements
+	fields := collector.readRecord(t)
+	assert.Equal(t, net.ParseIP("fd00::1").To16(), net.IP(fields[0][27]))
+	assert.Equal(t, net.ParseIP("fd00::2").To16(), net.IP(fields[0][28]))
+	assert.NotContains(t, fields[0], uint16(8))
+	assert.Equal(t, "pod-1", string(fields[testEnterpriseID][1]))
+	assert.Equal(t, "pod-2", string(fields[testEnterpriseID][5]))
+}
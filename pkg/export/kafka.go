@@ -0,0 +1,285 @@
+package export
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
+
+	"github.com/netobserv/goflow2-kube-enricher/pkg/config"
+	"github.com/netobserv/goflow2-kube-enricher/pkg/health"
+	"github.com/netobserv/goflow2-kube-enricher/pkg/kafkaauth"
+)
+
+var klog = logrus.WithField("module", "export/kafka")
+
+// kafkaRecordHeader is the message header with the sequence number of the record that is kept
+// for the failure handler
+const kafkaRecordHeader = "goflow-kube-record"
+
+var compressionCodecs = map[string]kafka.Compression{
+	"gzip":   kafka.Gzip,
+	"snappy": kafka.Snappy,
+	"lz4":    kafka.Lz4,
+	"zstd":   kafka.Zstd,
+}
+
+// Kafka record exporter. The records are sent asynchronously in batches, so delivery errors
+// are not returned by ProcessRecord but reported in the metrics
+type Kafka struct {
+	config  config.KafkaConfig
+	writer  *kafka.Writer
+	encode  func(record map[string]interface{}) ([]byte, error)
+	metrics *kafkaMetrics
+	// pending messages, that haven't been delivered nor discarded yet
+	pending     sync.WaitGroup
+	undelivered undelivered
+	failures    FailureHandler
+	// records are the records of the pending messages, if they are passed to the failure
+	// handler. They are indexed by the sequence number in the kafkaRecordHeader of the messages
+	recordsLock sync.Mutex
+	records     map[uint64]map[string]interface{}
+	nextRecord  uint64
+}
+
+// kafkaMetrics reports the delivery of the messages to the Kafka brokers
+type kafkaMetrics struct {
+	name        string
+	reporter    *health.Reporter
+	recordsSent prometheus.Counter
+	bytesSent   prometheus.Counter
+}
+
+// NewKafka creates a Kafka flow exporter from a given configuration. The name identifies
+// the exporter in the reported metrics
+func NewKafka(cfg *config.KafkaConfig, name string, reporter *health.Reporter) (*Kafka, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("the provided config is not valid: %w", err)
+	}
+	transport, err := buildKafkaTransport(cfg)
+	if err != nil {
+		return nil, err
+	}
+	metrics, err := newKafkaMetrics(name, reporter)
+	if err != nil {
+		return nil, err
+	}
+	k := &Kafka{
+		config:  *cfg,
+		encode:  encodeJSON,
+		metrics: metrics,
+	}
+	if cfg.Encoding == config.PBFlagName {
+		k.encode = enrichedEndpoints{srcPrefix: cfg.SrcPrefix, dstPrefix: cfg.DstPrefix}.encodeProtobuf
+	}
+	k.writer = &kafka.Writer{
+		Addr:         kafka.TCP(cfg.Brokers...),
+		Topic:        cfg.Topic,
+		Balancer:     &kafka.Hash{},
+		MaxAttempts:  cfg.MaxAttempts,
+		BatchSize:    cfg.BatchSize,
+		BatchBytes:   cfg.BatchBytes,
+		BatchTimeout: cfg.BatchTimeout,
+		Compression:  compressionCodecs[cfg.Compression],
+		ReadTimeout:  cfg.Timeout,
+		WriteTimeout: cfg.Timeout,
+		RequiredAcks: kafka.RequireAll,
+		Async:        true,
+		Completion:   k.completion,
+		Logger:       kafka.LoggerFunc(klog.Debugf),
+		Transport:    transport,
+	}
+	return k, nil
+}
+
+func buildKafkaTransport(cfg *config.KafkaConfig) (*kafka.Transport, error) {
+	tlsConfig, err := kafkaauth.TLS(cfg.TLS)
+	if err != nil {
+		return nil, err
+	}
+	mechanism, err := kafkaauth.SASL(cfg.SASL)
+	if err != nil {
+		return nil, err
+	}
+	return &kafka.Transport{TLS: tlsConfig, SASL: mechanism}, nil
+}
+
+func newKafkaMetrics(name string, reporter *health.Reporter) (*kafkaMetrics, error) {
+	recordsSent, err := reporter.Register(prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "exporter_kafka_record_sent",
+			Help: "Number of records that have been delivered to the Kafka brokers.",
+		},
+		[]string{"exporter"},
+	))
+	if err != nil {
+		return nil, err
+	}
+	bytesSent, err := reporter.Register(prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "exporter_kafka_sent_bytes",
+			Help: "Size of the message keys and values that have been delivered to the Kafka brokers.",
+		},
+		[]string{"exporter"},
+	))
+	if err != nil {
+		return nil, err
+	}
+	return &kafkaMetrics{
+		name:        name,
+		reporter:    reporter,
+		recordsSent: recordsSent.(*prometheus.CounterVec).WithLabelValues(name),
+		bytesSent:   bytesSent.(*prometheus.CounterVec).WithLabelValues(name),
+	}, nil
+}
+
+// completion is invoked by the Kafka writer after each batch is delivered or discarded
+func (m *kafkaMetrics) completion(messages []kafka.Message, err error) {
+	if err != nil {
+		klog.WithError(err).WithField("records", len(messages)).
+			Warn("can't deliver records to Kafka")
+		for range messages {
+			m.reporter.RecordExportFailed(m.name)
+		}
+		return
+	}
+	size := 0
+	for i := range messages {
+		size += len(messages[i].Key) + len(messages[i].Value)
+	}
+	m.recordsSent.Add(float64(len(messages)))
+	m.bytesSent.Add(float64(size))
+}
+
+// completion accounts the messages of a batch after it is delivered or discarded. The
+// messages discarded because of a non-temporary broker error are considered rejected
+func (k *Kafka) completion(messages []kafka.Message, err error) {
+	k.metrics.completion(messages, err)
+	records := k.takeRecords(messages)
+	if err != nil && len(records) > 0 {
+		k.failures(records, err)
+	}
+	var kerr kafka.Error
+	if err != nil && len(records) < len(messages) && !(errors.As(err, &kerr) && !kerr.Temporary()) {
+		k.undelivered.add(len(messages)-len(records), err)
+	}
+	for range messages {
+		k.pending.Done()
+	}
+}
+
+// SetFailureHandler sets the handler of the records whose messages are discarded by the
+// writer. It must be invoked before processing any record
+func (k *Kafka) SetFailureHandler(handler FailureHandler) {
+	k.failures = handler
+	k.records = map[uint64]map[string]interface{}{}
+}
+
+// keepRecord keeps the record until its message is delivered or discarded, and returns the
+// message headers that identify it, or nil if the records aren't kept
+func (k *Kafka) keepRecord(record map[string]interface{}) []kafka.Header {
+	if k.records == nil {
+		return nil
+	}
+	k.recordsLock.Lock()
+	seq := k.nextRecord
+	k.nextRecord++
+	k.records[seq] = record
+	k.recordsLock.Unlock()
+	value := make([]byte, 8)
+	binary.BigEndian.PutUint64(value, seq)
+	return []kafka.Header{{Key: kafkaRecordHeader, Value: value}}
+}
+
+// recordSequence returns the sequence number of the record kept for the given message
+func recordSequence(message *kafka.Message) (uint64, bool) {
+	for _, h := range message.Headers {
+		if h.Key == kafkaRecordHeader && len(h.Value) == 8 {
+			return binary.BigEndian.Uint64(h.Value), true
+		}
+	}
+	return 0, false
+}
+
+// takeRecords returns, and stops keeping, the records of the given messages
+func (k *Kafka) takeRecords(messages []kafka.Message) []map[string]interface{} {
+	if k.records == nil {
+		return nil
+	}
+	k.recordsLock.Lock()
+	defer k.recordsLock.Unlock()
+	var records []map[string]interface{}
+	for i := range messages {
+		seq, ok := recordSequence(&messages[i])
+		if !ok {
+			continue
+		}
+		if record, ok := k.records[seq]; ok {
+			records = append(records, record)
+			delete(k.records, seq)
+		}
+	}
+	return records
+}
+
+// ProcessRecord queues the record to be sent to the Kafka topic
+func (k *Kafka) ProcessRecord(record map[string]interface{}) error {
+	value, err := k.encode(record)
+	if err != nil {
+		return fmt.Errorf("can't encode record: %w", err)
+	}
+	// the writer is asynchronous, but it might block while the topic metadata is fetched
+	ctx, cancel := context.WithTimeout(context.Background(), k.config.Timeout)
+	defer cancel()
+	k.pending.Add(1)
+	message := kafka.Message{
+		Key:     k.key(record),
+		Value:   value,
+		Headers: k.keepRecord(record),
+	}
+	if err = k.writer.WriteMessages(ctx, message); err != nil {
+		k.takeRecords([]kafka.Message{message})
+		k.pending.Done()
+	}
+	return err
+}
+
+// Flush waits for the queued messages to be delivered or discarded. It must be invoked from
+// the same goroutine as ProcessRecord
+func (k *Kafka) Flush() error {
+	k.pending.Wait()
+	return k.undelivered.take()
+}
+
+// key returns the values of the configured key fields, separated by commas, or nil if the
+// records don't have to be partitioned
+func (k *Kafka) key(record map[string]interface{}) []byte {
+	if len(k.config.KeyFields) == 0 {
+		return nil
+	}
+	values := make([]string, 0, len(k.config.KeyFields))
+	for _, field := range k.config.KeyFields {
+		if v, ok := record[field]; ok {
+			values = append(values, fmt.Sprint(v))
+		} else {
+			values = append(values, "")
+		}
+	}
+	return []byte(strings.Join(values, ","))
+}
+
+// Close sends the pending batches and closes the connections to the brokers
+func (k *Kafka) Close() error {
+	return k.writer.Close()
+}
+
+func encodeJSON(record map[string]interface{}) ([]byte, error) {
+	return jsoniter.ConfigCompatibleWithStandardLibrary.Marshal(record)
+}
@@ -0,0 +1,185 @@
+package export
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/netobserv/goflow2-kube-enricher/pkg/config"
+	"github.com/netobserv/goflow2-kube-enricher/pkg/health"
+	"github.com/netobserv/goflow2-kube-enricher/pkg/internal/mock"
+	goflowkubev1 "github.com/netobserv/goflow2-kube-enricher/pkg/pb/goflowkube/v1"
+)
+
+const kafkaTimeout = 5 * time.Second
+
+func testKafkaConfig(broker *mock.KafkaBroker) config.KafkaConfig {
+	return config.KafkaConfig{
+		Brokers:      []string{broker.Addr()},
+		Topic:        "flows",
+		KeyFields:    []string{"SrcNamespace", "DstNamespace"},
+		Encoding:     config.JSONFlagName,
+		BatchSize:    10,
+		BatchBytes:   1024 * 1024,
+		BatchTimeout: 10 * time.Millisecond,
+		Compression:  "snappy",
+		MaxAttempts:  1,
+		Timeout:      kafkaTimeout,
+		SrcPrefix:    "Src",
+		DstPrefix:    "Dst",
+	}
+}
+
+func readKafkaMessage(t *testing.T, broker *mock.KafkaBroker) mock.KafkaMessage {
+	select {
+	case msg := <-broker.Messages:
+		return msg
+	case <-time.After(kafkaTimeout):
+		require.Fail(t, "timeout while waiting for Kafka messages")
+		return mock.KafkaMessage{}
+	}
+}
+
+func getMetrics(t *testing.T, reporter *health.Reporter) string {
+	return scrapeMetrics(t, health.NewHTTPReporter(reporter))
+}
+
+func scrapeMetrics(t *testing.T, hr health.HTTPReporter) string {
+	rec := httptest.NewRecorder()
+	hr.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, err := ioutil.ReadAll(rec.Body)
+	require.NoError(t, err)
+	return string(body)
+}
+
+func TestKafka_JSON(t *testing.T) {
+	broker, err := mock.StartKafkaBroker("flows", 3)
+	require.NoError(t, err)
+	defer broker.Close()
+
+	// GIVEN a Kafka exporter that takes the partition key from the namespaces
+	reporter := health.NewReporter(health.Ready)
+	cfg := testKafkaConfig(broker)
+	kafka, err := NewKafka(&cfg, "kafka", reporter)
+	require.NoError(t, err)
+
+	// WHEN some records are exported
+	require.NoError(t, kafka.ProcessRecord(map[string]interface{}{
+		"SrcNamespace": "ns1", "DstNamespace": "ns2", "Bytes": 123,
+	}))
+	require.NoError(t, kafka.ProcessRecord(map[string]interface{}{
+		"SrcNamespace": "ns1", "DstNamespace": "ns2", "Bytes": 456,
+	}))
+	require.NoError(t, kafka.ProcessRecord(map[string]interface{}{
+		"DstNamespace": "ns2", "Bytes": 789,
+	}))
+
+	// THEN the records are received as JSON, keyed by the namespaces
+	received := map[string][]mock.KafkaMessage{}
+	for i := 0; i < 3; i++ {
+		msg := readKafkaMessage(t, broker)
+		received[string(msg.Key)] = append(received[string(msg.Key)], msg)
+	}
+	require.Len(t, received["ns1,ns2"], 2)
+	assert.JSONEq(t, `{"SrcNamespace":"ns1","DstNamespace":"ns2","Bytes":123}`,
+		string(received["ns1,ns2"][0].Value))
+	assert.JSONEq(t, `{"SrcNamespace":"ns1","DstNamespace":"ns2","Bytes":456}`,
+		string(received["ns1,ns2"][1].Value))
+	// AND the records with the same key are sent to the same partition
+	assert.Equal(t, received["ns1,ns2"][0].Partition, received["ns1,ns2"][1].Partition)
+	require.Len(t, received[",ns2"], 1)
+	assert.JSONEq(t, `{"DstNamespace":"ns2","Bytes":789}`, string(received[",ns2"][0].Value))
+
+	// AND the delivery is reported in the metrics
+	require.NoError(t, kafka.Close())
+	metrics := getMetrics(t, reporter)
+	assert.Contains(t, metrics, `exporter_kafka_record_sent{exporter="kafka"} 3`)
+	assert.Contains(t, metrics, `exporter_kafka_sent_bytes{exporter="kafka"}`)
+}
+
+func TestKafka_Protobuf(t *testing.T) {
+	broker, err := mock.StartKafkaBroker("flows", 1)
+	require.NoError(t, err)
+	defer broker.Close()
+
+	// GIVEN a Kafka exporter with protobuf encoding and no partition key
+	cfg := testKafkaConfig(broker)
+	cfg.Encoding = config.PBFlagName
+	cfg.KeyFields = nil
+	kafka, err := NewKafka(&cfg, "kafka", health.NewReporter(health.Ready))
+	require.NoError(t, err)
+	defer kafka.Close()
+
+	// WHEN a record is exported
+	require.NoError(t, kafka.ProcessRecord(map[string]interface{}{
+		"SrcPod": "pod1", "Bytes": uint64(123),
+	}))
+
+	// THEN it is received as an EnrichedFlow message, without key
+	msg := readKafkaMessage(t, broker)
+	assert.Empty(t, msg.Key)
+	record := goflowkubev1.EnrichedFlow{}
+	require.NoError(t, proto.Unmarshal(msg.Value, &record))
+	assert.Equal(t, "pod1", record.Src.Pod)
+	assert.Equal(t, uint64(123), record.Flow.Bytes)
+}
+
+func TestKafka_DeliveryFailure(t *testing.T) {
+	broker, err := mock.StartKafkaBroker("flows", 1)
+	require.NoError(t, err)
+	defer broker.Close()
+	broker.ProduceError = 10 // MESSAGE_TOO_LARGE
+
+	// GIVEN a Kafka exporter whose broker rejects the messages
+	reporter := health.NewReporter(health.Ready)
+	cfg := testKafkaConfig(broker)
+	kafka, err := NewKafka(&cfg, "my-kafka", reporter)
+	require.NoError(t, err)
+
+	// WHEN some records are exported
+	for i := 0; i < 2; i++ {
+		require.NoError(t, kafka.ProcessRecord(map[string]interface{}{"Bytes": i}))
+	}
+	// THEN the flush doesn't fail, since the rejected records can't be delivered later
+	require.NoError(t, kafka.Flush())
+	require.NoError(t, kafka.Close())
+
+	// AND the failures are reported in the metrics
+	metrics := getMetrics(t, reporter)
+	assert.Contains(t, metrics, `exporter_record_failed{exporter="my-kafka"} 2`)
+	assert.NotContains(t, metrics, `exporter_kafka_record_sent{exporter="my-kafka"} 2`)
+}
+
+func TestKafka_FailureHandler(t *testing.T) {
+	broker, err := mock.StartKafkaBroker("flows", 1)
+	require.NoError(t, err)
+	defer broker.Close()
+	broker.ProduceError = 10 // MESSAGE_TOO_LARGE
+
+	// GIVEN a Kafka exporter whose broker rejects the messages, with a failure handler
+	cfg := testKafkaConfig(broker)
+	kafka, err := NewKafka(&cfg, "my-kafka", health.NewReporter(health.Ready))
+	require.NoError(t, err)
+	var failed []map[string]interface{}
+	kafka.SetFailureHandler(func(records []map[string]interface{}, err error) {
+		assert.Error(t, err)
+		failed = append(failed, records...)
+	})
+
+	// WHEN some records are exported
+	for i := 0; i < 2; i++ {
+		require.NoError(t, kafka.ProcessRecord(map[string]interface{}{"Bytes": i}))
+	}
+	require.NoError(t, kafka.Flush())
+	require.NoError(t, kafka.Close())
+
+	// THEN the rejected records are passed to the failure handler
+	assert.ElementsMatch(t, []map[string]interface{}{{"Bytes": 0}, {"Bytes": 1}}, failed)
+	// AND they aren't kept anymore
+	assert.Empty(t, kafka.records)
+}
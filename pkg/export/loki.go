@@ -4,19 +4,14 @@ package export
 import (
 	"errors"
 	"fmt"
-	"math"
 	"strings"
 	"time"
 
-	logadapter "github.com/go-kit/kit/log/logrus"
-	jsoniter "github.com/json-iterator/go"
-	"github.com/netobserv/loki-client-go/loki"
-	"github.com/netobserv/loki-client-go/pkg/backoff"
-	"github.com/netobserv/loki-client-go/pkg/urlutil"
 	"github.com/prometheus/common/model"
 	"github.com/sirupsen/logrus"
 
 	"github.com/netobserv/goflow2-kube-enricher/pkg/config"
+	"github.com/netobserv/goflow2-kube-enricher/pkg/health"
 )
 
 var (
@@ -29,32 +24,68 @@ type emitter interface {
 	Handle(labels model.LabelSet, timestamp time.Time, record string) error
 }
 
+// recordEmitter is an emitter that can keep the original record of each entry, so the records
+// of the entries that can't be delivered are passed to its failure handler
+type recordEmitter interface {
+	emitter
+	FailureNotifier
+	HandleRecord(labels model.LabelSet, timestamp time.Time, line string, record map[string]interface{}) error
+}
+
 // Loki record exporter
 type Loki struct {
-	config     config.LokiConfig
-	lokiConfig loki.Config
-	emitter    emitter
+	config  config.LokiConfig
+	emitter emitter
+	// records is the emitter, if it reports the delivery failures to a failure handler
+	records    recordEmitter
+	endpoints  *lokiEndpoints
+	streams    *lokiStreams
+	lines      *lineEncoder
+	timestamps *lokiTimestamps
 	timeNow    func() time.Time
 	ready      bool
 }
 
-// NewLoki creates a Loki flow exporter from a given configuration
-func NewLoki(cfg *config.LokiConfig) (Loki, error) {
+// NewLoki creates a Loki flow exporter from a given configuration. The name identifies the
+// exporter in the reported metrics
+func NewLoki(cfg *config.LokiConfig, name string, reporter *health.Reporter) (Loki, error) {
 	if err := cfg.Validate(); err != nil {
 		return NewEmptyLoki(), fmt.Errorf("the provided config is not valid: %w", err)
 	}
-	lcfg, err := buildLokiConfig(cfg)
+	for _, label := range cfg.Labels {
+		if _, ok := highCardinalityFields[label]; ok {
+			log.WithField("label", label).Warn("this label usually has a high cardinality and might create" +
+				" too many Loki streams. Consider removing it from the labels or limiting its values")
+		}
+	}
+	timestamps, err := newLokiTimestamps(cfg, name, reporter)
+	if err != nil {
+		return NewEmptyLoki(), err
+	}
+	lines, err := newLineEncoder(cfg)
+	if err != nil {
+		return NewEmptyLoki(), err
+	}
+	streams, err := newLokiStreams(&cfg.Cardinality, cfg.Labels, name, reporter)
+	if err != nil {
+		return NewEmptyLoki(), err
+	}
+	endpoints, err := newLokiEndpoints(cfg, name, reporter)
 	if err != nil {
 		return NewEmptyLoki(), err
 	}
-	lokiClient, err := loki.NewWithLogger(lcfg, logadapter.NewLogger(log))
+	em, err := newLokiEmitter(cfg, endpoints, name, reporter)
 	if err != nil {
+		endpoints.Stop()
 		return NewEmptyLoki(), err
 	}
 	return Loki{
 		config:     *cfg,
-		lokiConfig: lcfg,
-		emitter:    lokiClient,
+		emitter:    em,
+		endpoints:  endpoints,
+		streams:    streams,
+		lines:      lines,
+		timestamps: timestamps,
 		timeNow:    time.Now,
 		ready:      true,
 	}, nil
@@ -70,26 +101,42 @@ func (l *Loki) IsReady() bool {
 	return l.ready
 }
 
-func buildLokiConfig(c *config.LokiConfig) (loki.Config, error) {
-	cfg := loki.Config{
-		TenantID:  c.TenantID,
-		BatchWait: c.BatchWait,
-		BatchSize: c.BatchSize,
-		Timeout:   c.Timeout,
-		BackoffConfig: backoff.BackoffConfig{
-			MinBackoff: c.MinBackoff,
-			MaxBackoff: c.MaxBackoff,
-			MaxRetries: c.MaxRetries,
-		},
-		Client: c.ClientConfig,
-	}
-	var clientURL urlutil.URLValue
-	err := clientURL.Set(strings.TrimSuffix(c.URL, "/") + "/loki/api/v1/push")
-	if err != nil {
-		return cfg, fmt.Errorf("failed to parse client URL: %w", err)
+// Close stops the Loki client, after sending the pending batches
+func (l *Loki) Close() error {
+	if stopper, ok := l.emitter.(interface{ Stop() }); ok {
+		stopper.Stop()
+	}
+	if l.endpoints != nil {
+		l.endpoints.Stop()
+	}
+	return nil
+}
+
+// SetFailureHandler sets the handler of the records that the Loki client couldn't deliver.
+// The segments of the WAL, and the entries of each endpoint in mirror mode, are not reported,
+// since the WAL doesn't keep the original records, and the other endpoints might have
+// delivered them
+func (l *Loki) SetFailureHandler(handler FailureHandler) {
+	if em, ok := l.emitter.(recordEmitter); ok {
+		em.SetFailureHandler(handler)
+		l.records = em
+	}
+}
+
+// Flush sends the buffered records, or stores them in the WAL if it is enabled
+func (l *Loki) Flush() error {
+	if !l.IsReady() {
+		return errors.New("Loki is not ready")
+	}
+	return flushEmitter(l.emitter)
+}
+
+// flushEmitter flushes the emitter, if it buffers the entries
+func flushEmitter(em emitter) error {
+	if flusher, ok := em.(Flusher); ok {
+		return flusher.Flush()
 	}
-	cfg.URL = clientURL
-	return cfg, nil
+	return nil
 }
 
 func (l *Loki) ProcessRecord(record map[string]interface{}) error {
@@ -97,8 +144,18 @@ func (l *Loki) ProcessRecord(record map[string]interface{}) error {
 		return errors.New("Loki is not ready")
 	}
 
-	// Get timestamp from record (default: TimeFlowStart)
-	timestamp := l.extractTimestamp(record)
+	// the record is modified below, so the original is kept for the failure handler
+	var original map[string]interface{}
+	if l.records != nil {
+		original = CopyRecord(record)
+	}
+
+	// Get timestamp from record (default: TimeReceived)
+	now := l.timeNow()
+	timestamp, routeLabels, ok := l.timestamps.checkAge(l.timestamps.extract(record, now), now)
+	if !ok {
+		return nil
+	}
 
 	labels := model.LabelSet{}
 
@@ -108,6 +165,11 @@ func (l *Loki) ProcessRecord(record map[string]interface{}) error {
 	}
 
 	l.addNonStaticLabels(record, labels)
+	for k, v := range routeLabels {
+		labels[k] = v
+	}
+	// the tenant fields might be removed from the record afterwards
+	tenants := l.tenants(record)
 
 	// Remove labels and configured ignore list from record
 	ignoreList := append(l.config.IgnoreList, l.config.Labels...)
@@ -115,36 +177,77 @@ func (l *Loki) ProcessRecord(record map[string]interface{}) error {
 		delete(record, label)
 	}
 
-	js, err := jsoniter.ConfigCompatibleWithStandardLibrary.Marshal(record)
+	line, err := l.lines.encode(record)
 	if err != nil {
 		return err
 	}
-	return l.emitter.Handle(labels, timestamp, string(js))
+	if len(tenants) == 0 {
+		return l.emit(labels, timestamp, line, original)
+	}
+	// the emitters batch the records by their tenant label
+	for _, tenant := range tenants {
+		tenantLabels := labels.Clone()
+		tenantLabels[lokiTenantLabel] = model.LabelValue(tenant)
+		if err := l.emit(tenantLabels, timestamp, line, original); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func (l *Loki) extractTimestamp(record map[string]interface{}) time.Time {
-	if l.config.TimestampLabel == "" {
-		return l.timeNow()
+// emit sends the entry to the emitter, along with the original record if it is kept. The
+// stream is accounted by the cardinality limits with its final labels, since the route and
+// tenant labels create distinct streams
+func (l *Loki) emit(labels model.LabelSet, timestamp time.Time, line string, original map[string]interface{}) error {
+	if l.streams != nil {
+		l.streams.limit(labels)
 	}
-	timestamp, ok := record[string(l.config.TimestampLabel)]
-	if !ok {
-		log.WithField("timestampLabel", l.config.TimestampLabel).
-			Warnf("Timestamp label not found in record. Using local time")
-		return l.timeNow()
+	if l.records != nil {
+		return l.records.HandleRecord(labels, timestamp, line, original)
 	}
-	ft, ok := getFloat64(timestamp)
-	if !ok {
-		log.WithField(string(l.config.TimestampLabel), timestamp).
-			Warnf("Invalid timestamp found: float64 expected but got %T. Using local time", timestamp)
-		return l.timeNow()
+	return l.emitter.Handle(labels, timestamp, line)
+}
+
+// tenants returns the Loki tenants where the record must be sent, according to its source
+// and destination fields. It returns nil if the tenant can't be derived from the record, so
+// it is sent to the default tenant
+func (l *Loki) tenants(record map[string]interface{}) []string {
+	tc := &l.config.Tenant
+	if !tc.Enabled() {
+		return nil
+	}
+	src, dst := l.tenantOf(record, tc.SrcField), l.tenantOf(record, tc.DstField)
+	switch {
+	case src == "" && dst == "":
+		return nil
+	case src == "" || src == dst:
+		return []string{dst}
+	case dst == "":
+		return []string{src}
+	}
+	switch tc.CrossTenant {
+	case config.CrossTenantSource:
+		return []string{src}
+	case config.CrossTenantDestination:
+		return []string{dst}
+	default:
+		return []string{src, dst}
+	}
+}
+
+func (l *Loki) tenantOf(record map[string]interface{}, field string) string {
+	if field == "" {
+		return ""
+	}
+	val, ok := record[field]
+	if !ok || val == nil {
+		return ""
 	}
-	if ft == 0 {
-		log.WithField("timestampLabel", l.config.TimestampLabel).
-			Warnf("Empty timestamp in record. Using local time")
-		return l.timeNow()
+	value := fmt.Sprint(val)
+	if tenant, ok := l.config.Tenant.Mapping[value]; ok {
+		return tenant
 	}
-	tsNanos := int64(ft * float64(l.config.TimestampScale))
-	return time.Unix(tsNanos/int64(time.Second), tsNanos%int64(time.Second))
+	return value
 }
 
 func (l *Loki) addNonStaticLabels(record map[string]interface{}, labels model.LabelSet) {
@@ -169,25 +272,3 @@ func (l *Loki) addNonStaticLabels(record map[string]interface{}, labels model.La
 		labels[sanitizedKey] = lv
 	}
 }
-
-func getFloat64(timestamp interface{}) (ft float64, ok bool) {
-	switch i := timestamp.(type) {
-	case float64:
-		return i, true
-	case float32:
-		return float64(i), true
-	case int64:
-		return float64(i), true
-	case int32:
-		return float64(i), true
-	case uint64:
-		return float64(i), true
-	case uint32:
-		return float64(i), true
-	case int:
-		return float64(i), true
-	default:
-		log.Warnf("Type %T is not implemented for float64 conversion\n", i)
-		return math.NaN(), false
-	}
-}
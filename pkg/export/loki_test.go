@@ -14,6 +14,7 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"github.com/netobserv/goflow2-kube-enricher/pkg/config"
+	"github.com/netobserv/goflow2-kube-enricher/pkg/health"
 )
 
 type fakeEmitter struct {
@@ -46,21 +47,23 @@ printInput: true
 	require.NoError(t, err)
 	require.NoError(t, cfgFile.Close())
 
-	// WHEN it is loaded and transformed into a Loki Config
+	// WHEN it is loaded and a Loki exporter is created from it
 	cfg, err := config.Load(cfgFile.Name())
 	require.NoError(t, err)
-
-	// THEN the generated loki config is consistent with the parsed data
-	ccfg, err := buildLokiConfig(&cfg.Loki)
+	loki, err := NewLoki(&cfg.Loki, "loki", health.NewReporter(health.Ready))
 	require.NoError(t, err)
+	defer loki.Close()
 
-	require.NotNil(t, ccfg.URL)
-	assert.Equal(t, "https://foo:8888/loki/api/v1/push", ccfg.URL.String())
-	assert.Equal(t, "theTenant", ccfg.TenantID)
-	assert.Equal(t, time.Minute, ccfg.BatchWait)
-	assert.NotZero(t, ccfg.BatchSize)
-	assert.Equal(t, cfg.Loki.BatchSize, ccfg.BatchSize)
-	assert.Equal(t, cfg.Loki.MinBackoff, ccfg.BackoffConfig.MinBackoff)
+	// THEN the endpoint and the client are configured from the parsed data
+	require.Len(t, loki.endpoints.endpoints, 1)
+	assert.Equal(t, "https://foo:8888/loki/api/v1/push", loki.endpoints.endpoints[0].pushURL)
+	client, ok := loki.emitter.(*lokiClient)
+	require.True(t, ok)
+	assert.Equal(t, "theTenant", client.config.TenantID)
+	assert.Equal(t, time.Minute, client.config.BatchWait)
+	assert.NotZero(t, client.config.BatchSize)
+	assert.Equal(t, cfg.Loki.BatchSize, client.config.BatchSize)
+	assert.Equal(t, cfg.Loki.MinBackoff, client.pusher.config.MinBackoff)
 }
 
 func TestLoki_ProcessRecord(t *testing.T) {
@@ -81,7 +84,7 @@ printInput: true
 printOutput: true
 `))
 	require.NoError(t, err)
-	loki, err := NewLoki(&cfg.Loki)
+	loki, err := NewLoki(&cfg.Loki, "loki", health.NewReporter(health.Starting))
 	require.NoError(t, err)
 	loki.emitter = &fe
 
@@ -107,6 +110,255 @@ printOutput: true
 	}, time.Unix(124567, 0), `{"other":"val","ts":124567,"value":5678}`)
 }
 
+func TestLoki_Tenants(t *testing.T) {
+	for _, testCase := range []struct {
+		policy   string
+		record   map[string]interface{}
+		expected []model.LabelValue
+	}{
+		{policy: "duplicate", record: map[string]interface{}{"SrcNamespace": "ns-1", "DstNamespace": "ns-2"},
+			expected: []model.LabelValue{"team-1", "ns-2"}},
+		{policy: "source", record: map[string]interface{}{"SrcNamespace": "ns-1", "DstNamespace": "ns-2"},
+			expected: []model.LabelValue{"team-1"}},
+		{policy: "destination", record: map[string]interface{}{"SrcNamespace": "ns-1", "DstNamespace": "ns-2"},
+			expected: []model.LabelValue{"ns-2"}},
+		{policy: "duplicate", record: map[string]interface{}{"SrcNamespace": "ns-1", "DstNamespace": "ns-3"},
+			expected: []model.LabelValue{"team-1"}},
+		{policy: "source", record: map[string]interface{}{"DstNamespace": "ns-2"},
+			expected: []model.LabelValue{"ns-2"}},
+		{policy: "duplicate", record: map[string]interface{}{"SrcAddr": "10.0.0.1"},
+			expected: []model.LabelValue{""}},
+	} {
+		t.Run(fmt.Sprintf("%s %v", testCase.policy, testCase.record), func(t *testing.T) {
+			// GIVEN a Loki exporter that derives the tenant from the namespaces
+			fe := fakeEmitter{}
+			fe.On("Handle", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+			cfg, err := config.Read(strings.NewReader(fmt.Sprintf(`
+loki:
+  tenantID: default
+  ignoreList: [SrcNamespace, DstNamespace]
+  tenant:
+    srcField: SrcNamespace
+    dstField: DstNamespace
+    crossTenant: %s
+    mapping:
+      ns-1: team-1
+      ns-3: team-1
+`, testCase.policy)))
+			require.NoError(t, err)
+			loki, err := NewLoki(&cfg.Loki, "loki", health.NewReporter(health.Starting))
+			require.NoError(t, err)
+			loki.emitter = &fe
+
+			// WHEN it processes a record
+			require.NoError(t, loki.ProcessRecord(testCase.record))
+
+			// THEN the record is sent to the tenants given by the policy
+			var tenants []model.LabelValue
+			for _, call := range fe.Calls {
+				tenants = append(tenants, call.Arguments.Get(0).(model.LabelSet)["__tenant_id__"])
+			}
+			assert.Equal(t, testCase.expected, tenants)
+		})
+	}
+}
+
+func TestLoki_LineFormats(t *testing.T) {
+	record := func() map[string]interface{} {
+		return map[string]interface{}{"SrcPod": "pod-1", "DstPod": "pod 2", "Bytes": 1500, "VlanId": 0,
+			"IPv6FlowLabel": uint32(0), "SrcMac": "", "Etype": 2048}
+	}
+	for _, testCase := range []struct {
+		name     string
+		config   string
+		expected string
+	}{
+		{name: "default", config: "{ignoreList: [Etype]}",
+			expected: `{"Bytes":1500,"DstPod":"pod 2","IPv6FlowLabel":0,"SrcMac":"","SrcPod":"pod-1","VlanId":0}`},
+		{name: "json omitting empty values", config: "{lineFormat: json, omitEmpty: true}",
+			expected: `{"Bytes":1500,"DstPod":"pod 2","Etype":2048,"SrcPod":"pod-1"}`},
+		{name: "logfmt", config: "{lineFormat: logfmt, omitEmpty: true}",
+			expected: `Bytes=1500 DstPod="pod 2" Etype=2048 SrcPod=pod-1`},
+		{name: "logfmt with allowlist", config: "{lineFormat: logfmt, fields: [SrcPod, VlanId, Missing]}",
+			expected: `SrcPod=pod-1 VlanId=0`},
+		{name: "template", config: "{lineFormat: template, lineTemplate: '{{.SrcPod}} -> {{.DstPod}}: {{.Bytes}}'}",
+			expected: `pod-1 -> pod 2: 1500`},
+		{name: "template with missing fields",
+			config:   "{lineFormat: template, lineTemplate: '{{.SrcPod}} -> {{.DstPod}}:{{.DstPort}}{{if .Missing}}!{{end}}'}",
+			expected: `pod-1 -> pod 2:`},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			// GIVEN a Loki exporter with a given line format
+			fe := fakeEmitter{}
+			fe.On("Handle", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+			cfg, err := config.Read(strings.NewReader("loki: " + testCase.config))
+			require.NoError(t, err)
+			loki, err := NewLoki(&cfg.Loki, "loki", health.NewReporter(health.Starting))
+			require.NoError(t, err)
+			loki.emitter = &fe
+
+			// WHEN it processes a record
+			require.NoError(t, loki.ProcessRecord(record()))
+
+			// THEN the log line is written in that format
+			fe.AssertCalled(t, "Handle", mock.Anything, mock.Anything, testCase.expected)
+		})
+	}
+}
+
+func TestLoki_LineFormatConfig(t *testing.T) {
+	// the line template is parsed when the exporter is created
+	cfg, err := config.Read(strings.NewReader("loki: {lineFormat: template, lineTemplate: '{{.SrcPod'}"))
+	require.NoError(t, err)
+	require.NoError(t, cfg.Loki.Validate())
+	_, err = NewLoki(&cfg.Loki, "loki", health.NewReporter(health.Starting))
+	assert.Error(t, err)
+}
+
+// handledLabels returns the values of the given label in the records that have been handled
+func handledLabels(fe *fakeEmitter, label model.LabelName) []model.LabelValue {
+	var values []model.LabelValue
+	for _, call := range fe.Calls {
+		values = append(values, call.Arguments.Get(0).(model.LabelSet)[label])
+	}
+	return values
+}
+
+func TestLoki_LabelValuesLimit(t *testing.T) {
+	// GIVEN a Loki exporter that accepts up to 2 values per label, and 3 for the namespaces
+	fe := fakeEmitter{}
+	fe.On("Handle", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	reporter := health.NewReporter(health.Ready)
+	cfg := config.Default()
+	cfg.Loki.Labels = []string{"SrcNamespace", "DstPort"}
+	cfg.Loki.Cardinality.MaxLabelValues = 2
+	cfg.Loki.Cardinality.LabelLimits = map[string]int{"SrcNamespace": 3}
+	loki, err := NewLoki(&cfg.Loki, "loki", reporter)
+	require.NoError(t, err)
+	loki.emitter = &fe
+
+	// WHEN records with more distinct values are exported
+	for i := 1; i <= 5; i++ {
+		require.NoError(t, loki.ProcessRecord(map[string]interface{}{
+			"SrcNamespace": fmt.Sprintf("ns-%d", i), "DstPort": 80 + i}))
+	}
+	require.NoError(t, loki.ProcessRecord(map[string]interface{}{"SrcNamespace": "ns-1", "DstPort": 81}))
+
+	// THEN the values exceeding the limits are replaced by "other"
+	assert.Equal(t, []model.LabelValue{"ns-1", "ns-2", "ns-3", "other", "other", "ns-1"},
+		handledLabels(&fe, "SrcNamespace"))
+	assert.Equal(t, []model.LabelValue{"81", "82", "other", "other", "other", "81"},
+		handledLabels(&fe, "DstPort"))
+	// AND the overflows and the active streams are reported
+	metrics := getMetrics(t, reporter)
+	assert.Contains(t, metrics, `exporter_loki_label_overflow{exporter="loki",label="SrcNamespace"} 2`)
+	assert.Contains(t, metrics, `exporter_loki_label_overflow{exporter="loki",label="DstPort"} 3`)
+	assert.Contains(t, metrics, `exporter_loki_streams{exporter="loki"} 4`)
+}
+
+func TestLoki_MaxStreams(t *testing.T) {
+	// GIVEN a Loki exporter that accepts up to 2 active streams, which expire after one minute
+	fe := fakeEmitter{}
+	fe.On("Handle", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	reporter := health.NewReporter(health.Ready)
+	hr := health.NewHTTPReporter(reporter)
+	cfg := config.Default()
+	cfg.Loki.Labels = []string{"SrcPod"}
+	cfg.Loki.Cardinality.MaxStreams = 2
+	cfg.Loki.Cardinality.StreamTTL = time.Minute
+	loki, err := NewLoki(&cfg.Loki, "loki", reporter)
+	require.NoError(t, err)
+	loki.emitter = &fe
+	now := time.Now()
+	loki.streams.now = func() time.Time { return now }
+
+	// WHEN records of more streams are exported
+	for _, pod := range []string{"pod-1", "pod-2", "pod-3", "pod-1"} {
+		require.NoError(t, loki.ProcessRecord(map[string]interface{}{"SrcPod": pod}))
+	}
+
+	// THEN the records of the new streams are sent to the overflow stream
+	assert.Equal(t, []model.LabelValue{"pod-1", "pod-2", "other", "pod-1"}, handledLabels(&fe, "SrcPod"))
+	metrics := scrapeMetrics(t, hr)
+	assert.Contains(t, metrics, `exporter_loki_stream_overflow{exporter="loki"} 1`)
+	assert.Contains(t, metrics, `exporter_loki_streams{exporter="loki"} 2`)
+
+	// AND WHEN the streams expire
+	now = now.Add(time.Minute)
+	require.NoError(t, loki.ProcessRecord(map[string]interface{}{"SrcPod": "pod-3"}))
+
+	// THEN new streams are accepted
+	assert.Equal(t, model.LabelValue("pod-3"), handledLabels(&fe, "SrcPod")[4])
+	assert.Contains(t, scrapeMetrics(t, hr), `exporter_loki_streams{exporter="loki"} 1`)
+}
+
+func TestLoki_MaxStreamsWithRouteLabels(t *testing.T) {
+	// GIVEN a Loki exporter that accepts up to 2 active streams, and routes the old records
+	fe := fakeEmitter{}
+	fe.On("Handle", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	reporter := health.NewReporter(health.Ready)
+	hr := health.NewHTTPReporter(reporter)
+	cfg := config.Default()
+	cfg.Loki.Labels = []string{"SrcPod"}
+	cfg.Loki.Cardinality.MaxStreams = 2
+	cfg.Loki.OutOfOrder = config.LokiOutOfOrderConfig{
+		MaxAge: 30 * time.Minute, Action: config.OutOfOrderRoute, RouteLabels: model.LabelSet{"late": "true"},
+	}
+	loki, err := NewLoki(&cfg.Loki, "loki", reporter)
+	require.NoError(t, err)
+	loki.emitter = &fe
+	now := time.Unix(1000000, 0)
+	loki.timeNow = func() time.Time { return now }
+
+	// WHEN a recent record, a routed record of the same pod and a record of another pod are exported
+	require.NoError(t, loki.ProcessRecord(map[string]interface{}{"SrcPod": "pod-1", "TimeReceived": 999000}))
+	require.NoError(t, loki.ProcessRecord(map[string]interface{}{"SrcPod": "pod-1", "TimeReceived": 990000}))
+	require.NoError(t, loki.ProcessRecord(map[string]interface{}{"SrcPod": "pod-2", "TimeReceived": 999000}))
+
+	// THEN the routed record counts as another stream
+	assert.Equal(t, []model.LabelValue{"pod-1", "pod-1", "other"}, handledLabels(&fe, "SrcPod"))
+	assert.Equal(t, []model.LabelValue{"", "true", ""}, handledLabels(&fe, "late"))
+	assert.Contains(t, scrapeMetrics(t, hr), `exporter_loki_streams{exporter="loki"} 2`)
+}
+
+func TestLoki_MaxStreamsWithTenants(t *testing.T) {
+	// GIVEN a Loki exporter that accepts up to 2 active streams, and sends the records to the
+	// tenants of both namespaces
+	fe := fakeEmitter{}
+	fe.On("Handle", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	reporter := health.NewReporter(health.Ready)
+	hr := health.NewHTTPReporter(reporter)
+	cfg := config.Default()
+	cfg.Loki.Labels = []string{"SrcPod"}
+	cfg.Loki.Cardinality.MaxStreams = 2
+	cfg.Loki.Tenant.SrcField = "SrcNamespace"
+	cfg.Loki.Tenant.DstField = "DstNamespace"
+	loki, err := NewLoki(&cfg.Loki, "loki", reporter)
+	require.NoError(t, err)
+	loki.emitter = &fe
+
+	// WHEN a record between two tenants and a record of another pod are exported
+	require.NoError(t, loki.ProcessRecord(map[string]interface{}{
+		"SrcPod": "pod-1", "SrcNamespace": "ns-1", "DstNamespace": "ns-2"}))
+	require.NoError(t, loki.ProcessRecord(map[string]interface{}{
+		"SrcPod": "pod-2", "SrcNamespace": "ns-1", "DstNamespace": "ns-1"}))
+
+	// THEN the copy sent to each tenant counts as another stream
+	assert.Equal(t, []model.LabelValue{"pod-1", "pod-1", "other"}, handledLabels(&fe, "SrcPod"))
+	assert.Equal(t, []model.LabelValue{"ns-1", "ns-2", "ns-1"}, handledLabels(&fe, lokiTenantLabel))
+	assert.Contains(t, scrapeMetrics(t, hr), `exporter_loki_streams{exporter="loki"} 2`)
+}
+
+func TestLoki_CardinalityConfig(t *testing.T) {
+	// the limits are disabled by default
+	cfg := config.Default()
+	cfg.Loki.Labels = []string{"SrcNamespace", "DstPort"}
+	loki, err := NewLoki(&cfg.Loki, "loki", health.NewReporter(health.Starting))
+	require.NoError(t, err)
+	assert.Nil(t, loki.streams)
+	require.NoError(t, loki.Close())
+}
+
 func TestTimestampScale(t *testing.T) {
 	// verifies that the unix residual time (below 1-second precision) is properly
 	// incorporated into the timestamp whichever scale it is
@@ -126,7 +378,7 @@ func TestTimestampScale(t *testing.T) {
 			cfg, err := config.Read(strings.NewReader(
 				fmt.Sprintf("loki: {timestampScale: %s}", testCase.unit)))
 			require.NoError(t, err)
-			loki, err := NewLoki(&cfg.Loki)
+			loki, err := NewLoki(&cfg.Loki, "loki", health.NewReporter(health.Starting))
 			require.NoError(t, err)
 			loki.emitter = &fe
 
@@ -137,6 +389,100 @@ func TestTimestampScale(t *testing.T) {
 	}
 }
 
+func TestLoki_TimestampFields(t *testing.T) {
+	// GIVEN a Loki exporter that looks for the timestamp in several fields, with a maximum
+	// clock skew of one hour
+	fe := fakeEmitter{}
+	fe.On("Handle", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	reporter := health.NewReporter(health.Ready)
+	cfg, err := config.Read(strings.NewReader(`
+loki:
+  maxClockSkew: 1h
+  timestampFields:
+    - name: TimeFlowEndMs
+      scale: 1ms
+    - name: TimeFlowStart
+    - name: TimeReceived
+`))
+	require.NoError(t, err)
+	loki, err := NewLoki(&cfg.Loki, "loki", reporter)
+	require.NoError(t, err)
+	loki.emitter = &fe
+	now := time.Unix(1000000, 0)
+	loki.timeNow = func() time.Time { return now }
+
+	// WHEN records with different timestamp fields are exported
+	for _, record := range []map[string]interface{}{
+		{"TimeFlowEndMs": 999999500, "TimeFlowStart": 999990, "TimeReceived": 999995},
+		{"TimeFlowEndMs": 0, "TimeFlowStart": 999990, "TimeReceived": 999995},
+		{"TimeFlowStart": 5, "TimeReceived": 999995},
+		{"TimeFlowStart": "yesterday", "TimeReceived": 2000000},
+	} {
+		require.NoError(t, loki.ProcessRecord(record))
+	}
+
+	// THEN the timestamp is taken from the first valid field
+	var timestamps []time.Time
+	for _, call := range fe.Calls {
+		timestamps = append(timestamps, call.Arguments.Get(1).(time.Time))
+	}
+	assert.Equal(t, []time.Time{
+		time.Unix(999999, 500000000), time.Unix(999990, 0), time.Unix(999995, 0), now,
+	}, timestamps)
+	// AND the skipped fields are reported
+	metrics := getMetrics(t, reporter)
+	assert.Contains(t, metrics, `exporter_loki_timestamp_skipped{exporter="loki",field="TimeFlowEndMs",reason="invalid"} 1`)
+	assert.Contains(t, metrics, `exporter_loki_timestamp_skipped{exporter="loki",field="TimeFlowEndMs",reason="missing"} 2`)
+	assert.Contains(t, metrics, `exporter_loki_timestamp_skipped{exporter="loki",field="TimeFlowStart",reason="skew"} 1`)
+	assert.Contains(t, metrics, `exporter_loki_timestamp_skipped{exporter="loki",field="TimeFlowStart",reason="invalid"} 1`)
+	assert.Contains(t, metrics, `exporter_loki_timestamp_skipped{exporter="loki",field="TimeReceived",reason="skew"} 1`)
+	assert.Contains(t, metrics, `exporter_loki_local_timestamp{exporter="loki"} 1`)
+}
+
+func TestLoki_OutOfOrder(t *testing.T) {
+	now := time.Unix(1000000, 0)
+	for _, testCase := range []struct {
+		action    string
+		timestamp time.Time
+		labels    model.LabelSet
+	}{
+		{action: "clamp", timestamp: now.Add(-30 * time.Minute), labels: model.LabelSet{"app": "goflow-kube"}},
+		{action: "route", timestamp: now, labels: model.LabelSet{"app": "goflow-kube", "late": "true"}},
+		{action: "drop"},
+	} {
+		t.Run(testCase.action, func(t *testing.T) {
+			// GIVEN a Loki exporter that accepts timestamps up to 30 minutes old
+			fe := fakeEmitter{}
+			fe.On("Handle", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+			reporter := health.NewReporter(health.Ready)
+			cfg := config.Default()
+			cfg.Loki.OutOfOrder = config.LokiOutOfOrderConfig{
+				MaxAge: 30 * time.Minute, Action: testCase.action, RouteLabels: model.LabelSet{"late": "true"},
+			}
+			loki, err := NewLoki(&cfg.Loki, "loki", reporter)
+			require.NoError(t, err)
+			loki.emitter = &fe
+			loki.timeNow = func() time.Time { return now }
+
+			// WHEN a recent record and an older record are exported
+			require.NoError(t, loki.ProcessRecord(map[string]interface{}{"TimeReceived": 999000}))
+			require.NoError(t, loki.ProcessRecord(map[string]interface{}{"TimeReceived": 990000}))
+
+			// THEN the recent record is sent as it is
+			fe.AssertCalled(t, "Handle", model.LabelSet{"app": "goflow-kube"}, time.Unix(999000, 0),
+				`{"TimeReceived":999000}`)
+			// AND the action is applied to the older record
+			if testCase.action == "drop" {
+				fe.AssertNumberOfCalls(t, "Handle", 1)
+			} else {
+				fe.AssertCalled(t, "Handle", testCase.labels, testCase.timestamp, `{"TimeReceived":990000}`)
+			}
+			assert.Contains(t, getMetrics(t, reporter),
+				fmt.Sprintf(`exporter_loki_out_of_order{action=%q,exporter="loki"} 1`, testCase.action))
+		})
+	}
+}
+
 // Tests those cases where the timestamp can't be extracted and reports the current time
 func TestTimestampExtraction_LocalTime(t *testing.T) {
 	for _, testCase := range []struct {
@@ -154,7 +500,7 @@ func TestTimestampExtraction_LocalTime(t *testing.T) {
 			fe.On("Handle", mock.Anything, mock.Anything, mock.Anything).Return(nil)
 			cfg := config.Default()
 			cfg.Loki.TimestampLabel = testCase.tsLabel
-			loki, err := NewLoki(&cfg.Loki)
+			loki, err := NewLoki(&cfg.Loki, "loki", health.NewReporter(health.Starting))
 			require.NoError(t, err)
 			loki.emitter = &fe
 			loki.timeNow = func() time.Time {
@@ -182,7 +528,7 @@ loki:
     - "ignored?"
 `))
 	require.NoError(t, err)
-	loki, err := NewLoki(&cfg.Loki)
+	loki, err := NewLoki(&cfg.Loki, "loki", health.NewReporter(health.Starting))
 	require.NoError(t, err)
 	loki.emitter = &fe
 
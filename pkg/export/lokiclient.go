@@ -0,0 +1,384 @@
+package export
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/netobserv/loki-client-go/loki"
+	"github.com/netobserv/loki-client-go/pkg/backoff"
+	"github.com/netobserv/loki-client-go/pkg/logproto"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+
+	"github.com/netobserv/goflow2-kube-enricher/pkg/config"
+	"github.com/netobserv/goflow2-kube-enricher/pkg/health"
+)
+
+var (
+	errLokiClosed    = errors.New("the Loki client is closed")
+	errLokiQueueFull = errors.New("the queue of the Loki endpoint is full")
+)
+
+// lokiTenantLabel is the reserved label that sets the tenant of an entry, as in the Loki client
+const lokiTenantLabel = loki.ReservedLabelTenantID
+
+// lokiMaxErrorMessageLength is the maximum number of bytes of the Loki responses that are
+// added to the errors, as the Loki client does
+const lokiMaxErrorMessageLength = 1024
+
+// lokiBatch groups the entries of a tenant by stream
+type lokiBatch struct {
+	logproto.PushRequest
+	tenant string
+	// index of each stream in the request, by its labels
+	streams   map[string]int
+	entries   int
+	bytes     int
+	createdAt time.Time
+	// records are the original records of the entries, if they are kept
+	records []map[string]interface{}
+}
+
+func newLokiBatch(tenant string, createdAt time.Time) *lokiBatch {
+	return &lokiBatch{tenant: tenant, streams: map[string]int{}, createdAt: createdAt}
+}
+
+func (b *lokiBatch) add(labels string, entries ...logproto.Entry) {
+	for _, entry := range entries {
+		b.bytes += len(entry.Line)
+	}
+	b.entries += len(entries)
+	if i, ok := b.streams[labels]; ok {
+		b.Streams[i].Entries = append(b.Streams[i].Entries, entries...)
+		return
+	}
+	b.streams[labels] = len(b.Streams)
+	b.Streams = append(b.Streams, logproto.Stream{Labels: labels, Entries: entries})
+}
+
+// lokiPusher sends the batches to the Loki push API, retrying them on connection errors,
+// throttling and server errors, and accounts the deliveries by endpoint and tenant. The
+// connection and server errors make the next retry go to another endpoint, if any
+type lokiPusher struct {
+	config    *config.LokiConfig
+	endpoints *lokiFailover
+	metrics   *lokiMetrics
+}
+
+func newLokiPusher(cfg *config.LokiConfig, endpoints *lokiFailover, name string,
+	reporter *health.Reporter) (*lokiPusher, error) {
+	metrics, err := newLokiMetrics(name, reporter)
+	if err != nil {
+		return nil, err
+	}
+	return &lokiPusher{config: cfg, endpoints: endpoints, metrics: metrics}, nil
+}
+
+// push sends a batch until Loki accepts it, the retries are exhausted or the context is done.
+// maxRetries limits the number of requests, as the Loki client does, and <= 0 means retrying
+// until the context is done. The batches that are rejected, or whose retries are exhausted,
+// are accounted as dropped
+func (p *lokiPusher) push(ctx context.Context, batch *lokiBatch, maxRetries int) error {
+	encoded, err := batch.Marshal()
+	if err != nil {
+		return err
+	}
+	body := snappy.Encode(nil, encoded)
+	if maxRetries < 0 {
+		maxRetries = 0
+	}
+	attempts := 0
+	var ep *lokiEndpoint
+	err = withRetries(ctx, backoff.BackoffConfig{
+		MinBackoff: p.config.MinBackoff,
+		MaxBackoff: p.config.MaxBackoff,
+		MaxRetries: maxRetries,
+	}, log, func() (bool, error) {
+		ep = p.endpoints.current()
+		if attempts > 0 {
+			p.metrics.retried(ep, batch)
+		}
+		attempts++
+		start := time.Now()
+		status, message, err := p.send(ctx, ep, batch.tenant, body)
+		p.metrics.requested(ep, batch, status, err, time.Since(start))
+		if err != nil || status/100 == 5 {
+			if ctx.Err() == nil {
+				p.endpoints.failed(ep)
+			}
+			if err != nil {
+				return true, err
+			}
+		} else {
+			// a client error means that the endpoint is up, even if it rejected the batch
+			ep.setUp(true)
+		}
+		if status/100 == 2 {
+			return false, nil
+		}
+		err = fmt.Errorf("server returned HTTP status %d", status)
+		if message != "" {
+			err = fmt.Errorf("server returned HTTP status %d: %s", status, message)
+		}
+		return status/100 == 5 || status == http.StatusTooManyRequests, err
+	})
+	if err == nil {
+		p.metrics.sent(ep, batch, len(body))
+	} else if ctx.Err() == nil {
+		p.metrics.dropped(ep, batch)
+	}
+	return err
+}
+
+// send pushes an encoded batch to an endpoint, and returns the response status and, if Loki
+// didn't accept the batch, the first line of the response, which usually explains the reason
+// (e.g. "entry too far behind" or "per stream rate limit exceeded")
+func (p *lokiPusher) send(ctx context.Context, ep *lokiEndpoint, tenant string,
+	body []byte) (int, string, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.config.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ep.pushURL, bytes.NewReader(body))
+	if err != nil {
+		return 0, "", err
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	if tenant != "" {
+		req.Header.Set("X-Scope-OrgID", tenant)
+	}
+	resp, err := ep.client.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	message := ""
+	if resp.StatusCode/100 != 2 {
+		scanner := bufio.NewScanner(io.LimitReader(resp.Body, lokiMaxErrorMessageLength))
+		if scanner.Scan() {
+			message = scanner.Text()
+		}
+	}
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	return resp.StatusCode, message, nil
+}
+
+// entryTenant returns the tenant of an entry from its reserved label, or the default tenant,
+// and the labels without the reserved label
+func entryTenant(labels model.LabelSet, defaultTenant string) (string, model.LabelSet) {
+	value, ok := labels[lokiTenantLabel]
+	if !ok {
+		return defaultTenant, labels
+	}
+	labels = labels.Clone()
+	delete(labels, lokiTenantLabel)
+	return string(value), labels
+}
+
+// lokiClient is an emitter that batches the entries in memory, a batch per tenant, and sends
+// each batch when it reaches the batch size or the batch wait time. The failed batches are
+// retried up to the configured maximum retries, and then dropped.
+//
+// The Loki exporter has its own client, instead of the one of loki-client-go, because the
+// latter only accepts single entries that it batches by itself, so the WAL can't replay the
+// batches stored in previous executions, and it is bound to a single URL, so the batches
+// can't fail over to other endpoints or be mirrored. It also only logs the dropped batches,
+// while their records must be passed to the failure handler, and it registers global metrics
+// labelled by host, instead of by exporter, endpoint and tenant. The wire format, the headers
+// and the retry policy are the ones of the upstream client.
+type lokiClient struct {
+	config  *config.LokiConfig
+	pusher  *lokiPusher
+	entries chan lokiEntry
+	// dropped, if not nil, makes Handle drop the entries when the entries queue is full,
+	// instead of waiting for the pending batches to be sent
+	dropped     prometheus.Counter
+	flushes     chan chan struct{}
+	undelivered undelivered
+	failures    FailureHandler
+	ctx         context.Context
+	cancel      context.CancelFunc
+	quit        chan struct{}
+	once        sync.Once
+	done        sync.WaitGroup
+}
+
+type lokiEntry struct {
+	tenant string
+	labels string
+	entry  logproto.Entry
+	record map[string]interface{}
+}
+
+// newLokiClient creates a client whose Handle waits until the entries are added to their
+// batches, or, if dropped is not nil, that queues up to queueSize entries and drops the rest
+func newLokiClient(cfg *config.LokiConfig, pusher *lokiPusher, queueSize int,
+	dropped prometheus.Counter) *lokiClient {
+	if dropped == nil {
+		queueSize = 0
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &lokiClient{
+		config:  cfg,
+		pusher:  pusher,
+		entries: make(chan lokiEntry, queueSize),
+		dropped: dropped,
+		flushes: make(chan chan struct{}),
+		ctx:     ctx,
+		cancel:  cancel,
+		quit:    make(chan struct{}),
+	}
+	c.done.Add(1)
+	go c.run()
+	return c
+}
+
+// Handle queues the entry to be added to the batch of its tenant
+func (c *lokiClient) Handle(labels model.LabelSet, timestamp time.Time, record string) error {
+	return c.HandleRecord(labels, timestamp, record, nil)
+}
+
+// HandleRecord queues the entry, and keeps the original record to pass it to the failure
+// handler if the entry can't be delivered
+func (c *lokiClient) HandleRecord(labels model.LabelSet, timestamp time.Time, line string,
+	record map[string]interface{}) error {
+	tenant, labels := entryTenant(labels, c.config.TenantID)
+	e := lokiEntry{
+		tenant: tenant,
+		labels: labels.String(),
+		entry:  logproto.Entry{Timestamp: timestamp, Line: line},
+		record: record,
+	}
+	if c.dropped != nil {
+		select {
+		case c.entries <- e:
+			return nil
+		case <-c.quit:
+			return errLokiClosed
+		default:
+			c.dropped.Inc()
+			return errLokiQueueFull
+		}
+	}
+	select {
+	case c.entries <- e:
+		return nil
+	case <-c.quit:
+		return errLokiClosed
+	}
+}
+
+func (c *lokiClient) run() {
+	defer c.done.Done()
+	batches := map[string]*lokiBatch{}
+	// the batches that reached the wait time are looked for 10 times per wait time, so they
+	// are sent with a maximum delay of 10% of the wait time
+	period := c.config.BatchWait / 10
+	if period < 10*time.Millisecond {
+		period = 10 * time.Millisecond
+	}
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.quit:
+			c.addQueued(batches)
+			for _, batch := range batches {
+				c.send(batch)
+			}
+			return
+		case e := <-c.entries:
+			c.add(batches, e)
+		case flushed := <-c.flushes:
+			c.addQueued(batches)
+			for tenant, batch := range batches {
+				c.send(batch)
+				delete(batches, tenant)
+			}
+			close(flushed)
+		case <-ticker.C:
+			for tenant, batch := range batches {
+				if time.Since(batch.createdAt) >= c.config.BatchWait {
+					c.send(batch)
+					delete(batches, tenant)
+				}
+			}
+		}
+	}
+}
+
+// add adds an entry to the batch of its tenant, sending the batch first if it is full
+func (c *lokiClient) add(batches map[string]*lokiBatch, e lokiEntry) {
+	batch, ok := batches[e.tenant]
+	if ok && batch.bytes+len(e.entry.Line) > c.config.BatchSize {
+		c.send(batch)
+		ok = false
+	}
+	if !ok {
+		batch = newLokiBatch(e.tenant, time.Now())
+		batches[e.tenant] = batch
+	}
+	batch.add(e.labels, e.entry)
+	if e.record != nil {
+		batch.records = append(batch.records, e.record)
+	}
+}
+
+// addQueued adds the entries that are waiting in the queue to their batches
+func (c *lokiClient) addQueued(batches map[string]*lokiBatch) {
+	for {
+		select {
+		case e := <-c.entries:
+			c.add(batches, e)
+		default:
+			return
+		}
+	}
+}
+
+func (c *lokiClient) send(batch *lokiBatch) {
+	if err := c.pusher.push(c.ctx, batch, c.config.MaxRetries); err != nil {
+		log.WithError(err).WithField("tenant", batch.tenant).Error("can't send batch to Loki. Dropping it")
+		if c.failures != nil && len(batch.records) > 0 {
+			c.failures(batch.records, err)
+		} else if isUnavailable(err) {
+			c.undelivered.add(batch.entries, err)
+		}
+	}
+}
+
+// SetFailureHandler sets the handler of the records of the batches that can't be delivered.
+// It must be invoked before handling any entry
+func (c *lokiClient) SetFailureHandler(handler FailureHandler) {
+	c.failures = handler
+}
+
+// Flush sends the pending batches, without waiting for their batch wait time
+func (c *lokiClient) Flush() error {
+	flushed := make(chan struct{})
+	select {
+	case c.flushes <- flushed:
+	case <-c.quit:
+		return errLokiClosed
+	}
+	<-flushed
+	return c.undelivered.take()
+}
+
+// Stop sends the pending batches and stops the client. If all the endpoints are down, the
+// pending batches are dropped instead of waiting for their retries
+func (c *lokiClient) Stop() {
+	c.once.Do(func() { close(c.quit) })
+	if !c.pusher.endpoints.anyUp() {
+		c.cancel()
+	}
+	c.done.Wait()
+	c.cancel()
+}
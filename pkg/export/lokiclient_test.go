@@ -0,0 +1,133 @@
+package export
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/netobserv/loki-client-go/pkg/logproto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/netobserv/goflow2-kube-enricher/pkg/config"
+	"github.com/netobserv/goflow2-kube-enricher/pkg/health"
+)
+
+func TestLoki_Metrics(t *testing.T) {
+	// GIVEN a Loki instance
+	fake := &fakeLoki{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	// AND a Loki exporter that derives the tenant from the source namespace
+	reporter := health.NewReporter(health.Ready)
+	cfg := testWALConfig(server.URL, "")
+	cfg.Tenant = config.LokiTenantConfig{SrcField: "SrcNamespace"}
+	loki, err := NewLoki(&cfg, "loki", reporter)
+	require.NoError(t, err)
+
+	// WHEN records from different namespaces are exported
+	for _, ns := range []string{"ns-1", "ns-2", "ns-1"} {
+		require.NoError(t, loki.ProcessRecord(map[string]interface{}{"TimeReceived": 1000, "SrcNamespace": ns}))
+	}
+	require.NoError(t, loki.Close())
+
+	// THEN they are sent to Loki
+	assert.Len(t, fake.getTenantLines("ns-1"), 2)
+	assert.Len(t, fake.getTenantLines("ns-2"), 1)
+	// AND the deliveries are reported by endpoint and tenant
+	metrics := getMetrics(t, reporter)
+	ep := `endpoint="` + strings.TrimPrefix(server.URL, "http://") + `",exporter="loki"`
+	assert.Contains(t, metrics, `exporter_loki_record_sent{`+ep+`,tenant="ns-1"} 2`)
+	assert.Contains(t, metrics, `exporter_loki_record_sent{`+ep+`,tenant="ns-2"} 1`)
+	assert.Contains(t, metrics, `exporter_loki_batch_sent{`+ep+`,tenant="ns-1"} 1`)
+	assert.Regexp(t, `exporter_loki_sent_bytes{`+ep+`,tenant="ns-2"} [1-9]`, metrics)
+	assert.Contains(t, metrics,
+		`exporter_loki_request_duration_seconds_count{`+ep+`,status="204",tenant="ns-1"} 1`)
+	assert.NotContains(t, metrics, "exporter_loki_retries{")
+	assert.NotContains(t, metrics, "exporter_loki_record_dropped{")
+}
+
+func TestLoki_MetricsFailures(t *testing.T) {
+	for _, tc := range []struct {
+		status      int
+		requests    int
+		undelivered bool
+	}{
+		// the server errors are retried until the maximum retries
+		{status: http.StatusServiceUnavailable, requests: 3, undelivered: true},
+		// the client errors aren't retried
+		{status: http.StatusBadRequest, requests: 1},
+	} {
+		t.Run(http.StatusText(tc.status), func(t *testing.T) {
+			// GIVEN a Loki instance that fails the requests
+			fake := &fakeLoki{status: tc.status}
+			server := httptest.NewServer(fake)
+			defer server.Close()
+			// AND a Loki exporter that sends each batch up to 3 times
+			reporter := health.NewReporter(health.Ready)
+			cfg := testWALConfig(server.URL, "")
+			cfg.MaxRetries = 3
+			loki, err := NewLoki(&cfg, "loki", reporter)
+			require.NoError(t, err)
+
+			// WHEN some records are exported
+			for i := 0; i < 3; i++ {
+				require.NoError(t, loki.ProcessRecord(map[string]interface{}{"TimeReceived": 1000 + i}))
+			}
+			err = loki.Flush()
+			require.NoError(t, loki.Close())
+
+			// THEN the flush fails if the records could be delivered later, but not if Loki rejected them
+			if tc.undelivered {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			// AND the failed requests are reported
+			metrics := getMetrics(t, reporter)
+			ep := `endpoint="` + strings.TrimPrefix(server.URL, "http://") + `",exporter="loki"`
+			assert.Contains(t, metrics, `exporter_loki_record_dropped{`+ep+`,tenant="tenant"} 3`)
+			assert.Contains(t, metrics, `exporter_loki_request_duration_seconds_count{`+ep+`,status="`+
+				strconv.Itoa(tc.status)+`",tenant="tenant"} `+strconv.Itoa(tc.requests))
+			if tc.requests > 1 {
+				assert.Contains(t, metrics,
+					`exporter_loki_retries{`+ep+`,tenant="tenant"} `+strconv.Itoa(tc.requests-1))
+			} else {
+				assert.NotContains(t, metrics, "exporter_loki_retries{")
+			}
+			assert.NotContains(t, metrics, "exporter_loki_record_sent{")
+		})
+	}
+}
+
+func TestLoki_RejectionMessage(t *testing.T) {
+	// GIVEN a Loki instance that rejects the requests with a long explanation
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusBadRequest)
+		_, _ = rw.Write([]byte("entry too far behind" + strings.Repeat(".", 2*lokiMaxErrorMessageLength) +
+			"\nsecond line"))
+	}))
+	defer server.Close()
+	reporter := health.NewReporter(health.Ready)
+	cfg := testWALConfig(server.URL, "")
+	endpoints, err := newLokiEndpoints(&cfg, "loki", reporter)
+	require.NoError(t, err)
+	pusher, err := newLokiPusher(&cfg, newLokiFailover(endpoints.endpoints), "loki", reporter)
+	require.NoError(t, err)
+
+	// WHEN a batch is pushed
+	batch := newLokiBatch("tenant", time.Now())
+	batch.add(`{app="goflow-kube"}`, logproto.Entry{Timestamp: time.Now(), Line: "flow"})
+	err = pusher.push(context.Background(), batch, 1)
+
+	// THEN the error contains the beginning of the response
+	require.Error(t, err)
+	assert.True(t, strings.HasPrefix(err.Error(), "server returned HTTP status 400: entry too far behind..."),
+		err.Error())
+	assert.NotContains(t, err.Error(), "second line")
+	assert.LessOrEqual(t, len(err.Error()), lokiMaxErrorMessageLength+100)
+}
@@ -0,0 +1,457 @@
+package export
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	promconf "github.com/prometheus/common/config"
+	"github.com/prometheus/common/model"
+
+	"github.com/netobserv/goflow2-kube-enricher/pkg/config"
+	"github.com/netobserv/goflow2-kube-enricher/pkg/health"
+)
+
+// lokiEndpoint is a Loki instance where the batches are pushed. It is considered down after a
+// failed push, and up after a successful push or health check
+type lokiEndpoint struct {
+	name     string
+	pushURL  string
+	readyURL string
+	client   *http.Client
+	mt       sync.Mutex
+	up       bool
+	upGauge  prometheus.Gauge
+	// activeGauge reports whether the endpoint is receiving the batches
+	activeGauge prometheus.Gauge
+}
+
+func (e *lokiEndpoint) isUp() bool {
+	e.mt.Lock()
+	defer e.mt.Unlock()
+	return e.up
+}
+
+func (e *lokiEndpoint) setUp(up bool) {
+	e.mt.Lock()
+	defer e.mt.Unlock()
+	if e.up != up {
+		if up {
+			log.WithField("endpoint", e.name).Info("Loki endpoint is up")
+		} else {
+			log.WithField("endpoint", e.name).Warn("Loki endpoint is down")
+		}
+	}
+	e.up = up
+	if up {
+		e.upGauge.Set(1)
+	} else {
+		e.upGauge.Set(0)
+	}
+}
+
+// lokiEndpoints are the Loki instances of an exporter. When there are several of them, their
+// /ready endpoint is periodically checked, and their state is reported to the health checks
+type lokiEndpoints struct {
+	endpoints []*lokiEndpoint
+	timeout   time.Duration
+	quit      chan struct{}
+	once      sync.Once
+	done      sync.WaitGroup
+}
+
+func newLokiEndpoints(cfg *config.LokiConfig, name string, reporter *health.Reporter) (*lokiEndpoints, error) {
+	upGauges, err := reporter.Register(prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "exporter_loki_endpoint_up",
+			Help: "Whether the Loki endpoint accepted the last push request or health check (1) or not (0).",
+		},
+		[]string{"exporter", "endpoint"},
+	))
+	if err != nil {
+		return nil, err
+	}
+	activeGauges, err := reporter.Register(prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "exporter_loki_endpoint_active",
+			Help: "Whether the records are being sent to the Loki endpoint (1) or not (0).",
+		},
+		[]string{"exporter", "endpoint"},
+	))
+	if err != nil {
+		return nil, err
+	}
+	es := &lokiEndpoints{timeout: cfg.Timeout, quit: make(chan struct{})}
+	for _, ecfg := range cfg.LokiEndpoints() {
+		clientConfig := cfg.ClientConfig
+		if ecfg.ClientConfig != nil {
+			clientConfig = *ecfg.ClientConfig
+		}
+		client, err := promconf.NewClientFromConfig(clientConfig, "loki")
+		if err != nil {
+			return nil, fmt.Errorf("endpoint %q: %w", ecfg.Name, err)
+		}
+		base := strings.TrimSuffix(ecfg.URL, "/")
+		ep := &lokiEndpoint{
+			name:        ecfg.Name,
+			pushURL:     base + "/loki/api/v1/push",
+			readyURL:    base + "/ready",
+			client:      client,
+			upGauge:     upGauges.(*prometheus.GaugeVec).WithLabelValues(name, ecfg.Name),
+			activeGauge: activeGauges.(*prometheus.GaugeVec).WithLabelValues(name, ecfg.Name),
+		}
+		// the endpoints are considered up until a request fails
+		ep.up = true
+		ep.upGauge.Set(1)
+		es.endpoints = append(es.endpoints, ep)
+	}
+	if len(cfg.Endpoints) > 0 {
+		reporter.AddCheck("loki/"+name, es.check)
+		es.done.Add(1)
+		go es.checkLoop(cfg.HealthCheckInterval)
+	}
+	return es, nil
+}
+
+// check is up if any endpoint is up, and reports the state of each endpoint
+func (es *lokiEndpoints) check() (bool, interface{}) {
+	anyUp := false
+	states := map[string]string{}
+	for _, ep := range es.endpoints {
+		if ep.isUp() {
+			anyUp = true
+			states[ep.name] = "UP"
+		} else {
+			states[ep.name] = "DOWN"
+		}
+	}
+	return anyUp, states
+}
+
+func (es *lokiEndpoints) checkLoop(interval time.Duration) {
+	defer es.done.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-es.quit:
+			return
+		case <-ticker.C:
+			for _, ep := range es.endpoints {
+				ep.setUp(es.ready(ep))
+			}
+		}
+	}
+}
+
+// ready returns whether the /ready endpoint of the Loki instance responds successfully
+func (es *lokiEndpoints) ready(ep *lokiEndpoint) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), es.timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ep.readyURL, nil)
+	if err != nil {
+		return false
+	}
+	resp, err := ep.client.Do(req)
+	if err != nil {
+		log.WithError(err).WithField("endpoint", ep.name).Debug("Loki health check failed")
+		return false
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	return resp.StatusCode/100 == 2
+}
+
+// Stop stops the health checks
+func (es *lokiEndpoints) Stop() {
+	es.once.Do(func() { close(es.quit) })
+	es.done.Wait()
+}
+
+// lokiFailover selects the endpoint where a pusher sends the batches: the first endpoint, in
+// order, that is up. If all of them are down, the batches are retried on each endpoint in turn
+type lokiFailover struct {
+	endpoints []*lokiEndpoint
+	mt        sync.Mutex
+	active    int
+}
+
+func newLokiFailover(endpoints []*lokiEndpoint) *lokiFailover {
+	f := &lokiFailover{endpoints: endpoints}
+	f.setActive(0)
+	return f
+}
+
+// current returns the endpoint where the next request must be sent
+func (f *lokiFailover) current() *lokiEndpoint {
+	f.mt.Lock()
+	defer f.mt.Unlock()
+	for i, ep := range f.endpoints {
+		if ep.isUp() {
+			f.setActive(i)
+			return ep
+		}
+	}
+	return f.endpoints[f.active]
+}
+
+// anyUp returns whether any of the endpoints is up
+func (f *lokiFailover) anyUp() bool {
+	for _, ep := range f.endpoints {
+		if ep.isUp() {
+			return true
+		}
+	}
+	return false
+}
+
+// failed marks the endpoint as down, so the next request is sent to another endpoint
+func (f *lokiFailover) failed(ep *lokiEndpoint) {
+	ep.setUp(false)
+	f.mt.Lock()
+	defer f.mt.Unlock()
+	for i, e := range f.endpoints {
+		if e.isUp() {
+			f.setActive(i)
+			return
+		}
+	}
+	for i, e := range f.endpoints {
+		if e == ep {
+			f.setActive((i + 1) % len(f.endpoints))
+			return
+		}
+	}
+}
+
+// setActive must be invoked while holding the lock, except on creation
+func (f *lokiFailover) setActive(active int) {
+	if active != f.active && len(f.endpoints) > 1 {
+		log.WithField("endpoint", f.endpoints[active].name).Info("sending the batches to another Loki endpoint")
+	}
+	f.active = active
+	for i, ep := range f.endpoints {
+		if i == active {
+			ep.activeGauge.Set(1)
+		} else {
+			ep.activeGauge.Set(0)
+		}
+	}
+}
+
+// lokiMirror is an emitter that sends every entry to the emitters of all the endpoints
+type lokiMirror []*lokiMirrorLeg
+
+// lokiMirrorLeg is the emitter of an endpoint of the mirror mode
+type lokiMirrorLeg struct {
+	emitter
+	endpoint *lokiEndpoint
+	// flushing is 1 while a flush of the emitter is in progress
+	flushing int32
+	// flushErr is the error of the last flush that wasn't reported yet
+	flushErr error
+	mt       sync.Mutex
+	// undelivered are the entries that the emitter didn't accept while other endpoints did
+	undelivered undelivered
+}
+
+var errLokiMirrorFlushing = errors.New("the previous flush of the Loki endpoints hasn't finished")
+
+// lokiMirrorCheckPeriod is how often a flush of the mirror mode checks whether the endpoints
+// it waits for are still up
+const lokiMirrorCheckPeriod = 10 * time.Millisecond
+
+// newLokiEmitter creates the emitter of the endpoints: in failover mode, a single emitter
+// whose batches are sent to the active endpoint, and in mirror mode, an emitter per endpoint.
+// Each emitter of the mirror mode has its own WAL, in a subdirectory named as the endpoint
+func newLokiEmitter(cfg *config.LokiConfig, endpoints *lokiEndpoints, name string,
+	reporter *health.Reporter) (emitter, error) {
+	if cfg.EndpointsMode != config.LokiMirror || len(endpoints.endpoints) == 1 {
+		return newLokiEndpointsEmitter(cfg, endpoints.endpoints, "", name, reporter)
+	}
+	mirror := make(lokiMirror, 0, len(endpoints.endpoints))
+	for _, ep := range endpoints.endpoints {
+		epCfg := *cfg
+		if cfg.WAL.Dir != "" {
+			epCfg.WAL.Dir = path.Join(cfg.WAL.Dir, keyReplacer.Replace(strings.ReplaceAll(ep.name, ":", "_")))
+		}
+		em, err := newLokiEndpointsEmitter(&epCfg, []*lokiEndpoint{ep}, ep.name, name, reporter)
+		if err != nil {
+			mirror.Stop()
+			return nil, fmt.Errorf("endpoint %q: %w", ep.name, err)
+		}
+		mirror = append(mirror, &lokiMirrorLeg{emitter: em, endpoint: ep})
+	}
+	return mirror, nil
+}
+
+// newLokiEndpointsEmitter creates a client, or a WAL if it is enabled, that sends the batches
+// to the given endpoints. The mirrorEndpoint is the name of the endpoint, when it is a single
+// endpoint of the mirror mode: it identifies the WAL in the metrics, and bounds the queue of
+// the client so the endpoint doesn't block the others
+func newLokiEndpointsEmitter(cfg *config.LokiConfig, endpoints []*lokiEndpoint, mirrorEndpoint, name string,
+	reporter *health.Reporter) (emitter, error) {
+	pusher, err := newLokiPusher(cfg, newLokiFailover(endpoints), name, reporter)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.WAL.Dir != "" {
+		return newLokiWAL(cfg, pusher, mirrorEndpoint, name, reporter)
+	}
+	if mirrorEndpoint == "" {
+		return newLokiClient(cfg, pusher, 0, nil), nil
+	}
+	dropped, err := reporter.Register(prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "exporter_loki_mirror_dropped",
+			Help: "Number of records that have been dropped because the queue of the Loki endpoint was full, " +
+				"in mirror mode without WAL.",
+		},
+		[]string{"exporter", "endpoint"},
+	))
+	if err != nil {
+		return nil, err
+	}
+	return newLokiClient(cfg, pusher, cfg.MirrorQueueSize,
+		dropped.(*prometheus.CounterVec).WithLabelValues(name, mirrorEndpoint)), nil
+}
+
+// Handle sends the entry to all the endpoints. It only fails if none accepted it. Otherwise,
+// the endpoints that didn't accept it report it in their next flush
+func (m lokiMirror) Handle(labels model.LabelSet, timestamp time.Time, record string) error {
+	var err error
+	var failed []*lokiMirrorLeg
+	for _, leg := range m {
+		if e := leg.Handle(labels, timestamp, record); e != nil {
+			err = e
+			failed = append(failed, leg)
+		}
+	}
+	if len(failed) == len(m) {
+		return err
+	}
+	for _, leg := range failed {
+		leg.undelivered.add(1, err)
+	}
+	return nil
+}
+
+// Flush flushes the emitters of all the endpoints concurrently, and waits for the endpoints
+// that are up, so the Kafka offsets are only committed once all of them got the entries. The
+// endpoints that are down, or that go down while flushing, aren't waited for, so an unavailable
+// endpoint doesn't block the others, and the entries they drop are only counted in their metrics.
+// If all the endpoints are down, it returns as soon as any of them succeeds, and only fails if
+// all of them failed. The endpoints whose previous flush hasn't finished yet are skipped
+func (m lokiMirror) Flush() error {
+	results := make(chan *lokiMirrorLeg, len(m))
+	started := map[*lokiMirrorLeg]struct{}{}
+	waiting := map[*lokiMirrorLeg]struct{}{}
+	for _, leg := range m {
+		if leg.startFlush(results) {
+			started[leg] = struct{}{}
+			if leg.endpoint.isUp() {
+				waiting[leg] = struct{}{}
+			}
+		}
+	}
+	if len(waiting) == 0 {
+		return m.flushAny(started, results)
+	}
+	ticker := time.NewTicker(lokiMirrorCheckPeriod)
+	defer ticker.Stop()
+	var flushed []*lokiMirrorLeg
+	for len(waiting) > 0 {
+		select {
+		case leg := <-results:
+			if _, ok := waiting[leg]; ok {
+				delete(waiting, leg)
+				flushed = append(flushed, leg)
+			}
+		case <-ticker.C:
+			for leg := range waiting {
+				if !leg.endpoint.isUp() {
+					delete(waiting, leg)
+				}
+			}
+		}
+	}
+	var errs []error
+	for _, leg := range m {
+		undelivered := leg.undelivered.take()
+		if !leg.endpoint.isUp() {
+			// the endpoint is down: its entries were already given up
+			continue
+		}
+		if undelivered != nil {
+			errs = append(errs, fmt.Errorf("endpoint %q: %w", leg.endpoint.name, undelivered))
+		}
+	}
+	for _, leg := range flushed {
+		if err := leg.takeFlushErr(); err != nil && leg.endpoint.isUp() {
+			errs = append(errs, fmt.Errorf("endpoint %q: %w", leg.endpoint.name, err))
+		}
+	}
+	if len(errs) > 0 {
+		return joinErrors(errs)
+	}
+	return nil
+}
+
+// flushAny waits until any of the started flushes succeeds, or all of them fail
+func (m lokiMirror) flushAny(started map[*lokiMirrorLeg]struct{}, results <-chan *lokiMirrorLeg) error {
+	for _, leg := range m {
+		// the endpoints are down, so the entries they didn't accept were already given up
+		_ = leg.undelivered.take()
+	}
+	err := errLokiMirrorFlushing
+	for i := 0; i < len(started); i++ {
+		if err = (<-results).takeFlushErr(); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// startFlush flushes the emitter in the background, and sends the leg to the given channel
+// when it finishes. It returns false if the previous flush hasn't finished
+func (l *lokiMirrorLeg) startFlush(results chan<- *lokiMirrorLeg) bool {
+	if !atomic.CompareAndSwapInt32(&l.flushing, 0, 1) {
+		return false
+	}
+	go func() {
+		err := flushEmitter(l.emitter)
+		l.mt.Lock()
+		l.flushErr = err
+		l.mt.Unlock()
+		atomic.StoreInt32(&l.flushing, 0)
+		results <- l
+	}()
+	return true
+}
+
+// takeFlushErr returns the error of the last flush, and forgets it
+func (l *lokiMirrorLeg) takeFlushErr() error {
+	l.mt.Lock()
+	defer l.mt.Unlock()
+	err := l.flushErr
+	l.flushErr = nil
+	return err
+}
+
+// Stop stops the emitters of all the endpoints
+func (m lokiMirror) Stop() {
+	for _, leg := range m {
+		if stopper, ok := leg.emitter.(interface{ Stop() }); ok {
+			stopper.Stop()
+		}
+	}
+}
@@ -0,0 +1,276 @@
+package export
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/netobserv/goflow2-kube-enricher/pkg/config"
+	"github.com/netobserv/goflow2-kube-enricher/pkg/health"
+)
+
+// readyChecks returns the checks of the readiness report
+func readyChecks(t *testing.T, hr health.HTTPReporter) (int, map[string]health.StatusCheck) {
+	rec := httptest.NewRecorder()
+	hr.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/health/ready", nil))
+	report := health.Report{}
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&report))
+	checks := map[string]health.StatusCheck{}
+	for _, check := range report.Checks {
+		checks[check.Name] = check
+	}
+	return rec.Code, checks
+}
+
+func TestLoki_Failover(t *testing.T) {
+	// GIVEN a primary Loki instance that is unavailable, and a secondary instance
+	primary := &fakeLoki{status: http.StatusServiceUnavailable}
+	primaryServer := httptest.NewServer(primary)
+	defer primaryServer.Close()
+	secondary := &fakeLoki{}
+	secondaryServer := httptest.NewServer(secondary)
+	defer secondaryServer.Close()
+	// AND a Loki exporter in failover mode
+	reporter := health.NewReporter(health.Ready)
+	hr := health.NewHTTPReporter(reporter)
+	cfg := testWALConfig("", "")
+	cfg.Endpoints = []config.LokiEndpointConfig{
+		{Name: "primary", URL: primaryServer.URL},
+		{Name: "secondary", URL: secondaryServer.URL},
+	}
+	cfg.EndpointsMode = config.LokiFailover
+	cfg.HealthCheckInterval = 20 * time.Millisecond
+	loki, err := NewLoki(&cfg, "loki", reporter)
+	require.NoError(t, err)
+	defer loki.Close()
+
+	// WHEN some records are exported
+	for i := 1; i <= 3; i++ {
+		require.NoError(t, loki.ProcessRecord(map[string]interface{}{"TimeReceived": 1000 + i}))
+	}
+
+	// THEN they are sent to the secondary instance
+	require.Eventually(t, func() bool {
+		return len(secondary.getEntries()) == 3 && strings.Contains(scrapeMetrics(t, hr),
+			`exporter_loki_record_sent{endpoint="secondary",exporter="loki",tenant="tenant"} 3`)
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Empty(t, primary.getEntries())
+	// AND the state of the endpoints is reported
+	metrics := scrapeMetrics(t, hr)
+	assert.Contains(t, metrics, `exporter_loki_endpoint_up{endpoint="primary",exporter="loki"} 0`)
+	assert.Contains(t, metrics, `exporter_loki_endpoint_active{endpoint="primary",exporter="loki"} 0`)
+	assert.Contains(t, metrics, `exporter_loki_endpoint_active{endpoint="secondary",exporter="loki"} 1`)
+	code, checks := readyChecks(t, hr)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "UP", checks["loki/loki"].Status)
+	assert.Equal(t, map[string]interface{}{"primary": "DOWN", "secondary": "UP"}, checks["loki/loki"].Data)
+
+	// AND WHEN the primary instance recovers
+	primary.setStatus(0)
+	require.Eventually(t, func() bool {
+		_, checks := readyChecks(t, hr)
+		return checks["loki/loki"].Data.(map[string]interface{})["primary"] == "UP"
+	}, 5*time.Second, 10*time.Millisecond)
+	require.NoError(t, loki.ProcessRecord(map[string]interface{}{"TimeReceived": 1004}))
+
+	// THEN the new records are sent to the primary instance again
+	require.Eventually(t, func() bool {
+		return len(primary.getEntries()) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Len(t, secondary.getEntries(), 3)
+}
+
+func TestLoki_Mirror(t *testing.T) {
+	dir, err := ioutil.TempDir("", "loki-wal")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	// GIVEN an old Loki instance, and a new instance that is unavailable
+	oldLoki := &fakeLoki{}
+	oldServer := httptest.NewServer(oldLoki)
+	defer oldServer.Close()
+	newLoki := &fakeLoki{status: http.StatusServiceUnavailable}
+	newServer := httptest.NewServer(newLoki)
+	defer newServer.Close()
+	// AND a Loki exporter with WAL in mirror mode
+	reporter := health.NewReporter(health.Ready)
+	hr := health.NewHTTPReporter(reporter)
+	cfg := testWALConfig("", dir)
+	cfg.Endpoints = []config.LokiEndpointConfig{
+		{Name: "old", URL: oldServer.URL},
+		{Name: "new", URL: newServer.URL},
+	}
+	cfg.EndpointsMode = config.LokiMirror
+	cfg.HealthCheckInterval = 20 * time.Millisecond
+	loki, err := NewLoki(&cfg, "loki", reporter)
+	require.NoError(t, err)
+	defer loki.Close()
+
+	// WHEN some records are exported
+	for i := 1; i <= 3; i++ {
+		require.NoError(t, loki.ProcessRecord(map[string]interface{}{"TimeReceived": 1000 + i}))
+	}
+
+	// THEN the available instance receives them
+	require.Eventually(t, func() bool {
+		return len(oldLoki.getEntries()) == 3
+	}, 5*time.Second, 10*time.Millisecond)
+	// AND they are kept in the WAL of the unavailable instance
+	assert.NotEmpty(t, walSegments(t, path.Join(dir, "new")))
+	var metrics string
+	require.Eventually(t, func() bool {
+		metrics = scrapeMetrics(t, hr)
+		return strings.Contains(metrics, `exporter_loki_endpoint_up{endpoint="new",exporter="loki"} 0`)
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Contains(t, metrics, `exporter_loki_endpoint_active{endpoint="new",exporter="loki"} 1`)
+	assert.Contains(t, metrics, `exporter_loki_endpoint_active{endpoint="old",exporter="loki"} 1`)
+	assert.Contains(t, metrics, `exporter_loki_wal_buffered_bytes{endpoint="old",exporter="loki"} 0`)
+	assert.NotContains(t, metrics, `exporter_loki_wal_buffered_bytes{endpoint="new",exporter="loki"} 0`)
+
+	// AND WHEN the new instance recovers
+	newLoki.setStatus(0)
+
+	// THEN it receives all the records too
+	require.Eventually(t, func() bool {
+		return len(newLoki.getEntries()) == 3
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Len(t, oldLoki.getEntries(), 3)
+}
+
+func TestLoki_MirrorHangingEndpoint(t *testing.T) {
+	// GIVEN a Loki instance, and another instance that never responds
+	fake := &fakeLoki{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	release := make(chan struct{})
+	hanging := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		<-release
+	}))
+	defer hanging.Close()
+	defer close(release)
+	// AND a Loki exporter without WAL in mirror mode, which retries the batches forever
+	reporter := health.NewReporter(health.Ready)
+	hr := health.NewHTTPReporter(reporter)
+	cfg := testWALConfig("", "")
+	cfg.Timeout = 500 * time.Millisecond
+	cfg.Endpoints = []config.LokiEndpointConfig{
+		{Name: "available", URL: server.URL},
+		{Name: "hanging", URL: hanging.URL},
+	}
+	cfg.EndpointsMode = config.LokiMirror
+	cfg.HealthCheckInterval = 20 * time.Millisecond
+	cfg.MirrorQueueSize = 5
+	loki, err := NewLoki(&cfg, "loki", reporter)
+	require.NoError(t, err)
+
+	available := loki.emitter.(lokiMirror)[0].emitter.(*lokiClient)
+
+	// WHEN many records are exported, as fast as the available instance receives them
+	for i := 1; i <= 50; i++ {
+		require.NoError(t, loki.ProcessRecord(map[string]interface{}{"TimeReceived": 1000 + i}))
+		require.Eventually(t, func() bool {
+			return len(available.entries) == 0
+		}, 5*time.Second, time.Millisecond)
+	}
+	require.NoError(t, loki.Flush())
+
+	// THEN the available instance receives all of them
+	lines := map[string]struct{}{}
+	for _, entry := range fake.getEntries() {
+		lines[entry.Line] = struct{}{}
+	}
+	assert.Len(t, lines, 50)
+	// AND the records that don't fit in the queue of the hanging instance are dropped
+	var metrics string
+	require.Eventually(t, func() bool {
+		metrics = scrapeMetrics(t, hr)
+		return strings.Contains(metrics, `exporter_loki_endpoint_up{endpoint="hanging",exporter="loki"} 0`)
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Regexp(t, `exporter_loki_mirror_dropped{endpoint="hanging",exporter="loki"} [1-9]`, metrics)
+	assert.Contains(t, metrics, `exporter_loki_mirror_dropped{endpoint="available",exporter="loki"} 0`)
+
+	// AND the exporter can be closed without waiting for the hanging instance
+	closed := make(chan error)
+	go func() { closed <- loki.Close() }()
+	select {
+	case err := <-closed:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "the exporter didn't close")
+	}
+}
+
+func TestLoki_MirrorFlushWaitsForAvailableEndpoints(t *testing.T) {
+	// GIVEN a Loki instance, and another instance that is slow to respond to the first request
+	fast := &fakeLoki{}
+	fastServer := httptest.NewServer(fast)
+	defer fastServer.Close()
+	slow := &fakeLoki{}
+	received := make(chan struct{}, 1)
+	release := make(chan struct{})
+	slowServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		select {
+		case received <- struct{}{}:
+		default:
+		}
+		<-release
+		slow.ServeHTTP(rw, req)
+	}))
+	defer slowServer.Close()
+	// AND a Loki exporter without WAL in mirror mode, with a small queue per endpoint
+	cfg := testWALConfig("", "")
+	cfg.Timeout = 5 * time.Second
+	cfg.Endpoints = []config.LokiEndpointConfig{
+		{Name: "fast", URL: fastServer.URL},
+		{Name: "slow", URL: slowServer.URL},
+	}
+	cfg.EndpointsMode = config.LokiMirror
+	cfg.HealthCheckInterval = time.Minute
+	cfg.MirrorQueueSize = 1
+	loki, err := NewLoki(&cfg, "loki", health.NewReporter(health.Ready))
+	require.NoError(t, err)
+	defer loki.Close()
+	var once sync.Once
+	defer once.Do(func() { close(release) })
+	available := loki.emitter.(lokiMirror)[0].emitter.(*lokiClient)
+
+	// WHEN a record is exported, and the slow instance is receiving it
+	require.NoError(t, loki.ProcessRecord(map[string]interface{}{"TimeReceived": 1001}))
+	select {
+	case <-received:
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "the slow instance didn't receive any request")
+	}
+	// AND more records are exported than the queue of the slow instance can hold
+	for i := 2; i <= 4; i++ {
+		require.NoError(t, loki.ProcessRecord(map[string]interface{}{"TimeReceived": 1000 + i}))
+		require.Eventually(t, func() bool {
+			return len(available.entries) == 0
+		}, 5*time.Second, time.Millisecond)
+	}
+	// AND the slow instance responds afterwards
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		once.Do(func() { close(release) })
+	}()
+
+	// THEN the flush waits for the slow instance, since it is up
+	err = loki.Flush()
+	assert.Len(t, fast.getEntries(), 4)
+	assert.Len(t, slow.getEntries(), 2)
+	// AND it fails because of the records that the slow instance dropped
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `endpoint "slow"`)
+	assert.Contains(t, err.Error(), "2 records couldn't be delivered")
+	// AND the dropped records are only reported once
+	assert.NoError(t, loki.Flush())
+}
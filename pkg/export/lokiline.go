@@ -0,0 +1,164 @@
+package export
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sort"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/go-logfmt/logfmt"
+	jsoniter "github.com/json-iterator/go"
+
+	"github.com/netobserv/goflow2-kube-enricher/pkg/config"
+)
+
+// lineEncoder writes the records as Loki log lines, after removing the fields that aren't
+// selected by the configuration
+type lineEncoder struct {
+	format   string
+	template *template.Template
+	// templateFields are the record fields used by the template, which are written as empty
+	// values when the record doesn't have them
+	templateFields []string
+	fields         map[string]struct{}
+	omitEmpty      bool
+}
+
+func newLineEncoder(cfg *config.LokiConfig) (*lineEncoder, error) {
+	le := &lineEncoder{format: cfg.LineFormat, omitEmpty: cfg.OmitEmpty}
+	if le.format == "" {
+		le.format = config.LokiLineJSON
+	}
+	if le.format == config.LokiLineTemplate {
+		tmpl, err := template.New("line").Option("missingkey=zero").Parse(cfg.LineTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("can't parse lineTemplate: %w", err)
+		}
+		le.template = tmpl
+		le.templateFields = templateFields(tmpl)
+	}
+	if len(cfg.Fields) > 0 {
+		le.fields = map[string]struct{}{}
+		for _, field := range cfg.Fields {
+			le.fields[field] = struct{}{}
+		}
+	}
+	return le, nil
+}
+
+// encode removes from the record the fields that aren't selected, and returns the log line
+func (le *lineEncoder) encode(record map[string]interface{}) (string, error) {
+	for field, value := range record {
+		if _, ok := le.fields[field]; le.fields != nil && !ok {
+			delete(record, field)
+		} else if le.omitEmpty && isEmptyValue(value) {
+			delete(record, field)
+		}
+	}
+	switch le.format {
+	case config.LokiLineLogfmt:
+		return encodeLogfmt(record)
+	case config.LokiLineTemplate:
+		// the zero value of the interface{} values of a map would be written as "<no value>"
+		for _, field := range le.templateFields {
+			if _, ok := record[field]; !ok {
+				record[field] = ""
+			}
+		}
+		buf := bytes.Buffer{}
+		if err := le.template.Execute(&buf, record); err != nil {
+			return "", err
+		}
+		return buf.String(), nil
+	default:
+		js, err := jsoniter.ConfigCompatibleWithStandardLibrary.Marshal(record)
+		return string(js), err
+	}
+}
+
+// templateFields returns the names of the fields referenced by a template, like SrcPod in
+// {{.SrcPod}} or {{if .SrcPod}}
+func templateFields(tmpl *template.Template) []string {
+	fields := map[string]struct{}{}
+	var walk func(node parse.Node)
+	walk = func(node parse.Node) {
+		switch n := node.(type) {
+		case *parse.ListNode:
+			if n != nil {
+				for _, child := range n.Nodes {
+					walk(child)
+				}
+			}
+		case *parse.ActionNode:
+			walk(n.Pipe)
+		case *parse.IfNode:
+			walk(&n.BranchNode)
+		case *parse.RangeNode:
+			walk(&n.BranchNode)
+		case *parse.WithNode:
+			walk(&n.BranchNode)
+		case *parse.BranchNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.TemplateNode:
+			walk(n.Pipe)
+		case *parse.PipeNode:
+			if n != nil {
+				for _, cmd := range n.Cmds {
+					walk(cmd)
+				}
+			}
+		case *parse.CommandNode:
+			for _, arg := range n.Args {
+				walk(arg)
+			}
+		case *parse.FieldNode:
+			fields[n.Ident[0]] = struct{}{}
+		}
+	}
+	for _, t := range tmpl.Templates() {
+		if t.Tree != nil {
+			walk(t.Tree.Root)
+		}
+	}
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// encodeLogfmt writes the record as key=value pairs, sorted by key
+func encodeLogfmt(record map[string]interface{}) (string, error) {
+	keys := make([]string, 0, len(record))
+	for key := range record {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	buf := bytes.Buffer{}
+	enc := logfmt.NewEncoder(&buf)
+	for _, key := range keys {
+		if err := enc.EncodeKeyval(key, record[key]); err != nil {
+			return "", fmt.Errorf("can't encode field %s: %w", key, err)
+		}
+	}
+	return buf.String(), nil
+}
+
+// isEmptyValue returns whether the value is nil, zero, false, or an empty string, slice or map
+func isEmptyValue(value interface{}) bool {
+	if value == nil {
+		return true
+	}
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map, reflect.Array, reflect.String:
+		return v.Len() == 0
+	default:
+		return v.IsZero()
+	}
+}
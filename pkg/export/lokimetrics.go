@@ -0,0 +1,86 @@
+package export
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/netobserv/goflow2-kube-enricher/pkg/health"
+)
+
+// lokiMetrics accounts the requests of a Loki pusher by exporter, endpoint and tenant
+type lokiMetrics struct {
+	name            string
+	recordsSent     *prometheus.CounterVec
+	bytesSent       *prometheus.CounterVec
+	batchesSent     *prometheus.CounterVec
+	retries         *prometheus.CounterVec
+	recordsDropped  *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+}
+
+func newLokiMetrics(name string, reporter *health.Reporter) (*lokiMetrics, error) {
+	m := &lokiMetrics{name: name}
+	for _, counter := range []struct {
+		vec  **prometheus.CounterVec
+		name string
+		help string
+	}{
+		{&m.recordsSent, "exporter_loki_record_sent", "Number of records that have been accepted by Loki."},
+		{&m.bytesSent, "exporter_loki_sent_bytes", "Size of the compressed batches that have been accepted by Loki."},
+		{&m.batchesSent, "exporter_loki_batch_sent", "Number of batches that have been accepted by Loki."},
+		{&m.retries, "exporter_loki_retries", "Number of times a batch has been sent again after a failed request."},
+		{&m.recordsDropped, "exporter_loki_record_dropped",
+			"Number of records that have been dropped because Loki rejected them or the retries were exhausted."},
+	} {
+		registered, err := reporter.Register(prometheus.NewCounterVec(
+			prometheus.CounterOpts{Name: counter.name, Help: counter.help},
+			[]string{"exporter", "endpoint", "tenant"},
+		))
+		if err != nil {
+			return nil, err
+		}
+		*counter.vec = registered.(*prometheus.CounterVec)
+	}
+	requestDuration, err := reporter.Register(prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "exporter_loki_request_duration_seconds",
+			Help:    "Duration of the requests to the Loki push API, by HTTP status code (or \"error\" if no response was received).",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"exporter", "endpoint", "tenant", "status"},
+	))
+	if err != nil {
+		return nil, err
+	}
+	m.requestDuration = requestDuration.(*prometheus.HistogramVec)
+	return m, nil
+}
+
+// retried accounts a request that is sent again after a failed one
+func (m *lokiMetrics) retried(ep *lokiEndpoint, batch *lokiBatch) {
+	m.retries.WithLabelValues(m.name, ep.name, batch.tenant).Inc()
+}
+
+// requested observes the duration of a request, by its response status, or "error" if no
+// response was received
+func (m *lokiMetrics) requested(ep *lokiEndpoint, batch *lokiBatch, status int, err error, duration time.Duration) {
+	statusLabel := "error"
+	if err == nil {
+		statusLabel = strconv.Itoa(status)
+	}
+	m.requestDuration.WithLabelValues(m.name, ep.name, batch.tenant, statusLabel).Observe(duration.Seconds())
+}
+
+// sent accounts a batch that Loki accepted, along with the size of its compressed body
+func (m *lokiMetrics) sent(ep *lokiEndpoint, batch *lokiBatch, bytes int) {
+	m.recordsSent.WithLabelValues(m.name, ep.name, batch.tenant).Add(float64(batch.entries))
+	m.bytesSent.WithLabelValues(m.name, ep.name, batch.tenant).Add(float64(bytes))
+	m.batchesSent.WithLabelValues(m.name, ep.name, batch.tenant).Inc()
+}
+
+// dropped accounts the entries of a batch that Loki rejected, or whose retries were exhausted
+func (m *lokiMetrics) dropped(ep *lokiEndpoint, batch *lokiBatch) {
+	m.recordsDropped.WithLabelValues(m.name, ep.name, batch.tenant).Add(float64(batch.entries))
+}
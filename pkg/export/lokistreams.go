@@ -0,0 +1,201 @@
+package export
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+
+	"github.com/netobserv/goflow2-kube-enricher/pkg/cardinality"
+	"github.com/netobserv/goflow2-kube-enricher/pkg/config"
+	"github.com/netobserv/goflow2-kube-enricher/pkg/health"
+)
+
+// highCardinalityFields are record fields whose values are usually unbounded, so using them
+// as Loki labels creates too many streams
+var highCardinalityFields = map[string]struct{}{
+	"SrcAddr": {}, "DstAddr": {}, "SrcPort": {}, "DstPort": {}, "SrcMac": {}, "DstMac": {},
+	"SrcPod": {}, "DstPod": {}, "SrcHostIP": {}, "DstHostIP": {}, "Bytes": {}, "Packets": {},
+	"TimeReceived": {}, "TimeFlowStart": {}, "TimeFlowEnd": {}, "SequenceNum": {},
+}
+
+// lokiStreams keeps bounded the number of Loki streams that are created from the non-static
+// labels. A new stream whose label values exceed the per-label limits gets those values
+// replaced by cardinality.Overflow. Once the maximum number of active streams is reached,
+// all the non-static labels of any new stream are replaced by cardinality.Overflow
+type lokiStreams struct {
+	config  config.LokiCardinalityConfig
+	metrics *lokiStreamsMetrics
+	now     func() time.Time
+	// limits of the distinct values of each non-static label. 0 means no limit
+	limits     map[model.LabelName]int
+	mt         sync.Mutex
+	streams    map[model.Fingerprint]*lokiStream
+	lastExpiry time.Time
+	// labelValues counts, for each label, the number of active streams using each value
+	labelValues map[model.LabelName]map[model.LabelValue]int
+}
+
+type lokiStream struct {
+	labels  model.LabelSet
+	updated time.Time
+}
+
+type lokiStreamsMetrics struct {
+	streams        prometheus.Gauge
+	labelOverflows *prometheus.CounterVec
+	streamOverflow prometheus.Counter
+	name           string
+}
+
+// newLokiStreams returns the guard of the streams created from the given record fields, or
+// nil if the configuration doesn't set any limit
+func newLokiStreams(cfg *config.LokiCardinalityConfig, labels []string, name string,
+	reporter *health.Reporter) (*lokiStreams, error) {
+	if len(labels) == 0 || (cfg.MaxLabelValues == 0 && len(cfg.LabelLimits) == 0 && cfg.MaxStreams == 0) {
+		return nil, nil
+	}
+	metrics, err := newLokiStreamsMetrics(name, reporter)
+	if err != nil {
+		return nil, err
+	}
+	s := &lokiStreams{
+		config:      *cfg,
+		metrics:     metrics,
+		now:         time.Now,
+		limits:      map[model.LabelName]int{},
+		streams:     map[model.Fingerprint]*lokiStream{},
+		labelValues: map[model.LabelName]map[model.LabelValue]int{},
+	}
+	for _, label := range labels {
+		limit, ok := cfg.LabelLimits[label]
+		if !ok {
+			limit = cfg.MaxLabelValues
+		}
+		key := model.LabelName(keyReplacer.Replace(label))
+		s.limits[key] = limit
+		s.labelValues[key] = map[model.LabelValue]int{}
+	}
+	return s, nil
+}
+
+func newLokiStreamsMetrics(name string, reporter *health.Reporter) (*lokiStreamsMetrics, error) {
+	streams, err := reporter.Register(prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "exporter_loki_streams",
+			Help: "Number of active Loki streams, as accounted by the cardinality limits.",
+		},
+		[]string{"exporter"},
+	))
+	if err != nil {
+		return nil, err
+	}
+	labelOverflows, err := reporter.Register(prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "exporter_loki_label_overflow",
+			Help: "Number of records whose label value has been replaced by \"" +
+				cardinality.Overflow + "\" because the label reached its maximum number of values.",
+		},
+		[]string{"exporter", "label"},
+	))
+	if err != nil {
+		return nil, err
+	}
+	streamOverflow, err := reporter.Register(prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "exporter_loki_stream_overflow",
+			Help: "Number of records whose labels have been replaced by \"" +
+				cardinality.Overflow + "\" because the maximum number of Loki streams was reached.",
+		},
+		[]string{"exporter"},
+	))
+	if err != nil {
+		return nil, err
+	}
+	return &lokiStreamsMetrics{
+		streams:        streams.(*prometheus.GaugeVec).WithLabelValues(name),
+		labelOverflows: labelOverflows.(*prometheus.CounterVec),
+		streamOverflow: streamOverflow.(*prometheus.CounterVec).WithLabelValues(name),
+		name:           name,
+	}, nil
+}
+
+// limit replaces, in the given labels, the values that exceed the cardinality limits
+func (s *lokiStreams) limit(labels model.LabelSet) {
+	now := s.now()
+	s.mt.Lock()
+	defer s.mt.Unlock()
+	// the expired streams are removed periodically, since their label values might be needed
+	// by the new streams
+	if s.config.StreamTTL > 0 && now.Sub(s.lastExpiry) >= s.config.StreamTTL {
+		s.expire(now)
+	}
+	if s.touch(labels, now) {
+		return
+	}
+	overflow := false
+	for name, limit := range s.limits {
+		lv, ok := labels[name]
+		if !ok || limit <= 0 {
+			continue
+		}
+		if _, ok := s.labelValues[name][lv]; !ok && len(s.labelValues[name]) >= limit {
+			labels[name] = cardinality.Overflow
+			s.metrics.labelOverflows.WithLabelValues(s.metrics.name, string(name)).Inc()
+			overflow = true
+		}
+	}
+	if overflow && s.touch(labels, now) {
+		return
+	}
+	if s.config.MaxStreams > 0 && len(s.streams) >= s.config.MaxStreams {
+		// the overflow stream is not tracked, so it doesn't count towards the limits
+		for name := range s.limits {
+			if _, ok := labels[name]; ok {
+				labels[name] = cardinality.Overflow
+			}
+		}
+		s.metrics.streamOverflow.Inc()
+		return
+	}
+	s.streams[labels.Fingerprint()] = &lokiStream{labels: labels.Clone(), updated: now}
+	s.trackLabelValues(labels, 1)
+	s.metrics.streams.Set(float64(len(s.streams)))
+}
+
+// touch updates the given stream and returns true, if it is active
+func (s *lokiStreams) touch(labels model.LabelSet, now time.Time) bool {
+	if st, ok := s.streams[labels.Fingerprint()]; ok {
+		st.updated = now
+		return true
+	}
+	return false
+}
+
+// expire removes the streams that haven't received records during the TTL
+func (s *lokiStreams) expire(now time.Time) {
+	s.lastExpiry = now
+	for fp, st := range s.streams {
+		if now.Sub(st.updated) >= s.config.StreamTTL {
+			delete(s.streams, fp)
+			s.trackLabelValues(st.labels, -1)
+		}
+	}
+	s.metrics.streams.Set(float64(len(s.streams)))
+}
+
+// trackLabelValues updates the number of streams using each value of the non-static labels.
+// The overflow value is not tracked, so it doesn't count towards the limits
+func (s *lokiStreams) trackLabelValues(labels model.LabelSet, delta int) {
+	for name, values := range s.labelValues {
+		lv, ok := labels[name]
+		if !ok || lv == cardinality.Overflow {
+			continue
+		}
+		values[lv] += delta
+		if values[lv] <= 0 {
+			delete(values, lv)
+		}
+	}
+}
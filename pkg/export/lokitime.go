@@ -0,0 +1,68 @@
+package export
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+
+	"github.com/netobserv/goflow2-kube-enricher/pkg/config"
+	"github.com/netobserv/goflow2-kube-enricher/pkg/health"
+)
+
+// lokiTimestamps extracts the timestamp of the records, and handles the timestamps that are
+// too old for Loki
+type lokiTimestamps struct {
+	*recordTimestamps
+	outOfOrder        config.LokiOutOfOrderConfig
+	outOfOrderCounter prometheus.Counter
+}
+
+func newLokiTimestamps(cfg *config.LokiConfig, name string, reporter *health.Reporter) (*lokiTimestamps, error) {
+	timestampFields := cfg.TimestampFields
+	if len(timestampFields) == 0 {
+		timestampFields = timestampLabelFields(cfg.TimestampLabel, cfg.TimestampScale)
+	}
+	timestamps, err := newRecordTimestamps(timestampFields, cfg.MaxClockSkew, "loki", name, reporter)
+	if err != nil {
+		return nil, err
+	}
+	outOfOrder, err := reporter.Register(prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "exporter_loki_out_of_order",
+			Help: "Number of records whose timestamp was too old for Loki, by the action that has been applied.",
+		},
+		[]string{"exporter", "action"},
+	))
+	if err != nil {
+		return nil, err
+	}
+	t := &lokiTimestamps{recordTimestamps: timestamps, outOfOrder: cfg.OutOfOrder}
+	if t.outOfOrder.Action == "" {
+		t.outOfOrder.Action = config.OutOfOrderClamp
+	}
+	t.outOfOrderCounter = outOfOrder.(*prometheus.CounterVec).WithLabelValues(name, t.outOfOrder.Action)
+	return t, nil
+}
+
+// checkAge applies the out-of-order action to the timestamps older than the maximum age. It
+// returns the timestamp to be sent, the additional labels of the record, if any, and false
+// if the record must be dropped
+func (t *lokiTimestamps) checkAge(ts, now time.Time) (time.Time, model.LabelSet, bool) {
+	if t.outOfOrder.MaxAge <= 0 {
+		return ts, nil, true
+	}
+	oldest := now.Add(-t.outOfOrder.MaxAge)
+	if !ts.Before(oldest) {
+		return ts, nil, true
+	}
+	t.outOfOrderCounter.Inc()
+	switch t.outOfOrder.Action {
+	case config.OutOfOrderDrop:
+		return ts, nil, false
+	case config.OutOfOrderRoute:
+		return now, t.outOfOrder.RouteLabels, true
+	default:
+		return oldest, nil, true
+	}
+}
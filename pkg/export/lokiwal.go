@@ -0,0 +1,483 @@
+package export
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/netobserv/loki-client-go/pkg/logproto"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"github.com/sirupsen/logrus"
+
+	"github.com/netobserv/goflow2-kube-enricher/pkg/config"
+	"github.com/netobserv/goflow2-kube-enricher/pkg/health"
+)
+
+var wlog = logrus.WithField("module", "export/lokiwal")
+
+var errWALClosed = errors.New("the Loki WAL is closed")
+
+const walSegmentExt = ".wal"
+
+// lokiWAL is an emitter that appends the entries to segment files in disk. Once a segment
+// reaches the batch size or the batch wait time, it is sent to Loki, in order, by a background
+// routine that retries until Loki accepts it. The segments are only removed after being sent,
+// so the pending entries are replayed, with their original timestamps, after a restart
+type lokiWAL struct {
+	config  *config.LokiConfig
+	pusher  *lokiPusher
+	metrics *lokiWALMetrics
+	timeNow func() time.Time
+
+	mt sync.Mutex
+	// active segment, where the new entries are appended
+	active      *os.File
+	activeSeq   uint64
+	activeSize  int64
+	activeSince time.Time
+	// sealed segments that are pending to be sent, oldest first
+	segments []walSegment
+	// size of all the segments, including the active one
+	size   int64
+	closed bool
+
+	sealed  chan struct{}
+	closing chan struct{}
+	ctx     context.Context
+	cancel  context.CancelFunc
+	done    sync.WaitGroup
+}
+
+type walSegment struct {
+	path string
+	size int64
+}
+
+type lokiWALMetrics struct {
+	bufferedBytes   prometheus.Gauge
+	droppedSegments prometheus.Counter
+}
+
+// newLokiWAL opens the WAL in the configured directory. Any segment left by a previous
+// execution is sent before the new entries. The endpoint labels the metrics of the WAL when
+// it belongs to a single endpoint of the mirror mode
+func newLokiWAL(cfg *config.LokiConfig, pusher *lokiPusher, endpoint, name string,
+	reporter *health.Reporter) (*lokiWAL, error) {
+	metrics, err := newLokiWALMetrics(name, endpoint, reporter)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(cfg.WAL.Dir, 0755); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &lokiWAL{
+		config:  cfg,
+		pusher:  pusher,
+		metrics: metrics,
+		timeNow: time.Now,
+		sealed:  make(chan struct{}, 1),
+		closing: make(chan struct{}),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+	if err := w.loadSegments(); err != nil {
+		cancel()
+		return nil, err
+	}
+	if len(w.segments) > 0 {
+		wlog.WithFields(logrus.Fields{"segments": len(w.segments), "bytes": w.size}).
+			Info("replaying pending WAL segments")
+	}
+	w.done.Add(2)
+	go w.sealOnWait()
+	go w.send()
+	return w, nil
+}
+
+func newLokiWALMetrics(name, endpoint string, reporter *health.Reporter) (*lokiWALMetrics, error) {
+	bufferedBytes, err := reporter.Register(prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "exporter_loki_wal_buffered_bytes",
+			Help: "Size of the Loki WAL segments that are pending to be sent.",
+		},
+		[]string{"exporter", "endpoint"},
+	))
+	if err != nil {
+		return nil, err
+	}
+	droppedSegments, err := reporter.Register(prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "exporter_loki_wal_dropped_segments",
+			Help: "Number of Loki WAL segments that have been dropped because the WAL reached its maximum size, or Loki rejected them.",
+		},
+		[]string{"exporter", "endpoint"},
+	))
+	if err != nil {
+		return nil, err
+	}
+	return &lokiWALMetrics{
+		bufferedBytes:   bufferedBytes.(*prometheus.GaugeVec).WithLabelValues(name, endpoint),
+		droppedSegments: droppedSegments.(*prometheus.CounterVec).WithLabelValues(name, endpoint),
+	}, nil
+}
+
+// loadSegments registers the segments of the WAL directory, ordered by their sequence number
+func (w *lokiWAL) loadSegments() error {
+	files, err := ioutil.ReadDir(w.config.WAL.Dir)
+	if err != nil {
+		return err
+	}
+	var seqs []uint64
+	sizes := map[uint64]int64{}
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), walSegmentExt) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(file.Name(), walSegmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		seqs = append(seqs, seq)
+		sizes[seq] = file.Size()
+	}
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+	for _, seq := range seqs {
+		w.segments = append(w.segments, walSegment{path: w.segmentPath(seq), size: sizes[seq]})
+		w.size += sizes[seq]
+		w.activeSeq = seq
+	}
+	w.metrics.bufferedBytes.Set(float64(w.size))
+	return nil
+}
+
+func (w *lokiWAL) segmentPath(seq uint64) string {
+	return path.Join(w.config.WAL.Dir, fmt.Sprintf("%020d%s", seq, walSegmentExt))
+}
+
+// Handle appends the entry to the active segment. Each entry is stored as its length-prefixed
+// tenant, followed by a length-prefixed logproto.Stream with a single entry
+func (w *lokiWAL) Handle(labels model.LabelSet, timestamp time.Time, record string) error {
+	tenant, labels := entryTenant(labels, w.config.TenantID)
+	stream := logproto.Stream{
+		Labels:  labels.String(),
+		Entries: []logproto.Entry{{Timestamp: timestamp, Line: record}},
+	}
+	encoded, err := stream.Marshal()
+	if err != nil {
+		return err
+	}
+	frame := make([]byte, 0, 2*binary.MaxVarintLen64+len(tenant)+len(encoded))
+	frame = appendFrame(frame, []byte(tenant))
+	frame = appendFrame(frame, encoded)
+
+	w.mt.Lock()
+	defer w.mt.Unlock()
+	if w.closed {
+		return errWALClosed
+	}
+	if w.active == nil {
+		w.activeSeq++
+		if w.active, err = os.OpenFile(w.segmentPath(w.activeSeq),
+			os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644); err != nil {
+			w.active = nil
+			return fmt.Errorf("can't create WAL segment: %w", err)
+		}
+		w.activeSize = 0
+		w.activeSince = w.timeNow()
+	}
+	if _, err := w.active.Write(frame); err != nil {
+		w.discardTornFrame()
+		return fmt.Errorf("can't write WAL segment: %w", err)
+	}
+	w.activeSize += int64(len(frame))
+	w.size += int64(len(frame))
+	if w.activeSize >= int64(w.config.BatchSize) {
+		w.seal()
+	}
+	w.evict()
+	w.metrics.bufferedBytes.Set(float64(w.size))
+	return nil
+}
+
+// discardTornFrame removes the part of a frame that a failed write left at the end of the
+// active segment, so the next frames can still be read. If the segment can't be truncated,
+// it is sealed as it is and the torn frame ends its readable entries. It must be invoked
+// while holding the lock
+func (w *lokiWAL) discardTornFrame() {
+	err := w.active.Truncate(w.activeSize)
+	if err == nil {
+		_, err = w.active.Seek(w.activeSize, io.SeekStart)
+	}
+	if err != nil {
+		wlog.WithError(err).Warn("can't truncate WAL segment. Sealing it")
+		w.seal()
+	}
+}
+
+// seal closes the active segment and queues it to be sent. It must be invoked while
+// holding the lock
+func (w *lokiWAL) seal() {
+	if w.active == nil {
+		return
+	}
+	if err := w.active.Sync(); err != nil {
+		wlog.WithError(err).Warn("can't sync WAL segment")
+	}
+	if err := w.active.Close(); err != nil {
+		wlog.WithError(err).Warn("can't close WAL segment")
+	}
+	w.segments = append(w.segments, walSegment{path: w.active.Name(), size: w.activeSize})
+	w.active = nil
+	select {
+	case w.sealed <- struct{}{}:
+	default:
+	}
+}
+
+// Flush seals the active segment, so its entries are persisted and queued to be sent. The
+// entries are not lost once they are in the WAL, so it doesn't wait for Loki to accept them
+func (w *lokiWAL) Flush() error {
+	w.mt.Lock()
+	defer w.mt.Unlock()
+	if w.closed {
+		return errWALClosed
+	}
+	w.seal()
+	return nil
+}
+
+// appendFrame appends the uvarint-encoded length of the data, followed by the data
+func appendFrame(dst, data []byte) []byte {
+	size := make([]byte, binary.MaxVarintLen64)
+	dst = append(dst, size[:binary.PutUvarint(size, uint64(len(data)))]...)
+	return append(dst, data...)
+}
+
+// evict drops the oldest sealed segments until the WAL fits in its maximum size. It must be
+// invoked while holding the lock
+func (w *lokiWAL) evict() {
+	for w.size > w.config.WAL.MaxSize && len(w.segments) > 0 {
+		oldest := w.segments[0]
+		wlog.WithField("segment", oldest.path).Warn("WAL is full. Dropping oldest segment")
+		w.drop(oldest)
+	}
+}
+
+// drop removes the first segment from the WAL. It must be invoked while holding the lock
+func (w *lokiWAL) drop(segment walSegment) {
+	w.segments = w.segments[1:]
+	w.size -= segment.size
+	w.metrics.droppedSegments.Inc()
+	if err := os.Remove(segment.path); err != nil && !os.IsNotExist(err) {
+		wlog.WithError(err).WithField("segment", segment.path).Warn("can't remove WAL segment")
+	}
+}
+
+// sealOnWait seals the active segment when it gets older than the batch wait time
+func (w *lokiWAL) sealOnWait() {
+	defer w.done.Done()
+	period := w.config.BatchWait / 10
+	if period < 10*time.Millisecond {
+		period = 10 * time.Millisecond
+	}
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.closing:
+			return
+		case <-ticker.C:
+			w.mt.Lock()
+			if w.active != nil && w.timeNow().Sub(w.activeSince) >= w.config.BatchWait {
+				w.seal()
+			}
+			w.mt.Unlock()
+		}
+	}
+}
+
+// send pushes the sealed segments to Loki, oldest first. When the WAL is closing, it returns
+// after sending all the pending segments, or when the send is cancelled
+func (w *lokiWAL) send() {
+	defer w.done.Done()
+	for {
+		w.mt.Lock()
+		var segment *walSegment
+		if len(w.segments) > 0 {
+			segment = &walSegment{}
+			*segment = w.segments[0]
+		}
+		w.mt.Unlock()
+		if segment == nil {
+			select {
+			case <-w.sealed:
+				continue
+			case <-w.closing:
+				return
+			}
+		}
+		err := w.sendSegment(segment.path)
+		if w.ctx.Err() != nil {
+			// the segment is kept to be sent in the next execution
+			return
+		}
+		w.mt.Lock()
+		// the segment might have been evicted while it was being sent
+		if len(w.segments) > 0 && w.segments[0].path == segment.path {
+			if err != nil {
+				wlog.WithError(err).WithField("segment", segment.path).Warn("Loki rejected WAL segment. Dropping it")
+				w.drop(*segment)
+			} else {
+				w.segments = w.segments[1:]
+				w.size -= segment.size
+				if err := os.Remove(segment.path); err != nil && !os.IsNotExist(err) {
+					wlog.WithError(err).WithField("segment", segment.path).Warn("can't remove WAL segment")
+				}
+			}
+			w.metrics.bufferedBytes.Set(float64(w.size))
+		}
+		w.mt.Unlock()
+	}
+}
+
+// sendSegment pushes the entries of a segment to Loki, in a request per tenant. Connection
+// errors, throttling and server errors are retried until Loki recovers
+func (w *lokiWAL) sendSegment(file string) error {
+	reqs, err := readSegment(file)
+	if err != nil {
+		if len(reqs) == 0 {
+			return err
+		}
+		// a segment might be truncated if the process was abruptly stopped
+		wlog.WithError(err).WithField("segment", file).Warn("WAL segment is corrupted. Sending the readable entries")
+	}
+	// a tenant rejecting its entries doesn't prevent sending the entries of the other tenants
+	var rejected error
+	for _, req := range reqs {
+		if err := w.pusher.push(w.ctx, req, 0); err != nil {
+			if w.ctx.Err() != nil {
+				return err
+			}
+			rejected = err
+		}
+	}
+	return rejected
+}
+
+// readSegment decodes the entries of a segment into a push request per tenant, in the order
+// the tenants appear in the segment, grouping the entries by stream. If the segment is
+// corrupted, it returns the entries that could be read along with the error
+func readSegment(file string) ([]*lokiBatch, error) {
+	var reqs []*lokiBatch
+	f, err := os.Open(file)
+	if err != nil {
+		return reqs, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return reqs, err
+	}
+	in := &segmentReader{in: bufio.NewReader(f), remaining: info.Size()}
+	byTenant := map[string]*lokiBatch{}
+	for {
+		tenant, err := in.readFrame()
+		if err == io.EOF {
+			return reqs, nil
+		} else if err != nil {
+			return reqs, err
+		}
+		encoded, err := in.readFrame()
+		if err == io.EOF {
+			return reqs, io.ErrUnexpectedEOF
+		} else if err != nil {
+			return reqs, err
+		}
+		stream := logproto.Stream{}
+		if err := stream.Unmarshal(encoded); err != nil {
+			return reqs, err
+		}
+		req, ok := byTenant[string(tenant)]
+		if !ok {
+			req = newLokiBatch(string(tenant), time.Now())
+			byTenant[req.tenant] = req
+			reqs = append(reqs, req)
+		}
+		req.add(stream.Labels, stream.Entries...)
+	}
+}
+
+// segmentReader reads the frames of a segment, keeping track of the bytes left in the file
+type segmentReader struct {
+	in        *bufio.Reader
+	remaining int64
+}
+
+func (r *segmentReader) ReadByte() (byte, error) {
+	b, err := r.in.ReadByte()
+	if err == nil {
+		r.remaining--
+	}
+	return b, err
+}
+
+// readFrame reads length-prefixed data. A length larger than the rest of the segment can
+// only come from a corrupted segment, so it is rejected before allocating the frame
+func (r *segmentReader) readFrame() ([]byte, error) {
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if size > uint64(r.remaining) {
+		return nil, fmt.Errorf("frame length %d exceeds the %d remaining bytes of the segment",
+			size, r.remaining)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r.in, data); err != nil {
+		return nil, err
+	}
+	r.remaining -= int64(size)
+	return data, nil
+}
+
+// Stop seals the active segment and waits, up to the Loki timeout, for the pending segments
+// to be sent. The segments that couldn't be sent are kept for the next execution
+func (w *lokiWAL) Stop() {
+	w.mt.Lock()
+	if w.closed {
+		w.mt.Unlock()
+		return
+	}
+	w.seal()
+	w.closed = true
+	w.mt.Unlock()
+	close(w.closing)
+	finished := make(chan struct{})
+	go func() {
+		w.done.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-time.After(w.config.Timeout):
+		w.mt.Lock()
+		wlog.WithField("segments", len(w.segments)).Info("keeping pending WAL segments for the next execution")
+		w.mt.Unlock()
+		w.cancel()
+		<-finished
+	}
+	w.cancel()
+}
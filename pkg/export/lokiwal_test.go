@@ -0,0 +1,306 @@
+package export
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/netobserv/loki-client-go/pkg/logproto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/netobserv/goflow2-kube-enricher/pkg/config"
+	"github.com/netobserv/goflow2-kube-enricher/pkg/health"
+)
+
+// fakeLoki stores the entries that are pushed to it, or rejects them with the configured status.
+// Its /ready endpoint fails with the same status
+type fakeLoki struct {
+	mt      sync.Mutex
+	status  int
+	entries []logproto.Entry
+	tenants []string
+	// entries by tenant
+	byTenant map[string][]logproto.Entry
+}
+
+func (f *fakeLoki) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	f.mt.Lock()
+	defer f.mt.Unlock()
+	if f.status != 0 {
+		rw.WriteHeader(f.status)
+		return
+	}
+	if req.URL.Path == "/ready" {
+		rw.WriteHeader(http.StatusOK)
+		return
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	decoded, err := snappy.Decode(nil, body)
+	if err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	pr := logproto.PushRequest{}
+	if err := pr.Unmarshal(decoded); err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	tenant := req.Header.Get("X-Scope-OrgID")
+	if f.byTenant == nil {
+		f.byTenant = map[string][]logproto.Entry{}
+	}
+	for _, stream := range pr.Streams {
+		f.entries = append(f.entries, stream.Entries...)
+		f.byTenant[tenant] = append(f.byTenant[tenant], stream.Entries...)
+	}
+	f.tenants = append(f.tenants, tenant)
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+func (f *fakeLoki) setStatus(status int) {
+	f.mt.Lock()
+	defer f.mt.Unlock()
+	f.status = status
+}
+
+func (f *fakeLoki) getEntries() []logproto.Entry {
+	f.mt.Lock()
+	defer f.mt.Unlock()
+	return append([]logproto.Entry{}, f.entries...)
+}
+
+func (f *fakeLoki) getTenantLines(tenant string) []string {
+	f.mt.Lock()
+	defer f.mt.Unlock()
+	var lines []string
+	for _, entry := range f.byTenant[tenant] {
+		lines = append(lines, entry.Line)
+	}
+	return lines
+}
+
+func testWALConfig(url, dir string) config.LokiConfig {
+	return config.LokiConfig{
+		URL:            url,
+		TenantID:       "tenant",
+		BatchWait:      50 * time.Millisecond,
+		BatchSize:      200,
+		Timeout:        time.Second,
+		MinBackoff:     10 * time.Millisecond,
+		MaxBackoff:     50 * time.Millisecond,
+		TimestampLabel: "TimeReceived",
+		TimestampScale: time.Second,
+		WAL:            config.LokiWALConfig{Dir: dir, MaxSize: 10 * 1024},
+	}
+}
+
+// walSegments returns the names of the segment files in the WAL directory
+func walSegments(t *testing.T, dir string) []string {
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	var names []string
+	for _, file := range files {
+		names = append(names, file.Name())
+	}
+	return names
+}
+
+func TestLokiWAL_Outage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "loki-wal")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	// GIVEN a Loki instance that is unavailable
+	fake := &fakeLoki{status: http.StatusServiceUnavailable}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	// AND a Loki exporter with WAL
+	reporter := health.NewReporter(health.Ready)
+	hr := health.NewHTTPReporter(reporter)
+	cfg := testWALConfig(server.URL, dir)
+	loki, err := NewLoki(&cfg, "loki", reporter)
+	require.NoError(t, err)
+	defer loki.Close()
+
+	// WHEN some records are exported
+	for i := 1; i <= 10; i++ {
+		require.NoError(t, loki.ProcessRecord(map[string]interface{}{"TimeReceived": 1000 + i, "Bytes": i}))
+	}
+
+	// THEN they are kept in disk
+	require.Eventually(t, func() bool {
+		return len(walSegments(t, dir)) > 1
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.NotContains(t, scrapeMetrics(t, hr), `exporter_loki_wal_buffered_bytes{endpoint="",exporter="loki"} 0`)
+	assert.Empty(t, fake.getEntries())
+
+	// AND WHEN Loki recovers
+	fake.setStatus(0)
+
+	// THEN all the records are sent, in order and with their original timestamps
+	require.Eventually(t, func() bool {
+		return len(fake.getEntries()) == 10
+	}, 5*time.Second, 10*time.Millisecond)
+	for i, entry := range fake.getEntries() {
+		assert.Equal(t, time.Unix(int64(1001+i), 0).Unix(), entry.Timestamp.Unix())
+	}
+	assert.Equal(t, "tenant", fake.tenants[0])
+	// AND the segments are removed
+	require.Eventually(t, func() bool {
+		return len(walSegments(t, dir)) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Contains(t, scrapeMetrics(t, hr), `exporter_loki_wal_buffered_bytes{endpoint="",exporter="loki"} 0`)
+}
+
+func TestLokiWAL_Restart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "loki-wal")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	// GIVEN a Loki instance that is down
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	// AND a Loki exporter with WAL, that is stopped after exporting some records
+	cfg := testWALConfig(down.URL, dir)
+	cfg.Timeout = 100 * time.Millisecond
+	loki, err := NewLoki(&cfg, "loki", health.NewReporter(health.Ready))
+	require.NoError(t, err)
+	for i := 1; i <= 5; i++ {
+		require.NoError(t, loki.ProcessRecord(map[string]interface{}{"TimeReceived": 1000 + i}))
+	}
+	require.NoError(t, loki.Close())
+	assert.NotEmpty(t, walSegments(t, dir))
+
+	// WHEN Loki is back
+	fake := &fakeLoki{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	// AND the exporter is restarted
+	cfg = testWALConfig(server.URL, dir)
+	loki, err = NewLoki(&cfg, "loki", health.NewReporter(health.Ready))
+	require.NoError(t, err)
+	defer loki.Close()
+	require.NoError(t, loki.ProcessRecord(map[string]interface{}{"TimeReceived": 1006}))
+
+	// THEN the records of the previous execution are replayed before the new ones
+	require.Eventually(t, func() bool {
+		return len(fake.getEntries()) == 6
+	}, 5*time.Second, 10*time.Millisecond)
+	for i, entry := range fake.getEntries() {
+		assert.Equal(t, int64(1001+i), entry.Timestamp.Unix())
+	}
+}
+
+func TestLokiWAL_CorruptedSegment(t *testing.T) {
+	dir, err := ioutil.TempDir("", "loki-wal")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	// GIVEN a WAL segment with a valid entry
+	stream := logproto.Stream{
+		Labels:  `{foo="bar"}`,
+		Entries: []logproto.Entry{{Timestamp: time.Unix(1001, 0), Line: "first"}},
+	}
+	encoded, err := stream.Marshal()
+	require.NoError(t, err)
+	segment := appendFrame(nil, []byte("tenant"))
+	segment = appendFrame(segment, encoded)
+	// AND a corrupted frame whose length is much larger than the segment
+	length := make([]byte, binary.MaxVarintLen64)
+	segment = append(segment, length[:binary.PutUvarint(length, 1<<50)]...)
+	segment = append(segment, "garbage"...)
+	file := path.Join(dir, "00000000000000000001"+walSegmentExt)
+	require.NoError(t, ioutil.WriteFile(file, segment, 0644))
+
+	// WHEN the segment is read
+	reqs, err := readSegment(file)
+
+	// THEN the corrupted frame is reported without being allocated
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "exceeds")
+	// AND the entries before it are returned
+	require.Len(t, reqs, 1)
+	assert.Equal(t, "tenant", reqs[0].tenant)
+}
+
+func TestLokiWAL_Eviction(t *testing.T) {
+	dir, err := ioutil.TempDir("", "loki-wal")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	// GIVEN a Loki instance that is unavailable
+	fake := &fakeLoki{status: http.StatusServiceUnavailable}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	// AND a Loki exporter whose WAL can store up to 3 segments
+	reporter := health.NewReporter(health.Ready)
+	cfg := testWALConfig(server.URL, dir)
+	cfg.BatchWait = time.Hour
+	cfg.WAL.MaxSize = 3 * int64(cfg.BatchSize)
+	loki, err := NewLoki(&cfg, "loki", reporter)
+	require.NoError(t, err)
+	defer loki.Close()
+
+	// WHEN more records than the WAL capacity are exported
+	for i := 1; i <= 100; i++ {
+		require.NoError(t, loki.ProcessRecord(map[string]interface{}{"TimeReceived": 1000 + i}))
+	}
+
+	// THEN the oldest segments are dropped
+	var size int64
+	for _, name := range walSegments(t, dir) {
+		info, err := os.Stat(path.Join(dir, name))
+		require.NoError(t, err)
+		size += info.Size()
+	}
+	assert.LessOrEqual(t, size, cfg.WAL.MaxSize)
+	metrics := getMetrics(t, reporter)
+	assert.Regexp(t, `exporter_loki_wal_dropped_segments{endpoint="",exporter="loki"} [1-9]`, metrics)
+
+	// AND WHEN Loki recovers
+	fake.setStatus(0)
+	require.NoError(t, loki.Close())
+
+	// THEN only the newest records are sent
+	entries := fake.getEntries()
+	require.NotEmpty(t, entries)
+	assert.Less(t, len(entries), 100)
+	assert.Equal(t, int64(1100), entries[len(entries)-1].Timestamp.Unix())
+}
+
+func TestLokiWAL_Tenants(t *testing.T) {
+	dir, err := ioutil.TempDir("", "loki-wal")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	// GIVEN a Loki instance
+	fake := &fakeLoki{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	// AND a Loki exporter with WAL that derives the tenant from the source namespace
+	cfg := testWALConfig(server.URL, dir)
+	cfg.Tenant = config.LokiTenantConfig{SrcField: "SrcNamespace"}
+	loki, err := NewLoki(&cfg, "loki", health.NewReporter(health.Ready))
+	require.NoError(t, err)
+
+	// WHEN records from different namespaces are exported
+	for _, ns := range []string{"ns-1", "ns-2", "", "ns-1"} {
+		require.NoError(t, loki.ProcessRecord(map[string]interface{}{"TimeReceived": 1000, "SrcNamespace": ns}))
+	}
+	require.NoError(t, loki.Close())
+
+	// THEN each tenant receives the records of its namespace
+	assert.Equal(t, []string{
+		`{"SrcNamespace":"ns-1","TimeReceived":1000}`, `{"SrcNamespace":"ns-1","TimeReceived":1000}`,
+	}, fake.getTenantLines("ns-1"))
+	assert.Equal(t, []string{`{"SrcNamespace":"ns-2","TimeReceived":1000}`}, fake.getTenantLines("ns-2"))
+	// AND the records without namespace are sent to the default tenant
+	assert.Equal(t, []string{`{"SrcNamespace":"","TimeReceived":1000}`}, fake.getTenantLines("tenant"))
+}
@@ -0,0 +1,361 @@
+package export
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/netobserv/loki-client-go/pkg/backoff"
+	"github.com/prometheus/client_golang/prometheus"
+	promconf "github.com/prometheus/common/config"
+	"github.com/sirupsen/logrus"
+
+	"github.com/netobserv/goflow2-kube-enricher/pkg/config"
+	"github.com/netobserv/goflow2-kube-enricher/pkg/health"
+)
+
+// OpenSearchTimestampField is the field where the timestamp of the flow is stored in the
+// indexed documents
+const OpenSearchTimestampField = "@timestamp"
+
+var oslog = logrus.WithField("module", "export/opensearch")
+
+// OpenSearch record exporter. The records are indexed asynchronously in batches through the
+// _bulk API, so indexing errors are not returned by ProcessRecord but reported in the metrics
+type OpenSearch struct {
+	config      config.OpenSearchConfig
+	name        string
+	client      *http.Client
+	url         string
+	template    []byte
+	timeNow     func() time.Time
+	timestamps  *recordTimestamps
+	documents   chan openSearchDocument
+	flushes     chan chan struct{}
+	done        chan struct{}
+	reporter    *health.Reporter
+	recordsSent prometheus.Counter
+	undelivered undelivered
+	failures    FailureHandler
+}
+
+// openSearchDocument is the _bulk entry of a record, along with the original record if it is
+// passed to the failure handler
+type openSearchDocument struct {
+	entry  []byte
+	record map[string]interface{}
+}
+
+// bulkResponse contains the fields of the _bulk API response that are checked by the exporter
+type bulkResponse struct {
+	Errors bool                          `json:"errors"`
+	Items  []map[string]bulkResponseItem `json:"items"`
+}
+
+type bulkResponseItem struct {
+	Status int             `json:"status"`
+	Error  json.RawMessage `json:"error"`
+}
+
+// NewOpenSearch creates an OpenSearch flow exporter from a given configuration. The name
+// identifies the exporter in the reported metrics
+func NewOpenSearch(cfg *config.OpenSearchConfig, name string, reporter *health.Reporter) (*OpenSearch, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("the provided config is not valid: %w", err)
+	}
+	client, err := promconf.NewClientFromConfig(cfg.ClientConfig, "opensearch")
+	if err != nil {
+		return nil, err
+	}
+	var template []byte
+	if cfg.TemplateFile != "" {
+		if template, err = ioutil.ReadFile(cfg.TemplateFile); err != nil {
+			return nil, fmt.Errorf("can't read index template: %w", err)
+		}
+		if !json.Valid(template) {
+			return nil, fmt.Errorf("index template %s is not a valid JSON document", cfg.TemplateFile)
+		}
+	}
+	recordsSent, err := reporter.Register(prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "exporter_opensearch_record_sent",
+			Help: "Number of records that have been indexed in OpenSearch.",
+		},
+		[]string{"exporter"},
+	))
+	if err != nil {
+		return nil, err
+	}
+	timestamps, err := newRecordTimestamps(timestampLabelFields(cfg.TimestampLabel, cfg.TimestampScale), 0,
+		"opensearch", name, reporter)
+	if err != nil {
+		return nil, err
+	}
+	o := &OpenSearch{
+		config:      *cfg,
+		name:        name,
+		client:      client,
+		url:         strings.TrimSuffix(cfg.URL, "/"),
+		template:    template,
+		timeNow:     time.Now,
+		timestamps:  timestamps,
+		documents:   make(chan openSearchDocument, 1024),
+		flushes:     make(chan chan struct{}),
+		done:        make(chan struct{}),
+		reporter:    reporter,
+		recordsSent: recordsSent.(*prometheus.CounterVec).WithLabelValues(name),
+	}
+	go o.run()
+	return o, nil
+}
+
+// ProcessRecord queues the record to be indexed in the daily index of its timestamp
+func (o *OpenSearch) ProcessRecord(record map[string]interface{}) error {
+	var original map[string]interface{}
+	if o.failures != nil {
+		original = CopyRecord(record)
+	}
+	timestamp := o.timestamps.extract(record, o.timeNow()).UTC()
+	record[OpenSearchTimestampField] = timestamp.Format(time.RFC3339Nano)
+	doc, err := jsoniter.ConfigCompatibleWithStandardLibrary.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("can't encode record: %w", err)
+	}
+	action := fmt.Sprintf(`{"index":{"_index":%q}}`, o.indexName(timestamp))
+	entry := make([]byte, 0, len(action)+len(doc)+2)
+	entry = append(entry, action...)
+	entry = append(entry, '\n')
+	entry = append(entry, doc...)
+	entry = append(entry, '\n')
+	o.documents <- openSearchDocument{entry: entry, record: original}
+	return nil
+}
+
+// SetFailureHandler sets the handler of the records that can't be indexed. It must be invoked
+// before processing any record
+func (o *OpenSearch) SetFailureHandler(handler FailureHandler) {
+	o.failures = handler
+}
+
+func (o *OpenSearch) indexName(timestamp time.Time) string {
+	return o.config.Index + "-" + timestamp.Format("2006.01.02")
+}
+
+// Flush sends the queued documents, without waiting for the batch wait time. It must be
+// invoked from the same goroutine as ProcessRecord
+func (o *OpenSearch) Flush() error {
+	flushed := make(chan struct{})
+	select {
+	case o.flushes <- flushed:
+	case <-o.done:
+		return errors.New("the OpenSearch exporter is closed")
+	}
+	<-flushed
+	return o.undelivered.take()
+}
+
+// Close sends the pending batch
+func (o *OpenSearch) Close() error {
+	close(o.documents)
+	<-o.done
+	return nil
+}
+
+// run groups the documents in batches that are sent when they reach the maximum size or
+// after the batch wait time
+func (o *OpenSearch) run() {
+	defer close(o.done)
+	templateInstalled := o.template == nil
+	var batch []openSearchDocument
+	size := 0
+	ticker := time.NewTicker(o.config.BatchWait)
+	defer ticker.Stop()
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if !templateInstalled {
+			templateInstalled = o.installTemplate()
+		}
+		o.sendBatch(batch)
+		batch = nil
+		size = 0
+	}
+	add := func(doc openSearchDocument) {
+		batch = append(batch, doc)
+		size += len(doc.entry)
+		if size >= o.config.BatchSize {
+			flush()
+		}
+	}
+	for {
+		select {
+		case doc, ok := <-o.documents:
+			if !ok {
+				flush()
+				return
+			}
+			add(doc)
+		case flushed := <-o.flushes:
+			// the documents queued before the flush request must be sent too
+			for queued := true; queued; {
+				select {
+				case doc := <-o.documents:
+					add(doc)
+				default:
+					queued = false
+				}
+			}
+			flush()
+			close(flushed)
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// installTemplate puts the index template, retrying on failure. If the template can't be
+// installed, the records are indexed anyway, and the installation is retried with the next batch
+func (o *OpenSearch) installTemplate() bool {
+	url := o.url + "/_index_template/" + o.config.Index
+	err := o.withRetries(func() (int, error) {
+		status, _, err := o.do(http.MethodPut, url, "application/json", o.template)
+		return status, err
+	})
+	if err != nil {
+		oslog.WithError(err).WithField("index", o.config.Index).Warn("can't install index template")
+		return false
+	}
+	oslog.WithField("index", o.config.Index).Debug("index template installed")
+	return true
+}
+
+// sendBatch sends the documents to the _bulk API, retrying on connection errors, throttling or
+// server errors. The documents that OpenSearch throttles or fails to index are sent again, in a
+// request with only those documents, while the documents that it rejects with other client
+// errors are reported as failed, and passed to the failure handler along with the documents
+// that couldn't be sent before the retries were exhausted
+func (o *OpenSearch) sendBatch(batch []openSearchDocument) {
+	pending := batch
+	sent := 0
+	var rejected []openSearchDocument
+	var rejections []error
+	err := o.withRetries(func() (int, error) {
+		body := bytes.Buffer{}
+		for _, doc := range pending {
+			body.Write(doc.entry)
+		}
+		status, response, err := o.do(http.MethodPost, o.url+"/_bulk", "application/x-ndjson", body.Bytes())
+		if err != nil || status/100 != 2 {
+			return status, err
+		}
+		bulk := bulkResponse{}
+		if err := json.Unmarshal(response, &bulk); err != nil {
+			oslog.WithError(err).Debug("can't parse _bulk response")
+			sent += len(pending)
+			pending = nil
+			return status, nil
+		}
+		var retried []openSearchDocument
+		rejectedBefore := len(rejected)
+		if bulk.Errors {
+			// the items of the response are in the same order as the documents of the request
+			for i, item := range bulk.Items {
+				for _, result := range item {
+					if i >= len(pending) || result.Status/100 == 2 {
+						continue
+					}
+					if result.Status == http.StatusTooManyRequests || result.Status/100 == 5 {
+						retried = append(retried, pending[i])
+						continue
+					}
+					oslog.WithField("status", result.Status).WithField("error", string(result.Error)).
+						Debug("record rejected by OpenSearch")
+					rejected = append(rejected, pending[i])
+					rejections = append(rejections, fmt.Errorf("document rejected with status %d: %s",
+						result.Status, string(result.Error)))
+				}
+			}
+		}
+		sent += len(pending) - len(retried) - (len(rejected) - rejectedBefore)
+		pending = retried
+		if len(retried) > 0 {
+			return status, fmt.Errorf("OpenSearch couldn't index %d documents", len(retried))
+		}
+		return status, nil
+	})
+	failed := len(rejected)
+	if err != nil {
+		oslog.WithError(err).WithField("records", len(pending)).Warn("can't send records to OpenSearch")
+		failed += len(pending)
+		if originals := openSearchRecords(pending); o.failures != nil && len(originals) > 0 {
+			o.failures(originals, err)
+		} else if isUnavailable(err) {
+			o.undelivered.add(len(pending), err)
+		}
+	}
+	for i, doc := range rejected {
+		if o.failures != nil && doc.record != nil {
+			o.failures([]map[string]interface{}{doc.record}, rejections[i])
+		}
+	}
+	o.recordsSent.Add(float64(sent))
+	for i := 0; i < failed; i++ {
+		o.reporter.RecordExportFailed(o.name)
+	}
+}
+
+// openSearchRecords returns the original records of the documents, if they are kept
+func openSearchRecords(docs []openSearchDocument) []map[string]interface{} {
+	var records []map[string]interface{}
+	for _, doc := range docs {
+		if doc.record != nil {
+			records = append(records, doc.record)
+		}
+	}
+	return records
+}
+
+// withRetries invokes the request function until it succeeds. Connection errors, throttling
+// and server errors are retried
+func (o *OpenSearch) withRetries(request func() (status int, err error)) error {
+	return withRetries(context.Background(), backoff.BackoffConfig{
+		MinBackoff: o.config.MinBackoff,
+		MaxBackoff: o.config.MaxBackoff,
+		MaxRetries: o.config.MaxRetries,
+	}, oslog, func() (bool, error) {
+		status, err := request()
+		if err != nil {
+			return true, err
+		}
+		if status/100 == 2 {
+			return false, nil
+		}
+		return status/100 == 5 || status == http.StatusTooManyRequests,
+			fmt.Errorf("server returned HTTP status %d", status)
+	})
+}
+
+func (o *OpenSearch) do(method, url, contentType string, body []byte) (int, []byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), o.config.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return 0, nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	response, err := ioutil.ReadAll(resp.Body)
+	return resp.StatusCode, response, err
+}
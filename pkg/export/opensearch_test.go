@@ -0,0 +1,389 @@
+package export
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	promconf "github.com/prometheus/common/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/netobserv/goflow2-kube-enricher/pkg/config"
+	"github.com/netobserv/goflow2-kube-enricher/pkg/health"
+)
+
+// fakeOpenSearch records the requests that are received by an OpenSearch stand-in
+type fakeOpenSearch struct {
+	mt       sync.Mutex
+	requests []fakeOpenSearchRequest
+	// responses, if not empty, are returned (and consumed) as status codes of the next requests
+	responses []int
+	// rejected is the number of documents to be rejected in each bulk request
+	rejected int
+	// throttled is the number of the next bulk requests whose first document is throttled
+	throttled int
+}
+
+type fakeOpenSearchRequest struct {
+	method   string
+	path     string
+	user     string
+	password string
+	body     []byte
+}
+
+func (f *fakeOpenSearch) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	user, password, _ := r.BasicAuth()
+	f.mt.Lock()
+	defer f.mt.Unlock()
+	f.requests = append(f.requests, fakeOpenSearchRequest{
+		method: r.Method, path: r.URL.Path, user: user, password: password, body: body,
+	})
+	if len(f.responses) > 0 {
+		status := f.responses[0]
+		f.responses = f.responses[1:]
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+	}
+	if r.URL.Path != "/_bulk" {
+		_, _ = w.Write([]byte(`{"acknowledged":true}`))
+		return
+	}
+	response := bulkResponse{Errors: f.rejected > 0 || f.throttled > 0}
+	for i := 0; i < bytes.Count(body, []byte("\n"))/2; i++ {
+		status := http.StatusCreated
+		if i == 0 && f.throttled > 0 {
+			status = http.StatusTooManyRequests
+		} else if i < f.rejected {
+			status = http.StatusBadRequest
+		}
+		response.Items = append(response.Items, map[string]bulkResponseItem{"index": {Status: status}})
+	}
+	if f.throttled > 0 {
+		f.throttled--
+	}
+	_ = json.NewEncoder(w).Encode(response)
+}
+
+func (f *fakeOpenSearch) getRequests() []fakeOpenSearchRequest {
+	f.mt.Lock()
+	defer f.mt.Unlock()
+	return append([]fakeOpenSearchRequest{}, f.requests...)
+}
+
+// bulkDocuments returns the index and the document of each action in a _bulk request body
+func bulkDocuments(t *testing.T, body []byte) ([]string, []map[string]interface{}) {
+	var indices []string
+	var docs []map[string]interface{}
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		action := map[string]map[string]string{}
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &action))
+		indices = append(indices, action["index"]["_index"])
+		require.True(t, scanner.Scan())
+		doc := map[string]interface{}{}
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &doc))
+		docs = append(docs, doc)
+	}
+	return indices, docs
+}
+
+func testOpenSearchConfig(url string) config.OpenSearchConfig {
+	return config.OpenSearchConfig{
+		URL:            url,
+		Index:          "flows",
+		BatchWait:      time.Hour,
+		BatchSize:      1024 * 1024,
+		Timeout:        5 * time.Second,
+		MinBackoff:     time.Millisecond,
+		MaxBackoff:     10 * time.Millisecond,
+		MaxRetries:     3,
+		TimestampLabel: "TimeFlowStart",
+		TimestampScale: time.Second,
+	}
+}
+
+func TestOpenSearch_DailyIndices(t *testing.T) {
+	fake := &fakeOpenSearch{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	// GIVEN an OpenSearch exporter with an index template and basic authentication
+	dir, err := ioutil.TempDir("", "opensearch")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	templateFile := path.Join(dir, "template.json")
+	template := `{"index_patterns":["flows-*"],"template":{"mappings":{"properties":{"Bytes":{"type":"long"}}}}}`
+	require.NoError(t, ioutil.WriteFile(templateFile, []byte(template), 0600))
+	reporter := health.NewReporter(health.Ready)
+	cfg := testOpenSearchConfig(server.URL)
+	cfg.TemplateFile = templateFile
+	cfg.ClientConfig = promconf.HTTPClientConfig{
+		BasicAuth: &promconf.BasicAuth{Username: "user", Password: "secret"},
+	}
+	exporter, err := NewOpenSearch(&cfg, "opensearch", reporter)
+	require.NoError(t, err)
+
+	// WHEN records from different days are exported
+	require.NoError(t, exporter.ProcessRecord(map[string]interface{}{
+		"TimeFlowStart": 1637501829, "Bytes": 123,
+	}))
+	require.NoError(t, exporter.ProcessRecord(map[string]interface{}{
+		"TimeFlowStart": 1637601829, "Bytes": 456,
+	}))
+	require.NoError(t, exporter.Close())
+
+	// THEN the index template is installed before sending the records
+	requests := fake.getRequests()
+	require.Len(t, requests, 2)
+	assert.Equal(t, http.MethodPut, requests[0].method)
+	assert.Equal(t, "/_index_template/flows", requests[0].path)
+	assert.JSONEq(t, template, string(requests[0].body))
+	// AND the records are sent in a single batch, to the index of their day
+	assert.Equal(t, http.MethodPost, requests[1].method)
+	assert.Equal(t, "/_bulk", requests[1].path)
+	indices, docs := bulkDocuments(t, requests[1].body)
+	assert.Equal(t, []string{"flows-2021.11.21", "flows-2021.11.22"}, indices)
+	assert.Equal(t, map[string]interface{}{
+		"TimeFlowStart": float64(1637501829), "Bytes": float64(123),
+		"@timestamp": "2021-11-21T13:37:09Z",
+	}, docs[0])
+	assert.EqualValues(t, 456, docs[1]["Bytes"])
+	// AND the requests are authenticated
+	for _, r := range requests {
+		assert.Equal(t, "user", r.user)
+		assert.Equal(t, "secret", r.password)
+	}
+	assert.Contains(t, getMetrics(t, reporter), `exporter_opensearch_record_sent{exporter="opensearch"} 2`)
+}
+
+func TestOpenSearch_InvalidTimestamps(t *testing.T) {
+	fake := &fakeOpenSearch{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	// GIVEN an OpenSearch exporter
+	reporter := health.NewReporter(health.Ready)
+	cfg := testOpenSearchConfig(server.URL)
+	exporter, err := NewOpenSearch(&cfg, "opensearch", reporter)
+	require.NoError(t, err)
+	exporter.timeNow = func() time.Time { return time.Date(2021, 11, 21, 13, 37, 9, 0, time.UTC) }
+
+	// WHEN records without a valid timestamp are exported
+	require.NoError(t, exporter.ProcessRecord(map[string]interface{}{"Bytes": 123}))
+	require.NoError(t, exporter.ProcessRecord(map[string]interface{}{"TimeFlowStart": "yesterday"}))
+	require.NoError(t, exporter.Close())
+
+	// THEN they are indexed with the local time
+	requests := fake.getRequests()
+	require.Len(t, requests, 1)
+	_, docs := bulkDocuments(t, requests[0].body)
+	require.Len(t, docs, 2)
+	assert.Equal(t, "2021-11-21T13:37:09Z", docs[0]["@timestamp"])
+	assert.Equal(t, "2021-11-21T13:37:09Z", docs[1]["@timestamp"])
+	// AND the skipped timestamps are accounted in the metrics
+	metrics := getMetrics(t, reporter)
+	assert.Contains(t, metrics,
+		`exporter_opensearch_timestamp_skipped{exporter="opensearch",field="TimeFlowStart",reason="missing"} 1`)
+	assert.Contains(t, metrics,
+		`exporter_opensearch_timestamp_skipped{exporter="opensearch",field="TimeFlowStart",reason="invalid"} 1`)
+	assert.Contains(t, metrics, `exporter_opensearch_local_timestamp{exporter="opensearch"} 2`)
+}
+
+func TestOpenSearch_BatchSize(t *testing.T) {
+	fake := &fakeOpenSearch{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	// GIVEN an OpenSearch exporter whose batches can't contain more than one record
+	cfg := testOpenSearchConfig(server.URL)
+	cfg.BatchSize = 10
+	exporter, err := NewOpenSearch(&cfg, "opensearch", health.NewReporter(health.Ready))
+	require.NoError(t, err)
+
+	// WHEN some records are exported
+	for i := 0; i < 3; i++ {
+		require.NoError(t, exporter.ProcessRecord(map[string]interface{}{"Bytes": i}))
+	}
+	require.NoError(t, exporter.Close())
+
+	// THEN each record is sent in its own batch
+	requests := fake.getRequests()
+	require.Len(t, requests, 3)
+	for i, r := range requests {
+		_, docs := bulkDocuments(t, r.body)
+		require.Len(t, docs, 1)
+		assert.EqualValues(t, i, docs[0]["Bytes"])
+	}
+}
+
+func TestOpenSearch_BatchWait(t *testing.T) {
+	fake := &fakeOpenSearch{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	// GIVEN an OpenSearch exporter with a short batch wait time
+	cfg := testOpenSearchConfig(server.URL)
+	cfg.BatchWait = 10 * time.Millisecond
+	exporter, err := NewOpenSearch(&cfg, "opensearch", health.NewReporter(health.Ready))
+	require.NoError(t, err)
+	defer exporter.Close()
+
+	// WHEN a record is exported
+	require.NoError(t, exporter.ProcessRecord(map[string]interface{}{"Bytes": 1}))
+
+	// THEN it is sent without waiting for more records
+	require.Eventually(t, func() bool {
+		return len(fake.getRequests()) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestOpenSearch_Retries(t *testing.T) {
+	// GIVEN an OpenSearch instance that is temporarily unavailable
+	fake := &fakeOpenSearch{responses: []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	reporter := health.NewReporter(health.Ready)
+	cfg := testOpenSearchConfig(server.URL)
+	exporter, err := NewOpenSearch(&cfg, "opensearch", reporter)
+	require.NoError(t, err)
+
+	// WHEN a record is exported
+	require.NoError(t, exporter.ProcessRecord(map[string]interface{}{"Bytes": 1}))
+	require.NoError(t, exporter.Close())
+
+	// THEN the request is retried until it succeeds
+	requests := fake.getRequests()
+	require.Len(t, requests, 3)
+	assert.Equal(t, requests[0].body, requests[2].body)
+	metrics := getMetrics(t, reporter)
+	assert.Contains(t, metrics, `exporter_opensearch_record_sent{exporter="opensearch"} 1`)
+	assert.NotContains(t, metrics, `exporter_record_failed{exporter="opensearch"}`)
+}
+
+func TestOpenSearch_ThrottledDocuments(t *testing.T) {
+	// GIVEN an OpenSearch instance that throttles the first document of the next two bulk requests
+	fake := &fakeOpenSearch{throttled: 2}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	reporter := health.NewReporter(health.Ready)
+	cfg := testOpenSearchConfig(server.URL)
+	exporter, err := NewOpenSearch(&cfg, "opensearch", reporter)
+	require.NoError(t, err)
+	var failed []map[string]interface{}
+	exporter.SetFailureHandler(func(records []map[string]interface{}, err error) {
+		failed = append(failed, records...)
+	})
+
+	// WHEN some records are exported
+	for i := 0; i < 3; i++ {
+		require.NoError(t, exporter.ProcessRecord(map[string]interface{}{"Bytes": i}))
+	}
+	require.NoError(t, exporter.Close())
+
+	// THEN the throttled document is sent again alone until it is indexed
+	requests := fake.getRequests()
+	require.Len(t, requests, 3)
+	_, docs := bulkDocuments(t, requests[0].body)
+	assert.Len(t, docs, 3)
+	for _, req := range requests[1:] {
+		_, docs := bulkDocuments(t, req.body)
+		require.Len(t, docs, 1)
+		assert.EqualValues(t, 0, docs[0]["Bytes"])
+	}
+	// AND no record is reported as failed
+	assert.Empty(t, failed)
+	metrics := getMetrics(t, reporter)
+	assert.Contains(t, metrics, `exporter_opensearch_record_sent{exporter="opensearch"} 3`)
+	assert.NotContains(t, metrics, `exporter_record_failed{exporter="opensearch"}`)
+}
+
+func TestOpenSearch_Failures(t *testing.T) {
+	// GIVEN an OpenSearch instance that rejects the first document of each batch
+	fake := &fakeOpenSearch{rejected: 1}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	reporter := health.NewReporter(health.Ready)
+	cfg := testOpenSearchConfig(server.URL)
+	exporter, err := NewOpenSearch(&cfg, "my-opensearch", reporter)
+	require.NoError(t, err)
+	hr := health.NewHTTPReporter(reporter)
+
+	// WHEN some records are exported
+	for i := 0; i < 3; i++ {
+		require.NoError(t, exporter.ProcessRecord(map[string]interface{}{"Bytes": i}))
+	}
+	require.NoError(t, exporter.Close())
+
+	// THEN the rejected documents are reported as failed, without retrying them
+	assert.Len(t, fake.getRequests(), 1)
+	metrics := scrapeMetrics(t, hr)
+	assert.Contains(t, metrics, `exporter_opensearch_record_sent{exporter="my-opensearch"} 2`)
+	assert.Contains(t, metrics, `exporter_record_failed{exporter="my-opensearch"} 1`)
+
+	// AND client errors are not retried
+	fake.mt.Lock()
+	fake.responses = []int{http.StatusBadRequest}
+	fake.mt.Unlock()
+	exporter, err = NewOpenSearch(&cfg, "my-opensearch", reporter)
+	require.NoError(t, err)
+	require.NoError(t, exporter.ProcessRecord(map[string]interface{}{"Bytes": 1}))
+	require.NoError(t, exporter.Close())
+	assert.Len(t, fake.getRequests(), 2)
+	assert.Contains(t, scrapeMetrics(t, hr), `exporter_record_failed{exporter="my-opensearch"} 2`)
+}
+
+func TestOpenSearch_FailureHandler(t *testing.T) {
+	// GIVEN an OpenSearch instance that rejects the first document of each batch
+	fake := &fakeOpenSearch{rejected: 1}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	reporter := health.NewReporter(health.Ready)
+	cfg := testOpenSearchConfig(server.URL)
+	exporter, err := NewOpenSearch(&cfg, "opensearch", reporter)
+	require.NoError(t, err)
+	// AND a handler of the delivery failures
+	var failed []map[string]interface{}
+	var failures []error
+	exporter.SetFailureHandler(func(records []map[string]interface{}, err error) {
+		failed = append(failed, records...)
+		failures = append(failures, err)
+	})
+
+	// WHEN some records are exported
+	for i := 0; i < 3; i++ {
+		require.NoError(t, exporter.ProcessRecord(map[string]interface{}{"Bytes": i}))
+	}
+	require.NoError(t, exporter.Close())
+
+	// THEN the original record of the rejected document is passed to the handler
+	assert.Equal(t, []map[string]interface{}{{"Bytes": 0}}, failed)
+	require.Len(t, failures, 1)
+	assert.Contains(t, failures[0].Error(), "status 400")
+
+	// AND the records of the batches that can't be sent are passed to the handler too
+	fake.mt.Lock()
+	fake.responses = []int{http.StatusBadRequest}
+	fake.mt.Unlock()
+	failed = nil
+	exporter, err = NewOpenSearch(&cfg, "opensearch", reporter)
+	require.NoError(t, err)
+	exporter.SetFailureHandler(func(records []map[string]interface{}, err error) {
+		failed = append(failed, records...)
+	})
+	require.NoError(t, exporter.ProcessRecord(map[string]interface{}{"Bytes": 1}))
+	require.NoError(t, exporter.Flush())
+	require.NoError(t, exporter.Close())
+	assert.Equal(t, []map[string]interface{}{{"Bytes": 1}}, failed)
+}
@@ -0,0 +1,450 @@
+package export
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/netobserv/loki-client-go/pkg/backoff"
+	"github.com/prometheus/client_golang/prometheus"
+	promconf "github.com/prometheus/common/config"
+	"github.com/sirupsen/logrus"
+	collogs "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	otlpcommon "go.opentelemetry.io/proto/otlp/common/v1"
+	otlplogs "go.opentelemetry.io/proto/otlp/logs/v1"
+	otlpresource "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/netobserv/goflow2-kube-enricher/pkg/config"
+	"github.com/netobserv/goflow2-kube-enricher/pkg/fields"
+	"github.com/netobserv/goflow2-kube-enricher/pkg/health"
+	"github.com/netobserv/goflow2-kube-enricher/pkg/topology"
+)
+
+const otlpInstrumentationName = "goflow-kube"
+
+var otlog = logrus.WithField("module", "export/otlp")
+
+// otlpResourceFields maps the kubernetes fields of the resource endpoint (without prefix)
+// to the OpenTelemetry semantic conventions
+var otlpResourceFields = map[string]string{
+	"Pod":                "k8s.pod.name",
+	"Namespace":          "k8s.namespace.name",
+	topology.FieldZone:   "cloud.availability_zone",
+	topology.FieldRegion: "cloud.region",
+}
+
+// otlpWorkloadAttributes maps the workload kinds to the attribute of their name in the
+// OpenTelemetry semantic conventions
+var otlpWorkloadAttributes = map[string]string{
+	"Deployment":  "k8s.deployment.name",
+	"ReplicaSet":  "k8s.replicaset.name",
+	"StatefulSet": "k8s.statefulset.name",
+	"DaemonSet":   "k8s.daemonset.name",
+	"Job":         "k8s.job.name",
+	"CronJob":     "k8s.cronjob.name",
+}
+
+// otlpClient sends the export requests to the collector
+type otlpClient interface {
+	// export returns whether a failed request can be retried
+	export(ctx context.Context, req *collogs.ExportLogsServiceRequest) (retriable bool, err error)
+	close() error
+}
+
+// OTLP record exporter. The records are sent asynchronously in batches as OpenTelemetry log
+// records, so delivery errors are not returned by ProcessRecord but reported in the metrics
+type OTLP struct {
+	config      config.OTLPConfig
+	name        string
+	client      otlpClient
+	timeNow     func() time.Time
+	timestamps  *recordTimestamps
+	records     chan otlpRecord
+	flushes     chan chan struct{}
+	done        chan struct{}
+	reporter    *health.Reporter
+	recordsSent prometheus.Counter
+	undelivered undelivered
+	failures    FailureHandler
+}
+
+type otlpRecord struct {
+	resource []*otlpcommon.KeyValue
+	log      *otlplogs.LogRecord
+	// original is the record, if it is passed to the failure handler
+	original map[string]interface{}
+}
+
+// NewOTLP creates an OTLP logs exporter from a given configuration. The name identifies the
+// exporter in the reported metrics
+func NewOTLP(cfg *config.OTLPConfig, name string, reporter *health.Reporter) (*OTLP, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("the provided config is not valid: %w", err)
+	}
+	var client otlpClient
+	var err error
+	if cfg.Protocol == config.OTLPHTTPProtobuf {
+		client, err = newOTLPHTTPClient(cfg)
+	} else {
+		client, err = newOTLPGRPCClient(cfg)
+	}
+	if err != nil {
+		return nil, err
+	}
+	recordsSent, err := reporter.Register(prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "exporter_otlp_record_sent",
+			Help: "Number of records that have been delivered to the OpenTelemetry collector.",
+		},
+		[]string{"exporter"},
+	))
+	if err != nil {
+		return nil, err
+	}
+	timestamps, err := newRecordTimestamps(timestampLabelFields(cfg.TimestampLabel, cfg.TimestampScale), 0,
+		"otlp", name, reporter)
+	if err != nil {
+		return nil, err
+	}
+	o := &OTLP{
+		config:      *cfg,
+		name:        name,
+		client:      client,
+		timeNow:     time.Now,
+		timestamps:  timestamps,
+		records:     make(chan otlpRecord, cfg.BatchSize),
+		flushes:     make(chan chan struct{}),
+		done:        make(chan struct{}),
+		reporter:    reporter,
+		recordsSent: recordsSent.(*prometheus.CounterVec).WithLabelValues(name),
+	}
+	go o.run()
+	return o, nil
+}
+
+// ProcessRecord queues the record to be sent as a log record. The kubernetes fields of the
+// resource endpoint are sent as resource attributes, and the rest of fields as log attributes
+func (o *OTLP) ProcessRecord(record map[string]interface{}) error {
+	var original map[string]interface{}
+	if o.failures != nil {
+		original = CopyRecord(record)
+	}
+	timestamp := o.timestamps.extract(record, o.timeNow())
+	resource := o.resourceAttributes(record)
+	logRecord := &otlplogs.LogRecord{
+		TimeUnixNano:   uint64(timestamp.UnixNano()),
+		SeverityNumber: otlplogs.SeverityNumber_SEVERITY_NUMBER_INFO,
+		SeverityText:   "INFO",
+		Attributes:     make([]*otlpcommon.KeyValue, 0, len(record)),
+	}
+	for k, v := range record {
+		logRecord.Attributes = append(logRecord.Attributes, otlpKeyValue(k, v))
+	}
+	sortKeyValues(logRecord.Attributes)
+	o.records <- otlpRecord{resource: resource, log: logRecord, original: original}
+	return nil
+}
+
+// SetFailureHandler sets the handler of the records of the batches that can't be delivered.
+// It must be invoked before processing any record
+func (o *OTLP) SetFailureHandler(handler FailureHandler) {
+	o.failures = handler
+}
+
+// resourceAttributes returns the static resource attributes plus the semantic-convention
+// attributes of the resource endpoint. The fields that are mapped to resource attributes are
+// removed from the record
+func (o *OTLP) resourceAttributes(record map[string]interface{}) []*otlpcommon.KeyValue {
+	attrs := make([]*otlpcommon.KeyValue, 0, len(o.config.ResourceAttributes)+len(otlpResourceFields)+1)
+	for k, v := range o.config.ResourceAttributes {
+		attrs = append(attrs, otlpKeyValue(k, v))
+	}
+	prefix := o.config.ResourcePrefix
+	for field, attr := range otlpResourceFields {
+		if v, ok := record[prefix+field]; ok {
+			attrs = append(attrs, otlpKeyValue(attr, v))
+			delete(record, prefix+field)
+		}
+	}
+	if kind, ok := record[prefix+"WorkloadKind"].(string); ok {
+		if attr, ok := otlpWorkloadAttributes[kind]; ok {
+			if v, ok := record[prefix+"Workload"]; ok {
+				attrs = append(attrs, otlpKeyValue(attr, v))
+				delete(record, prefix+"Workload")
+				delete(record, prefix+"WorkloadKind")
+			}
+		}
+	}
+	sortKeyValues(attrs)
+	return attrs
+}
+
+// Flush sends the queued records, without waiting for the batch wait time. It must be invoked
+// from the same goroutine as ProcessRecord
+func (o *OTLP) Flush() error {
+	flushed := make(chan struct{})
+	select {
+	case o.flushes <- flushed:
+	case <-o.done:
+		return errors.New("the OTLP exporter is closed")
+	}
+	<-flushed
+	return o.undelivered.take()
+}
+
+// Close sends the pending batch and closes the connection to the collector
+func (o *OTLP) Close() error {
+	close(o.records)
+	<-o.done
+	return o.client.close()
+}
+
+// run groups the records in batches that are sent when they reach the maximum size or after
+// the batch wait time
+func (o *OTLP) run() {
+	defer close(o.done)
+	var batch []otlpRecord
+	ticker := time.NewTicker(o.config.BatchWait)
+	defer ticker.Stop()
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		o.sendBatch(batch)
+		batch = nil
+	}
+	add := func(record otlpRecord) {
+		batch = append(batch, record)
+		if len(batch) >= o.config.BatchSize {
+			flush()
+		}
+	}
+	for {
+		select {
+		case record, ok := <-o.records:
+			if !ok {
+				flush()
+				return
+			}
+			add(record)
+		case flushed := <-o.flushes:
+			// the records queued before the flush request must be sent too
+			for queued := true; queued; {
+				select {
+				case record := <-o.records:
+					add(record)
+				default:
+					queued = false
+				}
+			}
+			flush()
+			close(flushed)
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// sendBatch groups the log records by resource and sends them in a single export request
+func (o *OTLP) sendBatch(batch []otlpRecord) {
+	req := &collogs.ExportLogsServiceRequest{}
+	resources := map[string]*otlplogs.InstrumentationLibraryLogs{}
+	for _, record := range batch {
+		key := resourceKey(record.resource)
+		logs, ok := resources[key]
+		if !ok {
+			logs = &otlplogs.InstrumentationLibraryLogs{
+				InstrumentationLibrary: &otlpcommon.InstrumentationLibrary{Name: otlpInstrumentationName},
+			}
+			resources[key] = logs
+			req.ResourceLogs = append(req.ResourceLogs, &otlplogs.ResourceLogs{
+				Resource:                   &otlpresource.Resource{Attributes: record.resource},
+				InstrumentationLibraryLogs: []*otlplogs.InstrumentationLibraryLogs{logs},
+			})
+		}
+		logs.Logs = append(logs.Logs, record.log)
+	}
+	err := withRetries(context.Background(), backoff.BackoffConfig{
+		MinBackoff: o.config.MinBackoff,
+		MaxBackoff: o.config.MaxBackoff,
+		MaxRetries: o.config.MaxRetries,
+	}, otlog, func() (bool, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), o.config.Timeout)
+		defer cancel()
+		return o.client.export(ctx, req)
+	})
+	if err != nil {
+		otlog.WithError(err).WithField("records", len(batch)).
+			Warn("can't send records to the OpenTelemetry collector")
+		if o.failures != nil {
+			originals := make([]map[string]interface{}, 0, len(batch))
+			for _, record := range batch {
+				originals = append(originals, record.original)
+			}
+			o.failures(originals, err)
+		} else if isUnavailable(err) {
+			o.undelivered.add(len(batch), err)
+		}
+		for range batch {
+			o.reporter.RecordExportFailed(o.name)
+		}
+		return
+	}
+	o.recordsSent.Add(float64(len(batch)))
+}
+
+func resourceKey(attrs []*otlpcommon.KeyValue) string {
+	parts := make([]string, 0, len(attrs))
+	for _, kv := range attrs {
+		parts = append(parts, fmt.Sprintf("%s=%v", kv.Key, kv.Value.GetValue()))
+	}
+	return strings.Join(parts, "\xff")
+}
+
+func sortKeyValues(kvs []*otlpcommon.KeyValue) {
+	sort.Slice(kvs, func(i, j int) bool {
+		return kvs[i].Key < kvs[j].Key
+	})
+}
+
+// otlpKeyValue converts a record field into an attribute, keeping the type of numeric and
+// boolean values
+func otlpKeyValue(key string, value interface{}) *otlpcommon.KeyValue {
+	av := &otlpcommon.AnyValue{}
+	switch v := value.(type) {
+	case string:
+		av.Value = &otlpcommon.AnyValue_StringValue{StringValue: v}
+	case bool:
+		av.Value = &otlpcommon.AnyValue_BoolValue{BoolValue: v}
+	case int, int32, int64, uint, uint8, uint16, uint32, uint64:
+		if num, ok := fields.Int64(v); ok {
+			av.Value = &otlpcommon.AnyValue_IntValue{IntValue: num}
+		} else {
+			// the unsigned values beyond the int64 range
+			num, _ := fields.Uint64(v)
+			av.Value = &otlpcommon.AnyValue_DoubleValue{DoubleValue: float64(num)}
+		}
+	case float32:
+		av.Value = &otlpcommon.AnyValue_DoubleValue{DoubleValue: float64(v)}
+	case float64:
+		av.Value = &otlpcommon.AnyValue_DoubleValue{DoubleValue: v}
+	default:
+		av.Value = &otlpcommon.AnyValue_StringValue{StringValue: fmt.Sprint(v)}
+	}
+	return &otlpcommon.KeyValue{Key: key, Value: av}
+}
+
+// otlpGRPCClient sends the export requests through the gRPC logs service
+type otlpGRPCClient struct {
+	conn    *grpc.ClientConn
+	service collogs.LogsServiceClient
+	headers metadata.MD
+}
+
+func newOTLPGRPCClient(cfg *config.OTLPConfig) (*otlpGRPCClient, error) {
+	opts := []grpc.DialOption{grpc.WithInsecure()}
+	if cfg.TLS != nil {
+		tlsConfig, err := promconf.NewTLSConfig(cfg.TLS)
+		if err != nil {
+			return nil, err
+		}
+		opts = []grpc.DialOption{grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig))}
+	}
+	// the connection is established in background, so the exporter can be started before the
+	// collector is available
+	conn, err := grpc.Dial(cfg.Endpoint, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &otlpGRPCClient{
+		conn:    conn,
+		service: collogs.NewLogsServiceClient(conn),
+		headers: metadata.New(cfg.Headers),
+	}, nil
+}
+
+func (c *otlpGRPCClient) export(ctx context.Context, req *collogs.ExportLogsServiceRequest) (bool, error) {
+	_, err := c.service.Export(metadata.NewOutgoingContext(ctx, c.headers), req)
+	if err == nil {
+		return false, nil
+	}
+	switch status.Code(err) {
+	case codes.Canceled, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Aborted,
+		codes.OutOfRange, codes.Unavailable, codes.DataLoss:
+		return true, err
+	default:
+		return false, err
+	}
+}
+
+func (c *otlpGRPCClient) close() error {
+	return c.conn.Close()
+}
+
+// otlpHTTPClient sends the export requests as protobuf messages to the /v1/logs path
+type otlpHTTPClient struct {
+	client  *http.Client
+	url     string
+	headers map[string]string
+}
+
+func newOTLPHTTPClient(cfg *config.OTLPConfig) (*otlpHTTPClient, error) {
+	clientConfig := promconf.HTTPClientConfig{}
+	if cfg.TLS != nil {
+		clientConfig.TLSConfig = *cfg.TLS
+	}
+	client, err := promconf.NewClientFromConfig(clientConfig, "otlp")
+	if err != nil {
+		return nil, err
+	}
+	return &otlpHTTPClient{
+		client:  client,
+		url:     strings.TrimSuffix(cfg.Endpoint, "/") + "/v1/logs",
+		headers: cfg.Headers,
+	}, nil
+}
+
+func (c *otlpHTTPClient) export(ctx context.Context, req *collogs.ExportLogsServiceRequest) (bool, error) {
+	body, err := proto.Marshal(req)
+	if err != nil {
+		return false, err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	for k, v := range c.headers {
+		httpReq.Header.Set(k, v)
+	}
+	httpReq.Header.Set("Content-Type", "application/x-protobuf")
+	resp, err := c.client.Do(httpReq)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode/100 == 2:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode == http.StatusBadGateway,
+		resp.StatusCode == http.StatusServiceUnavailable, resp.StatusCode == http.StatusGatewayTimeout:
+		return true, fmt.Errorf("collector returned HTTP status %d", resp.StatusCode)
+	default:
+		return false, fmt.Errorf("collector returned HTTP status %d", resp.StatusCode)
+	}
+}
+
+func (c *otlpHTTPClient) close() error {
+	c.client.CloseIdleConnections()
+	return nil
+}
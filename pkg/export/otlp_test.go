@@ -0,0 +1,243 @@
+package export
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	otlpcommon "go.opentelemetry.io/proto/otlp/common/v1"
+
+	"github.com/netobserv/goflow2-kube-enricher/pkg/config"
+	"github.com/netobserv/goflow2-kube-enricher/pkg/health"
+	"github.com/netobserv/goflow2-kube-enricher/pkg/internal/mock"
+)
+
+func testOTLPConfig(endpoint, protocol string) config.OTLPConfig {
+	return config.OTLPConfig{
+		Endpoint:       endpoint,
+		Protocol:       protocol,
+		Headers:        map[string]string{"x-api-key": "secret"},
+		BatchWait:      time.Hour,
+		BatchSize:      100,
+		Timeout:        5 * time.Second,
+		MinBackoff:     time.Millisecond,
+		MaxBackoff:     10 * time.Millisecond,
+		MaxRetries:     3,
+		TimestampLabel: "TimeFlowStart",
+		TimestampScale: time.Second,
+		ResourcePrefix: "Src",
+		ResourceAttributes: map[string]string{
+			"service.name": "goflow-kube",
+		},
+	}
+}
+
+func readOTLPRequest(t *testing.T, receiver *mock.OTLPReceiver) mock.OTLPRequest {
+	select {
+	case req := <-receiver.Requests:
+		return req
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "timeout while waiting for OTLP requests")
+		return mock.OTLPRequest{}
+	}
+}
+
+// otlpAttributes converts the attributes into a map, for easier comparison
+func otlpAttributes(kvs []*otlpcommon.KeyValue) map[string]interface{} {
+	attrs := map[string]interface{}{}
+	for _, kv := range kvs {
+		switch v := kv.Value.Value.(type) {
+		case *otlpcommon.AnyValue_StringValue:
+			attrs[kv.Key] = v.StringValue
+		case *otlpcommon.AnyValue_IntValue:
+			attrs[kv.Key] = v.IntValue
+		case *otlpcommon.AnyValue_DoubleValue:
+			attrs[kv.Key] = v.DoubleValue
+		case *otlpcommon.AnyValue_BoolValue:
+			attrs[kv.Key] = v.BoolValue
+		}
+	}
+	return attrs
+}
+
+func testOTLPExport(t *testing.T, protocol string) {
+	receiver, err := mock.StartOTLPReceiver()
+	require.NoError(t, err)
+	defer receiver.Close()
+	endpoint := receiver.GRPCAddr()
+	if protocol == config.OTLPHTTPProtobuf {
+		endpoint = receiver.HTTPURL()
+	}
+
+	// GIVEN an OTLP exporter
+	reporter := health.NewReporter(health.Ready)
+	cfg := testOTLPConfig(endpoint, protocol)
+	exporter, err := NewOTLP(&cfg, "otlp", reporter)
+	require.NoError(t, err)
+
+	// WHEN records from different source pods are exported
+	require.NoError(t, exporter.ProcessRecord(map[string]interface{}{
+		"TimeFlowStart":   1637501829,
+		"SrcPod":          "pod-1",
+		"SrcNamespace":    "ns-1",
+		"SrcWorkload":     "api",
+		"SrcWorkloadKind": "Deployment",
+		"DstPod":          "pod-2",
+		"Bytes":           uint64(123),
+		"Sampled":         true,
+	}))
+	require.NoError(t, exporter.ProcessRecord(map[string]interface{}{
+		"TimeFlowStart": 1637501830,
+		"SrcPod":        "pod-3",
+		"SrcNamespace":  "ns-1",
+		"Bytes":         456,
+	}))
+	require.NoError(t, exporter.Close())
+
+	// THEN the records are sent in a single request, grouped by resource
+	req := readOTLPRequest(t, receiver)
+	assert.Equal(t, "secret", req.Headers["x-api-key"])
+	require.Len(t, req.Logs.ResourceLogs, 2)
+	// AND the kubernetes fields of the source are sent as semantic-convention resource attributes
+	first := req.Logs.ResourceLogs[0]
+	assert.Equal(t, map[string]interface{}{
+		"service.name":        "goflow-kube",
+		"k8s.pod.name":        "pod-1",
+		"k8s.namespace.name":  "ns-1",
+		"k8s.deployment.name": "api",
+	}, otlpAttributes(first.Resource.Attributes))
+	assert.Equal(t, map[string]interface{}{
+		"service.name":       "goflow-kube",
+		"k8s.pod.name":       "pod-3",
+		"k8s.namespace.name": "ns-1",
+	}, otlpAttributes(req.Logs.ResourceLogs[1].Resource.Attributes))
+	// AND the rest of fields as log attributes, with the flow timestamp
+	require.Len(t, first.InstrumentationLibraryLogs, 1)
+	require.Len(t, first.InstrumentationLibraryLogs[0].Logs, 1)
+	log := first.InstrumentationLibraryLogs[0].Logs[0]
+	assert.Equal(t, uint64(time.Unix(1637501829, 0).UnixNano()), log.TimeUnixNano)
+	assert.Equal(t, map[string]interface{}{
+		"TimeFlowStart": int64(1637501829),
+		"DstPod":        "pod-2",
+		"Bytes":         int64(123),
+		"Sampled":       true,
+	}, otlpAttributes(log.Attributes))
+
+	assert.Contains(t, getMetrics(t, reporter), `exporter_otlp_record_sent{exporter="otlp"} 2`)
+}
+
+func TestOTLP_GRPC(t *testing.T) {
+	testOTLPExport(t, config.OTLPGRPC)
+}
+
+func TestOTLP_HTTP(t *testing.T) {
+	testOTLPExport(t, config.OTLPHTTPProtobuf)
+}
+
+func TestOTLP_Batching(t *testing.T) {
+	receiver, err := mock.StartOTLPReceiver()
+	require.NoError(t, err)
+	defer receiver.Close()
+
+	// GIVEN an OTLP exporter whose batches contain up to 2 records
+	cfg := testOTLPConfig(receiver.GRPCAddr(), config.OTLPGRPC)
+	cfg.BatchSize = 2
+	exporter, err := NewOTLP(&cfg, "otlp", health.NewReporter(health.Ready))
+	require.NoError(t, err)
+	defer exporter.Close()
+
+	// WHEN 3 records are exported
+	for i := 0; i < 3; i++ {
+		require.NoError(t, exporter.ProcessRecord(map[string]interface{}{"Bytes": i}))
+	}
+
+	// THEN a full batch is sent without waiting
+	req := readOTLPRequest(t, receiver)
+	require.Len(t, req.Logs.ResourceLogs, 1)
+	assert.Len(t, req.Logs.ResourceLogs[0].InstrumentationLibraryLogs[0].Logs, 2)
+	select {
+	case <-receiver.Requests:
+		require.Fail(t, "the incomplete batch should not have been sent")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestOTLP_Retries(t *testing.T) {
+	for _, protocol := range []string{config.OTLPGRPC, config.OTLPHTTPProtobuf} {
+		t.Run(protocol, func(t *testing.T) {
+			// GIVEN a collector that is temporarily unavailable
+			receiver, err := mock.StartOTLPReceiver()
+			require.NoError(t, err)
+			defer receiver.Close()
+			receiver.FailNext(2)
+			endpoint := receiver.GRPCAddr()
+			if protocol == config.OTLPHTTPProtobuf {
+				endpoint = receiver.HTTPURL()
+			}
+			reporter := health.NewReporter(health.Ready)
+			cfg := testOTLPConfig(endpoint, protocol)
+			exporter, err := NewOTLP(&cfg, "otlp", reporter)
+			require.NoError(t, err)
+
+			// WHEN a record is exported
+			require.NoError(t, exporter.ProcessRecord(map[string]interface{}{"Bytes": 1}))
+			require.NoError(t, exporter.Close())
+
+			// THEN the request is retried until it succeeds
+			req := readOTLPRequest(t, receiver)
+			require.Len(t, req.Logs.ResourceLogs, 1)
+			metrics := getMetrics(t, reporter)
+			assert.Contains(t, metrics, `exporter_otlp_record_sent{exporter="otlp"} 1`)
+			assert.NotContains(t, metrics, `exporter_record_failed{exporter="otlp"}`)
+		})
+	}
+}
+
+func TestOTLP_Failure(t *testing.T) {
+	// GIVEN a collector that is unavailable for longer than the retries
+	receiver, err := mock.StartOTLPReceiver()
+	require.NoError(t, err)
+	defer receiver.Close()
+	receiver.FailNext(10)
+	reporter := health.NewReporter(health.Ready)
+	cfg := testOTLPConfig(receiver.GRPCAddr(), config.OTLPGRPC)
+	exporter, err := NewOTLP(&cfg, "my-otlp", reporter)
+	require.NoError(t, err)
+
+	// WHEN some records are exported
+	for i := 0; i < 2; i++ {
+		require.NoError(t, exporter.ProcessRecord(map[string]interface{}{"Bytes": i}))
+	}
+	require.NoError(t, exporter.Close())
+
+	// THEN the failures are reported in the metrics
+	assert.Contains(t, getMetrics(t, reporter), `exporter_record_failed{exporter="my-otlp"} 2`)
+}
+
+func TestOTLP_FailureHandler(t *testing.T) {
+	// GIVEN a collector that is unavailable for longer than the retries
+	receiver, err := mock.StartOTLPReceiver()
+	require.NoError(t, err)
+	defer receiver.Close()
+	receiver.FailNext(10)
+	reporter := health.NewReporter(health.Ready)
+	cfg := testOTLPConfig(receiver.GRPCAddr(), config.OTLPGRPC)
+	exporter, err := NewOTLP(&cfg, "otlp", reporter)
+	require.NoError(t, err)
+	// AND a handler of the delivery failures
+	var failed []map[string]interface{}
+	exporter.SetFailureHandler(func(records []map[string]interface{}, err error) {
+		failed = append(failed, records...)
+	})
+
+	// WHEN some records are exported
+	for i := 0; i < 2; i++ {
+		require.NoError(t, exporter.ProcessRecord(map[string]interface{}{"Bytes": i}))
+	}
+
+	// THEN the original records are passed to the handler, instead of failing the flush
+	require.NoError(t, exporter.Flush())
+	require.NoError(t, exporter.Close())
+	assert.Equal(t, []map[string]interface{}{{"Bytes": 0}, {"Bytes": 1}}, failed)
+}
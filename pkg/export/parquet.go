@@ -0,0 +1,340 @@
+package export
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+
+	"github.com/netobserv/goflow2-kube-enricher/pkg/config"
+	"github.com/netobserv/goflow2-kube-enricher/pkg/fields"
+	"github.com/netobserv/goflow2-kube-enricher/pkg/health"
+)
+
+var pqlog = logrus.WithField("module", "export/parquet")
+
+const (
+	// parquetTimestampField holds the timestamp of the record, as extracted from the
+	// TimestampLabel, in milliseconds since epoch
+	parquetTimestampField = "Timestamp"
+	// parquetDefaultPartition is the Hive name of the partitions whose value is missing
+	parquetDefaultPartition = "__HIVE_DEFAULT_PARTITION__"
+	// parquetInProgressSuffix is appended to the files that are still being written, so
+	// they are ignored by the query engines until they are complete
+	parquetInProgressSuffix = ".inprogress"
+)
+
+// parquetFlow is the fixed schema of the Parquet files. The names of the columns are the
+// names of the record fields from goflow2 and the kubernetes enrichment. Missing or
+// mistyped fields are stored as nulls
+type parquetFlow struct {
+	Timestamp        *int64  `parquet:"name=Timestamp, type=INT64, convertedtype=TIMESTAMP_MILLIS, repetitiontype=OPTIONAL"`
+	Type             *int64  `parquet:"name=Type, type=INT64, repetitiontype=OPTIONAL"`
+	TimeReceived     *int64  `parquet:"name=TimeReceived, type=INT64, repetitiontype=OPTIONAL"`
+	TimeFlowStart    *int64  `parquet:"name=TimeFlowStart, type=INT64, repetitiontype=OPTIONAL"`
+	TimeFlowEnd      *int64  `parquet:"name=TimeFlowEnd, type=INT64, repetitiontype=OPTIONAL"`
+	SequenceNum      *int64  `parquet:"name=SequenceNum, type=INT64, repetitiontype=OPTIONAL"`
+	SamplingRate     *int64  `parquet:"name=SamplingRate, type=INT64, repetitiontype=OPTIONAL"`
+	FlowDirection    *int64  `parquet:"name=FlowDirection, type=INT64, repetitiontype=OPTIONAL"`
+	Bytes            *int64  `parquet:"name=Bytes, type=INT64, repetitiontype=OPTIONAL"`
+	Packets          *int64  `parquet:"name=Packets, type=INT64, repetitiontype=OPTIONAL"`
+	Etype            *int64  `parquet:"name=Etype, type=INT64, repetitiontype=OPTIONAL"`
+	Proto            *int64  `parquet:"name=Proto, type=INT64, repetitiontype=OPTIONAL"`
+	SrcAddr          *string `parquet:"name=SrcAddr, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+	DstAddr          *string `parquet:"name=DstAddr, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+	SrcPort          *int64  `parquet:"name=SrcPort, type=INT64, repetitiontype=OPTIONAL"`
+	DstPort          *int64  `parquet:"name=DstPort, type=INT64, repetitiontype=OPTIONAL"`
+	SrcMac           *string `parquet:"name=SrcMac, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+	DstMac           *string `parquet:"name=DstMac, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+	InIf             *int64  `parquet:"name=InIf, type=INT64, repetitiontype=OPTIONAL"`
+	OutIf            *int64  `parquet:"name=OutIf, type=INT64, repetitiontype=OPTIONAL"`
+	SrcVlan          *int64  `parquet:"name=SrcVlan, type=INT64, repetitiontype=OPTIONAL"`
+	DstVlan          *int64  `parquet:"name=DstVlan, type=INT64, repetitiontype=OPTIONAL"`
+	VlanId           *int64  `parquet:"name=VlanId, type=INT64, repetitiontype=OPTIONAL"`
+	IPTos            *int64  `parquet:"name=IPTos, type=INT64, repetitiontype=OPTIONAL"`
+	IPTTL            *int64  `parquet:"name=IPTTL, type=INT64, repetitiontype=OPTIONAL"`
+	TCPFlags         *int64  `parquet:"name=TCPFlags, type=INT64, repetitiontype=OPTIONAL"`
+	IcmpType         *int64  `parquet:"name=IcmpType, type=INT64, repetitiontype=OPTIONAL"`
+	IcmpCode         *int64  `parquet:"name=IcmpCode, type=INT64, repetitiontype=OPTIONAL"`
+	ForwardingStatus *int64  `parquet:"name=ForwardingStatus, type=INT64, repetitiontype=OPTIONAL"`
+	SrcAS            *int64  `parquet:"name=SrcAS, type=INT64, repetitiontype=OPTIONAL"`
+	DstAS            *int64  `parquet:"name=DstAS, type=INT64, repetitiontype=OPTIONAL"`
+	NextHopAS        *int64  `parquet:"name=NextHopAS, type=INT64, repetitiontype=OPTIONAL"`
+	SrcPod           *string `parquet:"name=SrcPod, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+	SrcNamespace     *string `parquet:"name=SrcNamespace, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+	SrcHostIP        *string `parquet:"name=SrcHostIP, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+	SrcWorkload      *string `parquet:"name=SrcWorkload, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+	SrcWorkloadKind  *string `parquet:"name=SrcWorkloadKind, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+	SrcNetwork       *string `parquet:"name=SrcNetwork, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+	SrcZone          *string `parquet:"name=SrcZone, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+	SrcRegion        *string `parquet:"name=SrcRegion, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+	SrcWarn          *string `parquet:"name=SrcWarn, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+	DstPod           *string `parquet:"name=DstPod, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+	DstNamespace     *string `parquet:"name=DstNamespace, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+	DstHostIP        *string `parquet:"name=DstHostIP, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+	DstWorkload      *string `parquet:"name=DstWorkload, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+	DstWorkloadKind  *string `parquet:"name=DstWorkloadKind, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+	DstNetwork       *string `parquet:"name=DstNetwork, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+	DstZone          *string `parquet:"name=DstZone, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+	DstRegion        *string `parquet:"name=DstRegion, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+	DstWarn          *string `parquet:"name=DstWarn, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+}
+
+var parquetCodecs = map[string]parquet.CompressionCodec{
+	config.ParquetUncompressed: parquet.CompressionCodec_UNCOMPRESSED,
+	config.ParquetSnappy:       parquet.CompressionCodec_SNAPPY,
+	config.ParquetGzip:         parquet.CompressionCodec_GZIP,
+	config.ParquetZstd:         parquet.CompressionCodec_ZSTD,
+}
+
+// Parquet archives the records into Parquet files with a fixed schema, partitioned by hour and
+// optionally by namespace. The file of each partition is closed once its hour has ended and it
+// hasn't received any record during the configured lateness, when too many files are open, or
+// when the exporter is closed
+type Parquet struct {
+	config     *config.ParquetConfig
+	timeNow    func() time.Time
+	timestamps *recordTimestamps
+	mt         sync.Mutex
+	files      map[parquetPartition]*parquetFile
+	// nextClose is the earliest time at which any of the open files may have to be closed
+	nextClose time.Time
+	quit      chan struct{}
+	closeOnce sync.Once
+}
+
+type parquetPartition struct {
+	hour      time.Time
+	namespace string
+}
+
+type parquetFile struct {
+	path      string
+	file      *os.File
+	writer    *writer.ParquetWriter
+	lastWrite time.Time
+}
+
+// parquetCloseInterval is the period at which the expired files are closed, so the idle
+// partitions are completed even if no more records arrive
+const parquetCloseInterval = time.Minute
+
+// NewParquet creates a Parquet exporter from a given configuration. The name identifies the
+// exporter in the reported metrics
+func NewParquet(cfg *config.ParquetConfig, name string, reporter *health.Reporter) (*Parquet, error) {
+	if err := os.MkdirAll(cfg.Path, 0755); err != nil {
+		return nil, err
+	}
+	timestamps, err := newRecordTimestamps(timestampLabelFields(cfg.TimestampLabel, cfg.TimestampScale), 0,
+		"parquet", name, reporter)
+	if err != nil {
+		return nil, err
+	}
+	p := &Parquet{
+		config:     cfg,
+		timeNow:    time.Now,
+		timestamps: timestamps,
+		files:      map[parquetPartition]*parquetFile{},
+		quit:       make(chan struct{}),
+	}
+	go p.closeLoop()
+	return p, nil
+}
+
+func (p *Parquet) ProcessRecord(record map[string]interface{}) error {
+	ts := p.timestamps.extract(record, p.timeNow())
+	flow := toParquetFlow(record)
+	millis := ts.UnixNano() / int64(time.Millisecond)
+	flow.Timestamp = &millis
+
+	partition := parquetPartition{hour: ts.UTC().Truncate(time.Hour)}
+	if p.config.PartitionByNamespace {
+		if ns, ok := record[p.config.NamespaceField].(string); ok && ns != "" {
+			partition.namespace = ns
+		} else {
+			partition.namespace = parquetDefaultPartition
+		}
+	}
+
+	p.mt.Lock()
+	defer p.mt.Unlock()
+	now := p.timeNow()
+	p.closeExpired(now)
+	file, ok := p.files[partition]
+	if !ok {
+		if len(p.files) >= p.config.MaxOpenFiles {
+			p.closeOldest()
+		}
+		var err error
+		if file, err = p.createFile(partition); err != nil {
+			return err
+		}
+		p.files[partition] = file
+	}
+	file.lastWrite = now
+	if deadline := p.closeDeadline(partition, file); p.nextClose.IsZero() || deadline.Before(p.nextClose) {
+		p.nextClose = deadline
+	}
+	return file.writer.Write(flow)
+}
+
+// Close writes the footer of all the open files, and syncs them to disk
+func (p *Parquet) Close() error {
+	p.closeOnce.Do(func() { close(p.quit) })
+	p.mt.Lock()
+	defer p.mt.Unlock()
+	var lastErr error
+	for partition, file := range p.files {
+		if err := file.close(); err != nil {
+			pqlog.WithError(err).WithField("path", file.path).Warn("can't close parquet file")
+			lastErr = err
+		}
+		delete(p.files, partition)
+	}
+	p.nextClose = time.Time{}
+	return lastErr
+}
+
+func (p *Parquet) closeLoop() {
+	ticker := time.NewTicker(parquetCloseInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.quit:
+			return
+		case <-ticker.C:
+			p.mt.Lock()
+			p.closeExpired(p.timeNow())
+			p.mt.Unlock()
+		}
+	}
+}
+
+// closeDeadline returns the wall-clock time after which the file of a partition is closed: the
+// end of its hour or its last write, whatever happens later, plus the max lateness period.
+// Using the wall clock instead of the record timestamps keeps a single record with a skewed
+// timestamp from closing all the files
+func (p *Parquet) closeDeadline(partition parquetPartition, file *parquetFile) time.Time {
+	deadline := partition.hour.Add(time.Hour)
+	if file.lastWrite.After(deadline) {
+		deadline = file.lastWrite
+	}
+	return deadline.Add(p.config.MaxLateness)
+}
+
+// closeExpired closes the files whose close deadline has been reached
+func (p *Parquet) closeExpired(now time.Time) {
+	if p.nextClose.IsZero() || now.Before(p.nextClose) {
+		return
+	}
+	p.nextClose = time.Time{}
+	for partition, file := range p.files {
+		deadline := p.closeDeadline(partition, file)
+		if now.Before(deadline) {
+			if p.nextClose.IsZero() || deadline.Before(p.nextClose) {
+				p.nextClose = deadline
+			}
+			continue
+		}
+		p.closeFile(partition, file)
+	}
+}
+
+// closeOldest closes the file that has been idle for the longest time, to bound the number of
+// open files, and the memory of their row groups
+func (p *Parquet) closeOldest() {
+	var oldest parquetPartition
+	var oldestFile *parquetFile
+	for partition, file := range p.files {
+		if oldestFile == nil || file.lastWrite.Before(oldestFile.lastWrite) {
+			oldest, oldestFile = partition, file
+		}
+	}
+	if oldestFile != nil {
+		pqlog.WithField("path", oldestFile.path).Debug("too many open parquet files. Closing the oldest")
+		p.closeFile(oldest, oldestFile)
+	}
+}
+
+func (p *Parquet) closeFile(partition parquetPartition, file *parquetFile) {
+	if err := file.close(); err != nil {
+		pqlog.WithError(err).WithField("path", file.path).Warn("can't close parquet file")
+	}
+	delete(p.files, partition)
+}
+
+func (p *Parquet) createFile(partition parquetPartition) (*parquetFile, error) {
+	dir := filepath.Join(p.config.Path,
+		"date="+partition.hour.Format("2006-01-02"),
+		"hour="+partition.hour.Format("15"))
+	if partition.namespace != "" {
+		dir = filepath.Join(dir, "namespace="+partition.namespace)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir,
+		fmt.Sprintf("flows-%s.parquet", p.timeNow().UTC().Format("20060102T150405.000000000")))
+	file, err := os.OpenFile(path+parquetInProgressSuffix, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	pw, err := writer.NewParquetWriterFromWriter(file, new(parquetFlow), 1)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	pw.RowGroupSize = p.config.RowGroupSize
+	pw.CompressionType = parquetCodecs[p.config.Compression]
+	pqlog.WithField("path", path).Debug("created parquet file")
+	return &parquetFile{path: path, file: file, writer: pw}, nil
+}
+
+// close writes the footer of the file, syncs it to disk and removes its in-progress suffix
+func (f *parquetFile) close() error {
+	if err := f.writer.WriteStop(); err != nil {
+		f.file.Close()
+		return err
+	}
+	if err := f.file.Sync(); err != nil {
+		f.file.Close()
+		return err
+	}
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.path+parquetInProgressSuffix, f.path)
+}
+
+// toParquetFlow copies the record fields into the homonymous fields of the parquet schema
+func toParquetFlow(record map[string]interface{}) *parquetFlow {
+	flow := parquetFlow{}
+	val := reflect.ValueOf(&flow).Elem()
+	for i := 0; i < val.NumField(); i++ {
+		name := val.Type().Field(i).Name
+		if name == parquetTimestampField {
+			continue
+		}
+		value, ok := record[name]
+		if !ok || value == nil {
+			continue
+		}
+		field := val.Field(i)
+		switch field.Type().Elem().Kind() {
+		case reflect.String:
+			str := fmt.Sprint(value)
+			field.Set(reflect.ValueOf(&str))
+		case reflect.Int64:
+			if num, ok := fields.Int64(value); ok {
+				field.Set(reflect.ValueOf(&num))
+			}
+		}
+	}
+	return &flow
+}
@@ -0,0 +1,241 @@
+package export
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/reader"
+
+	"github.com/netobserv/goflow2-kube-enricher/pkg/config"
+	"github.com/netobserv/goflow2-kube-enricher/pkg/health"
+)
+
+func testParquetConfig(t *testing.T) (config.ParquetConfig, func()) {
+	dir, err := ioutil.TempDir("", "parquet")
+	require.NoError(t, err)
+	return config.ParquetConfig{
+		Path:           dir,
+		NamespaceField: "SrcNamespace",
+		RowGroupSize:   1024 * 1024,
+		Compression:    config.ParquetSnappy,
+		MaxLateness:    5 * time.Minute,
+		MaxOpenFiles:   16,
+		TimestampLabel: "TimeFlowStart",
+		TimestampScale: time.Second,
+	}, func() { os.RemoveAll(dir) }
+}
+
+// parquetFiles returns the relative paths of all the files in the archive
+func parquetFiles(t *testing.T, dir string) []string {
+	var files []string
+	require.NoError(t, filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			rel, _ := filepath.Rel(dir, path)
+			files = append(files, rel)
+		}
+		return err
+	}))
+	sort.Strings(files)
+	return files
+}
+
+func readParquet(t *testing.T, path string) []parquetFlow {
+	file, err := local.NewLocalFileReader(path)
+	require.NoError(t, err)
+	defer file.Close()
+	pr, err := reader.NewParquetReader(file, new(parquetFlow), 1)
+	require.NoError(t, err)
+	defer pr.ReadStop()
+	flows := make([]parquetFlow, pr.GetNumRows())
+	require.NoError(t, pr.Read(&flows))
+	return flows
+}
+
+func TestParquet_Schema(t *testing.T) {
+	// GIVEN a parquet exporter
+	cfg, cleanup := testParquetConfig(t)
+	defer cleanup()
+	exporter, err := NewParquet(&cfg, "parquet", health.NewReporter(health.Ready))
+	require.NoError(t, err)
+
+	// WHEN some records are exported
+	require.NoError(t, exporter.ProcessRecord(map[string]interface{}{
+		"TimeFlowStart":   uint64(1637501829),
+		"Bytes":           uint64(123),
+		"Proto":           uint32(6),
+		"SrcAddr":         "10.0.0.1",
+		"SrcPod":          "pod-1",
+		"SrcNamespace":    "ns-1",
+		"SrcWorkload":     "api",
+		"SrcWorkloadKind": "Deployment",
+		"Unknown":         "ignored",
+	}))
+	require.NoError(t, exporter.ProcessRecord(map[string]interface{}{
+		"TimeFlowStart": float64(1637501830),
+		"Bytes":         "not-a-number",
+		"DstPod":        "pod-2",
+	}))
+	// THEN the file is not visible until the exporter is closed
+	files := parquetFiles(t, cfg.Path)
+	require.Len(t, files, 1)
+	assert.True(t, strings.HasSuffix(files[0], ".parquet.inprogress"))
+	require.NoError(t, exporter.Close())
+
+	// AND the records are written with the fixed schema
+	files = parquetFiles(t, cfg.Path)
+	require.Len(t, files, 1)
+	assert.Regexp(t, `^date=2021-11-21/hour=13/flows-\d{8}T\d{6}\.\d{9}\.parquet$`, files[0])
+	flows := readParquet(t, filepath.Join(cfg.Path, files[0]))
+	require.Len(t, flows, 2)
+	assert.EqualValues(t, 1637501829000, *flows[0].Timestamp)
+	assert.EqualValues(t, 1637501829, *flows[0].TimeFlowStart)
+	assert.EqualValues(t, 123, *flows[0].Bytes)
+	assert.EqualValues(t, 6, *flows[0].Proto)
+	assert.Equal(t, "10.0.0.1", *flows[0].SrcAddr)
+	assert.Equal(t, "pod-1", *flows[0].SrcPod)
+	assert.Equal(t, "ns-1", *flows[0].SrcNamespace)
+	assert.Equal(t, "api", *flows[0].SrcWorkload)
+	assert.Equal(t, "Deployment", *flows[0].SrcWorkloadKind)
+	assert.Nil(t, flows[0].DstPod)
+	// AND the missing or mistyped fields are null
+	assert.Nil(t, flows[1].Bytes)
+	assert.Nil(t, flows[1].SrcPod)
+	assert.Equal(t, "pod-2", *flows[1].DstPod)
+}
+
+func TestParquet_Partitions(t *testing.T) {
+	// GIVEN a parquet exporter partitioned by namespace
+	cfg, cleanup := testParquetConfig(t)
+	defer cleanup()
+	cfg.PartitionByNamespace = true
+	exporter, err := NewParquet(&cfg, "parquet", health.NewReporter(health.Ready))
+	require.NoError(t, err)
+
+	// WHEN records from different hours and namespaces are exported
+	// 2021-11-21 13:37:09
+	start := time.Date(2021, 11, 21, 13, 37, 9, 0, time.UTC).Unix()
+	require.NoError(t, exporter.ProcessRecord(map[string]interface{}{
+		"TimeFlowStart": start, "SrcNamespace": "ns-1", "Bytes": 1,
+	}))
+	require.NoError(t, exporter.ProcessRecord(map[string]interface{}{
+		"TimeFlowStart": start, "SrcNamespace": "ns-2", "Bytes": 2,
+	}))
+	require.NoError(t, exporter.ProcessRecord(map[string]interface{}{
+		"TimeFlowStart": start + 3600, "SrcNamespace": "ns-1", "Bytes": 3,
+	}))
+	// AND a record without namespace
+	require.NoError(t, exporter.ProcessRecord(map[string]interface{}{
+		"TimeFlowStart": start + 3600, "Bytes": 4,
+	}))
+	require.NoError(t, exporter.Close())
+
+	// THEN each partition is written in its own directory
+	files := parquetFiles(t, cfg.Path)
+	require.Len(t, files, 4)
+	for i, expected := range []string{
+		"date=2021-11-21/hour=13/namespace=ns-1/",
+		"date=2021-11-21/hour=13/namespace=ns-2/",
+		"date=2021-11-21/hour=14/namespace=__HIVE_DEFAULT_PARTITION__/",
+		"date=2021-11-21/hour=14/namespace=ns-1/",
+	} {
+		assert.True(t, strings.HasPrefix(files[i], expected), "%s should have %s prefix", files[i], expected)
+		assert.True(t, strings.HasSuffix(files[i], ".parquet"))
+	}
+	flows := readParquet(t, filepath.Join(cfg.Path, files[3]))
+	require.Len(t, flows, 1)
+	assert.EqualValues(t, 3, *flows[0].Bytes)
+}
+
+func TestParquet_CloseExpiredPartitions(t *testing.T) {
+	// GIVEN a parquet exporter
+	cfg, cleanup := testParquetConfig(t)
+	defer cleanup()
+	exporter, err := NewParquet(&cfg, "parquet", health.NewReporter(health.Ready))
+	require.NoError(t, err)
+	defer exporter.Close()
+	now := time.Date(2021, 11, 21, 13, 37, 9, 0, time.UTC)
+	exporter.timeNow = func() time.Time { return now }
+
+	// WHEN a record is exported
+	start := now.Unix()
+	require.NoError(t, exporter.ProcessRecord(map[string]interface{}{"TimeFlowStart": start}))
+	// AND a record from the next hour arrives within the lateness period
+	now = now.Add(1500 * time.Second)
+	require.NoError(t, exporter.ProcessRecord(map[string]interface{}{"TimeFlowStart": start + 1500}))
+	// THEN the file of the previous hour is kept open
+	assert.Len(t, exporter.files, 2)
+
+	// AND WHEN a record with a skewed timestamp arrives
+	require.NoError(t, exporter.ProcessRecord(map[string]interface{}{"TimeFlowStart": start + 86400}))
+	// THEN the files of the current hours are kept open
+	assert.Len(t, exporter.files, 3)
+
+	// AND WHEN the lateness period passes without receiving more records
+	now = now.Add(5 * time.Minute)
+	exporter.mt.Lock()
+	exporter.closeExpired(now)
+	exporter.mt.Unlock()
+
+	// THEN the file of the previous hour is closed
+	assert.Len(t, exporter.files, 2)
+	files := parquetFiles(t, cfg.Path)
+	require.Len(t, files, 3)
+	assert.True(t, strings.HasPrefix(files[0], "date=2021-11-21/hour=13/"))
+	assert.True(t, strings.HasSuffix(files[0], ".parquet"))
+	assert.Len(t, readParquet(t, filepath.Join(cfg.Path, files[0])), 1)
+	assert.True(t, strings.HasSuffix(files[1], ".parquet.inprogress"))
+	assert.True(t, strings.HasSuffix(files[2], ".parquet.inprogress"))
+
+	// AND WHEN the lateness period passes after the last record of an already ended hour
+	now = now.Add(24 * time.Hour)
+	exporter.mt.Lock()
+	exporter.closeExpired(now)
+	exporter.mt.Unlock()
+
+	// THEN all the files are closed
+	assert.Empty(t, exporter.files)
+	for _, file := range parquetFiles(t, cfg.Path) {
+		assert.True(t, strings.HasSuffix(file, ".parquet"), file)
+	}
+}
+
+func TestParquet_MaxOpenFiles(t *testing.T) {
+	// GIVEN a parquet exporter partitioned by namespace, which can't keep more than 2 open files
+	cfg, cleanup := testParquetConfig(t)
+	defer cleanup()
+	cfg.PartitionByNamespace = true
+	cfg.MaxOpenFiles = 2
+	exporter, err := NewParquet(&cfg, "parquet", health.NewReporter(health.Ready))
+	require.NoError(t, err)
+	defer exporter.Close()
+	now := time.Date(2021, 11, 21, 13, 37, 9, 0, time.UTC)
+	exporter.timeNow = func() time.Time { return now }
+
+	// WHEN records from 3 namespaces are exported
+	for _, ns := range []string{"ns1", "ns2", "ns1", "ns3"} {
+		now = now.Add(time.Second)
+		require.NoError(t, exporter.ProcessRecord(map[string]interface{}{
+			"TimeFlowStart": now.Unix(), "SrcNamespace": ns,
+		}))
+	}
+
+	// THEN the file that has been idle for the longest time is closed
+	assert.Len(t, exporter.files, 2)
+	files := parquetFiles(t, cfg.Path)
+	require.Len(t, files, 3)
+	assert.True(t, strings.HasPrefix(files[0], "date=2021-11-21/hour=13/namespace=ns1/"))
+	assert.True(t, strings.HasSuffix(files[0], ".parquet.inprogress"))
+	assert.True(t, strings.HasPrefix(files[1], "date=2021-11-21/hour=13/namespace=ns2/"))
+	assert.True(t, strings.HasSuffix(files[1], ".parquet"))
+	assert.Len(t, readParquet(t, filepath.Join(cfg.Path, files[1])), 1)
+	assert.True(t, strings.HasPrefix(files[2], "date=2021-11-21/hour=13/namespace=ns3/"))
+	assert.True(t, strings.HasSuffix(files[2], ".parquet.inprogress"))
+}
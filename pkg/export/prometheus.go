@@ -0,0 +1,209 @@
+package export
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/netobserv/goflow2-kube-enricher/pkg/cardinality"
+	"github.com/netobserv/goflow2-kube-enricher/pkg/config"
+	"github.com/netobserv/goflow2-kube-enricher/pkg/fields"
+	"github.com/netobserv/goflow2-kube-enricher/pkg/health"
+)
+
+// record fields that are accounted by the flow metrics
+var flowMetricFields = map[string]string{
+	config.FlowMetricBytes:   "Bytes",
+	config.FlowMetricPackets: "Packets",
+}
+
+// Prometheus record exporter. It aggregates the records into counters that are exposed in
+// the /metrics endpoint of the health service
+type Prometheus struct {
+	metrics []*flowMetric
+}
+
+// NewPrometheus creates the metrics defined in the configuration and registers them in the
+// reporter. The name identifies the exporter in the reported metrics
+func NewPrometheus(cfg *config.PrometheusConfig, name string, reporter *health.Reporter) (*Prometheus, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("the provided config is not valid: %w", err)
+	}
+	overflows, err := reporter.Register(prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "exporter_prometheus_label_overflow",
+			Help: "Number of records whose label values have been replaced by \"" +
+				cardinality.Overflow + "\" because the metric reached its limits.",
+		},
+		[]string{"exporter", "metric"},
+	))
+	if err != nil {
+		return nil, err
+	}
+	p := &Prometheus{}
+	for i := range cfg.Metrics {
+		mcfg := &cfg.Metrics[i]
+		metric := newFlowMetric(mcfg, overflows.(*prometheus.CounterVec).WithLabelValues(name, mcfg.Name))
+		registered, err := reporter.Register(metric)
+		if err != nil {
+			return nil, err
+		}
+		if registered != metric {
+			return nil, fmt.Errorf("metric %q is already registered", mcfg.Name)
+		}
+		p.metrics = append(p.metrics, metric)
+	}
+	return p, nil
+}
+
+// ProcessRecord accounts the record in all the metrics
+func (p *Prometheus) ProcessRecord(record map[string]interface{}) error {
+	for _, m := range p.metrics {
+		m.observe(record)
+	}
+	return nil
+}
+
+// Close does nothing, since the metrics keep being exposed by the health service
+func (p *Prometheus) Close() error {
+	return nil
+}
+
+// flowMetric is a counter that keeps bounded the number of distinct values of each label,
+// and removes the series that haven't been updated for a given TTL
+type flowMetric struct {
+	config    config.FlowMetricConfig
+	desc      *prometheus.Desc
+	overflows prometheus.Counter
+	now       func() time.Time
+	mt        sync.Mutex
+	series    map[string]*flowSeries
+	// labelValues counts, for each label, the number of series using each value
+	labelValues []map[string]int
+	lastExpiry  time.Time
+}
+
+type flowSeries struct {
+	labelValues []string
+	value       float64
+	updated     time.Time
+}
+
+func newFlowMetric(cfg *config.FlowMetricConfig, overflows prometheus.Counter) *flowMetric {
+	help := cfg.Help
+	if help == "" {
+		help = fmt.Sprintf("Number of %s of the enriched flows.", cfg.Value)
+	}
+	m := &flowMetric{
+		config:      *cfg,
+		desc:        prometheus.NewDesc(cfg.Name, help, cfg.Labels, nil),
+		overflows:   overflows,
+		now:         time.Now,
+		series:      map[string]*flowSeries{},
+		labelValues: make([]map[string]int, len(cfg.Labels)),
+	}
+	for i := range m.labelValues {
+		m.labelValues[i] = map[string]int{}
+	}
+	return m
+}
+
+// Describe implements prometheus.Collector
+func (m *flowMetric) Describe(ch chan<- *prometheus.Desc) {
+	ch <- m.desc
+}
+
+// Collect implements prometheus.Collector
+func (m *flowMetric) Collect(ch chan<- prometheus.Metric) {
+	m.mt.Lock()
+	defer m.mt.Unlock()
+	m.expire(m.now())
+	for _, s := range m.series {
+		ch <- prometheus.MustNewConstMetric(m.desc, prometheus.CounterValue, s.value, s.labelValues...)
+	}
+}
+
+func (m *flowMetric) observe(record map[string]interface{}) {
+	value := 1.0
+	if field, ok := flowMetricFields[m.config.Value]; ok {
+		v, ok := fields.Uint64(record[field])
+		if !ok {
+			return
+		}
+		value = float64(v)
+	}
+	now := m.now()
+	m.mt.Lock()
+	defer m.mt.Unlock()
+	// the expired series are also removed periodically, since their label values might be
+	// needed by the new series
+	if m.config.TTL > 0 && now.Sub(m.lastExpiry) >= m.config.TTL {
+		m.expire(now)
+	}
+	labelValues := make([]string, 0, len(m.config.Labels))
+	overflow := false
+	for i, label := range m.config.Labels {
+		lv := labelValue(record[label])
+		if m.config.MaxLabelValues > 0 {
+			if _, ok := m.labelValues[i][lv]; !ok && len(m.labelValues[i]) >= m.config.MaxLabelValues {
+				lv = cardinality.Overflow
+				overflow = true
+			}
+		}
+		labelValues = append(labelValues, lv)
+	}
+	if overflow {
+		m.overflows.Inc()
+	}
+	key := strings.Join(labelValues, "\xff")
+	s, ok := m.series[key]
+	if !ok {
+		s = &flowSeries{labelValues: labelValues}
+		m.series[key] = s
+		m.trackLabelValues(labelValues, 1)
+	}
+	s.value += value
+	s.updated = now
+}
+
+// expire removes the series that haven't been updated during the TTL
+func (m *flowMetric) expire(now time.Time) {
+	if m.config.TTL <= 0 {
+		return
+	}
+	m.lastExpiry = now
+	for key, s := range m.series {
+		if now.Sub(s.updated) >= m.config.TTL {
+			delete(m.series, key)
+			m.trackLabelValues(s.labelValues, -1)
+		}
+	}
+}
+
+// trackLabelValues updates the number of series using each label value. The overflow
+// value is not tracked, so it doesn't count towards the limits
+func (m *flowMetric) trackLabelValues(labelValues []string, delta int) {
+	for i, lv := range labelValues {
+		if lv == cardinality.Overflow {
+			continue
+		}
+		m.labelValues[i][lv] += delta
+		if m.labelValues[i][lv] <= 0 {
+			delete(m.labelValues[i], lv)
+		}
+	}
+}
+
+func labelValue(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return val
+	default:
+		return fmt.Sprint(val)
+	}
+}
@@ -0,0 +1,116 @@
+package export
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/netobserv/goflow2-kube-enricher/pkg/config"
+	"github.com/netobserv/goflow2-kube-enricher/pkg/health"
+)
+
+func TestPrometheus(t *testing.T) {
+	// GIVEN a Prometheus exporter with bytes and flows metrics
+	reporter := health.NewReporter(health.Ready)
+	exporter, err := NewPrometheus(&config.PrometheusConfig{Metrics: []config.FlowMetricConfig{{
+		Name:   "workload_bytes_total",
+		Value:  config.FlowMetricBytes,
+		Labels: []string{"SrcNamespace", "DstWorkload"},
+	}, {
+		Name:   "flows_total",
+		Help:   "Number of flows per protocol.",
+		Value:  config.FlowMetricFlows,
+		Labels: []string{"Proto"},
+	}}}, "prometheus", reporter)
+	require.NoError(t, err)
+
+	// WHEN some records are exported
+	require.NoError(t, exporter.ProcessRecord(map[string]interface{}{
+		"SrcNamespace": "ns1", "DstWorkload": "api", "Proto": 6, "Bytes": 100,
+	}))
+	require.NoError(t, exporter.ProcessRecord(map[string]interface{}{
+		"SrcNamespace": "ns1", "DstWorkload": "api", "Proto": 6, "Bytes": uint64(50),
+	}))
+	require.NoError(t, exporter.ProcessRecord(map[string]interface{}{
+		"SrcNamespace": "ns2", "Proto": 17, "Bytes": 10,
+	}))
+	// records without the accounted field are ignored by the metric
+	require.NoError(t, exporter.ProcessRecord(map[string]interface{}{
+		"SrcNamespace": "ns3", "Proto": 17,
+	}))
+
+	// THEN the records are aggregated by the label values
+	metrics := getMetrics(t, reporter)
+	assert.Contains(t, metrics, `workload_bytes_total{DstWorkload="api",SrcNamespace="ns1"} 150`)
+	assert.Contains(t, metrics, `workload_bytes_total{DstWorkload="",SrcNamespace="ns2"} 10`)
+	assert.NotContains(t, metrics, `SrcNamespace="ns3"`)
+	assert.Contains(t, metrics, `# HELP flows_total Number of flows per protocol.`)
+	assert.Contains(t, metrics, `flows_total{Proto="6"} 2`)
+	assert.Contains(t, metrics, `flows_total{Proto="17"} 2`)
+}
+
+func TestPrometheus_LabelValuesLimit(t *testing.T) {
+	// GIVEN a metric that accepts up to 2 distinct values per label
+	reporter := health.NewReporter(health.Ready)
+	exporter, err := NewPrometheus(&config.PrometheusConfig{Metrics: []config.FlowMetricConfig{{
+		Name:           "port_packets_total",
+		Value:          config.FlowMetricPackets,
+		Labels:         []string{"SrcNamespace", "DstPort"},
+		MaxLabelValues: 2,
+	}}}, "scan", reporter)
+	require.NoError(t, err)
+
+	// WHEN a port scan is exported
+	for port := 1; port <= 10; port++ {
+		require.NoError(t, exporter.ProcessRecord(map[string]interface{}{
+			"SrcNamespace": "ns1", "DstPort": port, "Packets": 1,
+		}))
+	}
+
+	// THEN the values exceeding the limit are aggregated in the overflow bucket
+	metrics := getMetrics(t, reporter)
+	assert.Contains(t, metrics, `port_packets_total{DstPort="1",SrcNamespace="ns1"} 1`)
+	assert.Contains(t, metrics, `port_packets_total{DstPort="2",SrcNamespace="ns1"} 1`)
+	assert.Contains(t, metrics, `port_packets_total{DstPort="other",SrcNamespace="ns1"} 8`)
+	assert.NotContains(t, metrics, `DstPort="3"`)
+	// AND the overflows are reported
+	assert.Contains(t, metrics,
+		`exporter_prometheus_label_overflow{exporter="scan",metric="port_packets_total"} 8`)
+}
+
+func TestPrometheus_Expiry(t *testing.T) {
+	// GIVEN a metric whose series expire after one minute
+	reporter := health.NewReporter(health.Ready)
+	exporter, err := NewPrometheus(&config.PrometheusConfig{Metrics: []config.FlowMetricConfig{{
+		Name:           "bytes_total",
+		Value:          config.FlowMetricBytes,
+		Labels:         []string{"DstPort"},
+		MaxLabelValues: 1,
+		TTL:            time.Minute,
+	}}}, "prometheus", reporter)
+	require.NoError(t, err)
+	now := time.Now()
+	metric := exporter.metrics[0]
+	metric.now = func() time.Time { return now }
+	hr := health.NewHTTPReporter(reporter)
+
+	// WHEN a series is not updated during the TTL
+	require.NoError(t, exporter.ProcessRecord(map[string]interface{}{"DstPort": 80, "Bytes": 10}))
+	require.NoError(t, exporter.ProcessRecord(map[string]interface{}{"DstPort": 443, "Bytes": 20}))
+	assert.Contains(t, scrapeMetrics(t, hr), `bytes_total{DstPort="80"} 10`)
+	assert.Contains(t, scrapeMetrics(t, hr), `bytes_total{DstPort="other"} 20`)
+	now = now.Add(time.Minute)
+
+	// THEN it is removed
+	metrics := scrapeMetrics(t, hr)
+	assert.NotContains(t, metrics, `bytes_total{`)
+
+	// AND its label values are available for new series
+	require.NoError(t, exporter.ProcessRecord(map[string]interface{}{"DstPort": 443, "Bytes": 30}))
+	metrics = scrapeMetrics(t, hr)
+	assert.Contains(t, metrics, `bytes_total{DstPort="443"} 30`)
+	assert.NotContains(t, metrics, `bytes_total{DstPort="80"}`)
+	assert.NotContains(t, metrics, `bytes_total{DstPort="other"}`)
+}
@@ -0,0 +1,48 @@
+package export
+
+import (
+	"context"
+	"errors"
+
+	"github.com/netobserv/loki-client-go/pkg/backoff"
+	"github.com/sirupsen/logrus"
+)
+
+// unavailableError is returned by withRetries when the last request failed with a retriable
+// error, because the retries were exhausted or the context is done
+type unavailableError struct {
+	err error
+}
+
+func (e *unavailableError) Error() string {
+	return e.err.Error()
+}
+
+func (e *unavailableError) Unwrap() error {
+	return e.err
+}
+
+// isUnavailable returns whether an error returned by withRetries was caused by an unavailable
+// sink, so the request might succeed if it is sent later
+func isUnavailable(err error) bool {
+	var unavailable *unavailableError
+	return errors.As(err, &unavailable)
+}
+
+// withRetries invokes the request function until it succeeds or returns a non-retriable
+// error, backing off between retries as Loki does. The retries stop when the context is done
+func withRetries(ctx context.Context, cfg backoff.BackoffConfig, log *logrus.Entry,
+	request func() (retriable bool, err error)) error {
+	bk := backoff.New(ctx, cfg)
+	for {
+		retriable, err := request()
+		if err == nil || !retriable {
+			return err
+		}
+		log.WithError(err).WithField("retries", bk.NumRetries()).Debug("request failed")
+		bk.Wait()
+		if !bk.Ongoing() {
+			return &unavailableError{err: err}
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package export
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	rotatedTimeFormat = "20060102T150405.000"
+	gzipExtension     = ".gz"
+)
+
+// rotatingFile is an io.WriteCloser that rotates the underlying file when it reaches a
+// maximum size or age. The rotated files are renamed as <name>-<timestamp><ext>, optionally
+// gzipped, and only the newest maxBackups files are kept
+type rotatingFile struct {
+	path       string
+	maxSize    int64
+	interval   time.Duration
+	compress   bool
+	maxBackups int
+	// header is written at the beginning of each new file
+	header  []byte
+	timeNow func() time.Time
+	file    *os.File
+	size    int64
+	opened  time.Time
+	// background tracks the compression of the rotated files
+	background sync.WaitGroup
+	// cleanup serializes the compression and removal of the rotated files
+	cleanup sync.Mutex
+}
+
+// Write the data into the current file, rotating it first if the data would exceed the
+// maximum size, or if the file is older than the rotation interval
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	if r.file == nil {
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+	if r.mustRotate(len(p)) {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// Close flushes the current file to disk and waits for the pending compressions
+func (r *rotatingFile) Close() error {
+	defer r.background.Wait()
+	if r.file == nil {
+		return nil
+	}
+	err := r.closeFile()
+	r.file = nil
+	return err
+}
+
+func (r *rotatingFile) mustRotate(length int) bool {
+	if r.size > int64(len(r.header)) && r.size+int64(length) > r.maxSize {
+		return true
+	}
+	return r.interval > 0 && r.timeNow().Sub(r.opened) >= r.interval
+}
+
+// open the file for appending, or create it if it doesn't exist
+func (r *rotatingFile) open() error {
+	if err := os.MkdirAll(filepath.Dir(r.path), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	r.file = file
+	r.size = info.Size()
+	r.opened = r.timeNow()
+	if r.size == 0 && len(r.header) > 0 {
+		n, err := r.file.Write(r.header)
+		r.size += int64(n)
+		return err
+	}
+	return nil
+}
+
+func (r *rotatingFile) closeFile() error {
+	if err := r.file.Sync(); err != nil {
+		r.file.Close()
+		return err
+	}
+	return r.file.Close()
+}
+
+func (r *rotatingFile) rotate() error {
+	if err := r.closeFile(); err != nil {
+		filelog.WithError(err).WithField("path", r.path).Warn("can't close file before rotation")
+	}
+	r.file = nil
+	rotated := r.rotatedPath()
+	if err := os.Rename(r.path, rotated); err != nil {
+		return err
+	}
+	if r.compress {
+		r.background.Add(1)
+		go func() {
+			defer r.background.Done()
+			r.cleanup.Lock()
+			defer r.cleanup.Unlock()
+			if err := compressFile(rotated); err != nil {
+				filelog.WithError(err).WithField("path", rotated).Warn("can't compress rotated file")
+			}
+			r.removeOldBackups()
+		}()
+	} else {
+		r.removeOldBackups()
+	}
+	return r.open()
+}
+
+// rotatedPath returns the name of the next rotated file. If the name is already used by another
+// rotated file (e.g. if the file is rotated twice within the same millisecond), a sequence
+// number is appended to the timestamp, since the rename would replace the existing file
+func (r *rotatingFile) rotatedPath() string {
+	ext := filepath.Ext(r.path)
+	base := fmt.Sprintf("%s-%s", strings.TrimSuffix(r.path, ext), r.timeNow().UTC().Format(rotatedTimeFormat))
+	rotated := base + ext
+	for seq := 1; fileExists(rotated) || fileExists(rotated+gzipExtension); seq++ {
+		rotated = fmt.Sprintf("%s-%d%s", base, seq, ext)
+	}
+	return rotated
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// removeOldBackups keeps the newest maxBackups rotated files, according to the timestamp and
+// sequence number in their names
+func (r *rotatingFile) removeOldBackups() {
+	if r.maxBackups <= 0 {
+		return
+	}
+	backups, err := r.backups()
+	if err != nil {
+		filelog.WithError(err).WithField("path", r.path).Warn("can't list rotated files")
+		return
+	}
+	for i := 0; i < len(backups)-r.maxBackups; i++ {
+		if err := os.Remove(backups[i]); err != nil {
+			filelog.WithError(err).WithField("path", backups[i]).Warn("can't remove rotated file")
+		}
+	}
+}
+
+// backups returns the rotated files, sorted from oldest to newest
+func (r *rotatingFile) backups() ([]string, error) {
+	ext := filepath.Ext(r.path)
+	prefix := filepath.Base(strings.TrimSuffix(r.path, ext)) + "-"
+	entries, err := ioutil.ReadDir(filepath.Dir(r.path))
+	if err != nil {
+		return nil, err
+	}
+	type backup struct {
+		path      string
+		timestamp string
+		seq       int
+	}
+	var found []backup
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		suffix := strings.TrimSuffix(strings.TrimSuffix(name, gzipExtension), ext)
+		suffix = strings.TrimPrefix(suffix, prefix)
+		if len(suffix) < len(rotatedTimeFormat) {
+			continue
+		}
+		timestamp, seqSuffix := suffix[:len(rotatedTimeFormat)], suffix[len(rotatedTimeFormat):]
+		if _, err := time.Parse(rotatedTimeFormat, timestamp); err != nil {
+			continue
+		}
+		seq := 0
+		if seqSuffix != "" {
+			if !strings.HasPrefix(seqSuffix, "-") {
+				continue
+			}
+			if seq, err = strconv.Atoi(seqSuffix[1:]); err != nil {
+				continue
+			}
+		}
+		found = append(found, backup{path: filepath.Join(filepath.Dir(r.path), name), timestamp: timestamp, seq: seq})
+	}
+	// the timestamps are sortable in alphabetical order
+	sort.Slice(found, func(i, j int) bool {
+		if found[i].timestamp != found[j].timestamp {
+			return found[i].timestamp < found[j].timestamp
+		}
+		return found[i].seq < found[j].seq
+	})
+	backups := make([]string, 0, len(found))
+	for _, b := range found {
+		backups = append(backups, b.path)
+	}
+	return backups, nil
+}
+
+// compressFile gzips the file and removes the original one
+func compressFile(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(path+gzipExtension, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(out)
+	if _, err := io.Copy(gz, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
@@ -0,0 +1,332 @@
+package export
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+
+	"github.com/netobserv/goflow2-kube-enricher/pkg/config"
+	"github.com/netobserv/goflow2-kube-enricher/pkg/health"
+)
+
+var tlog = logrus.WithField("module", "export/tail")
+
+// tailKeepAlive is the interval of the SSE comments that keep idle connections open
+const tailKeepAlive = 15 * time.Second
+
+// Reasons of the records that are dropped for a tail client
+const (
+	tailDropRateLimit  = "rate_limit"
+	tailDropBufferFull = "buffer_full"
+)
+
+// Tail forwards the records to the next exporter, and also streams them as Server-Sent Events
+// to the clients of its HTTP handler. Each client has a rate limit and a bounded buffer, so
+// slow clients never block the processing of the records: the records exceeding the rate or
+// the buffer are dropped for that client
+type Tail struct {
+	config  *config.TailConfig
+	next    Exporter
+	metrics *tailMetrics
+	mt      sync.Mutex
+	subs    map[*tailSubscriber]struct{}
+}
+
+type tailMetrics struct {
+	subscribers prometheus.Gauge
+	recordsSent prometheus.Counter
+	dropped     *prometheus.CounterVec
+}
+
+type tailSubscriber struct {
+	filter  tailFilter
+	limiter *rate.Limiter
+	records chan []byte
+	// dropped records for this subscriber. It is only modified while holding the tail lock
+	dropped uint64
+}
+
+// tailFilter selects the records whose source or destination matches any of the values of
+// each non-empty list
+type tailFilter struct {
+	namespaces []string
+	pods       []string
+	workloads  []string
+	ips        []string
+	ports      []uint32
+}
+
+// NewTail creates a Tail exporter that forwards the records to the next exporter
+func NewTail(cfg *config.TailConfig, next Exporter, reporter *health.Reporter) (*Tail, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	metrics, err := newTailMetrics(reporter)
+	if err != nil {
+		return nil, err
+	}
+	return &Tail{
+		config:  cfg,
+		next:    next,
+		metrics: metrics,
+		subs:    map[*tailSubscriber]struct{}{},
+	}, nil
+}
+
+func newTailMetrics(reporter *health.Reporter) (*tailMetrics, error) {
+	subscribers, err := reporter.Register(prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "exporter_tail_subscribers",
+		Help: "Number of clients connected to the live tail of the flows.",
+	}))
+	if err != nil {
+		return nil, err
+	}
+	recordsSent, err := reporter.Register(prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "exporter_tail_record_sent",
+		Help: "Number of records that have been sent to the live tail clients.",
+	}))
+	if err != nil {
+		return nil, err
+	}
+	dropped, err := reporter.Register(prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "exporter_tail_record_dropped",
+			Help: "Number of records that have been dropped for a live tail client, because of its rate limit or its full buffer.",
+		},
+		[]string{"reason"},
+	))
+	if err != nil {
+		return nil, err
+	}
+	return &tailMetrics{
+		subscribers: subscribers.(prometheus.Gauge),
+		recordsSent: recordsSent.(prometheus.Counter),
+		dropped:     dropped.(*prometheus.CounterVec),
+	}, nil
+}
+
+// ProcessRecord sends the record to the buffers of the matching clients, without blocking,
+// and forwards it to the next exporter
+func (t *Tail) ProcessRecord(record map[string]interface{}) error {
+	t.publish(record)
+	return t.next.ProcessRecord(record)
+}
+
+func (t *Tail) publish(record map[string]interface{}) {
+	t.mt.Lock()
+	defer t.mt.Unlock()
+	var js []byte
+	for sub := range t.subs {
+		if !sub.filter.matches(record) {
+			continue
+		}
+		if sub.limiter != nil && !sub.limiter.Allow() {
+			sub.dropped++
+			t.metrics.dropped.WithLabelValues(tailDropRateLimit).Inc()
+			continue
+		}
+		if js == nil {
+			// encoding the record only once, and before the next exporter can modify it
+			var err error
+			if js, err = encodeJSON(record); err != nil {
+				tlog.WithError(err).Debug("can't encode record")
+				return
+			}
+		}
+		select {
+		case sub.records <- js:
+		default:
+			sub.dropped++
+			t.metrics.dropped.WithLabelValues(tailDropBufferFull).Inc()
+		}
+	}
+}
+
+// ServeHTTP streams the records matching the filter of the query parameters (namespace, pod,
+// workload, ip and port) as Server-Sent Events, until the client disconnects. Each parameter
+// accepts multiple comma-separated values. The "rate" parameter sets a lower rate limit than
+// the configured one. When some records are dropped, a "dropped" event reports the total
+// number of records that have been dropped for the client
+func (t *Tail) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	filter, err := parseTailFilter(req)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	limit, err := t.rateLimit(req)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	flusher, ok := rw.(http.Flusher)
+	if !ok {
+		http.Error(rw, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	sub, status, err := t.subscribe(filter, limit)
+	if err != nil {
+		http.Error(rw, err.Error(), status)
+		return
+	}
+	defer t.unsubscribe(sub)
+	sublog := tlog.WithField("client", req.RemoteAddr)
+	sublog.WithField("query", req.URL.RawQuery).Debug("new live tail client")
+
+	rw.Header().Set("Content-Type", "text/event-stream")
+	rw.Header().Set("Cache-Control", "no-cache")
+	rw.Header().Set("Connection", "keep-alive")
+	rw.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(tailKeepAlive)
+	defer keepAlive.Stop()
+	var reported uint64
+	for {
+		var err error
+		select {
+		case <-req.Context().Done():
+			sublog.Debug("live tail client disconnected")
+			return
+		case <-keepAlive.C:
+			_, err = fmt.Fprint(rw, ": keep-alive\n\n")
+		case js, ok := <-sub.records:
+			if !ok {
+				return
+			}
+			t.mt.Lock()
+			dropped := sub.dropped
+			t.mt.Unlock()
+			if dropped != reported {
+				reported = dropped
+				_, err = fmt.Fprintf(rw, "event: dropped\ndata: %d\n\n", dropped)
+			}
+			if err == nil {
+				_, err = fmt.Fprintf(rw, "data: %s\n\n", js)
+			}
+			if err == nil {
+				t.metrics.recordsSent.Inc()
+			}
+		}
+		if err != nil {
+			sublog.WithError(err).Debug("can't write to live tail client. Closing connection")
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// rateLimit returns the limiter for a new client, or nil if its rate is unlimited
+func (t *Tail) rateLimit(req *http.Request) (*rate.Limiter, error) {
+	limit := t.config.RateLimit
+	if param := req.URL.Query().Get("rate"); param != "" {
+		requested, err := strconv.ParseFloat(param, 64)
+		if err != nil || requested <= 0 {
+			return nil, fmt.Errorf("invalid rate %q. Required > 0", param)
+		}
+		if limit == 0 || requested < limit {
+			limit = requested
+		}
+	}
+	if limit == 0 {
+		return nil, nil
+	}
+	return rate.NewLimiter(rate.Limit(limit), int(math.Ceil(limit))), nil
+}
+
+func (t *Tail) subscribe(filter tailFilter, limiter *rate.Limiter) (*tailSubscriber, int, error) {
+	t.mt.Lock()
+	defer t.mt.Unlock()
+	if t.subs == nil {
+		return nil, http.StatusServiceUnavailable, fmt.Errorf("the live tail is shutting down")
+	}
+	if t.config.MaxSubscribers > 0 && len(t.subs) >= t.config.MaxSubscribers {
+		return nil, http.StatusTooManyRequests,
+			fmt.Errorf("maximum number of live tail clients reached: %d", t.config.MaxSubscribers)
+	}
+	sub := &tailSubscriber{
+		filter:  filter,
+		limiter: limiter,
+		records: make(chan []byte, t.config.BufferSize),
+	}
+	t.subs[sub] = struct{}{}
+	t.metrics.subscribers.Inc()
+	return sub, http.StatusOK, nil
+}
+
+func (t *Tail) unsubscribe(sub *tailSubscriber) {
+	t.mt.Lock()
+	defer t.mt.Unlock()
+	if _, ok := t.subs[sub]; ok {
+		delete(t.subs, sub)
+		t.metrics.subscribers.Dec()
+	}
+}
+
+// Flush flushes the next exporter
+func (t *Tail) Flush() error {
+	return Flush(t.next)
+}
+
+// SetFailureHandler sets the handler of the delivery failures of the next exporter
+func (t *Tail) SetFailureHandler(handler FailureHandler) {
+	SetFailureHandler(t.next, handler)
+}
+
+// Close finishes the connections of the clients and closes the next exporter
+func (t *Tail) Close() error {
+	t.mt.Lock()
+	for sub := range t.subs {
+		close(sub.records)
+		t.metrics.subscribers.Dec()
+	}
+	t.subs = nil
+	t.mt.Unlock()
+	return t.next.Close()
+}
+
+func parseTailFilter(req *http.Request) (tailFilter, error) {
+	query := req.URL.Query()
+	filter := tailFilter{
+		namespaces: queryValues(query["namespace"]),
+		pods:       queryValues(query["pod"]),
+		workloads:  queryValues(query["workload"]),
+		ips:        queryValues(query["ip"]),
+	}
+	for _, port := range queryValues(query["port"]) {
+		p, err := strconv.ParseUint(port, 10, 16)
+		if err != nil {
+			return filter, fmt.Errorf("invalid port %q", port)
+		}
+		filter.ports = append(filter.ports, uint32(p))
+	}
+	return filter, nil
+}
+
+// queryValues splits the comma-separated values of a query parameter
+func queryValues(params []string) []string {
+	var values []string
+	for _, param := range params {
+		for _, v := range strings.Split(param, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				values = append(values, v)
+			}
+		}
+	}
+	return values
+}
+
+func (f *tailFilter) matches(record map[string]interface{}) bool {
+	return matchesAnyString(f.namespaces, record, "SrcNamespace", "DstNamespace") &&
+		matchesAnyString(f.pods, record, "SrcPod", "DstPod") &&
+		matchesAnyString(f.workloads, record, "SrcWorkload", "DstWorkload") &&
+		matchesAnyString(f.ips, record, "SrcAddr", "DstAddr") &&
+		matchesAnyNumber(f.ports, record, "SrcPort", "DstPort")
+}
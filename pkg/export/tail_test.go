@@ -0,0 +1,198 @@
+package export
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/netobserv/goflow2-kube-enricher/pkg/config"
+	"github.com/netobserv/goflow2-kube-enricher/pkg/health"
+)
+
+func testTailConfig() config.TailConfig {
+	return config.TailConfig{Enabled: true, MaxSubscribers: 2, BufferSize: 10}
+}
+
+// tailClient connects to the live tail and returns a reader of its events
+func tailClient(t *testing.T, server *httptest.Server, query string) (*http.Response, *bufio.Reader) {
+	resp, err := server.Client().Get(server.URL + "/flows/tail?" + query)
+	require.NoError(t, err)
+	return resp, bufio.NewReader(resp.Body)
+}
+
+// readEvent returns the lines of the next Server-Sent Event
+func readEvent(t *testing.T, in *bufio.Reader) []string {
+	var lines []string
+	for {
+		line, err := in.ReadString('\n')
+		require.NoError(t, err)
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			return lines
+		}
+		lines = append(lines, line)
+	}
+}
+
+func waitTailSubscribers(t *testing.T, tail *Tail, subscribers int) {
+	require.Eventually(t, func() bool {
+		tail.mt.Lock()
+		defer tail.mt.Unlock()
+		return len(tail.subs) == subscribers
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestTail_Filters(t *testing.T) {
+	// GIVEN a live tail in front of another exporter
+	reporter := health.NewReporter(health.Ready)
+	next := &fakeExporter{}
+	cfg := testTailConfig()
+	tail, err := NewTail(&cfg, next, reporter)
+	require.NoError(t, err)
+	server := httptest.NewServer(tail)
+	defer server.Close()
+
+	// AND a client of the ns-1 namespace flows on ports 53 or 443
+	nsResp, nsEvents := tailClient(t, server, "namespace=ns-1&port=53,443")
+	defer nsResp.Body.Close()
+	assert.Equal(t, http.StatusOK, nsResp.StatusCode)
+	assert.Equal(t, "text/event-stream", nsResp.Header.Get("Content-Type"))
+	// AND a client of the flows from a given IP
+	ipResp, ipEvents := tailClient(t, server, "ip=10.0.0.1")
+	defer ipResp.Body.Close()
+	waitTailSubscribers(t, tail, 2)
+
+	// WHEN some records are exported
+	for _, record := range []map[string]interface{}{
+		{"SrcNamespace": "ns-1", "DstPort": 80, "Bytes": 1},
+		{"DstNamespace": "ns-1", "SrcPort": float64(443), "Bytes": 2},
+		{"SrcAddr": "10.0.0.1", "SrcNamespace": "ns-2", "DstPort": 53, "Bytes": 3},
+		{"DstAddr": "10.0.0.1", "SrcNamespace": "ns-1", "DstPort": 53, "Bytes": 4},
+	} {
+		require.NoError(t, tail.ProcessRecord(record))
+	}
+
+	// THEN all the records are forwarded to the next exporter
+	assert.Len(t, next.records, 4)
+	// AND each client receives the records matching its filter
+	assert.Equal(t, []string{`data: {"Bytes":2,"DstNamespace":"ns-1","SrcPort":443}`}, readEvent(t, nsEvents))
+	assert.Equal(t, []string{`data: {"Bytes":4,"DstAddr":"10.0.0.1","DstPort":53,"SrcNamespace":"ns-1"}`},
+		readEvent(t, nsEvents))
+	assert.Equal(t, []string{`data: {"Bytes":3,"DstPort":53,"SrcAddr":"10.0.0.1","SrcNamespace":"ns-2"}`},
+		readEvent(t, ipEvents))
+	assert.Equal(t, []string{`data: {"Bytes":4,"DstAddr":"10.0.0.1","DstPort":53,"SrcNamespace":"ns-1"}`},
+		readEvent(t, ipEvents))
+
+	// AND WHEN a client disconnects
+	ipResp.Body.Close()
+	// THEN it is removed from the live tail
+	waitTailSubscribers(t, tail, 1)
+	metrics := getMetrics(t, reporter)
+	assert.Contains(t, metrics, `exporter_tail_subscribers 1`)
+	assert.Contains(t, metrics, `exporter_tail_record_sent 4`)
+
+	// AND WHEN the live tail is closed
+	require.NoError(t, tail.Close())
+	// THEN the remaining connections are finished, as well as the next exporter
+	_, err = nsEvents.ReadString('\n')
+	assert.Error(t, err)
+	assert.True(t, next.closed)
+}
+
+func TestTail_RateLimit(t *testing.T) {
+	// GIVEN a live tail limited to 100 records per second
+	reporter := health.NewReporter(health.Ready)
+	cfg := testTailConfig()
+	cfg.RateLimit = 100
+	tail, err := NewTail(&cfg, &fakeExporter{}, reporter)
+	require.NoError(t, err)
+	server := httptest.NewServer(tail)
+	defer server.Close()
+
+	// AND a client that requests a lower rate
+	resp, events := tailClient(t, server, "rate=2")
+	defer resp.Body.Close()
+	waitTailSubscribers(t, tail, 1)
+
+	// WHEN more records than the allowed rate are exported at once
+	for i := 0; i < 2; i++ {
+		require.NoError(t, tail.ProcessRecord(map[string]interface{}{"Bytes": i}))
+	}
+	assert.Equal(t, []string{`data: {"Bytes":0}`}, readEvent(t, events))
+	assert.Equal(t, []string{`data: {"Bytes":1}`}, readEvent(t, events))
+	for i := 2; i < 5; i++ {
+		require.NoError(t, tail.ProcessRecord(map[string]interface{}{"Bytes": i}))
+	}
+
+	// THEN the records exceeding the rate are dropped
+	assert.Contains(t, getMetrics(t, reporter), `exporter_tail_record_dropped{reason="rate_limit"} 3`)
+
+	// AND WHEN another record is exported after the rate limiter refills
+	time.Sleep(600 * time.Millisecond)
+	require.NoError(t, tail.ProcessRecord(map[string]interface{}{"Bytes": 10}))
+	// THEN the dropped records are reported before it
+	event := readEvent(t, events)
+	require.Len(t, event, 2)
+	assert.Equal(t, "event: dropped", event[0])
+	assert.Equal(t, "data: 3", event[1])
+	assert.Equal(t, []string{`data: {"Bytes":10}`}, readEvent(t, events))
+}
+
+func TestTail_SlowSubscriber(t *testing.T) {
+	// GIVEN a live tail whose clients can buffer 2 records
+	reporter := health.NewReporter(health.Ready)
+	cfg := testTailConfig()
+	cfg.BufferSize = 2
+	tail, err := NewTail(&cfg, &fakeExporter{}, reporter)
+	require.NoError(t, err)
+
+	// AND a client that doesn't consume its records
+	sub, _, err := tail.subscribe(tailFilter{}, nil)
+	require.NoError(t, err)
+
+	// WHEN more records than the buffer size are exported
+	for i := 0; i < 5; i++ {
+		require.NoError(t, tail.ProcessRecord(map[string]interface{}{"Bytes": i}))
+	}
+
+	// THEN the records that don't fit in the buffer are dropped
+	assert.Len(t, sub.records, 2)
+	assert.EqualValues(t, 3, sub.dropped)
+	assert.Contains(t, getMetrics(t, reporter), `exporter_tail_record_dropped{reason="buffer_full"} 3`)
+}
+
+func TestTail_Rejections(t *testing.T) {
+	// GIVEN a live tail that accepts up to 2 clients
+	cfg := testTailConfig()
+	tail, err := NewTail(&cfg, &fakeExporter{}, health.NewReporter(health.Ready))
+	require.NoError(t, err)
+	server := httptest.NewServer(tail)
+	defer server.Close()
+
+	// WHEN a client provides invalid parameters
+	// THEN it is rejected
+	for _, query := range []string{"port=http", "port=70000", "rate=0", "rate=fast"} {
+		resp, err := server.Client().Get(server.URL + "/flows/tail?" + query)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, query)
+	}
+
+	// AND WHEN a third client connects
+	for i := 0; i < 2; i++ {
+		resp, _ := tailClient(t, server, "")
+		defer resp.Body.Close()
+	}
+	waitTailSubscribers(t, tail, 2)
+	resp, err := server.Client().Get(server.URL + "/flows/tail")
+	require.NoError(t, err)
+	resp.Body.Close()
+	// THEN it is rejected
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+}
@@ -0,0 +1,112 @@
+package export
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+
+	"github.com/netobserv/goflow2-kube-enricher/pkg/config"
+	"github.com/netobserv/goflow2-kube-enricher/pkg/fields"
+	"github.com/netobserv/goflow2-kube-enricher/pkg/health"
+)
+
+// Reasons why a timestamp field is skipped
+const (
+	timestampMissing = "missing"
+	timestampInvalid = "invalid"
+	timestampSkew    = "skew"
+)
+
+// recordTimestamps extracts the timestamp of the records from an ordered list of fields. The
+// records without a valid timestamp get the local time, and the anomalies are accounted in
+// metrics instead of being logged for each record
+type recordTimestamps struct {
+	fields    []config.TimestampFieldConfig
+	maxSkew   time.Duration
+	skipped   *prometheus.CounterVec
+	localTime prometheus.Counter
+	name      string
+}
+
+// newRecordTimestamps creates the timestamps extractor of an exporter. The metrics are named
+// after the exporter type (e.g. exporter_opensearch_timestamp_skipped), and labelled by the
+// exporter name
+func newRecordTimestamps(timestampFields []config.TimestampFieldConfig, maxSkew time.Duration,
+	exporterType, name string, reporter *health.Reporter) (*recordTimestamps, error) {
+	skipped, err := reporter.Register(prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "exporter_" + exporterType + "_timestamp_skipped",
+			Help: "Number of records whose timestamp field has been skipped because it was missing, invalid or beyond the maximum clock skew.",
+		},
+		[]string{"exporter", "field", "reason"},
+	))
+	if err != nil {
+		return nil, err
+	}
+	localTime, err := reporter.Register(prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "exporter_" + exporterType + "_local_timestamp",
+			Help: "Number of records that have been sent with the local time, because none of their timestamp fields was valid.",
+		},
+		[]string{"exporter"},
+	))
+	if err != nil {
+		return nil, err
+	}
+	return &recordTimestamps{
+		fields:    timestampFields,
+		maxSkew:   maxSkew,
+		skipped:   skipped.(*prometheus.CounterVec),
+		localTime: localTime.(*prometheus.CounterVec).WithLabelValues(name),
+		name:      name,
+	}, nil
+}
+
+// timestampLabelFields returns the timestamp field of the exporters that are configured with
+// a single timestamp label, if any
+func timestampLabelFields(label model.LabelName, scale time.Duration) []config.TimestampFieldConfig {
+	if label == "" {
+		return nil
+	}
+	return []config.TimestampFieldConfig{{Name: string(label), Scale: scale}}
+}
+
+// extract returns the timestamp from the first valid field of the record. If none is valid,
+// it returns the local time
+func (t *recordTimestamps) extract(record map[string]interface{}, now time.Time) time.Time {
+	if len(t.fields) == 0 {
+		return now
+	}
+	for i := range t.fields {
+		field := &t.fields[i]
+		ts, reason := t.fieldTimestamp(record, field, now)
+		if reason == "" {
+			return ts
+		}
+		t.skipped.WithLabelValues(t.name, field.Name, reason).Inc()
+	}
+	t.localTime.Inc()
+	return now
+}
+
+// fieldTimestamp returns the timestamp of the given field, or the reason why it is skipped
+func (t *recordTimestamps) fieldTimestamp(record map[string]interface{}, field *config.TimestampFieldConfig,
+	now time.Time) (time.Time, string) {
+	value, ok := record[field.Name]
+	if !ok {
+		return time.Time{}, timestampMissing
+	}
+	ft, ok := fields.Float64(value)
+	if !ok || ft <= 0 {
+		return time.Time{}, timestampInvalid
+	}
+	tsNanos := int64(ft * float64(field.Scale))
+	ts := time.Unix(tsNanos/int64(time.Second), tsNanos%int64(time.Second))
+	if t.maxSkew > 0 {
+		if skew := now.Sub(ts); skew > t.maxSkew || skew < -t.maxSkew {
+			return time.Time{}, timestampSkew
+		}
+	}
+	return ts, ""
+}
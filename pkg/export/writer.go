@@ -0,0 +1,33 @@
+package export
+
+import (
+	"io"
+	"sync"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+// Writer exports the records as JSON lines to an io.Writer (e.g. the standard output)
+type Writer struct {
+	mt  sync.Mutex
+	out io.Writer
+}
+
+func NewWriter(out io.Writer) *Writer {
+	return &Writer{out: out}
+}
+
+func (w *Writer) ProcessRecord(record map[string]interface{}) error {
+	js, err := jsoniter.ConfigCompatibleWithStandardLibrary.Marshal(record)
+	if err != nil {
+		return err
+	}
+	w.mt.Lock()
+	defer w.mt.Unlock()
+	_, err = w.out.Write(append(js, '\n'))
+	return err
+}
+
+func (w *Writer) Close() error {
+	return nil
+}
@@ -0,0 +1,72 @@
+// Package fields provides helpers to read the values of the flow records, whose numeric
+// fields might have different types depending on the input format
+package fields
+
+import (
+	"math"
+	"reflect"
+)
+
+// Float64 returns the numeric value of a record field as a float64, and false if the value
+// is not numeric
+func Float64(val interface{}) (float64, bool) {
+	switch i := val.(type) {
+	case float64:
+		return i, true
+	case float32:
+		return float64(i), true
+	case int64:
+		return float64(i), true
+	case int32:
+		return float64(i), true
+	case uint64:
+		return float64(i), true
+	case uint32:
+		return float64(i), true
+	case int:
+		return float64(i), true
+	default:
+		return 0, false
+	}
+}
+
+// Int64 returns the numeric value of a record field as an int64, without its decimals, and
+// false if the value is not numeric or doesn't fit in an int64. The goflow2 enums (e.g. the
+// flow type) are numeric values too
+func Int64(val interface{}) (int64, bool) {
+	v := reflect.ValueOf(val)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if v.Uint() > math.MaxInt64 {
+			return 0, false
+		}
+		return int64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		if f := v.Float(); f >= math.MinInt64 && f < 1<<63 {
+			return int64(f), true
+		}
+	}
+	return 0, false
+}
+
+// Uint64 returns the numeric value of a record field as an uint64, without its decimals, and
+// false if the value is not numeric or is negative
+func Uint64(val interface{}) (uint64, bool) {
+	v := reflect.ValueOf(val)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if v.Int() < 0 {
+			return 0, false
+		}
+		return uint64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return v.Uint(), true
+	case reflect.Float32, reflect.Float64:
+		if f := v.Float(); f >= 0 && f < 1<<64 {
+			return uint64(f), true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,51 @@
+package fields
+
+import (
+	"math"
+	"testing"
+
+	flowpb "github.com/netsampler/goflow2/pb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInt64(t *testing.T) {
+	for _, tc := range []struct {
+		value    interface{}
+		expected int64
+		ok       bool
+	}{
+		{value: int32(-5), expected: -5, ok: true},
+		{value: uint16(443), expected: 443, ok: true},
+		{value: 12.7, expected: 12, ok: true},
+		{value: flowpb.FlowMessage_IPFIX, expected: int64(flowpb.FlowMessage_IPFIX), ok: true},
+		{value: uint64(math.MaxUint64), ok: false},
+		{value: 1e20, ok: false},
+		{value: "123", ok: false},
+		{value: nil, ok: false},
+	} {
+		num, ok := Int64(tc.value)
+		assert.Equal(t, tc.ok, ok, "%#v", tc.value)
+		assert.Equal(t, tc.expected, num, "%#v", tc.value)
+	}
+}
+
+func TestUint64(t *testing.T) {
+	for _, tc := range []struct {
+		value    interface{}
+		expected uint64
+		ok       bool
+	}{
+		{value: uint64(math.MaxUint64), expected: math.MaxUint64, ok: true},
+		{value: 443, expected: 443, ok: true},
+		{value: float32(12.7), expected: 12, ok: true},
+		{value: -1, ok: false},
+		{value: -0.5, ok: false},
+		{value: 1e20, ok: false},
+		{value: "123", ok: false},
+		{value: nil, ok: false},
+	} {
+		num, ok := Uint64(tc.value)
+		assert.Equal(t, tc.ok, ok, "%#v", tc.value)
+		assert.Equal(t, tc.expected, num, "%#v", tc.value)
+	}
+}
@@ -0,0 +1,84 @@
+// Package deadletter defines a Format that reads the entries of a dead-letter file, as JSON
+// lines, and returns their original records so they can be replayed through the pipeline
+package deadletter
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Fields of the dead-letter entries
+const (
+	// FieldTime is the time, in seconds since epoch, when the record failed
+	FieldTime = "Time"
+	// FieldStage is the stage where the record failed (see StageEnrich and StageExport)
+	FieldStage = "Stage"
+	// FieldError is the error that made the record fail
+	FieldError = "Error"
+	// FieldRecord is the record, as it was received and before being enriched. The records that
+	// an asynchronous exporter failed to deliver are kept as they were exported, after the
+	// enrichment, which is applied again when they are replayed
+	FieldRecord = "Record"
+	// FieldExporter is the name of the exporter that failed, when the record was delivered by
+	// the other exporters. It is not set if the record failed in all of them
+	FieldExporter = "Exporter"
+)
+
+// Stages where a record can fail
+const (
+	StageEnrich = "enrich"
+	StageExport = "export"
+)
+
+type Format struct {
+	scanner  *bufio.Scanner
+	line     int
+	exporter string
+}
+
+func NewScanner(in io.Reader) *Format {
+	scanner := bufio.NewScanner(in)
+	// the entries contain the whole record, which might exceed the default token size
+	scanner.Buffer(make([]byte, 64*1024), 10*1024*1024)
+	return &Format{scanner: scanner}
+}
+
+// SetExporter makes Next return only the entries of the given exporter, so their records are
+// replayed to that exporter only. By default, only the entries of the records that failed in
+// all the exporters are returned, so no exporter receives a record twice
+func (f *Format) SetExporter(name string) {
+	f.exporter = name
+}
+
+// Next returns the original record of the next entry, or io.EOF when there are no more entries
+func (f *Format) Next() (map[string]interface{}, error) {
+	for f.scanner.Scan() {
+		f.line++
+		raw := f.scanner.Bytes()
+		if len(raw) == 0 {
+			continue
+		}
+		var entry map[string]interface{}
+		if err := json.Unmarshal(raw, &entry); err != nil {
+			return nil, fmt.Errorf("line %d: %w", f.line, err)
+		}
+		record, ok := entry[FieldRecord].(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("line %d: not a dead-letter entry: missing %s field", f.line, FieldRecord)
+		}
+		if exporter, _ := entry[FieldExporter].(string); exporter != f.exporter {
+			continue
+		}
+		return record, nil
+	}
+	if err := f.scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+func (f *Format) Shutdown() {
+	// Can't shutdown the dead-letter file reader
+}
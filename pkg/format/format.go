@@ -1,7 +1,27 @@
 // Package format defines a Format interface for various input formats
 package format
 
+import (
+	"errors"
+	"time"
+)
+
+// ErrIdle is returned by the Next method of a Committer when no record has been read for a
+// commit interval, while some records haven't been committed yet. They can then be committed
+// without waiting for the next record, and Next can be invoked again
+var ErrIdle = errors.New("no record has been read for a commit interval")
+
 type Format interface {
 	Next() (map[string]interface{}, error)
 	Shutdown()
 }
+
+// Committer is implemented by the formats that need to know when a record has been processed
+// (e.g. to commit its offset in the source, so it is not read again after a restart)
+type Committer interface {
+	// Commit marks all the records returned by Next as processed
+	Commit() error
+	// CommitInterval is the minimum time between commits. If 0, each record is committed
+	// after being processed
+	CommitInterval() time.Duration
+}
@@ -0,0 +1,232 @@
+// Package kafka implements the Format interface for goflow2 protobuf messages that are read
+// from a Kafka topic
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	goflowpb "github.com/netsampler/goflow2/pb"
+	"github.com/prometheus/client_golang/prometheus"
+	kafkago "github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/netobserv/goflow2-kube-enricher/pkg/config"
+	"github.com/netobserv/goflow2-kube-enricher/pkg/format"
+	pbFormat "github.com/netobserv/goflow2-kube-enricher/pkg/format/pb"
+	"github.com/netobserv/goflow2-kube-enricher/pkg/health"
+	"github.com/netobserv/goflow2-kube-enricher/pkg/kafkaauth"
+)
+
+// Scheme of the listen addresses that are read from Kafka
+const Scheme = "kafka"
+
+var log = logrus.WithField("module", "format/kafka")
+
+// messageReader abstracts the Kafka consumer
+type messageReader interface {
+	FetchMessage(ctx context.Context) (kafkago.Message, error)
+	CommitMessages(ctx context.Context, msgs ...kafkago.Message) error
+	Close() error
+}
+
+// Format reads the goflow2 FlowMessage protobufs from a Kafka topic, as a member of a
+// consumer group. The offsets are committed after the records are processed
+type Format struct {
+	reader         messageReader
+	ctx            context.Context
+	cancel         func()
+	lengthPrefixed bool
+	commitInterval time.Duration
+	// last message read from each partition, whose offset hasn't been committed yet
+	pending  map[int]kafkago.Message
+	received *prometheus.CounterVec
+	invalid  *prometheus.CounterVec
+	lag      *prometheus.GaugeVec
+}
+
+// ParseAddress returns the brokers and the topic from a listen address with the form
+// kafka://broker1:port1,broker2:port2/topic
+func ParseAddress(address *url.URL) (brokers []string, topic string, err error) {
+	if address.Scheme != Scheme {
+		return nil, "", fmt.Errorf("unexpected scheme: %q", address.Scheme)
+	}
+	for _, broker := range strings.Split(address.Host, ",") {
+		if broker != "" {
+			brokers = append(brokers, broker)
+		}
+	}
+	if len(brokers) == 0 {
+		return nil, "", errors.New("no Kafka brokers provided")
+	}
+	topic = strings.Trim(address.Path, "/")
+	if topic == "" {
+		return nil, "", errors.New("no Kafka topic provided")
+	}
+	return brokers, topic, nil
+}
+
+// NewConsumer connects to the provided Kafka brokers and starts consuming the given topic
+func NewConsumer(brokers []string, topic string, cfg *config.KafkaInputConfig,
+	reporter *health.Reporter) (*Format, error) {
+	if cfg.GroupID == "" {
+		return nil, errors.New("the Kafka consumer group ID can't be empty")
+	}
+	tlsConfig, err := kafkaauth.TLS(cfg.TLS)
+	if err != nil {
+		return nil, err
+	}
+	mechanism, err := kafkaauth.SASL(cfg.SASL)
+	if err != nil {
+		return nil, err
+	}
+	reader := kafkago.NewReader(kafkago.ReaderConfig{
+		Brokers:  brokers,
+		GroupID:  cfg.GroupID,
+		Topic:    topic,
+		MinBytes: cfg.MinBytes,
+		MaxBytes: cfg.MaxBytes,
+		MaxWait:  cfg.MaxWait,
+		// the offsets are committed synchronously, once the reader has delivered the records
+		CommitInterval: 0,
+		Dialer: &kafkago.Dialer{
+			Timeout:       kafkago.DefaultDialer.Timeout,
+			DualStack:     true,
+			TLS:           tlsConfig,
+			SASLMechanism: mechanism,
+		},
+		Logger:      kafkago.LoggerFunc(log.Debugf),
+		ErrorLogger: kafkago.LoggerFunc(log.Warnf),
+	})
+	return newFormat(reader, cfg, reporter), nil
+}
+
+func newFormat(reader messageReader, cfg *config.KafkaInputConfig, reporter *health.Reporter) *Format {
+	ctx, cancel := context.WithCancel(context.Background())
+	f := &Format{
+		reader:         reader,
+		ctx:            ctx,
+		cancel:         cancel,
+		lengthPrefixed: cfg.LengthPrefixed,
+		commitInterval: cfg.CommitInterval,
+		pending:        map[int]kafkago.Message{},
+		received: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "kafka_consumer_record_received",
+				Help: "Number of messages that have been read from each Kafka partition.",
+			},
+			[]string{"partition"},
+		),
+		invalid: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "kafka_consumer_record_invalid",
+				Help: "Number of messages from each Kafka partition that could not be decoded.",
+			},
+			[]string{"partition"},
+		),
+		lag: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "kafka_consumer_lag",
+				Help: "Number of messages in each Kafka partition that haven't been read yet.",
+			},
+			[]string{"partition"},
+		),
+	}
+	reporter.MustRegister(f.received, f.invalid, f.lag)
+	return f
+}
+
+// Next returns the next valid flow from the topic. Messages that can't be decoded are
+// skipped, and committed along with the next records. If no message arrives for a commit
+// interval while some messages haven't been committed, it returns format.ErrIdle so they
+// are committed even if the topic is idle
+func (f *Format) Next() (map[string]interface{}, error) {
+	for {
+		msg, err := f.fetch()
+		if err != nil {
+			return nil, err
+		}
+		f.pending[msg.Partition] = msg
+		partition := strconv.Itoa(msg.Partition)
+		f.received.WithLabelValues(partition).Inc()
+		f.lag.WithLabelValues(partition).Set(float64(msg.HighWaterMark - msg.Offset - 1))
+
+		record, err := f.decode(msg.Value)
+		if err == nil {
+			return record, nil
+		}
+		f.invalid.WithLabelValues(partition).Inc()
+		log.WithError(err).WithFields(logrus.Fields{
+			"partition": msg.Partition,
+			"offset":    msg.Offset,
+		}).Debug("can't decode message. Skipping it")
+	}
+}
+
+// fetch waits for the next message, up to a commit interval if there are messages waiting to
+// be committed
+func (f *Format) fetch() (kafkago.Message, error) {
+	if f.commitInterval <= 0 || len(f.pending) == 0 {
+		return f.reader.FetchMessage(f.ctx)
+	}
+	ctx, cancel := context.WithTimeout(f.ctx, f.commitInterval)
+	defer cancel()
+	msg, err := f.reader.FetchMessage(ctx)
+	if err != nil && f.ctx.Err() == nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return msg, format.ErrIdle
+	}
+	return msg, err
+}
+
+func (f *Format) decode(value []byte) (map[string]interface{}, error) {
+	if f.lengthPrefixed {
+		length, n := protowire.ConsumeVarint(value)
+		if n < 0 || uint64(len(value)-n) < length {
+			return nil, errors.New("invalid message length")
+		}
+		value = value[n : n+int(length)]
+	}
+	message := goflowpb.FlowMessage{}
+	if err := proto.Unmarshal(value, &message); err != nil {
+		return nil, err
+	}
+	return pbFormat.RenderMessage(&message)
+}
+
+// Commit the offsets of the messages that have been returned by Next, in all the partitions
+func (f *Format) Commit() error {
+	if len(f.pending) == 0 {
+		return nil
+	}
+	msgs := make([]kafkago.Message, 0, len(f.pending))
+	for _, msg := range f.pending {
+		msgs = append(msgs, msg)
+	}
+	sort.Slice(msgs, func(i, j int) bool { return msgs[i].Partition < msgs[j].Partition })
+	if err := f.reader.CommitMessages(f.ctx, msgs...); err != nil {
+		return fmt.Errorf("can't commit Kafka offsets: %w", err)
+	}
+	f.pending = map[int]kafkago.Message{}
+	return nil
+}
+
+// CommitInterval returns the configured minimum time between commits
+func (f *Format) CommitInterval() time.Duration {
+	return f.commitInterval
+}
+
+// Shutdown stops consuming messages and leaves the consumer group
+func (f *Format) Shutdown() {
+	f.cancel()
+	if err := f.reader.Close(); err != nil {
+		log.WithError(err).Warn("can't close Kafka consumer")
+	}
+}
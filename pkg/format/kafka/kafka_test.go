@@ -0,0 +1,173 @@
+package kafka
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	goflowpb "github.com/netsampler/goflow2/pb"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	kafkago "github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/netobserv/goflow2-kube-enricher/pkg/config"
+	"github.com/netobserv/goflow2-kube-enricher/pkg/format"
+	"github.com/netobserv/goflow2-kube-enricher/pkg/health"
+)
+
+// fakeReader returns the provided messages and stores the committed offsets
+type fakeReader struct {
+	messages  []kafkago.Message
+	committed []int64
+	closed    bool
+}
+
+func (f *fakeReader) FetchMessage(ctx context.Context) (kafkago.Message, error) {
+	if len(f.messages) == 0 {
+		<-ctx.Done()
+		return kafkago.Message{}, ctx.Err()
+	}
+	msg := f.messages[0]
+	f.messages = f.messages[1:]
+	return msg, nil
+}
+
+func (f *fakeReader) CommitMessages(_ context.Context, msgs ...kafkago.Message) error {
+	for _, msg := range msgs {
+		f.committed = append(f.committed, msg.Offset)
+	}
+	return nil
+}
+
+func (f *fakeReader) Close() error {
+	f.closed = true
+	return nil
+}
+
+func flowMessage(t *testing.T, src, dst string) []byte {
+	msg, err := proto.Marshal(&goflowpb.FlowMessage{
+		SrcAddr: net.ParseIP(src).To4(),
+		DstAddr: net.ParseIP(dst).To4(),
+		Bytes:   123,
+	})
+	require.NoError(t, err)
+	return msg
+}
+
+func TestParseAddress(t *testing.T) {
+	address, err := url.Parse("kafka://broker1:9092,broker2:9092/flows")
+	require.NoError(t, err)
+	brokers, topic, err := ParseAddress(address)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"broker1:9092", "broker2:9092"}, brokers)
+	assert.Equal(t, "flows", topic)
+
+	address, err = url.Parse("kafka://broker1:9092")
+	require.NoError(t, err)
+	_, _, err = ParseAddress(address)
+	assert.Error(t, err)
+}
+
+func TestNext_CommitAfterProcessing(t *testing.T) {
+	// GIVEN a Kafka topic with a valid message, an invalid message and another valid message
+	reader := &fakeReader{messages: []kafkago.Message{
+		{Partition: 1, Offset: 10, HighWaterMark: 13, Value: flowMessage(t, "10.0.0.1", "10.0.0.2")},
+		{Partition: 1, Offset: 11, HighWaterMark: 13, Value: []byte{0xff, 0xab, 0xcd, 0xef}},
+		{Partition: 1, Offset: 12, HighWaterMark: 14, Value: flowMessage(t, "10.0.0.3", "10.0.0.4")},
+	}}
+	cfg := config.Default().KafkaInput
+	format := newFormat(reader, &cfg, health.NewReporter(health.Starting))
+
+	// WHEN the first record is read
+	record, err := format.Next()
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.1", record["SrcAddr"])
+	assert.Equal(t, "10.0.0.2", record["DstAddr"])
+	assert.EqualValues(t, 123, record["Bytes"])
+	// THEN its offset is not committed until the record is processed
+	assert.Empty(t, reader.committed)
+	require.NoError(t, format.Commit())
+	assert.Equal(t, []int64{10}, reader.committed)
+
+	// AND the invalid messages are skipped, and committed along with the next record
+	record, err = format.Next()
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.3", record["SrcAddr"])
+	assert.Equal(t, []int64{10}, reader.committed)
+	require.NoError(t, format.Commit())
+	assert.Equal(t, []int64{10, 12}, reader.committed)
+
+	// AND the metrics are updated
+	assert.Equal(t, float64(3), testutil.ToFloat64(format.received.WithLabelValues("1")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(format.invalid.WithLabelValues("1")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(format.lag.WithLabelValues("1")))
+
+	format.Shutdown()
+	assert.True(t, reader.closed)
+	_, err = format.Next()
+	assert.Error(t, err)
+}
+
+func TestCommit_AllPartitions(t *testing.T) {
+	// GIVEN a Kafka topic with messages in two partitions
+	reader := &fakeReader{messages: []kafkago.Message{
+		{Partition: 0, Offset: 5, Value: flowMessage(t, "10.0.0.1", "10.0.0.2")},
+		{Partition: 1, Offset: 7, Value: flowMessage(t, "10.0.0.1", "10.0.0.2")},
+		{Partition: 0, Offset: 6, Value: flowMessage(t, "10.0.0.1", "10.0.0.2")},
+	}}
+	cfg := config.Default().KafkaInput
+	format := newFormat(reader, &cfg, health.NewReporter(health.Starting))
+	assert.Equal(t, cfg.CommitInterval, format.CommitInterval())
+
+	// WHEN all the records are read before committing
+	for i := 0; i < 3; i++ {
+		_, err := format.Next()
+		require.NoError(t, err)
+	}
+	require.NoError(t, format.Commit())
+
+	// THEN the last offset of each partition is committed
+	assert.Equal(t, []int64{6, 7}, reader.committed)
+	require.NoError(t, format.Commit())
+	assert.Equal(t, []int64{6, 7}, reader.committed)
+}
+
+func TestNext_Idle(t *testing.T) {
+	// GIVEN a Kafka topic with a single message
+	reader := &fakeReader{messages: []kafkago.Message{
+		{Partition: 0, Offset: 5, Value: flowMessage(t, "10.0.0.1", "10.0.0.2")},
+	}}
+	cfg := config.Default().KafkaInput
+	cfg.CommitInterval = 10 * time.Millisecond
+	f := newFormat(reader, &cfg, health.NewReporter(health.Starting))
+	defer f.Shutdown()
+	_, err := f.Next()
+	require.NoError(t, err)
+
+	// WHEN no other message arrives for a commit interval
+	_, err = f.Next()
+
+	// THEN the reader is told to commit the pending message without waiting for the next one
+	assert.ErrorIs(t, err, format.ErrIdle)
+	require.NoError(t, f.Commit())
+	assert.Equal(t, []int64{5}, reader.committed)
+}
+
+func TestNext_LengthPrefixed(t *testing.T) {
+	msg := flowMessage(t, "10.0.0.1", "10.0.0.2")
+	reader := &fakeReader{messages: []kafkago.Message{
+		{Value: append(protowire.AppendVarint(nil, uint64(len(msg))), msg...)},
+	}}
+	cfg := config.Default().KafkaInput
+	cfg.LengthPrefixed = true
+	format := newFormat(reader, &cfg, health.NewReporter(health.Starting))
+
+	record, err := format.Next()
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.1", record["SrcAddr"])
+}
@@ -2,6 +2,9 @@
 package health
 
 import (
+	"errors"
+	"sync"
+
 	"github.com/prometheus/client_golang/prometheus"
 )
 
@@ -33,13 +36,27 @@ func (s Status) String() string {
 // Reporter of the health status and the statistics of the service
 type Reporter struct {
 	Status          Status
+	registry        *prometheus.Registry
 	recordEnriched  prometheus.Counter
 	recordDiscarded *prometheus.CounterVec
+	exportFailed    *prometheus.CounterVec
+	checksMt        sync.Mutex
+	checks          []namedCheck
+}
+
+// Check returns whether a component of the service is healthy, along with any data that
+// describes its state
+type Check func() (up bool, data interface{})
+
+type namedCheck struct {
+	name  string
+	check Check
 }
 
 func NewReporter(s Status) *Reporter {
-	return &Reporter{
-		Status: s,
+	r := &Reporter{
+		Status:   s,
+		registry: prometheus.NewRegistry(),
 		recordEnriched: prometheus.NewCounterVec(
 			prometheus.CounterOpts{
 				Name: "reader_record_enriched",
@@ -54,7 +71,52 @@ func NewReporter(s Status) *Reporter {
 			},
 			[]string{"error"},
 		),
+		exportFailed: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "exporter_record_failed",
+				Help: "Number of enriched records that could not be forwarded to a given exporter.",
+			},
+			[]string{"exporter"},
+		),
+	}
+	r.registry.MustRegister(r.recordEnriched)
+	r.registry.MustRegister(r.recordDiscarded)
+	r.registry.MustRegister(r.exportFailed)
+	return r
+}
+
+// MustRegister adds the provided collectors to the registry that is exposed by the
+// metrics endpoint. It panics if any collector can't be registered
+func (r *Reporter) MustRegister(cs ...prometheus.Collector) {
+	r.registry.MustRegister(cs...)
+}
+
+// Register adds the provided collector to the registry that is exposed by the metrics endpoint.
+// If an equivalent collector was already registered, it returns the existing one so it can be
+// shared (e.g. by different exporters of the same type)
+func (r *Reporter) Register(c prometheus.Collector) (prometheus.Collector, error) {
+	if err := r.registry.Register(c); err != nil {
+		var are prometheus.AlreadyRegisteredError
+		if errors.As(err, &are) {
+			return are.ExistingCollector, nil
+		}
+		return nil, err
 	}
+	return c, nil
+}
+
+// AddCheck adds a check to the readiness report. The service is not ready while any of its
+// checks is down
+func (r *Reporter) AddCheck(name string, check Check) {
+	r.checksMt.Lock()
+	defer r.checksMt.Unlock()
+	r.checks = append(r.checks, namedCheck{name: name, check: check})
+}
+
+func (r *Reporter) getChecks() []namedCheck {
+	r.checksMt.Lock()
+	defer r.checksMt.Unlock()
+	return append([]namedCheck{}, r.checks...)
 }
 
 // RecordEnriched annotates a record as successfully processed
@@ -66,3 +128,8 @@ func (r *Reporter) RecordEnriched() {
 func (r *Reporter) RecordDiscarded(err error) {
 	r.recordDiscarded.WithLabelValues(err.Error()).Inc()
 }
+
+// RecordExportFailed annotates that a record couldn't be forwarded to the given exporter
+func (r *Reporter) RecordExportFailed(exporter string) {
+	r.exportFailed.WithLabelValues(exporter).Inc()
+}
@@ -48,9 +48,7 @@ type StatusCheck struct {
 }
 
 func NewHTTPReporter(reporter *Reporter) HTTPReporter {
-	reg := prometheus.NewRegistry()
-	reg.MustRegister(reporter.recordEnriched)
-	reg.MustRegister(reporter.recordDiscarded)
+	reg := reporter.registry
 	reg.MustRegister(utils.NetFlowStats)
 	reg.MustRegister(utils.NetFlowErrors)
 	reg.MustRegister(utils.NetFlowSetRecordsStatsSum)
@@ -69,19 +67,24 @@ func NewHTTPReporter(reporter *Reporter) HTTPReporter {
 	return hr
 }
 
+// Handle registers an extra handler for the given pattern
+func (hs *HTTPReporter) Handle(pattern string, handler http.Handler) {
+	hs.endpoints.Handle(pattern, handler)
+}
+
 func (hs *HTTPReporter) Handler() http.Handler {
 	return hs.endpoints
 }
 
 func (hs *HTTPReporter) live(rw http.ResponseWriter, _ *http.Request) {
-	hs.writeStatus(rw, hs.reporter.Status != Error)
+	hs.writeStatus(rw, hs.reporter.Status != Error, nil)
 }
 
 func (hs *HTTPReporter) ready(rw http.ResponseWriter, _ *http.Request) {
-	hs.writeStatus(rw, hs.reporter.Status == Ready)
+	hs.writeStatus(rw, hs.reporter.Status == Ready, hs.reporter.getChecks())
 }
 
-func (hs *HTTPReporter) writeStatus(rw http.ResponseWriter, up bool) {
+func (hs *HTTPReporter) writeStatus(rw http.ResponseWriter, up bool, checks []namedCheck) {
 	host, err := os.Hostname()
 	if err != nil {
 		host = err.Error()
@@ -89,23 +92,23 @@ func (hs *HTTPReporter) writeStatus(rw http.ResponseWriter, up bool) {
 	checkMetrics := map[string]interface{}{
 		hostDataFieldName: host,
 	}
-	var statusName string
-	var statusCode int
-	if up {
-		statusName = statusUp
-		statusCode = statusCodeHealthy
-	} else {
-		statusName = statusDown
-		statusCode = statusCodeUnhealthy
-	}
 	report := Report{
-		Status: statusName,
 		Checks: []StatusCheck{{
 			Name:   statusNameFlows,
-			Status: statusName,
+			Status: statusName(up),
 			Data:   checkMetrics,
 		}},
 	}
+	for _, c := range checks {
+		checkUp, data := c.check()
+		up = up && checkUp
+		report.Checks = append(report.Checks, StatusCheck{Name: c.name, Status: statusName(checkUp), Data: data})
+	}
+	report.Status = statusName(up)
+	statusCode := statusCodeUnhealthy
+	if up {
+		statusCode = statusCodeHealthy
+	}
 	rw.Header().Set(contentTypeHeader, healthContentType)
 	rw.WriteHeader(statusCode)
 	out, err := json.Marshal(report)
@@ -118,6 +121,13 @@ func (hs *HTTPReporter) writeStatus(rw http.ResponseWriter, up bool) {
 	}
 }
 
+func statusName(up bool) string {
+	if up {
+		return statusUp
+	}
+	return statusDown
+}
+
 func (hs *HTTPReporter) metrics(rw http.ResponseWriter, _ *http.Request) {
 	mfs, err := hs.registry.Gather()
 	if err != nil {
@@ -57,11 +57,47 @@ func TestHttpReporter_Health(t *testing.T) {
 	}
 }
 
+func TestHttpReporter_Checks(t *testing.T) {
+	reporter := NewReporter(Ready)
+	svc := NewHTTPReporter(reporter)
+	server := httptest.NewServer(svc.Handler())
+	defer server.Close()
+	up := true
+	reporter.AddCheck("loki/loki", func() (bool, interface{}) {
+		return up, map[string]string{"primary": "UP"}
+	})
+
+	for _, tc := range []testCase{
+		{path: "/health/ready", expectedCode: 200, expectedStatus: "UP"},
+		{path: "/health/ready", expectedCode: 503, expectedStatus: "DOWN"},
+	} {
+		up = tc.expectedStatus == "UP"
+		resp, err := server.Client().Get(server.URL + tc.path)
+		require.NoError(t, err)
+		assert.Equal(t, tc.expectedCode, resp.StatusCode)
+		reported := Report{}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&reported))
+		assert.Equal(t, tc.expectedStatus, reported.Status)
+		require.Len(t, reported.Checks, 2)
+		assert.Equal(t, "UP", reported.Checks[0].Status)
+		assert.Equal(t, "loki/loki", reported.Checks[1].Name)
+		assert.Equal(t, tc.expectedStatus, reported.Checks[1].Status)
+		assert.Equal(t, map[string]interface{}{"primary": "UP"}, reported.Checks[1].Data)
+	}
+
+	// the checks don't affect the liveness
+	up = false
+	resp, err := server.Client().Get(server.URL + "/health/live")
+	require.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+}
+
 func TestHttpReporter_Metrics(t *testing.T) {
 	reporter := NewReporter(Ready)
 	svc := NewHTTPReporter(reporter)
 	svc.reporter.RecordEnriched()
 	svc.reporter.RecordDiscarded(errors.New("file not found"))
+	svc.reporter.RecordExportFailed("loki")
 	utils.NetFlowStats.WithLabelValues("foo", "9").Inc()
 	utils.NetFlowStats.WithLabelValues("foo", "9").Inc()
 	utils.NetFlowStats.WithLabelValues("bar", "9").Inc()
@@ -79,4 +115,5 @@ func TestHttpReporter_Metrics(t *testing.T) {
 	assert.Contains(t, body, `flow_process_nf_errors_count{error="boom",router="foo"} 1`)
 	assert.Contains(t, body, "reader_record_enriched 1")
 	assert.Contains(t, body, `reader_record_discarded{error="file not found"} 1`)
+	assert.Contains(t, body, `exporter_record_failed{exporter="loki"} 1`)
 }
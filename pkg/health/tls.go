@@ -0,0 +1,45 @@
+package health
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/netobserv/goflow2-kube-enricher/pkg/config"
+)
+
+// ServerTLS returns the TLS configuration of a server, or nil if TLS is not enabled. If
+// clientAuth is set and a client CA is provided, the clients must authenticate with a
+// certificate signed by it. The health service doesn't authenticate its clients, since the
+// kubelet probes and the Prometheus scrapes don't present any certificate
+func ServerTLS(cfg *config.ServerTLSConfig, clientAuth bool) (*tls.Config, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid server TLS config: %w", err)
+	}
+	cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading server certificate: %w", err)
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if clientAuth && cfg.ClientCAFile != "" {
+		ca, err := ioutil.ReadFile(cfg.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, errors.New("no valid certificates found in the client CA file")
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tlsConfig, nil
+}
@@ -0,0 +1,44 @@
+package health
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/netobserv/goflow2-kube-enricher/pkg/config"
+	"github.com/netobserv/goflow2-kube-enricher/pkg/internal/mock"
+)
+
+func TestServerTLS_ClientAuth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "server-tls")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	certs, err := mock.GenerateCertificates(dir)
+	require.NoError(t, err)
+	cfg := &config.ServerTLSConfig{
+		CertFile:     certs.ServerCertFile,
+		KeyFile:      certs.ServerKeyFile,
+		ClientCAFile: certs.CAFile,
+	}
+
+	// the servers that authenticate their clients require a certificate signed by the client CA
+	tlsConfig, err := ServerTLS(cfg, true)
+	require.NoError(t, err)
+	assert.Equal(t, tls.RequireAndVerifyClientCert, tlsConfig.ClientAuth)
+	assert.NotNil(t, tlsConfig.ClientCAs)
+
+	// the health service doesn't, so the probes and the scrapes keep working
+	tlsConfig, err = ServerTLS(cfg, false)
+	require.NoError(t, err)
+	assert.Equal(t, tls.NoClientCert, tlsConfig.ClientAuth)
+	assert.Len(t, tlsConfig.Certificates, 1)
+
+	// TLS is optional
+	tlsConfig, err = ServerTLS(nil, true)
+	require.NoError(t, err)
+	assert.Nil(t, tlsConfig)
+}
@@ -0,0 +1,99 @@
+package mock
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path"
+	"time"
+)
+
+// Certificates holds the paths of a self-signed CA and of the server and client certificates
+// that are signed by it
+type Certificates struct {
+	CAFile         string
+	ServerCertFile string
+	ServerKeyFile  string
+	ClientCertFile string
+	ClientKeyFile  string
+}
+
+// GenerateCertificates writes into the directory a CA, a server certificate for localhost
+// and 127.0.0.1, and a client certificate
+func GenerateCertificates(dir string) (Certificates, error) {
+	certs := Certificates{
+		CAFile:         path.Join(dir, "ca.crt"),
+		ServerCertFile: path.Join(dir, "server.crt"),
+		ServerKeyFile:  path.Join(dir, "server.key"),
+		ClientCertFile: path.Join(dir, "client.crt"),
+		ClientKeyFile:  path.Join(dir, "client.key"),
+	}
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return certs, err
+	}
+	ca := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, ca, ca, &caKey.PublicKey, caKey)
+	if err != nil {
+		return certs, err
+	}
+	if err := writePEM(certs.CAFile, "CERTIFICATE", caDER); err != nil {
+		return certs, err
+	}
+	if err := signCertificate(ca, caKey, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, certs.ServerCertFile, certs.ServerKeyFile); err != nil {
+		return certs, err
+	}
+	err = signCertificate(ca, caKey, &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: "client"},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, certs.ClientCertFile, certs.ClientKeyFile)
+	return certs, err
+}
+
+func signCertificate(ca *x509.Certificate, caKey *ecdsa.PrivateKey, cert *x509.Certificate,
+	certFile, keyFile string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	cert.NotBefore = ca.NotBefore
+	cert.NotAfter = ca.NotAfter
+	cert.KeyUsage = x509.KeyUsageDigitalSignature
+	der, err := x509.CreateCertificate(rand.Reader, cert, ca, &key.PublicKey, caKey)
+	if err != nil {
+		return err
+	}
+	if err := writePEM(certFile, "CERTIFICATE", der); err != nil {
+		return err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	return writePEM(keyFile, "EC PRIVATE KEY", keyDER)
+}
+
+func writePEM(file, blockType string, der []byte) error {
+	return ioutil.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600)
+}
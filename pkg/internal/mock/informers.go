@@ -1,4 +1,4 @@
-// Package mock provides some kubernetes-related mocks for testing
+// Package mock provides some kubernetes-related mocks and other service stand-ins for testing
 package mock
 
 import (
@@ -20,6 +20,21 @@ func (o *InformersMock) PodByIP(ip string) *corev1.Pod {
 	return args.Get(0).(*corev1.Pod)
 }
 
+func (o *InformersMock) PodByMAC(mac string) *corev1.Pod {
+	args := o.Called(mac)
+	return args.Get(0).(*corev1.Pod)
+}
+
+func (o *InformersMock) PodNetworkByIP(ip string) string {
+	args := o.Called(ip)
+	return args.String(0)
+}
+
+func (o *InformersMock) PodNetworkByMAC(mac string) string {
+	args := o.Called(mac)
+	return args.String(0)
+}
+
 func (o *InformersMock) ServiceByIP(ip string) *corev1.Service {
 	args := o.Called(ip)
 	return args.Get(0).(*corev1.Service)
@@ -30,6 +45,21 @@ func (o *InformersMock) ReplicaSet(namespace, name string) *appsv1.ReplicaSet {
 	return args.Get(0).(*appsv1.ReplicaSet)
 }
 
+func (o *InformersMock) NodeByName(name string) *corev1.Node {
+	args := o.Called(name)
+	return args.Get(0).(*corev1.Node)
+}
+
+func (o *InformersMock) NamespaceByName(name string) *corev1.Namespace {
+	args := o.Called(name)
+	return args.Get(0).(*corev1.Namespace)
+}
+
+func (o *InformersMock) CustomObjectByIP(ip string) *meta.CustomObject {
+	args := o.Called(ip)
+	return args.Get(0).(*meta.CustomObject)
+}
+
 func fakePod(name, ns, host string) *corev1.Pod {
 	return &corev1.Pod{
 		ObjectMeta: metav1.ObjectMeta{
@@ -53,6 +83,7 @@ func fakeService(name, ns string) *corev1.Service {
 
 func (o *InformersMock) MockPod(name, ns, ip, host string) {
 	o.On("PodByIP", ip).Return(fakePod(name, ns, host))
+	o.On("PodNetworkByIP", ip).Return(meta.DefaultNetwork)
 }
 
 func (o *InformersMock) MockPodInDepl(name, ns, ip, host, rs, depl string) {
@@ -62,6 +93,7 @@ func (o *InformersMock) MockPodInDepl(name, ns, ip, host, rs, depl string) {
 		Kind: "ReplicaSet",
 	})
 	o.On("PodByIP", ip).Return(pod)
+	o.On("PodNetworkByIP", ip).Return(meta.DefaultNetwork)
 	o.On("ReplicaSet", ns, rs).Return(&appsv1.ReplicaSet{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      rs,
@@ -76,12 +108,49 @@ func (o *InformersMock) MockPodInDepl(name, ns, ip, host, rs, depl string) {
 	})
 }
 
+func (o *InformersMock) MockPodInZone(name, ns, ip, host, node, zone, region string) {
+	pod := fakePod(name, ns, host)
+	pod.Spec.NodeName = node
+	o.On("PodByIP", ip).Return(pod)
+	o.On("PodNetworkByIP", ip).Return(meta.DefaultNetwork)
+	o.On("NodeByName", node).Return(&corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: node,
+			Labels: map[string]string{
+				corev1.LabelTopologyZone:   zone,
+				corev1.LabelTopologyRegion: region,
+			},
+		},
+	})
+}
+
+func (o *InformersMock) MockNamespace(name string, labels map[string]string) {
+	o.On("NamespaceByName", name).Return(&corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: labels,
+		},
+	})
+}
+
+func (o *InformersMock) MockPodByMAC(name, ns, mac, host, network string) {
+	o.On("PodByMAC", mac).Return(fakePod(name, ns, host))
+	o.On("PodNetworkByMAC", mac).Return(network)
+}
+
+func (o *InformersMock) MockCustomObject(kind, name, ns, ip string) {
+	o.On("PodByIP", ip).Return((*corev1.Pod)(nil))
+	o.On("CustomObjectByIP", ip).Return(&meta.CustomObject{Kind: kind, Name: name, Namespace: ns})
+}
+
 func (o *InformersMock) MockService(name, ns, ip string) {
 	o.On("PodByIP", ip).Return((*corev1.Pod)(nil))
+	o.On("CustomObjectByIP", ip).Return((*meta.CustomObject)(nil))
 	o.On("ServiceByIP", ip).Return(fakeService(name, ns))
 }
 
 func (o *InformersMock) MockNoMatch(ip string) {
 	o.On("PodByIP", ip).Return((*corev1.Pod)(nil))
+	o.On("CustomObjectByIP", ip).Return((*meta.CustomObject)(nil))
 	o.On("ServiceByIP", ip).Return((*corev1.Service)(nil))
 }
@@ -0,0 +1,200 @@
+package mock
+
+import (
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+
+	"github.com/segmentio/kafka-go/protocol"
+	"github.com/segmentio/kafka-go/protocol/apiversions"
+	"github.com/segmentio/kafka-go/protocol/metadata"
+	"github.com/segmentio/kafka-go/protocol/produce"
+)
+
+const kafkaNodeID = 1
+
+// KafkaMessage as received by the KafkaBroker
+type KafkaMessage struct {
+	Topic     string
+	Partition int
+	Key       []byte
+	Value     []byte
+}
+
+// KafkaBroker is an in-process stand-in of a single Kafka broker. It only implements the
+// subset of the protocol that is required by the producers: API versions, metadata and
+// produce requests
+type KafkaBroker struct {
+	// Messages is a buffered channel with the messages that have been produced to the broker
+	Messages   chan KafkaMessage
+	listener   net.Listener
+	topic      string
+	partitions int
+	mt         sync.Mutex
+	offsets    map[int]int64
+	// ProduceError, if not zero, is returned as error code of the produce requests
+	ProduceError int16
+}
+
+// StartKafkaBroker listens in a random local port for Kafka connections. The broker only
+// accepts messages for the given topic, which has the provided number of partitions
+func StartKafkaBroker(topic string, partitions int) (*KafkaBroker, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	b := &KafkaBroker{
+		Messages:   make(chan KafkaMessage, 256),
+		listener:   listener,
+		topic:      topic,
+		partitions: partitions,
+		offsets:    map[int]int64{},
+	}
+	go b.accept()
+	return b, nil
+}
+
+// Addr returns the host:port address of the broker
+func (b *KafkaBroker) Addr() string {
+	return b.listener.Addr().String()
+}
+
+// Close stops listening for connections
+func (b *KafkaBroker) Close() error {
+	return b.listener.Close()
+}
+
+func (b *KafkaBroker) accept() {
+	for {
+		conn, err := b.listener.Accept()
+		if err != nil {
+			return
+		}
+		go b.serve(conn)
+	}
+}
+
+func (b *KafkaBroker) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		apiVersion, correlationID, _, req, err := protocol.ReadRequest(conn)
+		if err != nil {
+			return
+		}
+		var res protocol.Message
+		switch r := req.(type) {
+		case *apiversions.Request:
+			res = apiVersionsResponse()
+		case *metadata.Request:
+			res = b.metadataResponse(r)
+		case *produce.Request:
+			if res, err = b.produce(r); err != nil {
+				return
+			}
+		default:
+			// unsupported request
+			return
+		}
+		if err := protocol.WriteResponse(conn, apiVersion, correlationID, res); err != nil {
+			return
+		}
+	}
+}
+
+func apiVersionsResponse() *apiversions.Response {
+	res := &apiversions.Response{}
+	for _, key := range []protocol.ApiKey{protocol.ApiVersions, protocol.Metadata, protocol.Produce} {
+		res.ApiKeys = append(res.ApiKeys, apiversions.ApiKeyResponse{
+			ApiKey:     int16(key),
+			MinVersion: key.MinVersion(),
+			MaxVersion: key.MaxVersion(),
+		})
+	}
+	return res
+}
+
+func (b *KafkaBroker) metadataResponse(req *metadata.Request) *metadata.Response {
+	host, portStr, _ := net.SplitHostPort(b.Addr())
+	port, _ := strconv.Atoi(portStr)
+	res := &metadata.Response{
+		Brokers:      []metadata.ResponseBroker{{NodeID: kafkaNodeID, Host: host, Port: int32(port)}},
+		ControllerID: kafkaNodeID,
+	}
+	names := req.TopicNames
+	if names == nil {
+		// all the topics are requested
+		names = []string{b.topic}
+	}
+	for _, name := range names {
+		topic := metadata.ResponseTopic{Name: name}
+		if name != b.topic {
+			topic.ErrorCode = int16(3) // UNKNOWN_TOPIC_OR_PARTITION
+		}
+		for p := 0; name == b.topic && p < b.partitions; p++ {
+			topic.Partitions = append(topic.Partitions, metadata.ResponsePartition{
+				PartitionIndex: int32(p),
+				LeaderID:       kafkaNodeID,
+				ReplicaNodes:   []int32{kafkaNodeID},
+				IsrNodes:       []int32{kafkaNodeID},
+			})
+		}
+		res.Topics = append(res.Topics, topic)
+	}
+	return res
+}
+
+func (b *KafkaBroker) produce(req *produce.Request) (*produce.Response, error) {
+	res := &produce.Response{}
+	for _, t := range req.Topics {
+		rt := produce.ResponseTopic{Topic: t.Topic}
+		for _, p := range t.Partitions {
+			rp := produce.ResponsePartition{Partition: p.Partition, ErrorCode: b.ProduceError}
+			msgs, err := readMessages(t.Topic, int(p.Partition), p.RecordSet)
+			if err != nil {
+				return nil, err
+			}
+			if b.ProduceError == 0 {
+				b.mt.Lock()
+				rp.BaseOffset = b.offsets[int(p.Partition)]
+				b.offsets[int(p.Partition)] += int64(len(msgs))
+				b.mt.Unlock()
+				for _, m := range msgs {
+					b.Messages <- m
+				}
+			}
+			rt.Partitions = append(rt.Partitions, rp)
+		}
+		res.Topics = append(res.Topics, rt)
+	}
+	return res, nil
+}
+
+func readMessages(topic string, partition int, rs protocol.RecordSet) ([]KafkaMessage, error) {
+	var msgs []KafkaMessage
+	if rs.Records == nil {
+		return msgs, nil
+	}
+	for {
+		record, err := rs.Records.ReadRecord()
+		if errors.Is(err, io.EOF) {
+			return msgs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		msg := KafkaMessage{Topic: topic, Partition: partition}
+		if record.Key != nil {
+			if msg.Key, err = protocol.ReadAll(record.Key); err != nil {
+				return nil, err
+			}
+		}
+		if record.Value != nil {
+			if msg.Value, err = protocol.ReadAll(record.Value); err != nil {
+				return nil, err
+			}
+		}
+		msgs = append(msgs, msg)
+	}
+}
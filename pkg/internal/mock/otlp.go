@@ -0,0 +1,137 @@
+package mock
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	collogs "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// OTLPRequest is an export request, as received by the OTLPReceiver
+type OTLPRequest struct {
+	Logs *collogs.ExportLogsServiceRequest
+	// Headers of the request, or gRPC metadata, with lowercase keys
+	Headers map[string]string
+}
+
+// OTLPReceiver is an in-process stand-in of an OpenTelemetry collector that accepts OTLP
+// logs through gRPC and HTTP/protobuf
+type OTLPReceiver struct {
+	collogs.UnimplementedLogsServiceServer
+	// Requests is a buffered channel with the export requests that have been accepted
+	Requests chan OTLPRequest
+	grpc     *grpc.Server
+	listener net.Listener
+	http     *httptest.Server
+	mt       sync.Mutex
+	failures int
+}
+
+// StartOTLPReceiver listens in random local ports for gRPC and HTTP export requests
+func StartOTLPReceiver() (*OTLPReceiver, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	r := &OTLPReceiver{
+		Requests: make(chan OTLPRequest, 256),
+		grpc:     grpc.NewServer(),
+		listener: listener,
+	}
+	collogs.RegisterLogsServiceServer(r.grpc, r)
+	go func() {
+		_ = r.grpc.Serve(listener)
+	}()
+	r.http = httptest.NewServer(http.HandlerFunc(r.serveHTTP))
+	return r, nil
+}
+
+// GRPCAddr returns the host:port address of the gRPC service
+func (r *OTLPReceiver) GRPCAddr() string {
+	return r.listener.Addr().String()
+}
+
+// HTTPURL returns the base URL of the HTTP service
+func (r *OTLPReceiver) HTTPURL() string {
+	return r.http.URL
+}
+
+// FailNext makes the receiver reject the next n requests as temporarily unavailable
+func (r *OTLPReceiver) FailNext(n int) {
+	r.mt.Lock()
+	defer r.mt.Unlock()
+	r.failures = n
+}
+
+// Close stops the gRPC and HTTP services
+func (r *OTLPReceiver) Close() {
+	r.grpc.Stop()
+	r.http.Close()
+}
+
+func (r *OTLPReceiver) fail() bool {
+	r.mt.Lock()
+	defer r.mt.Unlock()
+	if r.failures > 0 {
+		r.failures--
+		return true
+	}
+	return false
+}
+
+// Export implements the gRPC logs service
+func (r *OTLPReceiver) Export(ctx context.Context, req *collogs.ExportLogsServiceRequest) (
+	*collogs.ExportLogsServiceResponse, error) {
+	if r.fail() {
+		return nil, status.Error(codes.Unavailable, "try again later")
+	}
+	headers := map[string]string{}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		for k, v := range md {
+			if len(v) > 0 {
+				headers[k] = v[0]
+			}
+		}
+	}
+	r.Requests <- OTLPRequest{Logs: req, Headers: headers}
+	return &collogs.ExportLogsServiceResponse{}, nil
+}
+
+func (r *OTLPReceiver) serveHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path != "/v1/logs" || req.Header.Get("Content-Type") != "application/x-protobuf" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if r.fail() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	logs := &collogs.ExportLogsServiceRequest{}
+	if err := proto.Unmarshal(body, logs); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	headers := map[string]string{}
+	for k := range req.Header {
+		headers[strings.ToLower(k)] = req.Header.Get(k)
+	}
+	r.Requests <- OTLPRequest{Logs: logs, Headers: headers}
+	response, _ := proto.Marshal(&collogs.ExportLogsServiceResponse{})
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	_, _ = w.Write(response)
+}
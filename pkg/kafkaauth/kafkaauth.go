@@ -0,0 +1,51 @@
+// Package kafkaauth builds the TLS and SASL settings that are shared by the Kafka producers
+// and consumers
+package kafkaauth
+
+import (
+	"crypto/tls"
+	"fmt"
+
+	promconf "github.com/prometheus/common/config"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+
+	"github.com/netobserv/goflow2-kube-enricher/pkg/config"
+)
+
+// TLS returns the TLS configuration of the Kafka connections, or nil if TLS is not enabled
+func TLS(cfg *promconf.TLSConfig) (*tls.Config, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	tlsConfig, err := promconf.NewTLSConfig(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating TLS config: %w", err)
+	}
+	return tlsConfig, nil
+}
+
+// SASL returns the SASL mechanism of the Kafka connections, or nil if SASL is not enabled
+func SASL(cfg *config.KafkaSASLConfig) (sasl.Mechanism, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	var mechanism sasl.Mechanism
+	var err error
+	username, password := cfg.Username, string(cfg.Password)
+	switch cfg.Mechanism {
+	case "plain":
+		mechanism = plain.Mechanism{Username: username, Password: password}
+	case "scram-sha-256":
+		mechanism, err = scram.Mechanism(scram.SHA256, username, password)
+	case "scram-sha-512":
+		mechanism, err = scram.Mechanism(scram.SHA512, username, password)
+	default:
+		err = fmt.Errorf("unknown SASL mechanism: %q", cfg.Mechanism)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("creating SASL mechanism: %w", err)
+	}
+	return mechanism, nil
+}
@@ -0,0 +1,149 @@
+package meta
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/jsonpath"
+
+	"github.com/netobserv/goflow2-kube-enricher/pkg/config"
+)
+
+// CustomObject is the minimal representation of any custom resource object owning an IP
+type CustomObject struct {
+	Kind      string `json:"kind"`
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+}
+
+// customIndex provides the objects of a given custom resource, indexed by their IPs
+type customIndex struct {
+	resource string
+	indexer  cache.Indexer
+	// object converts the indexed items into CustomObjects
+	object func(item interface{}) *CustomObject
+}
+
+// newCustomInformer watches the objects of a given custom resource, indexing them by the IPs
+// that are extracted from the configured JSONPath
+func newCustomInformer(factory dynamicinformer.DynamicSharedInformerFactory, cfg *config.CustomResourceConfig) (*customIndex, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	gvr := schema.GroupVersionResource{Group: cfg.Group, Version: cfg.Version, Resource: cfg.Resource}
+	ips, err := parseJSONPath("ips", cfg.IPs)
+	if err != nil {
+		return nil, err
+	}
+	name, err := parseJSONPath("name", cfg.Name)
+	if err != nil {
+		return nil, err
+	}
+	namespace, err := parseJSONPath("namespace", cfg.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	kind, err := parseJSONPath("kind", cfg.Kind)
+	if err != nil {
+		return nil, err
+	}
+	informer := factory.ForResource(gvr).Informer()
+	if err := informer.AddIndexers(map[string]cache.IndexFunc{
+		IndexIP: func(obj interface{}) ([]string, error) {
+			u, ok := obj.(*unstructured.Unstructured)
+			if !ok {
+				return nil, nil
+			}
+			values := ips.values(u.Object)
+			for i, ip := range values {
+				// some resources report the addresses in CIDR notation
+				values[i] = strings.SplitN(ip, "/", 2)[0]
+			}
+			return values, nil
+		},
+	}); err != nil {
+		return nil, err
+	}
+	return &customIndex{
+		resource: gvr.String(),
+		indexer:  informer.GetIndexer(),
+		object: func(item interface{}) *CustomObject {
+			u := item.(*unstructured.Unstructured)
+			return &CustomObject{
+				Kind:      first(kind.values(u.Object)),
+				Name:      first(name.values(u.Object)),
+				Namespace: first(namespace.values(u.Object)),
+			}
+		},
+	}, nil
+}
+
+func (ci *customIndex) byIP(ip string) *CustomObject {
+	item, err := ci.indexer.ByIndex(IndexIP, ip)
+	if err != nil {
+		// should never happen as long as we provide the correct index function
+		// otherwise it's a bug in our code
+		panic(err)
+	}
+	if len(item) == 0 {
+		return nil
+	}
+	if len(item) > 1 {
+		ilog.WithFields(logrus.Fields{
+			"ip":       ip,
+			"resource": ci.resource,
+			"results":  len(item),
+		}).Warn("multiple objects for a single IP. Returning the first object and ignoring the rest")
+	}
+	return ci.object(item[0])
+}
+
+// jsonPath wraps a parsed JSONPath expression, which is not safe for concurrent use
+type jsonPath struct {
+	mt sync.Mutex
+	jp *jsonpath.JSONPath
+}
+
+func parseJSONPath(name, expr string) (*jsonPath, error) {
+	jp := jsonpath.New(name).AllowMissingKeys(true)
+	if err := jp.Parse(expr); err != nil {
+		return nil, fmt.Errorf("invalid %s JSONPath %q: %w", name, expr, err)
+	}
+	return &jsonPath{jp: jp}, nil
+}
+
+// values returns the string representation of all the non-empty values found in the object
+func (p *jsonPath) values(obj map[string]interface{}) []string {
+	p.mt.Lock()
+	results, err := p.jp.FindResults(obj)
+	p.mt.Unlock()
+	if err != nil {
+		ilog.WithError(err).Debug("can't evaluate JSONPath")
+		return nil
+	}
+	var values []string
+	for _, result := range results {
+		for _, v := range result {
+			if !v.IsValid() || !v.CanInterface() || v.Interface() == nil {
+				continue
+			}
+			if s := fmt.Sprint(v.Interface()); s != "" {
+				values = append(values, s)
+			}
+		}
+	}
+	return values
+}
+
+func first(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
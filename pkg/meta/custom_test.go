@@ -0,0 +1,73 @@
+package meta
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/netobserv/goflow2-kube-enricher/pkg/config"
+)
+
+func TestInformers_CustomResources(t *testing.T) {
+	vmi := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "kubevirt.io/v1",
+		"kind":       "VirtualMachineInstance",
+		"metadata": map[string]interface{}{
+			"name":      "my-vm",
+			"namespace": "vms",
+		},
+		"status": map[string]interface{}{
+			"interfaces": []interface{}{
+				map[string]interface{}{"name": "default", "ipAddress": "10.244.1.8"},
+				map[string]interface{}{"name": "secondary", "ipAddress": "192.168.0.4/24"},
+			},
+		},
+	}}
+	dynClient := dynfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{
+			{Group: "kubevirt.io", Version: "v1", Resource: "virtualmachineinstances"}: "VirtualMachineInstanceList",
+		}, vmi)
+	cfg := config.Default()
+	cfg.CustomResources = []config.CustomResourceConfig{{
+		Group:    "kubevirt.io",
+		Version:  "v1",
+		Resource: "virtualmachineinstances",
+		IPs:      "{.status.interfaces[*].ipAddress}",
+	}}
+
+	informers, err := NewInformers(fake.NewSimpleClientset(), dynClient, cfg)
+	require.NoError(t, err)
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	require.NoError(t, informers.Start(stopCh))
+	informers.WaitForCacheSync(stopCh)
+
+	for _, ip := range []string{"10.244.1.8", "192.168.0.4"} {
+		obj := informers.CustomObjectByIP(ip)
+		require.NotNil(t, obj, ip)
+		assert.Equal(t, CustomObject{Kind: "VirtualMachineInstance", Name: "my-vm", Namespace: "vms"}, *obj)
+	}
+	assert.Nil(t, informers.CustomObjectByIP("10.244.1.9"))
+}
+
+func TestInformers_CustomResourcesErrors(t *testing.T) {
+	cfg := config.Default()
+	cfg.CustomResources = []config.CustomResourceConfig{{Version: "v1", Resource: "foos", IPs: "{.status.ip}"}}
+	_, err := NewInformers(fake.NewSimpleClientset(), nil, cfg)
+	assert.Error(t, err, "a dynamic client should be required")
+
+	dynClient := dynfake.NewSimpleDynamicClient(runtime.NewScheme())
+	cfg.CustomResources = []config.CustomResourceConfig{{Version: "v1", Resource: "foos"}}
+	_, err = NewInformers(fake.NewSimpleClientset(), dynClient, cfg)
+	assert.Error(t, err, "ips should be required")
+
+	cfg.CustomResources = []config.CustomResourceConfig{{Version: "v1", Resource: "foos", IPs: "{.status.ip"}}
+	_, err = NewInformers(fake.NewSimpleClientset(), dynClient, cfg)
+	assert.Error(t, err, "JSONPath should be valid")
+}
@@ -0,0 +1,172 @@
+package meta
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Inventory provides access to the kubernetes objects from a static set of YAML or JSON
+// manifests (e.g. the output of 'kubectl get -o yaml'), so the flows can be enriched
+// without any connection to the API server
+type Inventory struct {
+	stores
+}
+
+// LoadInventory loads the Pods, Services, ReplicaSets, Nodes and Namespaces from the given files. If any
+// path is a directory, all the .yaml, .yml and .json files there are loaded. Any other kind of
+// object is ignored
+func LoadInventory(paths []string) (*Inventory, error) {
+	inv := newInventory()
+	files, err := manifestFiles(paths)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		if err := inv.loadFile(file); err != nil {
+			return nil, fmt.Errorf("loading inventory file %s: %w", file, err)
+		}
+	}
+	if len(inv.pods.ListKeys())+len(inv.services.ListKeys()) == 0 {
+		return nil, errors.New("no Pods nor Services found in the inventory")
+	}
+	return inv, nil
+}
+
+func newInventory() *Inventory {
+	return &Inventory{stores: stores{
+		pods:        cache.NewIndexer(cache.MetaNamespaceKeyFunc, podIndexers),
+		services:    cache.NewIndexer(cache.MetaNamespaceKeyFunc, serviceIndexers),
+		replicaSets: cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{}),
+		nodes:       cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{}),
+		namespaces:  cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{}),
+		networks:    newPodNetworks(),
+	}}
+}
+
+func manifestFiles(paths []string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		entries, err := ioutil.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			switch strings.ToLower(filepath.Ext(entry.Name())) {
+			case ".yaml", ".yml", ".json":
+				if !entry.IsDir() {
+					files = append(files, filepath.Join(path, entry.Name()))
+				}
+			}
+		}
+	}
+	return files, nil
+}
+
+func (inv *Inventory) loadFile(path string) error {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	decoder := yaml.NewYAMLOrJSONDecoder(bytes.NewReader(content), 4096)
+	for {
+		raw := runtime.RawExtension{}
+		if err := decoder.Decode(&raw); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		if len(bytes.TrimSpace(raw.Raw)) == 0 || bytes.Equal(bytes.TrimSpace(raw.Raw), []byte("null")) {
+			// empty YAML document
+			continue
+		}
+		if err := inv.addRaw(raw.Raw); err != nil {
+			return err
+		}
+	}
+}
+
+func (inv *Inventory) addRaw(raw []byte) error {
+	obj, gvk, err := scheme.Codecs.UniversalDeserializer().Decode(raw, nil, nil)
+	if err != nil {
+		if runtime.IsNotRegisteredError(err) {
+			ilog.WithError(err).Debug("ignoring unknown object kind in inventory")
+			return nil
+		}
+		return err
+	}
+	switch o := obj.(type) {
+	case *corev1.List:
+		for i := range o.Items {
+			if err := inv.addRaw(o.Items[i].Raw); err != nil {
+				return err
+			}
+		}
+	case *corev1.PodList:
+		for i := range o.Items {
+			if err := inv.addPod(&o.Items[i]); err != nil {
+				return err
+			}
+		}
+	case *corev1.ServiceList:
+		for i := range o.Items {
+			if err := inv.services.Add(&o.Items[i]); err != nil {
+				return err
+			}
+		}
+	case *corev1.NodeList:
+		for i := range o.Items {
+			if err := inv.nodes.Add(&o.Items[i]); err != nil {
+				return err
+			}
+		}
+	case *corev1.NamespaceList:
+		for i := range o.Items {
+			if err := inv.namespaces.Add(&o.Items[i]); err != nil {
+				return err
+			}
+		}
+	case *appsv1.ReplicaSetList:
+		for i := range o.Items {
+			if err := inv.replicaSets.Add(&o.Items[i]); err != nil {
+				return err
+			}
+		}
+	case *corev1.Pod:
+		return inv.addPod(o)
+	case *corev1.Service:
+		return inv.services.Add(o)
+	case *corev1.Node:
+		return inv.nodes.Add(o)
+	case *corev1.Namespace:
+		return inv.namespaces.Add(o)
+	case *appsv1.ReplicaSet:
+		return inv.replicaSets.Add(o)
+	default:
+		ilog.WithFields(logrus.Fields{"kind": gvk.String()}).
+			Debug("ignoring unsupported object kind in inventory")
+	}
+	return nil
+}
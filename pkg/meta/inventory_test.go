@@ -0,0 +1,134 @@
+package meta
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// as returned by 'kubectl get pods,replicasets -o yaml'
+const kubectlList = `
+apiVersion: v1
+kind: List
+items:
+- apiVersion: v1
+  kind: Pod
+  metadata:
+    name: front-5c8d7-abcde
+    namespace: shop
+    ownerReferences:
+    - apiVersion: apps/v1
+      kind: ReplicaSet
+      name: front-5c8d7
+  spec:
+    nodeName: node-1
+  status:
+    hostIP: 10.0.0.1
+    podIP: 10.244.0.5
+    podIPs:
+    - ip: 10.244.0.5
+- apiVersion: apps/v1
+  kind: ReplicaSet
+  metadata:
+    name: front-5c8d7
+    namespace: shop
+    ownerReferences:
+    - apiVersion: apps/v1
+      kind: Deployment
+      name: front
+`
+
+const multiDocument = `
+apiVersion: v1
+kind: Service
+metadata:
+  name: front
+  namespace: shop
+spec:
+  clusterIP: 10.96.0.10
+  clusterIPs:
+  - 10.96.0.10
+---
+apiVersion: v1
+kind: Node
+metadata:
+  name: node-1
+  labels:
+    topology.kubernetes.io/zone: eu-1a
+---
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: shop
+  labels:
+    team: retail
+---
+apiVersion: kubevirt.io/v1
+kind: VirtualMachineInstance
+metadata:
+  name: ignored
+---
+`
+
+const jsonPod = `{
+  "apiVersion": "v1",
+  "kind": "Pod",
+  "metadata": {"name": "db", "namespace": "shop"},
+  "status": {"hostIP": "10.0.0.2", "podIP": "10.244.1.3", "podIPs": [{"ip": "10.244.1.3"}]}
+}`
+
+func TestLoadInventory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inventory_")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "list.yaml"), []byte(kubectlList), 0600))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "multi.yml"), []byte(multiDocument), 0600))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "ignored.txt"), []byte("not a manifest"), 0600))
+	podFile := filepath.Join(os.TempDir(), "inventory_pod.json")
+	require.NoError(t, ioutil.WriteFile(podFile, []byte(jsonPod), 0600))
+	defer os.Remove(podFile)
+
+	inv, err := LoadInventory([]string{dir, podFile})
+	require.NoError(t, err)
+
+	pod := inv.PodByIP("10.244.0.5")
+	require.NotNil(t, pod)
+	assert.Equal(t, "front-5c8d7-abcde", pod.Name)
+	rs := inv.ReplicaSet("shop", "front-5c8d7")
+	require.NotNil(t, rs)
+	assert.Equal(t, "front", rs.OwnerReferences[0].Name)
+	node := inv.NodeByName("node-1")
+	require.NotNil(t, node)
+	assert.Equal(t, "eu-1a", node.Labels["topology.kubernetes.io/zone"])
+	ns := inv.NamespaceByName("shop")
+	require.NotNil(t, ns)
+	assert.Equal(t, "retail", ns.Labels["team"])
+	svc := inv.ServiceByIP("10.96.0.10")
+	require.NotNil(t, svc)
+	assert.Equal(t, "front", svc.Name)
+	pod = inv.PodByIP("10.244.1.3")
+	require.NotNil(t, pod)
+	assert.Equal(t, "db", pod.Name)
+
+	assert.Nil(t, inv.PodByIP("10.0.0.1"))
+	assert.Nil(t, inv.CustomObjectByIP("10.244.0.5"))
+}
+
+func TestLoadInventory_Errors(t *testing.T) {
+	_, err := LoadInventory([]string{"/non/existing/file.yaml"})
+	assert.Error(t, err)
+
+	dir, err := ioutil.TempDir("", "inventory_")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	_, err = LoadInventory([]string{dir})
+	assert.Error(t, err, "an empty inventory should fail")
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "broken.yaml"), []byte("kind: [Pod"), 0600))
+	_, err = LoadInventory([]string{dir})
+	assert.Error(t, err)
+}
@@ -2,6 +2,7 @@
 package meta
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"strings"
@@ -10,9 +11,13 @@ import (
 	"github.com/sirupsen/logrus"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/cache"
+
+	"github.com/netobserv/goflow2-kube-enricher/pkg/config"
 )
 
 const (
@@ -20,6 +25,7 @@ const (
 	// that are composed as namespace/name
 	NamespaceSeparator = "/"
 	IndexIP            = "IP"
+	IndexMAC           = "MAC"
 )
 
 var ilog = logrus.WithFields(logrus.Fields{
@@ -28,70 +34,175 @@ var ilog = logrus.WithFields(logrus.Fields{
 
 type InformersInterface interface {
 	PodByIP(ip string) *corev1.Pod
+	PodByMAC(mac string) *corev1.Pod
+	PodNetworkByIP(ip string) string
+	PodNetworkByMAC(mac string) string
 	ServiceByIP(ip string) *corev1.Service
 	ReplicaSet(namespace, name string) *appsv1.ReplicaSet
+	NodeByName(name string) *corev1.Node
+	NamespaceByName(name string) *corev1.Namespace
+	CustomObjectByIP(ip string) *CustomObject
 }
 
 type Informers struct {
-	InformersInterface
+	stores
 	informerFactory informers.SharedInformerFactory
-	pods            cache.SharedIndexInformer
-	services        cache.SharedIndexInformer
-	replicaSet      cache.SharedIndexInformer
+	dynamicFactory  dynamicinformer.DynamicSharedInformerFactory
+}
+
+// stores groups the indexed caches where the kubernetes objects are looked up, whether they
+// are populated by the informers or from a static inventory
+type stores struct {
+	pods        cache.Indexer
+	services    cache.Indexer
+	replicaSets cache.Indexer
+	nodes       cache.Indexer
+	namespaces  cache.Indexer
+	custom      []*customIndex
+	networks    *podNetworks
+}
+
+var podIndexers = cache.Indexers{
+	IndexIP: func(obj interface{}) ([]string, error) {
+		pod := obj.(*corev1.Pod)
+		ips := make([]string, 0, len(pod.Status.PodIPs))
+		for _, ip := range pod.Status.PodIPs {
+			// ignoring host-networked Pod IPs
+			if ip.IP != pod.Status.HostIP {
+				ips = append(ips, ip.IP)
+			}
+		}
+		// IPs from secondary networks (e.g. Multus, OVN)
+		for _, iface := range podInterfaces(pod) {
+			for _, ip := range iface.ips {
+				if ip != pod.Status.HostIP {
+					ips = append(ips, ip)
+				}
+			}
+		}
+		return ips, nil
+	},
+	IndexMAC: func(obj interface{}) ([]string, error) {
+		ifaces := podInterfaces(obj.(*corev1.Pod))
+		macs := make([]string, 0, len(ifaces))
+		for _, iface := range ifaces {
+			if iface.mac != "" {
+				macs = append(macs, iface.mac)
+			}
+		}
+		return macs, nil
+	},
+}
+
+var serviceIndexers = cache.Indexers{
+	IndexIP: func(obj interface{}) ([]string, error) {
+		spec := obj.(*corev1.Service).Spec
+		if spec.ClusterIP == corev1.ClusterIPNone {
+			return []string{}, nil
+		}
+		return spec.ClusterIPs, nil
+	},
 }
 
-func NewInformers(client kubernetes.Interface) Informers {
+// NewInformers creates the informers for Pods, Services and ReplicaSets. Depending on the
+// configuration, it also creates informers for Nodes, Namespaces and the configured custom
+// resources
+func NewInformers(client kubernetes.Interface, dynClient dynamic.Interface, cfg *config.Config) (Informers, error) {
 	// TODO: configure resync time
 	factory := informers.NewSharedInformerFactory(client, 1*time.Hour)
 	pods := factory.Core().V1().Pods().Informer()
-	if err := pods.AddIndexers(map[string]cache.IndexFunc{
-		IndexIP: func(obj interface{}) ([]string, error) {
-			pod := obj.(*corev1.Pod)
-			ips := make([]string, 0, len(pod.Status.PodIPs))
-			for _, ip := range pod.Status.PodIPs {
-				// ignoring host-networked Pod IPs
-				if ip.IP != pod.Status.HostIP {
-					ips = append(ips, ip.IP)
-				}
-			}
-			return ips, nil
-		},
-	}); err != nil {
+	if err := pods.AddIndexers(podIndexers); err != nil {
 		// this should never happen, as it only returns error if the informer has
 		// been alrady started
 		panic(err)
 	}
+	networks := newPodNetworks()
+	pods.AddEventHandler(networks.eventHandler())
 	services := factory.Core().V1().Services().Informer()
-	if err := services.AddIndexers(map[string]cache.IndexFunc{
-		IndexIP: func(obj interface{}) ([]string, error) {
-			spec := obj.(*corev1.Service).Spec
-			if spec.ClusterIP == corev1.ClusterIPNone {
-				return []string{}, nil
-			}
-			return spec.ClusterIPs, nil
-		},
-	}); err != nil {
+	if err := services.AddIndexers(serviceIndexers); err != nil {
 		panic(err)
 	}
-	return Informers{
+	inf := Informers{
 		informerFactory: factory,
-		pods:            pods,
-		services:        services,
-		replicaSet:      factory.Apps().V1().ReplicaSets().Informer(),
+		stores: stores{
+			pods:        pods.GetIndexer(),
+			services:    services.GetIndexer(),
+			replicaSets: factory.Apps().V1().ReplicaSets().Informer().GetIndexer(),
+			networks:    networks,
+		},
+	}
+	if cfg.ZoneMetrics.Enabled {
+		inf.nodes = factory.Core().V1().Nodes().Informer().GetIndexer()
+	}
+	if len(cfg.NamespaceLabels) > 0 {
+		inf.namespaces = factory.Core().V1().Namespaces().Informer().GetIndexer()
+	}
+	if len(cfg.CustomResources) > 0 {
+		if dynClient == nil {
+			return inf, errors.New("a dynamic client is required to watch custom resources")
+		}
+		inf.dynamicFactory = dynamicinformer.NewDynamicSharedInformerFactory(dynClient, 1*time.Hour)
+		for i := range cfg.CustomResources {
+			ci, err := newCustomInformer(inf.dynamicFactory, &cfg.CustomResources[i])
+			if err != nil {
+				return inf, err
+			}
+			inf.custom = append(inf.custom, ci)
+		}
 	}
+	return inf, nil
 }
 
 func (s *Informers) Start(stopCh <-chan struct{}) error {
 	s.informerFactory.Start(stopCh)
+	if s.dynamicFactory != nil {
+		s.dynamicFactory.Start(stopCh)
+	}
 	return nil
 }
 
 func (s *Informers) WaitForCacheSync(stopCh <-chan struct{}) {
 	s.informerFactory.WaitForCacheSync(stopCh)
+	if s.dynamicFactory != nil {
+		s.dynamicFactory.WaitForCacheSync(stopCh)
+	}
+}
+
+func (s *stores) PodByIP(ip string) *corev1.Pod {
+	return s.podByIndex(IndexIP, ip)
+}
+
+// PodByMAC returns the Pod having the given MAC in any of its networks, as reported by the
+// Multus and OVN-Kubernetes annotations
+func (s *stores) PodByMAC(mac string) *corev1.Pod {
+	mac = normalizeMAC(mac)
+	if mac == "" {
+		return nil
+	}
+	return s.podByIndex(IndexMAC, mac)
+}
+
+// PodNetworkByIP returns the name of the network where a Pod has the given IP
+func (s *stores) PodNetworkByIP(ip string) string {
+	return s.networks.networkByIP(ip)
+}
+
+// PodNetworkByMAC returns the name of the network where a Pod has the given MAC
+func (s *stores) PodNetworkByMAC(mac string) string {
+	return s.networks.networkByMAC(mac)
 }
 
-func (s *Informers) PodByIP(ip string) *corev1.Pod {
-	item, err := s.pods.GetIndexer().ByIndex(IndexIP, ip)
+// addPod adds a Pod to the store and indexes its networks
+func (s *stores) addPod(pod *corev1.Pod) error {
+	if err := s.pods.Add(pod); err != nil {
+		return err
+	}
+	s.networks.add(pod)
+	return nil
+}
+
+func (s *stores) podByIndex(index, value string) *corev1.Pod {
+	item, err := s.pods.ByIndex(index, value)
 	if err != nil {
 		// should never happen as long as we provide the correct index function
 		// otherwise it's a bug in our code
@@ -105,15 +216,16 @@ func (s *Informers) PodByIP(ip string) *corev1.Pod {
 	// since we are excluding host-networked pods, the relation IP:Pod should be 1:1.
 	if len(item) > 1 {
 		ilog.WithFields(logrus.Fields{
-			"ip":      ip,
+			"index":   index,
+			"value":   value,
 			"results": len(item),
-		}).Warn("multiple pods for a single IP. Returning the first pod and ignoring the rest")
+		}).Warn("multiple pods for a single address. Returning the first pod and ignoring the rest")
 	}
 	return item[0].(*corev1.Pod)
 }
 
-func (s *Informers) ServiceByIP(ip string) *corev1.Service {
-	item, err := s.services.GetIndexer().ByIndex(IndexIP, ip)
+func (s *stores) ServiceByIP(ip string) *corev1.Service {
+	item, err := s.services.ByIndex(IndexIP, ip)
 	if err != nil {
 		// should never happen as long as we provide the correct index function
 		// otherwise it's a bug in our code
@@ -133,8 +245,8 @@ func (s *Informers) ServiceByIP(ip string) *corev1.Service {
 	return item[0].(*corev1.Service)
 }
 
-func (s *Informers) ReplicaSet(namespace, name string) *appsv1.ReplicaSet {
-	item, ok, err := s.replicaSet.GetIndexer().GetByKey(namespace + NamespaceSeparator + name)
+func (s *stores) ReplicaSet(namespace, name string) *appsv1.ReplicaSet {
+	item, ok, err := s.replicaSets.GetByKey(namespace + NamespaceSeparator + name)
 	if err != nil {
 		// should never happen. Otherwise it's a bug in our code
 		panic(err)
@@ -145,31 +257,97 @@ func (s *Informers) ReplicaSet(namespace, name string) *appsv1.ReplicaSet {
 	return item.(*appsv1.ReplicaSet)
 }
 
-func (s *Informers) DebugInfo(out io.Writer) {
+// NodeByName returns the Node with the given name, or nil if it is not found or the Nodes
+// are not being watched
+func (s *stores) NodeByName(name string) *corev1.Node {
+	if s.nodes == nil {
+		return nil
+	}
+	item, ok, err := s.nodes.GetByKey(name)
+	if err != nil {
+		// should never happen. Otherwise it's a bug in our code
+		panic(err)
+	}
+	if !ok {
+		return nil
+	}
+	return item.(*corev1.Node)
+}
+
+// NamespaceByName returns the Namespace with the given name, or nil if it is not found or the
+// Namespaces are not being watched
+func (s *stores) NamespaceByName(name string) *corev1.Namespace {
+	if s.namespaces == nil {
+		return nil
+	}
+	item, ok, err := s.namespaces.GetByKey(name)
+	if err != nil {
+		// should never happen. Otherwise it's a bug in our code
+		panic(err)
+	}
+	if !ok {
+		return nil
+	}
+	return item.(*corev1.Namespace)
+}
+
+// CustomObjectByIP returns the first object owning the given IP, from the configured custom
+// resources, in the same order as they are configured
+func (s *stores) CustomObjectByIP(ip string) *CustomObject {
+	for _, ci := range s.custom {
+		if obj := ci.byIP(ip); obj != nil {
+			return obj
+		}
+	}
+	return nil
+}
+
+func (s *stores) DebugInfo(out io.Writer) {
 	fmt.Fprintln(out, "==== Services")
-	for _, svc := range s.services.GetStore().ListKeys() {
+	for _, svc := range s.services.ListKeys() {
 		fmt.Fprintln(out, "-", svc)
 	}
 	fmt.Fprintln(out, "==== ReplicaSets")
-	for _, rs := range s.replicaSet.GetStore().ListKeys() {
+	for _, rs := range s.replicaSets.ListKeys() {
 		rskeys := strings.Split(rs, NamespaceSeparator)
 		rset := s.ReplicaSet(rskeys[0], rskeys[1])
 		fmt.Fprintln(out, "-", rs, "replicas:", rset.Status.Replicas)
 	}
+	if s.nodes != nil {
+		fmt.Fprintln(out, "==== Nodes")
+		for _, node := range s.nodes.ListKeys() {
+			fmt.Fprintln(out, "-", node)
+		}
+	}
+	if s.namespaces != nil {
+		fmt.Fprintln(out, "==== Namespaces")
+		for _, ns := range s.namespaces.ListKeys() {
+			fmt.Fprintln(out, "-", ns)
+		}
+	}
 	fmt.Fprintln(out, "==== Pods")
-	for _, pod := range s.pods.GetStore().ListKeys() {
+	for _, pod := range s.pods.ListKeys() {
 		fmt.Fprintln(out, "-", pod)
 	}
 	fmt.Fprintln(out, "=== Pods by IP")
-	for _, ip := range s.pods.GetIndexer().ListIndexFuncValues(IndexIP) {
+	for _, ip := range s.pods.ListIndexFuncValues(IndexIP) {
 		pod := s.PodByIP(ip)
-		if pod.Status.PodIP != ip {
-			panic("ips not equal")
+		fmt.Fprintln(out, "-", ip, ":", pod.Name, "network:", s.PodNetworkByIP(ip))
+	}
+	fmt.Fprintln(out, "=== Pods by MAC")
+	for _, mac := range s.pods.ListIndexFuncValues(IndexMAC) {
+		pod := s.PodByMAC(mac)
+		fmt.Fprintln(out, "-", mac, ":", pod.Name, "network:", s.PodNetworkByMAC(mac))
+	}
+	for _, ci := range s.custom {
+		fmt.Fprintln(out, "===", ci.resource, "by IP")
+		for _, ip := range ci.indexer.ListIndexFuncValues(IndexIP) {
+			obj := ci.byIP(ip)
+			fmt.Fprintln(out, "-", ip, ":", obj.Kind, obj.Namespace+NamespaceSeparator+obj.Name)
 		}
-		fmt.Fprintln(out, "-", ip, ":", pod.Name)
 	}
 	fmt.Fprintln(out, "=== Services by IP")
-	for _, ip := range s.services.GetIndexer().ListIndexFuncValues(IndexIP) {
+	for _, ip := range s.services.ListIndexFuncValues(IndexIP) {
 		svc := s.ServiceByIP(ip)
 		if svc != nil {
 			if svc.Spec.ClusterIP != ip {
@@ -0,0 +1,231 @@
+package meta
+
+import (
+	"encoding/json"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// DefaultNetwork is the name given to the primary network of the pods
+	DefaultNetwork = "default"
+
+	// AnnotationNetworkStatus is set by Multus with the status of all the pod networks
+	AnnotationNetworkStatus = "k8s.v1.cni.cncf.io/network-status"
+	// AnnotationOVNPodNetworks is set by OVN-Kubernetes with the addresses of all the pod networks
+	AnnotationOVNPodNetworks = "k8s.ovn.org/pod-networks"
+)
+
+// podInterface is an IP/MAC attachment of a Pod to a given network
+type podInterface struct {
+	network string
+	ips     []string
+	mac     string
+}
+
+// networkStatus is an entry of the k8s.v1.cni.cncf.io/network-status annotation
+type networkStatus struct {
+	Name    string   `json:"name"`
+	IPs     []string `json:"ips"`
+	MAC     string   `json:"mac"`
+	Default bool     `json:"default"`
+}
+
+// ovnPodNetwork is an entry of the k8s.ovn.org/pod-networks annotation
+type ovnPodNetwork struct {
+	IPAddresses []string `json:"ip_addresses"`
+	MACAddress  string   `json:"mac_address"`
+}
+
+// podInterfaces returns all the network attachments of a Pod, as they are reported by the
+// Multus and OVN-Kubernetes annotations
+func podInterfaces(pod *corev1.Pod) []podInterface {
+	var ifaces []podInterface
+	if ann, ok := pod.Annotations[AnnotationNetworkStatus]; ok {
+		var statuses []networkStatus
+		if err := json.Unmarshal([]byte(ann), &statuses); err != nil {
+			ilog.WithError(err).WithFields(logrus.Fields{
+				"pod": pod.Namespace + NamespaceSeparator + pod.Name,
+			}).Debug("can't parse " + AnnotationNetworkStatus + " annotation")
+		}
+		for _, st := range statuses {
+			network := st.Name
+			if st.Default {
+				network = DefaultNetwork
+			}
+			ifaces = append(ifaces, podInterface{
+				network: network,
+				ips:     st.IPs,
+				mac:     normalizeMAC(st.MAC),
+			})
+		}
+	}
+	if ann, ok := pod.Annotations[AnnotationOVNPodNetworks]; ok {
+		var networks map[string]ovnPodNetwork
+		if err := json.Unmarshal([]byte(ann), &networks); err != nil {
+			ilog.WithError(err).WithFields(logrus.Fields{
+				"pod": pod.Namespace + NamespaceSeparator + pod.Name,
+			}).Debug("can't parse " + AnnotationOVNPodNetworks + " annotation")
+		}
+		for name, pn := range networks {
+			ips := make([]string, 0, len(pn.IPAddresses))
+			for _, addr := range pn.IPAddresses {
+				// OVN reports the addresses in CIDR notation
+				ips = append(ips, strings.SplitN(addr, "/", 2)[0])
+			}
+			ifaces = append(ifaces, podInterface{
+				network: name,
+				ips:     ips,
+				mac:     normalizeMAC(pn.MACAddress),
+			})
+		}
+	}
+	return ifaces
+}
+
+// podNetworks indexes by IP and MAC the networks where the Pods are attached, so the Multus
+// and OVN-Kubernetes annotations are parsed when a Pod is indexed instead of for each record
+type podNetworks struct {
+	mu    sync.RWMutex
+	byIP  map[string]podNetwork
+	byMAC map[string]podNetwork
+	// addresses of each Pod, by Pod key, to unindex them when the Pod is updated or deleted
+	addresses map[string]podAddresses
+}
+
+// podNetwork is the network of an address, with the key of the Pod that owns the address
+type podNetwork struct {
+	pod     string
+	network string
+}
+
+type podAddresses struct {
+	ips  []string
+	macs []string
+}
+
+func newPodNetworks() *podNetworks {
+	return &podNetworks{
+		byIP:      map[string]podNetwork{},
+		byMAC:     map[string]podNetwork{},
+		addresses: map[string]podAddresses{},
+	}
+}
+
+// eventHandler keeps the index up to date with the Pods that are received by an informer
+func (n *podNetworks) eventHandler() cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if pod, ok := obj.(*corev1.Pod); ok {
+				n.add(pod)
+			}
+		},
+		UpdateFunc: func(_, obj interface{}) {
+			if pod, ok := obj.(*corev1.Pod); ok {
+				n.add(pod)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				n.remove(tombstone.Key)
+			} else if pod, ok := obj.(*corev1.Pod); ok {
+				n.remove(pod.Namespace + NamespaceSeparator + pod.Name)
+			}
+		},
+	}
+}
+
+// add indexes the addresses of the given Pod, replacing the ones it was previously indexed with
+func (n *podNetworks) add(pod *corev1.Pod) {
+	key := pod.Namespace + NamespaceSeparator + pod.Name
+	ifaces := podInterfaces(pod)
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.removeLocked(key)
+	var addrs podAddresses
+	for _, ip := range pod.Status.PodIPs {
+		n.byIP[ip.IP] = podNetwork{pod: key, network: DefaultNetwork}
+		addrs.ips = append(addrs.ips, ip.IP)
+	}
+	for _, iface := range ifaces {
+		for _, ip := range iface.ips {
+			// the primary IPs of the Pod always belong to the default network
+			if current, ok := n.byIP[ip]; ok && current.pod == key {
+				continue
+			}
+			n.byIP[ip] = podNetwork{pod: key, network: iface.network}
+			addrs.ips = append(addrs.ips, ip)
+		}
+		if iface.mac != "" {
+			n.byMAC[iface.mac] = podNetwork{pod: key, network: iface.network}
+			addrs.macs = append(addrs.macs, iface.mac)
+		}
+	}
+	n.addresses[key] = addrs
+}
+
+// remove unindexes the addresses of the Pod with the given key
+func (n *podNetworks) remove(key string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.removeLocked(key)
+}
+
+func (n *podNetworks) removeLocked(key string) {
+	addrs, ok := n.addresses[key]
+	if !ok {
+		return
+	}
+	// an address could have been taken by another Pod in the meantime
+	for _, ip := range addrs.ips {
+		if n.byIP[ip].pod == key {
+			delete(n.byIP, ip)
+		}
+	}
+	for _, mac := range addrs.macs {
+		if n.byMAC[mac].pod == key {
+			delete(n.byMAC, mac)
+		}
+	}
+	delete(n.addresses, key)
+}
+
+// networkByIP returns the name of the network where a Pod has the given IP
+func (n *podNetworks) networkByIP(ip string) string {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	if pn, ok := n.byIP[ip]; ok {
+		return pn.network
+	}
+	return DefaultNetwork
+}
+
+// networkByMAC returns the name of the network where a Pod has the given MAC
+func (n *podNetworks) networkByMAC(mac string) string {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	if pn, ok := n.byMAC[normalizeMAC(mac)]; ok {
+		return pn.network
+	}
+	return DefaultNetwork
+}
+
+// normalizeMAC returns the MAC in the same format as goflow2 renders it, or an empty string
+// if the MAC is invalid or zero
+func normalizeMAC(mac string) string {
+	hw, err := net.ParseMAC(mac)
+	if err != nil {
+		return ""
+	}
+	for _, b := range hw {
+		if b != 0 {
+			return hw.String()
+		}
+	}
+	return ""
+}
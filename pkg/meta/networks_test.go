@@ -0,0 +1,106 @@
+package meta
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/netobserv/goflow2-kube-enricher/pkg/config"
+)
+
+var multiNetworkPod = &corev1.Pod{
+	ObjectMeta: metav1.ObjectMeta{
+		Name:      "multi",
+		Namespace: "default",
+		Annotations: map[string]string{
+			AnnotationNetworkStatus: `[
+				{"name":"ovn-kubernetes","interface":"eth0","ips":["10.244.0.5"],"mac":"0a:58:0a:f4:00:05","default":true},
+				{"name":"default/macvlan","interface":"net1","ips":["192.168.1.10"],"mac":"AA:BB:CC:DD:EE:01"}
+			]`,
+			AnnotationOVNPodNetworks: `{
+				"default":{"ip_addresses":["10.244.0.5/24"],"mac_address":"0a:58:0a:f4:00:05"},
+				"default/l2net":{"ip_addresses":["172.16.0.3/16"],"mac_address":"0a:58:ac:10:00:03"}
+			}`,
+		},
+	},
+	Status: corev1.PodStatus{
+		HostIP: "10.0.0.1",
+		PodIP:  "10.244.0.5",
+		PodIPs: []corev1.PodIP{{IP: "10.244.0.5"}},
+	},
+}
+
+func TestPodNetworks(t *testing.T) {
+	networks := newPodNetworks()
+	networks.add(multiNetworkPod)
+
+	assert.Equal(t, DefaultNetwork, networks.networkByIP("10.244.0.5"))
+	assert.Equal(t, "default/macvlan", networks.networkByIP("192.168.1.10"))
+	assert.Equal(t, "default/l2net", networks.networkByIP("172.16.0.3"))
+
+	assert.Equal(t, DefaultNetwork, networks.networkByMAC("0a:58:0a:f4:00:05"))
+	assert.Equal(t, "default/macvlan", networks.networkByMAC("aa:bb:cc:dd:ee:01"))
+	assert.Equal(t, "default/l2net", networks.networkByMAC("0A:58:AC:10:00:03"))
+}
+
+func TestPodNetworks_Update(t *testing.T) {
+	networks := newPodNetworks()
+	networks.add(multiNetworkPod)
+
+	// WHEN a pod is detached from its secondary networks
+	updated := multiNetworkPod.DeepCopy()
+	updated.Annotations = nil
+	networks.add(updated)
+
+	// THEN its secondary addresses are not indexed anymore
+	assert.Empty(t, networks.byIP["192.168.1.10"])
+	assert.Empty(t, networks.byMAC["aa:bb:cc:dd:ee:01"])
+	assert.Equal(t, DefaultNetwork, networks.networkByIP("10.244.0.5"))
+
+	// AND all its addresses are unindexed when it is deleted
+	networks.remove("default/multi")
+	assert.Empty(t, networks.byIP)
+	assert.Empty(t, networks.byMAC)
+	assert.Empty(t, networks.addresses)
+}
+
+func TestPodNetworks_InvalidAnnotation(t *testing.T) {
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Annotations: map[string]string{AnnotationNetworkStatus: "not json"},
+	}}
+	assert.Empty(t, podInterfaces(pod))
+	networks := newPodNetworks()
+	networks.add(pod)
+	assert.Equal(t, DefaultNetwork, networks.networkByIP("1.2.3.4"))
+}
+
+func TestInformers_IndexSecondaryNetworks(t *testing.T) {
+	informers, err := NewInformers(fake.NewSimpleClientset(multiNetworkPod), nil, config.Default())
+	require.NoError(t, err)
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	require.NoError(t, informers.Start(stopCh))
+	informers.WaitForCacheSync(stopCh)
+
+	for _, ip := range []string{"10.244.0.5", "192.168.1.10", "172.16.0.3"} {
+		pod := informers.PodByIP(ip)
+		require.NotNil(t, pod, ip)
+		assert.Equal(t, "multi", pod.Name)
+	}
+	for _, mac := range []string{"0a:58:0a:f4:00:05", "AA:BB:CC:DD:EE:01", "0a:58:ac:10:00:03"} {
+		pod := informers.PodByMAC(mac)
+		require.NotNil(t, pod, mac)
+		assert.Equal(t, "multi", pod.Name)
+	}
+	assert.Eventually(t, func() bool {
+		return informers.PodNetworkByIP("192.168.1.10") == "default/macvlan"
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, "default/l2net", informers.PodNetworkByMAC("0a:58:ac:10:00:03"))
+	assert.Nil(t, informers.PodByIP("10.0.0.1"))
+	assert.Nil(t, informers.PodByMAC("00:00:00:00:00:00"))
+}
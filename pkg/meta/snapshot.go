@@ -0,0 +1,230 @@
+package meta
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// SnapshotVersion is the version of the Snapshot format. It must be increased any time that
+// the format changes in a way that is not backwards-compatible
+const SnapshotVersion = 1
+
+// Snapshot contains the state of all the objects that are used for the enrichment at a given
+// moment, so it can be later replayed (e.g. to reproduce a misattribution).
+// The indices and owner chains are informative, to easily inspect the snapshots: when a
+// snapshot is replayed, they are recalculated from the objects
+type Snapshot struct {
+	Version       int                     `json:"version"`
+	Timestamp     time.Time               `json:"timestamp"`
+	Pods          []*corev1.Pod           `json:"pods"`
+	Services      []*corev1.Service       `json:"services"`
+	ReplicaSets   []*appsv1.ReplicaSet    `json:"replicaSets"`
+	Nodes         []*corev1.Node          `json:"nodes,omitempty"`
+	Namespaces    []*corev1.Namespace     `json:"namespaces,omitempty"`
+	CustomObjects []*SnapshotCustomObject `json:"customObjects,omitempty"`
+	PodsByIP      map[string]string       `json:"podsByIP"`
+	PodsByMAC     map[string]string       `json:"podsByMAC"`
+	ServicesByIP  map[string]string       `json:"servicesByIP"`
+	OwnerChains   map[string][]string     `json:"ownerChains"`
+}
+
+// SnapshotCustomObject is an object from a custom resource, as it is resolved from the
+// JSONPath expressions in the configuration
+type SnapshotCustomObject struct {
+	CustomObject
+	Resource string   `json:"resource"`
+	IPs      []string `json:"ips"`
+}
+
+// Snapshotter is implemented by any InformersInterface that can provide a Snapshot
+type Snapshotter interface {
+	Snapshot() *Snapshot
+}
+
+// Snapshot returns the current state of the stores
+func (s *stores) Snapshot() *Snapshot {
+	snap := Snapshot{
+		Version:      SnapshotVersion,
+		Timestamp:    time.Now(),
+		PodsByIP:     map[string]string{},
+		PodsByMAC:    map[string]string{},
+		ServicesByIP: map[string]string{},
+		OwnerChains:  map[string][]string{},
+	}
+	for _, item := range s.pods.List() {
+		pod := item.(*corev1.Pod)
+		snap.Pods = append(snap.Pods, pod)
+		if chain := s.ownerChain(pod); len(chain) > 0 {
+			snap.OwnerChains[pod.Namespace+NamespaceSeparator+pod.Name] = chain
+		}
+	}
+	// the objects deleted after listing the index values are skipped
+	for _, ip := range s.pods.ListIndexFuncValues(IndexIP) {
+		if pod := s.PodByIP(ip); pod != nil {
+			snap.PodsByIP[ip] = pod.Namespace + NamespaceSeparator + pod.Name
+		}
+	}
+	for _, mac := range s.pods.ListIndexFuncValues(IndexMAC) {
+		if pod := s.PodByMAC(mac); pod != nil {
+			snap.PodsByMAC[mac] = pod.Namespace + NamespaceSeparator + pod.Name
+		}
+	}
+	for _, item := range s.services.List() {
+		snap.Services = append(snap.Services, item.(*corev1.Service))
+	}
+	for _, ip := range s.services.ListIndexFuncValues(IndexIP) {
+		if svc := s.ServiceByIP(ip); svc != nil {
+			snap.ServicesByIP[ip] = svc.Namespace + NamespaceSeparator + svc.Name
+		}
+	}
+	for _, item := range s.replicaSets.List() {
+		snap.ReplicaSets = append(snap.ReplicaSets, item.(*appsv1.ReplicaSet))
+	}
+	if s.nodes != nil {
+		for _, item := range s.nodes.List() {
+			snap.Nodes = append(snap.Nodes, item.(*corev1.Node))
+		}
+	}
+	if s.namespaces != nil {
+		for _, item := range s.namespaces.List() {
+			snap.Namespaces = append(snap.Namespaces, item.(*corev1.Namespace))
+		}
+	}
+	for _, ci := range s.custom {
+		indexIPs := ci.indexer.GetIndexers()[IndexIP]
+		for _, item := range ci.indexer.List() {
+			ips, _ := indexIPs(item)
+			snap.CustomObjects = append(snap.CustomObjects, &SnapshotCustomObject{
+				CustomObject: *ci.object(item),
+				Resource:     ci.resource,
+				IPs:          ips,
+			})
+		}
+	}
+	return &snap
+}
+
+// ownerChain returns the owners of a Pod, as they are resolved during the enrichment
+// (e.g. ReplicaSet/foo-1234 -> Deployment/foo)
+func (s *stores) ownerChain(pod *corev1.Pod) []string {
+	if len(pod.OwnerReferences) == 0 {
+		return nil
+	}
+	ref := pod.OwnerReferences[0]
+	chain := []string{ref.Kind + "/" + ref.Name}
+	if ref.Kind == "ReplicaSet" {
+		if rs := s.ReplicaSet(pod.Namespace, ref.Name); rs != nil && len(rs.OwnerReferences) > 0 {
+			owner := rs.OwnerReferences[0]
+			chain = append(chain, owner.Kind+"/"+owner.Name)
+		}
+	}
+	return chain
+}
+
+// Write the snapshot as a JSON document
+func (snap *Snapshot) Write(out io.Writer) error {
+	return json.NewEncoder(out).Encode(snap)
+}
+
+// ReadSnapshot reads a JSON snapshot, as it is written by Snapshot.Write
+func ReadSnapshot(in io.Reader) (*Snapshot, error) {
+	snap := Snapshot{}
+	if err := json.NewDecoder(in).Decode(&snap); err != nil {
+		return nil, fmt.Errorf("can't decode snapshot: %w", err)
+	}
+	if snap.Version != SnapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d. Expected: %d", snap.Version, SnapshotVersion)
+	}
+	return &snap, nil
+}
+
+// LoadSnapshot replays a snapshot file into an Inventory
+func LoadSnapshot(path string) (*Inventory, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	snap, err := ReadSnapshot(file)
+	if err != nil {
+		return nil, err
+	}
+	inv := newInventory()
+	for _, pod := range snap.Pods {
+		if err := inv.addPod(pod); err != nil {
+			return nil, err
+		}
+	}
+	for _, svc := range snap.Services {
+		if err := inv.services.Add(svc); err != nil {
+			return nil, err
+		}
+	}
+	for _, rs := range snap.ReplicaSets {
+		if err := inv.replicaSets.Add(rs); err != nil {
+			return nil, err
+		}
+	}
+	for _, node := range snap.Nodes {
+		if err := inv.nodes.Add(node); err != nil {
+			return nil, err
+		}
+	}
+	for _, ns := range snap.Namespaces {
+		if err := inv.namespaces.Add(ns); err != nil {
+			return nil, err
+		}
+	}
+	// custom objects are grouped by resource, keeping the same order as in the snapshot
+	byResource := map[string]*customIndex{}
+	for _, obj := range snap.CustomObjects {
+		ci, ok := byResource[obj.Resource]
+		if !ok {
+			ci = newSnapshotCustomIndex(obj.Resource)
+			byResource[obj.Resource] = ci
+			inv.custom = append(inv.custom, ci)
+		}
+		if err := ci.indexer.Add(obj); err != nil {
+			return nil, err
+		}
+	}
+	return inv, nil
+}
+
+func newSnapshotCustomIndex(resource string) *customIndex {
+	return &customIndex{
+		resource: resource,
+		indexer: cache.NewIndexer(func(obj interface{}) (string, error) {
+			sco := obj.(*SnapshotCustomObject)
+			return sco.Namespace + NamespaceSeparator + sco.Name, nil
+		}, cache.Indexers{
+			IndexIP: func(obj interface{}) ([]string, error) {
+				return obj.(*SnapshotCustomObject).IPs, nil
+			},
+		}),
+		object: func(item interface{}) *CustomObject {
+			co := item.(*SnapshotCustomObject).CustomObject
+			return &co
+		},
+	}
+}
+
+// SnapshotHandler returns an HTTP handler that serves the current snapshot as a JSON document
+func SnapshotHandler(s Snapshotter) http.HandlerFunc {
+	return func(rw http.ResponseWriter, _ *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		rw.Header().Set("Content-Disposition",
+			fmt.Sprintf(`attachment; filename="snapshot-%d.json"`, time.Now().Unix()))
+		if err := s.Snapshot().Write(rw); err != nil {
+			ilog.WithError(err).Error("can't write snapshot")
+		}
+	}
+}
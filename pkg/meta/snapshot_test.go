@@ -0,0 +1,145 @@
+package meta
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/netobserv/goflow2-kube-enricher/pkg/config"
+)
+
+func startTestInformers(t *testing.T) *Informers {
+	clientset := fake.NewSimpleClientset(
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "front-1234-abcd", Namespace: "shop",
+				OwnerReferences: []metav1.OwnerReference{{Kind: "ReplicaSet", Name: "front-1234"}},
+			},
+			Status: corev1.PodStatus{HostIP: "10.0.0.1", PodIP: "10.244.0.7", PodIPs: []corev1.PodIP{{IP: "10.244.0.7"}}},
+		},
+		&appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{
+			Name: "front-1234", Namespace: "shop",
+			OwnerReferences: []metav1.OwnerReference{{Kind: "Deployment", Name: "front"}},
+		}},
+		&corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "front", Namespace: "shop"},
+			Spec:       corev1.ServiceSpec{ClusterIP: "10.96.0.10", ClusterIPs: []string{"10.96.0.10"}},
+		},
+		multiNetworkPod,
+	)
+	dynClient := dynfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{
+			{Group: "kubevirt.io", Version: "v1", Resource: "virtualmachineinstances"}: "VirtualMachineInstanceList",
+		}, &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "kubevirt.io/v1",
+			"kind":       "VirtualMachineInstance",
+			"metadata":   map[string]interface{}{"name": "my-vm", "namespace": "vms"},
+			"status":     map[string]interface{}{"ip": "10.244.1.8"},
+		}})
+	cfg := config.Default()
+	cfg.CustomResources = []config.CustomResourceConfig{{
+		Group: "kubevirt.io", Version: "v1", Resource: "virtualmachineinstances", IPs: "{.status.ip}",
+	}}
+	informers, err := NewInformers(clientset, dynClient, cfg)
+	require.NoError(t, err)
+	stopCh := make(chan struct{})
+	t.Cleanup(func() { close(stopCh) })
+	require.NoError(t, informers.Start(stopCh))
+	informers.WaitForCacheSync(stopCh)
+	return &informers
+}
+
+func TestSnapshot_RecordAndReplay(t *testing.T) {
+	informers := startTestInformers(t)
+
+	// GIVEN a snapshot of the informers
+	snap := informers.Snapshot()
+	assert.Equal(t, SnapshotVersion, snap.Version)
+	assert.Equal(t, "shop/front-1234-abcd", snap.PodsByIP["10.244.0.7"])
+	assert.Equal(t, "default/multi", snap.PodsByIP["192.168.1.10"])
+	assert.Equal(t, "default/multi", snap.PodsByMAC["0a:58:0a:f4:00:05"])
+	assert.Equal(t, "shop/front", snap.ServicesByIP["10.96.0.10"])
+	assert.Equal(t, []string{"ReplicaSet/front-1234", "Deployment/front"}, snap.OwnerChains["shop/front-1234-abcd"])
+
+	// WHEN it is written and replayed
+	file, err := ioutil.TempFile("", "snapshot_")
+	require.NoError(t, err)
+	defer os.Remove(file.Name())
+	require.NoError(t, snap.Write(file))
+	require.NoError(t, file.Close())
+	replayed, err := LoadSnapshot(file.Name())
+	require.NoError(t, err)
+
+	// THEN the replayed inventory provides the same objects as the original informers
+	for _, ip := range []string{"10.244.0.5", "10.244.0.7", "192.168.1.10", "10.96.0.10", "10.244.1.8", "1.2.3.4"} {
+		assert.Equal(t, informers.PodByIP(ip), replayed.PodByIP(ip), ip)
+		assert.Equal(t, informers.ServiceByIP(ip), replayed.ServiceByIP(ip), ip)
+		assert.Equal(t, informers.CustomObjectByIP(ip), replayed.CustomObjectByIP(ip), ip)
+	}
+	assert.Equal(t, informers.PodByMAC("aa:bb:cc:dd:ee:01"), replayed.PodByMAC("aa:bb:cc:dd:ee:01"))
+	assert.Equal(t, informers.ReplicaSet("shop", "front-1234"), replayed.ReplicaSet("shop", "front-1234"))
+	assert.Equal(t, &CustomObject{Kind: "VirtualMachineInstance", Name: "my-vm", Namespace: "vms"},
+		replayed.CustomObjectByIP("10.244.1.8"))
+
+	// AND the snapshot of the replayed inventory matches the original snapshot
+	replayedSnap := replayed.Snapshot()
+	assert.Equal(t, snap.PodsByIP, replayedSnap.PodsByIP)
+	assert.Equal(t, snap.PodsByMAC, replayedSnap.PodsByMAC)
+	assert.Equal(t, snap.ServicesByIP, replayedSnap.ServicesByIP)
+	assert.Equal(t, snap.OwnerChains, replayedSnap.OwnerChains)
+}
+
+func TestSnapshot_Handler(t *testing.T) {
+	informers := startTestInformers(t)
+	rw := httptest.NewRecorder()
+	SnapshotHandler(informers)(rw, httptest.NewRequest("GET", "/debug/snapshot", nil))
+	assert.Equal(t, "application/json", rw.Header().Get("Content-Type"))
+	snap, err := ReadSnapshot(bytes.NewReader(rw.Body.Bytes()))
+	require.NoError(t, err)
+	assert.Len(t, snap.Pods, 2)
+}
+
+// staleIndexer lists an extra index value, as if its object had been deleted after listing them
+type staleIndexer struct {
+	cache.Indexer
+	value string
+}
+
+func (s *staleIndexer) ListIndexFuncValues(name string) []string {
+	return append(s.Indexer.ListIndexFuncValues(name), s.value)
+}
+
+func TestSnapshot_DeletedObjects(t *testing.T) {
+	// GIVEN informers whose objects are deleted while the snapshot is taken
+	informers := startTestInformers(t)
+	informers.pods = &staleIndexer{Indexer: informers.pods, value: "10.244.0.99"}
+	informers.services = &staleIndexer{Indexer: informers.services, value: "10.96.0.99"}
+
+	// WHEN the snapshot is taken
+	snap := informers.Snapshot()
+
+	// THEN the deleted objects are skipped
+	assert.NotContains(t, snap.PodsByIP, "10.244.0.99")
+	assert.NotContains(t, snap.ServicesByIP, "10.96.0.99")
+	assert.Equal(t, "shop/front-1234-abcd", snap.PodsByIP["10.244.0.7"])
+}
+
+func TestSnapshot_UnsupportedVersion(t *testing.T) {
+	_, err := ReadSnapshot(strings.NewReader(`{"version":1000}`))
+	assert.Error(t, err)
+}
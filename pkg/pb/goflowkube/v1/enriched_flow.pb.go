@@ -0,0 +1,352 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.27.1
+// 	protoc        (unknown)
+// source: goflowkube/v1/enriched_flow.proto
+
+// Package goflowkube.v1 defines the messages produced by goflow2-kube-enricher. Changes in
+// this package are backwards compatible: fields are only added, never renamed or reused.
+// Breaking changes will be published in a new goflowkube.vN package.
+
+package goflowkubev1
+
+import (
+	pb "github.com/netsampler/goflow2/pb"
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// EnrichedFlow is a goflow2 flow, enriched with the kubernetes metadata of its endpoints
+type EnrichedFlow struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// Flow as received from goflow2. The fields that are not part of the goflow2 message
+	// (e.g. from a JSON input) are stored in the extra_fields property
+	Flow *pb.FlowMessage `protobuf:"bytes,1,opt,name=flow,proto3" json:"flow,omitempty"`
+	// Kubernetes metadata of the source endpoint, from the Src-prefixed fields
+	Src *Endpoint `protobuf:"bytes,2,opt,name=src,proto3" json:"src,omitempty"`
+	// Kubernetes metadata of the destination endpoint, from the Dst-prefixed fields
+	Dst *Endpoint `protobuf:"bytes,3,opt,name=dst,proto3" json:"dst,omitempty"`
+	// Fields of the record that are neither goflow2 nor kubernetes fields, as strings
+	ExtraFields map[string]string `protobuf:"bytes,4,rep,name=extra_fields,json=extraFields,proto3" json:"extra_fields,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+}
+
+func (x *EnrichedFlow) Reset() {
+	*x = EnrichedFlow{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_goflowkube_v1_enriched_flow_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *EnrichedFlow) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*EnrichedFlow) ProtoMessage() {}
+
+func (x *EnrichedFlow) ProtoReflect() protoreflect.Message {
+	mi := &file_goflowkube_v1_enriched_flow_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use EnrichedFlow.ProtoReflect.Descriptor instead.
+func (*EnrichedFlow) Descriptor() ([]byte, []int) {
+	return file_goflowkube_v1_enriched_flow_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *EnrichedFlow) GetFlow() *pb.FlowMessage {
+	if x != nil {
+		return x.Flow
+	}
+	return nil
+}
+
+func (x *EnrichedFlow) GetSrc() *Endpoint {
+	if x != nil {
+		return x.Src
+	}
+	return nil
+}
+
+func (x *EnrichedFlow) GetDst() *Endpoint {
+	if x != nil {
+		return x.Dst
+	}
+	return nil
+}
+
+func (x *EnrichedFlow) GetExtraFields() map[string]string {
+	if x != nil {
+		return x.ExtraFields
+	}
+	return nil
+}
+
+// Endpoint holds the kubernetes metadata of a flow endpoint. Empty fields mean that the
+// endpoint could not be enriched with that information
+type Endpoint struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Pod       string `protobuf:"bytes,1,opt,name=pod,proto3" json:"pod,omitempty"`
+	Namespace string `protobuf:"bytes,2,opt,name=namespace,proto3" json:"namespace,omitempty"`
+	// IP of the node where the pod runs
+	HostIp string `protobuf:"bytes,3,opt,name=host_ip,json=hostIp,proto3" json:"host_ip,omitempty"`
+	// Name of the owner of the pod (e.g. a Deployment), or of the Service
+	Workload string `protobuf:"bytes,4,opt,name=workload,proto3" json:"workload,omitempty"`
+	// Kind of the workload (e.g. Deployment, StatefulSet, Service...)
+	WorkloadKind string `protobuf:"bytes,5,opt,name=workload_kind,json=workloadKind,proto3" json:"workload_kind,omitempty"`
+	// Name of the pod network (for secondary networks, e.g. Multus)
+	Network string `protobuf:"bytes,6,opt,name=network,proto3" json:"network,omitempty"`
+	// Topology zone and region of the node
+	Zone   string `protobuf:"bytes,7,opt,name=zone,proto3" json:"zone,omitempty"`
+	Region string `protobuf:"bytes,8,opt,name=region,proto3" json:"region,omitempty"`
+	// Warnings raised during the enrichment (e.g. ambiguous owners)
+	Warn string `protobuf:"bytes,9,opt,name=warn,proto3" json:"warn,omitempty"`
+}
+
+func (x *Endpoint) Reset() {
+	*x = Endpoint{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_goflowkube_v1_enriched_flow_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Endpoint) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Endpoint) ProtoMessage() {}
+
+func (x *Endpoint) ProtoReflect() protoreflect.Message {
+	mi := &file_goflowkube_v1_enriched_flow_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Endpoint.ProtoReflect.Descriptor instead.
+func (*Endpoint) Descriptor() ([]byte, []int) {
+	return file_goflowkube_v1_enriched_flow_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Endpoint) GetPod() string {
+	if x != nil {
+		return x.Pod
+	}
+	return ""
+}
+
+func (x *Endpoint) GetNamespace() string {
+	if x != nil {
+		return x.Namespace
+	}
+	return ""
+}
+
+func (x *Endpoint) GetHostIp() string {
+	if x != nil {
+		return x.HostIp
+	}
+	return ""
+}
+
+func (x *Endpoint) GetWorkload() string {
+	if x != nil {
+		return x.Workload
+	}
+	return ""
+}
+
+func (x *Endpoint) GetWorkloadKind() string {
+	if x != nil {
+		return x.WorkloadKind
+	}
+	return ""
+}
+
+func (x *Endpoint) GetNetwork() string {
+	if x != nil {
+		return x.Network
+	}
+	return ""
+}
+
+func (x *Endpoint) GetZone() string {
+	if x != nil {
+		return x.Zone
+	}
+	return ""
+}
+
+func (x *Endpoint) GetRegion() string {
+	if x != nil {
+		return x.Region
+	}
+	return ""
+}
+
+func (x *Endpoint) GetWarn() string {
+	if x != nil {
+		return x.Warn
+	}
+	return ""
+}
+
+var File_goflowkube_v1_enriched_flow_proto protoreflect.FileDescriptor
+
+var file_goflowkube_v1_enriched_flow_proto_rawDesc = []byte{
+	0x0a, 0x21, 0x67, 0x6f, 0x66, 0x6c, 0x6f, 0x77, 0x6b, 0x75, 0x62, 0x65, 0x2f, 0x76, 0x31, 0x2f,
+	0x65, 0x6e, 0x72, 0x69, 0x63, 0x68, 0x65, 0x64, 0x5f, 0x66, 0x6c, 0x6f, 0x77, 0x2e, 0x70, 0x72,
+	0x6f, 0x74, 0x6f, 0x12, 0x0d, 0x67, 0x6f, 0x66, 0x6c, 0x6f, 0x77, 0x6b, 0x75, 0x62, 0x65, 0x2e,
+	0x76, 0x31, 0x1a, 0x0d, 0x70, 0x62, 0x2f, 0x66, 0x6c, 0x6f, 0x77, 0x2e, 0x70, 0x72, 0x6f, 0x74,
+	0x6f, 0x22, 0x9e, 0x02, 0x0a, 0x0c, 0x45, 0x6e, 0x72, 0x69, 0x63, 0x68, 0x65, 0x64, 0x46, 0x6c,
+	0x6f, 0x77, 0x12, 0x27, 0x0a, 0x04, 0x66, 0x6c, 0x6f, 0x77, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0b,
+	0x32, 0x13, 0x2e, 0x66, 0x6c, 0x6f, 0x77, 0x70, 0x62, 0x2e, 0x46, 0x6c, 0x6f, 0x77, 0x4d, 0x65,
+	0x73, 0x73, 0x61, 0x67, 0x65, 0x52, 0x04, 0x66, 0x6c, 0x6f, 0x77, 0x12, 0x29, 0x0a, 0x03, 0x73,
+	0x72, 0x63, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x17, 0x2e, 0x67, 0x6f, 0x66, 0x6c, 0x6f,
+	0x77, 0x6b, 0x75, 0x62, 0x65, 0x2e, 0x76, 0x31, 0x2e, 0x45, 0x6e, 0x64, 0x70, 0x6f, 0x69, 0x6e,
+	0x74, 0x52, 0x03, 0x73, 0x72, 0x63, 0x12, 0x29, 0x0a, 0x03, 0x64, 0x73, 0x74, 0x18, 0x03, 0x20,
+	0x01, 0x28, 0x0b, 0x32, 0x17, 0x2e, 0x67, 0x6f, 0x66, 0x6c, 0x6f, 0x77, 0x6b, 0x75, 0x62, 0x65,
+	0x2e, 0x76, 0x31, 0x2e, 0x45, 0x6e, 0x64, 0x70, 0x6f, 0x69, 0x6e, 0x74, 0x52, 0x03, 0x64, 0x73,
+	0x74, 0x12, 0x4f, 0x0a, 0x0c, 0x65, 0x78, 0x74, 0x72, 0x61, 0x5f, 0x66, 0x69, 0x65, 0x6c, 0x64,
+	0x73, 0x18, 0x04, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x2c, 0x2e, 0x67, 0x6f, 0x66, 0x6c, 0x6f, 0x77,
+	0x6b, 0x75, 0x62, 0x65, 0x2e, 0x76, 0x31, 0x2e, 0x45, 0x6e, 0x72, 0x69, 0x63, 0x68, 0x65, 0x64,
+	0x46, 0x6c, 0x6f, 0x77, 0x2e, 0x45, 0x78, 0x74, 0x72, 0x61, 0x46, 0x69, 0x65, 0x6c, 0x64, 0x73,
+	0x45, 0x6e, 0x74, 0x72, 0x79, 0x52, 0x0b, 0x65, 0x78, 0x74, 0x72, 0x61, 0x46, 0x69, 0x65, 0x6c,
+	0x64, 0x73, 0x1a, 0x3e, 0x0a, 0x10, 0x45, 0x78, 0x74, 0x72, 0x61, 0x46, 0x69, 0x65, 0x6c, 0x64,
+	0x73, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x12, 0x10, 0x0a, 0x03, 0x6b, 0x65, 0x79, 0x18, 0x01, 0x20,
+	0x01, 0x28, 0x09, 0x52, 0x03, 0x6b, 0x65, 0x79, 0x12, 0x14, 0x0a, 0x05, 0x76, 0x61, 0x6c, 0x75,
+	0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x3a, 0x02,
+	0x38, 0x01, 0x22, 0xee, 0x01, 0x0a, 0x08, 0x45, 0x6e, 0x64, 0x70, 0x6f, 0x69, 0x6e, 0x74, 0x12,
+	0x10, 0x0a, 0x03, 0x70, 0x6f, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x70, 0x6f,
+	0x64, 0x12, 0x1c, 0x0a, 0x09, 0x6e, 0x61, 0x6d, 0x65, 0x73, 0x70, 0x61, 0x63, 0x65, 0x18, 0x02,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x6e, 0x61, 0x6d, 0x65, 0x73, 0x70, 0x61, 0x63, 0x65, 0x12,
+	0x17, 0x0a, 0x07, 0x68, 0x6f, 0x73, 0x74, 0x5f, 0x69, 0x70, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x06, 0x68, 0x6f, 0x73, 0x74, 0x49, 0x70, 0x12, 0x1a, 0x0a, 0x08, 0x77, 0x6f, 0x72, 0x6b,
+	0x6c, 0x6f, 0x61, 0x64, 0x18, 0x04, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x77, 0x6f, 0x72, 0x6b,
+	0x6c, 0x6f, 0x61, 0x64, 0x12, 0x23, 0x0a, 0x0d, 0x77, 0x6f, 0x72, 0x6b, 0x6c, 0x6f, 0x61, 0x64,
+	0x5f, 0x6b, 0x69, 0x6e, 0x64, 0x18, 0x05, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0c, 0x77, 0x6f, 0x72,
+	0x6b, 0x6c, 0x6f, 0x61, 0x64, 0x4b, 0x69, 0x6e, 0x64, 0x12, 0x18, 0x0a, 0x07, 0x6e, 0x65, 0x74,
+	0x77, 0x6f, 0x72, 0x6b, 0x18, 0x06, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x6e, 0x65, 0x74, 0x77,
+	0x6f, 0x72, 0x6b, 0x12, 0x12, 0x0a, 0x04, 0x7a, 0x6f, 0x6e, 0x65, 0x18, 0x07, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x04, 0x7a, 0x6f, 0x6e, 0x65, 0x12, 0x16, 0x0a, 0x06, 0x72, 0x65, 0x67, 0x69, 0x6f,
+	0x6e, 0x18, 0x08, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x72, 0x65, 0x67, 0x69, 0x6f, 0x6e, 0x12,
+	0x12, 0x0a, 0x04, 0x77, 0x61, 0x72, 0x6e, 0x18, 0x09, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x77,
+	0x61, 0x72, 0x6e, 0x42, 0x4e, 0x5a, 0x4c, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f,
+	0x6d, 0x2f, 0x6e, 0x65, 0x74, 0x6f, 0x62, 0x73, 0x65, 0x72, 0x76, 0x2f, 0x67, 0x6f, 0x66, 0x6c,
+	0x6f, 0x77, 0x32, 0x2d, 0x6b, 0x75, 0x62, 0x65, 0x2d, 0x65, 0x6e, 0x72, 0x69, 0x63, 0x68, 0x65,
+	0x72, 0x2f, 0x70, 0x6b, 0x67, 0x2f, 0x70, 0x62, 0x2f, 0x67, 0x6f, 0x66, 0x6c, 0x6f, 0x77, 0x6b,
+	0x75, 0x62, 0x65, 0x2f, 0x76, 0x31, 0x3b, 0x67, 0x6f, 0x66, 0x6c, 0x6f, 0x77, 0x6b, 0x75, 0x62,
+	0x65, 0x76, 0x31, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_goflowkube_v1_enriched_flow_proto_rawDescOnce sync.Once
+	file_goflowkube_v1_enriched_flow_proto_rawDescData = file_goflowkube_v1_enriched_flow_proto_rawDesc
+)
+
+func file_goflowkube_v1_enriched_flow_proto_rawDescGZIP() []byte {
+	file_goflowkube_v1_enriched_flow_proto_rawDescOnce.Do(func() {
+		file_goflowkube_v1_enriched_flow_proto_rawDescData = protoimpl.X.CompressGZIP(file_goflowkube_v1_enriched_flow_proto_rawDescData)
+	})
+	return file_goflowkube_v1_enriched_flow_proto_rawDescData
+}
+
+var file_goflowkube_v1_enriched_flow_proto_msgTypes = make([]protoimpl.MessageInfo, 3)
+var file_goflowkube_v1_enriched_flow_proto_goTypes = []interface{}{
+	(*EnrichedFlow)(nil),   // 0: goflowkube.v1.EnrichedFlow
+	(*Endpoint)(nil),       // 1: goflowkube.v1.Endpoint
+	nil,                    // 2: goflowkube.v1.EnrichedFlow.ExtraFieldsEntry
+	(*pb.FlowMessage)(nil), // 3: flowpb.FlowMessage
+}
+var file_goflowkube_v1_enriched_flow_proto_depIdxs = []int32{
+	3, // 0: goflowkube.v1.EnrichedFlow.flow:type_name -> flowpb.FlowMessage
+	1, // 1: goflowkube.v1.EnrichedFlow.src:type_name -> goflowkube.v1.Endpoint
+	1, // 2: goflowkube.v1.EnrichedFlow.dst:type_name -> goflowkube.v1.Endpoint
+	2, // 3: goflowkube.v1.EnrichedFlow.extra_fields:type_name -> goflowkube.v1.EnrichedFlow.ExtraFieldsEntry
+	4, // [4:4] is the sub-list for method output_type
+	4, // [4:4] is the sub-list for method input_type
+	4, // [4:4] is the sub-list for extension type_name
+	4, // [4:4] is the sub-list for extension extendee
+	0, // [0:4] is the sub-list for field type_name
+}
+
+func init() { file_goflowkube_v1_enriched_flow_proto_init() }
+func file_goflowkube_v1_enriched_flow_proto_init() {
+	if File_goflowkube_v1_enriched_flow_proto != nil {
+		return
+	}
+	if !protoimpl.UnsafeEnabled {
+		file_goflowkube_v1_enriched_flow_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*EnrichedFlow); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_goflowkube_v1_enriched_flow_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Endpoint); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_goflowkube_v1_enriched_flow_proto_rawDesc,
+			NumEnums:      0,
+			NumMessages:   3,
+			NumExtensions: 0,
+			NumServices:   0,
+		},
+		GoTypes:           file_goflowkube_v1_enriched_flow_proto_goTypes,
+		DependencyIndexes: file_goflowkube_v1_enriched_flow_proto_depIdxs,
+		MessageInfos:      file_goflowkube_v1_enriched_flow_proto_msgTypes,
+	}.Build()
+	File_goflowkube_v1_enriched_flow_proto = out.File
+	file_goflowkube_v1_enriched_flow_proto_rawDesc = nil
+	file_goflowkube_v1_enriched_flow_proto_goTypes = nil
+	file_goflowkube_v1_enriched_flow_proto_depIdxs = nil
+}
@@ -0,0 +1,335 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.27.1
+// 	protoc        (unknown)
+// source: goflowkube/v1/flow_service.proto
+
+package goflowkubev1
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type SubscribeRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Filter *FlowFilter `protobuf:"bytes,1,opt,name=filter,proto3" json:"filter,omitempty"`
+}
+
+func (x *SubscribeRequest) Reset() {
+	*x = SubscribeRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_goflowkube_v1_flow_service_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *SubscribeRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SubscribeRequest) ProtoMessage() {}
+
+func (x *SubscribeRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_goflowkube_v1_flow_service_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SubscribeRequest.ProtoReflect.Descriptor instead.
+func (*SubscribeRequest) Descriptor() ([]byte, []int) {
+	return file_goflowkube_v1_flow_service_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *SubscribeRequest) GetFilter() *FlowFilter {
+	if x != nil {
+		return x.Filter
+	}
+	return nil
+}
+
+// FlowFilter selects the flows of a subscription. A flow matches a list of values if any of
+// its endpoints (source or destination) has any of them. Empty lists match any flow, and
+// a flow must match all the non-empty lists
+type FlowFilter struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Namespaces []string `protobuf:"bytes,1,rep,name=namespaces,proto3" json:"namespaces,omitempty"`
+	// Names of the workloads (e.g. the name of a Deployment or a Service)
+	Workloads []string `protobuf:"bytes,2,rep,name=workloads,proto3" json:"workloads,omitempty"`
+	// Layer 4 protocol numbers (e.g. 6 for TCP, 17 for UDP)
+	Protos []uint32 `protobuf:"varint,3,rep,packed,name=protos,proto3" json:"protos,omitempty"`
+	Ports  []uint32 `protobuf:"varint,4,rep,packed,name=ports,proto3" json:"ports,omitempty"`
+}
+
+func (x *FlowFilter) Reset() {
+	*x = FlowFilter{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_goflowkube_v1_flow_service_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *FlowFilter) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FlowFilter) ProtoMessage() {}
+
+func (x *FlowFilter) ProtoReflect() protoreflect.Message {
+	mi := &file_goflowkube_v1_flow_service_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FlowFilter.ProtoReflect.Descriptor instead.
+func (*FlowFilter) Descriptor() ([]byte, []int) {
+	return file_goflowkube_v1_flow_service_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *FlowFilter) GetNamespaces() []string {
+	if x != nil {
+		return x.Namespaces
+	}
+	return nil
+}
+
+func (x *FlowFilter) GetWorkloads() []string {
+	if x != nil {
+		return x.Workloads
+	}
+	return nil
+}
+
+func (x *FlowFilter) GetProtos() []uint32 {
+	if x != nil {
+		return x.Protos
+	}
+	return nil
+}
+
+func (x *FlowFilter) GetPorts() []uint32 {
+	if x != nil {
+		return x.Ports
+	}
+	return nil
+}
+
+type SubscribeResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Flow *EnrichedFlow `protobuf:"bytes,1,opt,name=flow,proto3" json:"flow,omitempty"`
+	// Number of flows that have been dropped for this subscription, since it started
+	Dropped uint64 `protobuf:"varint,2,opt,name=dropped,proto3" json:"dropped,omitempty"`
+}
+
+func (x *SubscribeResponse) Reset() {
+	*x = SubscribeResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_goflowkube_v1_flow_service_proto_msgTypes[2]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *SubscribeResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SubscribeResponse) ProtoMessage() {}
+
+func (x *SubscribeResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_goflowkube_v1_flow_service_proto_msgTypes[2]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SubscribeResponse.ProtoReflect.Descriptor instead.
+func (*SubscribeResponse) Descriptor() ([]byte, []int) {
+	return file_goflowkube_v1_flow_service_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *SubscribeResponse) GetFlow() *EnrichedFlow {
+	if x != nil {
+		return x.Flow
+	}
+	return nil
+}
+
+func (x *SubscribeResponse) GetDropped() uint64 {
+	if x != nil {
+		return x.Dropped
+	}
+	return 0
+}
+
+var File_goflowkube_v1_flow_service_proto protoreflect.FileDescriptor
+
+var file_goflowkube_v1_flow_service_proto_rawDesc = []byte{
+	0x0a, 0x20, 0x67, 0x6f, 0x66, 0x6c, 0x6f, 0x77, 0x6b, 0x75, 0x62, 0x65, 0x2f, 0x76, 0x31, 0x2f,
+	0x66, 0x6c, 0x6f, 0x77, 0x5f, 0x73, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65, 0x2e, 0x70, 0x72, 0x6f,
+	0x74, 0x6f, 0x12, 0x0d, 0x67, 0x6f, 0x66, 0x6c, 0x6f, 0x77, 0x6b, 0x75, 0x62, 0x65, 0x2e, 0x76,
+	0x31, 0x1a, 0x21, 0x67, 0x6f, 0x66, 0x6c, 0x6f, 0x77, 0x6b, 0x75, 0x62, 0x65, 0x2f, 0x76, 0x31,
+	0x2f, 0x65, 0x6e, 0x72, 0x69, 0x63, 0x68, 0x65, 0x64, 0x5f, 0x66, 0x6c, 0x6f, 0x77, 0x2e, 0x70,
+	0x72, 0x6f, 0x74, 0x6f, 0x22, 0x45, 0x0a, 0x10, 0x53, 0x75, 0x62, 0x73, 0x63, 0x72, 0x69, 0x62,
+	0x65, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x31, 0x0a, 0x06, 0x66, 0x69, 0x6c, 0x74,
+	0x65, 0x72, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x19, 0x2e, 0x67, 0x6f, 0x66, 0x6c, 0x6f,
+	0x77, 0x6b, 0x75, 0x62, 0x65, 0x2e, 0x76, 0x31, 0x2e, 0x46, 0x6c, 0x6f, 0x77, 0x46, 0x69, 0x6c,
+	0x74, 0x65, 0x72, 0x52, 0x06, 0x66, 0x69, 0x6c, 0x74, 0x65, 0x72, 0x22, 0x78, 0x0a, 0x0a, 0x46,
+	0x6c, 0x6f, 0x77, 0x46, 0x69, 0x6c, 0x74, 0x65, 0x72, 0x12, 0x1e, 0x0a, 0x0a, 0x6e, 0x61, 0x6d,
+	0x65, 0x73, 0x70, 0x61, 0x63, 0x65, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x09, 0x52, 0x0a, 0x6e,
+	0x61, 0x6d, 0x65, 0x73, 0x70, 0x61, 0x63, 0x65, 0x73, 0x12, 0x1c, 0x0a, 0x09, 0x77, 0x6f, 0x72,
+	0x6b, 0x6c, 0x6f, 0x61, 0x64, 0x73, 0x18, 0x02, 0x20, 0x03, 0x28, 0x09, 0x52, 0x09, 0x77, 0x6f,
+	0x72, 0x6b, 0x6c, 0x6f, 0x61, 0x64, 0x73, 0x12, 0x16, 0x0a, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f,
+	0x73, 0x18, 0x03, 0x20, 0x03, 0x28, 0x0d, 0x52, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x73, 0x12,
+	0x14, 0x0a, 0x05, 0x70, 0x6f, 0x72, 0x74, 0x73, 0x18, 0x04, 0x20, 0x03, 0x28, 0x0d, 0x52, 0x05,
+	0x70, 0x6f, 0x72, 0x74, 0x73, 0x22, 0x5e, 0x0a, 0x11, 0x53, 0x75, 0x62, 0x73, 0x63, 0x72, 0x69,
+	0x62, 0x65, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x2f, 0x0a, 0x04, 0x66, 0x6c,
+	0x6f, 0x77, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1b, 0x2e, 0x67, 0x6f, 0x66, 0x6c, 0x6f,
+	0x77, 0x6b, 0x75, 0x62, 0x65, 0x2e, 0x76, 0x31, 0x2e, 0x45, 0x6e, 0x72, 0x69, 0x63, 0x68, 0x65,
+	0x64, 0x46, 0x6c, 0x6f, 0x77, 0x52, 0x04, 0x66, 0x6c, 0x6f, 0x77, 0x12, 0x18, 0x0a, 0x07, 0x64,
+	0x72, 0x6f, 0x70, 0x70, 0x65, 0x64, 0x18, 0x02, 0x20, 0x01, 0x28, 0x04, 0x52, 0x07, 0x64, 0x72,
+	0x6f, 0x70, 0x70, 0x65, 0x64, 0x32, 0x5f, 0x0a, 0x0b, 0x46, 0x6c, 0x6f, 0x77, 0x53, 0x65, 0x72,
+	0x76, 0x69, 0x63, 0x65, 0x12, 0x50, 0x0a, 0x09, 0x53, 0x75, 0x62, 0x73, 0x63, 0x72, 0x69, 0x62,
+	0x65, 0x12, 0x1f, 0x2e, 0x67, 0x6f, 0x66, 0x6c, 0x6f, 0x77, 0x6b, 0x75, 0x62, 0x65, 0x2e, 0x76,
+	0x31, 0x2e, 0x53, 0x75, 0x62, 0x73, 0x63, 0x72, 0x69, 0x62, 0x65, 0x52, 0x65, 0x71, 0x75, 0x65,
+	0x73, 0x74, 0x1a, 0x20, 0x2e, 0x67, 0x6f, 0x66, 0x6c, 0x6f, 0x77, 0x6b, 0x75, 0x62, 0x65, 0x2e,
+	0x76, 0x31, 0x2e, 0x53, 0x75, 0x62, 0x73, 0x63, 0x72, 0x69, 0x62, 0x65, 0x52, 0x65, 0x73, 0x70,
+	0x6f, 0x6e, 0x73, 0x65, 0x30, 0x01, 0x42, 0x4e, 0x5a, 0x4c, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62,
+	0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x6e, 0x65, 0x74, 0x6f, 0x62, 0x73, 0x65, 0x72, 0x76, 0x2f, 0x67,
+	0x6f, 0x66, 0x6c, 0x6f, 0x77, 0x32, 0x2d, 0x6b, 0x75, 0x62, 0x65, 0x2d, 0x65, 0x6e, 0x72, 0x69,
+	0x63, 0x68, 0x65, 0x72, 0x2f, 0x70, 0x6b, 0x67, 0x2f, 0x70, 0x62, 0x2f, 0x67, 0x6f, 0x66, 0x6c,
+	0x6f, 0x77, 0x6b, 0x75, 0x62, 0x65, 0x2f, 0x76, 0x31, 0x3b, 0x67, 0x6f, 0x66, 0x6c, 0x6f, 0x77,
+	0x6b, 0x75, 0x62, 0x65, 0x76, 0x31, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_goflowkube_v1_flow_service_proto_rawDescOnce sync.Once
+	file_goflowkube_v1_flow_service_proto_rawDescData = file_goflowkube_v1_flow_service_proto_rawDesc
+)
+
+func file_goflowkube_v1_flow_service_proto_rawDescGZIP() []byte {
+	file_goflowkube_v1_flow_service_proto_rawDescOnce.Do(func() {
+		file_goflowkube_v1_flow_service_proto_rawDescData = protoimpl.X.CompressGZIP(file_goflowkube_v1_flow_service_proto_rawDescData)
+	})
+	return file_goflowkube_v1_flow_service_proto_rawDescData
+}
+
+var file_goflowkube_v1_flow_service_proto_msgTypes = make([]protoimpl.MessageInfo, 3)
+var file_goflowkube_v1_flow_service_proto_goTypes = []interface{}{
+	(*SubscribeRequest)(nil),  // 0: goflowkube.v1.SubscribeRequest
+	(*FlowFilter)(nil),        // 1: goflowkube.v1.FlowFilter
+	(*SubscribeResponse)(nil), // 2: goflowkube.v1.SubscribeResponse
+	(*EnrichedFlow)(nil),      // 3: goflowkube.v1.EnrichedFlow
+}
+var file_goflowkube_v1_flow_service_proto_depIdxs = []int32{
+	1, // 0: goflowkube.v1.SubscribeRequest.filter:type_name -> goflowkube.v1.FlowFilter
+	3, // 1: goflowkube.v1.SubscribeResponse.flow:type_name -> goflowkube.v1.EnrichedFlow
+	0, // 2: goflowkube.v1.FlowService.Subscribe:input_type -> goflowkube.v1.SubscribeRequest
+	2, // 3: goflowkube.v1.FlowService.Subscribe:output_type -> goflowkube.v1.SubscribeResponse
+	3, // [3:4] is the sub-list for method output_type
+	2, // [2:3] is the sub-list for method input_type
+	2, // [2:2] is the sub-list for extension type_name
+	2, // [2:2] is the sub-list for extension extendee
+	0, // [0:2] is the sub-list for field type_name
+}
+
+func init() { file_goflowkube_v1_flow_service_proto_init() }
+func file_goflowkube_v1_flow_service_proto_init() {
+	if File_goflowkube_v1_flow_service_proto != nil {
+		return
+	}
+	file_goflowkube_v1_enriched_flow_proto_init()
+	if !protoimpl.UnsafeEnabled {
+		file_goflowkube_v1_flow_service_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*SubscribeRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_goflowkube_v1_flow_service_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*FlowFilter); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_goflowkube_v1_flow_service_proto_msgTypes[2].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*SubscribeResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_goflowkube_v1_flow_service_proto_rawDesc,
+			NumEnums:      0,
+			NumMessages:   3,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_goflowkube_v1_flow_service_proto_goTypes,
+		DependencyIndexes: file_goflowkube_v1_flow_service_proto_depIdxs,
+		MessageInfos:      file_goflowkube_v1_flow_service_proto_msgTypes,
+	}.Build()
+	File_goflowkube_v1_flow_service_proto = out.File
+	file_goflowkube_v1_flow_service_proto_rawDesc = nil
+	file_goflowkube_v1_flow_service_proto_goTypes = nil
+	file_goflowkube_v1_flow_service_proto_depIdxs = nil
+}
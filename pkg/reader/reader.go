@@ -4,35 +4,111 @@ package reader
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"strings"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 
 	"github.com/netobserv/goflow2-kube-enricher/pkg/config"
 	"github.com/netobserv/goflow2-kube-enricher/pkg/export"
 	"github.com/netobserv/goflow2-kube-enricher/pkg/format"
+	"github.com/netobserv/goflow2-kube-enricher/pkg/format/deadletter"
 	"github.com/netobserv/goflow2-kube-enricher/pkg/health"
 	"github.com/netobserv/goflow2-kube-enricher/pkg/meta"
+	"github.com/netobserv/goflow2-kube-enricher/pkg/topology"
 )
 
 type Reader struct {
-	log       *logrus.Entry
-	informers meta.InformersInterface
-	config    *config.Config
-	format    format.Format
-	health    *health.Reporter
+	log        *logrus.Entry
+	informers  meta.InformersInterface
+	config     *config.Config
+	format     format.Format
+	health     *health.Reporter
+	zones      *topology.Metrics
+	deadLetter *export.DeadLetter
 }
 
+// stageError is the error of a record, annotated with the processing stage where it failed
+type stageError struct {
+	stage string
+	err   error
+}
+
+func (e *stageError) Error() string {
+	return e.err.Error()
+}
+
+func (e *stageError) Unwrap() error {
+	return e.err
+}
+
+// NewReader creates a Reader that enriches the flows from the live cluster, or from the
+// static inventory files if they are provided in the configuration. In the latter case, the
+// kubernetes clients can be nil
 func NewReader(format format.Format,
 	log *logrus.Entry,
 	cfg *config.Config,
 	health *health.Reporter,
-	clientset kubernetes.Interface) Reader {
-	informers := meta.NewInformers(clientset)
+	clientset kubernetes.Interface,
+	dynClient dynamic.Interface) Reader {
+	var informers meta.InformersInterface
+	if cfg.Inventory.Enabled() {
+		informers = loadInventory(log, cfg)
+	} else {
+		informers = startInformers(log, cfg, clientset, dynClient)
+	}
+
+	r := Reader{
+		log:       log,
+		informers: informers,
+		config:    cfg,
+		format:    format,
+		health:    health,
+	}
+	if cfg.ZoneMetrics.Enabled {
+		r.zones = topology.NewMetrics(&cfg.ZoneMetrics)
+		health.MustRegister(r.zones.Collectors()...)
+	}
+	return r
+}
+
+func loadInventory(log *logrus.Entry, cfg *config.Config) *meta.Inventory {
+	var inventory *meta.Inventory
+	var err error
+	if cfg.Inventory.Snapshot != "" {
+		log.WithField("snapshot", cfg.Inventory.Snapshot).Info("replaying snapshot")
+		inventory, err = meta.LoadSnapshot(cfg.Inventory.Snapshot)
+	} else {
+		log.WithField("paths", cfg.Inventory.Paths).Info("loading static inventory")
+		inventory, err = meta.LoadInventory(cfg.Inventory.Paths)
+		if len(cfg.CustomResources) > 0 {
+			log.Warn("custom resources are not supported by the static inventory. Ignoring them")
+		}
+	}
+	if err != nil {
+		log.WithError(err).Fatal("can't load inventory")
+	}
+	if logrus.IsLevelEnabled(logrus.DebugLevel) {
+		inventory.DebugInfo(log.Writer())
+	}
+	return inventory
+}
+
+func startInformers(log *logrus.Entry,
+	cfg *config.Config,
+	clientset kubernetes.Interface,
+	dynClient dynamic.Interface) *meta.Informers {
+	informers, err := meta.NewInformers(clientset, dynClient, cfg)
+	if err != nil {
+		log.WithError(err).Fatal("can't create informers")
+	}
 
 	stopCh := make(chan struct{})
 	defer close(stopCh)
@@ -44,51 +120,150 @@ func NewReader(format format.Format,
 	if logrus.IsLevelEnabled(logrus.DebugLevel) {
 		informers.DebugInfo(log.Writer())
 	}
+	return &informers
+}
 
-	return Reader{
-		log:       log,
-		informers: &informers,
-		config:    cfg,
-		format:    format,
-		health:    health,
-	}
+// Snapshotter returns the source of the kubernetes objects, if it supports snapshots
+func (r *Reader) Snapshotter() (meta.Snapshotter, bool) {
+	s, ok := r.informers.(meta.Snapshotter)
+	return s, ok
 }
 
-func (r *Reader) Start(ctx context.Context, loki *export.Loki) {
+// SetDeadLetter sets the sink of the records that can't be enriched or exported
+func (r *Reader) SetDeadLetter(deadLetter *export.DeadLetter) {
+	r.deadLetter = deadLetter
+}
+
+// Start reads, enriches and exports the records until the context is done, or the input
+// fails or ends. The records that the asynchronous exporters fail to deliver are sent to the
+// dead-letter sink too, if it is set
+func (r *Reader) Start(ctx context.Context, exporter export.Exporter) {
 	r.health.Status = health.Ready
+	if r.deadLetter != nil && exporter != nil {
+		export.SetFailureHandler(exporter, r.deadLetter.FailureHandler())
+	}
+	committer, _ := r.format.(format.Committer)
+	// whether there are processed records that haven't been committed yet
+	pending := false
+	lastCommit := time.Now()
+	commit := func() bool {
+		if committer == nil || !pending {
+			return true
+		}
+		if !r.commit(committer, exporter) {
+			return false
+		}
+		pending = false
+		lastCommit = time.Now()
+		return true
+	}
 	for {
 		select {
 		case <-ctx.Done():
+			commit()
 			r.format.Shutdown()
 			return
 		default:
 			record, err := r.format.Next()
+			if errors.Is(err, format.ErrIdle) {
+				// the records read so far are committed without waiting for the next one
+				pending = true
+				if !commit() {
+					return
+				}
+				continue
+			}
+			if err == io.EOF {
+				r.log.Info("end of input")
+				commit()
+				return
+			}
 			if err != nil {
 				r.health.Status = health.Error
 				r.log.Error(err)
+				commit()
 				return
 			}
 			if record == nil {
 				r.health.Status = health.Error
 				r.log.Error("nil record")
+				commit()
 				return
 			}
-			if err := r.enrich(record, loki); err == nil {
+			// the exporters might modify the record, so the original is kept for the dead-letter sink
+			var original map[string]interface{}
+			if r.deadLetter != nil {
+				original = export.CopyRecord(record)
+			}
+			if err := r.enrich(record, exporter); err == nil {
 				r.health.RecordEnriched()
 			} else {
 				r.health.RecordDiscarded(err)
 				r.log.Error(err)
+				if r.deadLetter != nil {
+					stage := deadletter.StageExport
+					var serr *stageError
+					if errors.As(err, &serr) {
+						stage = serr.stage
+					}
+					r.deadLetter.Send(original, stage, err)
+				}
+			}
+			// discarded records are also committed, since processing them again would fail
+			pending = true
+			if committer != nil && time.Since(lastCommit) >= committer.CommitInterval() {
+				if !commit() {
+					return
+				}
 			}
 		}
 	}
 }
 
+// commit marks the processed records as committed, after the exporter has delivered them. If
+// the exporter couldn't deliver them, they are not committed and false is returned, so the
+// reader stops and the records are processed again after a restart
+func (r *Reader) commit(committer format.Committer, exporter export.Exporter) bool {
+	if exporter != nil {
+		if err := export.Flush(exporter); err != nil {
+			r.health.Status = health.Error
+			r.log.WithError(err).Error("can't export records. Stopping without committing them")
+			return false
+		}
+	}
+	if err := committer.Commit(); err != nil {
+		r.log.WithError(err).Warn("can't commit records")
+	}
+	return true
+}
+
 var ownerNameFunc = func(owners interface{}, idx int) string {
 	owner := owners.([]metav1.OwnerReference)[idx]
 	return owner.Kind + "/" + owner.Name
 }
 
-func (r *Reader) enrich(record map[string]interface{}, loki *export.Loki) error {
+// enrich adds the kubernetes metadata to the record and exports it. The returned error is
+// annotated with the stage where the record failed
+func (r *Reader) enrich(record map[string]interface{}, exporter export.Exporter) error {
+	if err := r.enrichRecord(record); err != nil {
+		return &stageError{stage: deadletter.StageEnrich, err: err}
+	}
+	if exporter != nil {
+		if err := exporter.ProcessRecord(record); err != nil {
+			return &stageError{stage: deadletter.StageExport, err: err}
+		}
+	}
+	return nil
+}
+
+// enrichRecord adds the kubernetes metadata to the record. A malformed record that makes the
+// enrichment panic is returned as an error, so it doesn't stop the reader
+func (r *Reader) enrichRecord(record map[string]interface{}) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("can't enrich record: %v", p)
+		}
+	}()
 	if r.config.PrintInput {
 		bs, _ := json.Marshal(record)
 		fmt.Println(string(bs))
@@ -106,21 +281,42 @@ func (r *Reader) enrich(record map[string]interface{}, loki *export.Loki) error
 		}
 		if pod := r.informers.PodByIP(ip); pod != nil {
 			r.enrichPod(record, prefixOut, pod)
+			record[prefixOut+"Network"] = r.informers.PodNetworkByIP(ip)
+		} else if obj := r.informers.CustomObjectByIP(ip); obj != nil {
+			fillWorkloadRecord(record, prefixOut, obj.Kind, obj.Name, obj.Namespace)
 		} else {
-			// If there is no Pod for such IP, we try searching for a service
+			// If there is no Pod nor custom object for such IP, we try searching for a service
 			r.enrichService(ip, record, prefixOut)
 		}
 	}
+	// As a fallback, try to find by MAC the Pods that couldn't be found by IP
+	// (e.g. OVN flows that only carry MACs)
+	for macField, prefixOut := range r.config.MACFields {
+		if _, ok := record[prefixOut+"Workload"]; ok {
+			continue
+		}
+		val, ok := record[macField]
+		if !ok {
+			r.log.Debugf("Field %s not found in record", macField)
+			continue
+		}
+		mac, ok := val.(string)
+		if !ok {
+			r.log.Warnf("String expected for field %s value %v", macField, val)
+			continue
+		}
+		if pod := r.informers.PodByMAC(mac); pod != nil {
+			r.enrichPod(record, prefixOut, pod)
+			record[prefixOut+"Network"] = r.informers.PodNetworkByMAC(mac)
+		}
+	}
 
-	// Printing output before loki processing, because Loki will remove
-	// indexed fields from the records hence making them hidden in output
-	if r.config.PrintOutput {
-		bs, _ := json.Marshal(record)
-		fmt.Println(string(bs))
+	if len(r.config.NamespaceLabels) > 0 {
+		r.enrichNamespaceLabels(record)
 	}
 
-	if loki != nil {
-		return loki.ProcessRecord(record)
+	if r.zones != nil {
+		r.zones.Observe(record)
 	}
 
 	return nil
@@ -136,6 +332,9 @@ func (r *Reader) enrichService(ip string, record map[string]interface{}, prefixO
 
 func (r *Reader) enrichPod(record map[string]interface{}, prefixOut string, pod *v1.Pod) {
 	fillPodRecord(record, prefixOut, pod)
+	if r.zones != nil {
+		r.enrichZone(record, prefixOut, pod)
+	}
 	var warnings []string
 	if len(pod.OwnerReferences) > 0 {
 		warnings = r.checkTooMany(warnings, "owners", "pod "+pod.Name, pod.OwnerReferences, len(pod.OwnerReferences), ownerNameFunc)
@@ -161,6 +360,50 @@ func (r *Reader) enrichPod(record map[string]interface{}, prefixOut string, pod
 	}
 }
 
+func (r *Reader) enrichZone(record map[string]interface{}, prefixOut string, pod *v1.Pod) {
+	if pod.Spec.NodeName == "" {
+		return
+	}
+	node := r.informers.NodeByName(pod.Spec.NodeName)
+	if node == nil {
+		r.log.Warnf("Failed to get Node [name=%s]", pod.Spec.NodeName)
+		return
+	}
+	if zone, ok := node.Labels[v1.LabelTopologyZone]; ok {
+		record[prefixOut+topology.FieldZone] = zone
+	}
+	if region, ok := node.Labels[v1.LabelTopologyRegion]; ok {
+		record[prefixOut+topology.FieldRegion] = region
+	}
+}
+
+// enrichNamespaceLabels adds the configured labels of the source and destination namespaces
+func (r *Reader) enrichNamespaceLabels(record map[string]interface{}) {
+	enriched := map[string]struct{}{}
+	for _, fields := range []map[string]string{r.config.IPFields, r.config.MACFields} {
+		for _, prefixOut := range fields {
+			if _, ok := enriched[prefixOut]; ok {
+				continue
+			}
+			enriched[prefixOut] = struct{}{}
+			name, ok := record[prefixOut+"Namespace"].(string)
+			if !ok || name == "" {
+				continue
+			}
+			ns := r.informers.NamespaceByName(name)
+			if ns == nil {
+				r.log.Warnf("Failed to get Namespace [name=%s]", name)
+				continue
+			}
+			for label, suffix := range r.config.NamespaceLabels {
+				if value, ok := ns.Labels[label]; ok {
+					record[prefixOut+suffix] = value
+				}
+			}
+		}
+	}
+}
+
 func (r *Reader) checkTooMany(warnings []string, kind, ref string, items interface{}, size int, nameFunc func(interface{}, int) string) []string {
 	if size > 1 {
 		var names []string
@@ -2,6 +2,14 @@ package reader
 
 import (
 	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
 	"testing"
 	"time"
 
@@ -9,10 +17,14 @@ import (
 
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"github.com/netobserv/goflow2-kube-enricher/pkg/config"
 	"github.com/netobserv/goflow2-kube-enricher/pkg/export"
+	"github.com/netobserv/goflow2-kube-enricher/pkg/format"
+	"github.com/netobserv/goflow2-kube-enricher/pkg/format/deadletter"
 	"github.com/netobserv/goflow2-kube-enricher/pkg/internal/mock"
+	"github.com/netobserv/goflow2-kube-enricher/pkg/topology"
 )
 
 var spy = SpyDriver{shutdownCalled: false, nextCalled: false}
@@ -71,6 +83,7 @@ func TestEnrichNoMatch(t *testing.T) {
 		"SrcHostIP":       "10.0.0.100",
 		"SrcWorkload":     "test-pod1",
 		"SrcWorkloadKind": "Pod",
+		"SrcNetwork":      "default",
 		"DstAddr":         "10.0.0.2",
 	}, records)
 }
@@ -97,12 +110,14 @@ func TestEnrichSinglePods(t *testing.T) {
 		"SrcHostIP":       "10.0.0.100",
 		"SrcWorkload":     "test-pod1",
 		"SrcWorkloadKind": "Pod",
+		"SrcNetwork":      "default",
 		"DstAddr":         "10.0.0.2",
 		"DstPod":          "test-pod2",
 		"DstNamespace":    "test-namespace",
 		"DstHostIP":       "10.0.0.100",
 		"DstWorkload":     "test-pod2",
 		"DstWorkloadKind": "Pod",
+		"DstNetwork":      "default",
 	}, records)
 }
 
@@ -128,12 +143,14 @@ func TestEnrichDeploymentPods(t *testing.T) {
 		"SrcHostIP":       "10.0.0.100",
 		"SrcWorkload":     "test-deployment1",
 		"SrcWorkloadKind": "Deployment",
+		"SrcNetwork":      "default",
 		"DstAddr":         "10.0.0.2",
 		"DstPod":          "test-pod2",
 		"DstNamespace":    "test-namespace",
 		"DstHostIP":       "10.0.0.100",
 		"DstWorkload":     "test-deployment2",
 		"DstWorkloadKind": "Deployment",
+		"DstNetwork":      "default",
 	}, records)
 }
 
@@ -159,6 +176,7 @@ func TestEnrichPodAndService(t *testing.T) {
 		"SrcHostIP":       "10.0.0.100",
 		"SrcWorkload":     "test-pod1",
 		"SrcWorkloadKind": "Pod",
+		"SrcNetwork":      "default",
 		"DstAddr":         "10.0.0.2",
 		"DstNamespace":    "test-namespace",
 		"DstWorkload":     "test-service",
@@ -166,6 +184,136 @@ func TestEnrichPodAndService(t *testing.T) {
 	}, records)
 }
 
+func TestEnrichCustomObject(t *testing.T) {
+	assert := assert.New(t)
+	r, informers := setupSimpleReader()
+
+	informers.MockCustomObject("VirtualMachineInstance", "test-vm", "test-namespace", "10.0.0.1")
+	informers.MockNoMatch("10.0.0.2")
+
+	records := map[string]interface{}{
+		"SrcAddr": "10.0.0.1",
+		"DstAddr": "10.0.0.2",
+	}
+
+	err := r.enrich(records, nil)
+
+	assert.Nil(err)
+	assert.Equal(map[string]interface{}{
+		"SrcAddr":         "10.0.0.1",
+		"SrcNamespace":    "test-namespace",
+		"SrcWorkload":     "test-vm",
+		"SrcWorkloadKind": "VirtualMachineInstance",
+		"DstAddr":         "10.0.0.2",
+	}, records)
+}
+
+func TestEnrichByMAC(t *testing.T) {
+	assert := assert.New(t)
+	r, informers := setupSimpleReader()
+
+	informers.MockPod("test-pod1", "test-namespace", "10.0.0.1", "10.0.0.100")
+	informers.MockNoMatch("10.0.0.2")
+	informers.MockPodByMAC("test-pod2", "test-namespace", "0a:58:0a:f4:02:03", "10.0.0.100", "test-namespace/macvlan")
+
+	records := map[string]interface{}{
+		"SrcAddr": "10.0.0.1",
+		"DstAddr": "10.0.0.2",
+		"DstMac":  "0a:58:0a:f4:02:03",
+	}
+
+	err := r.enrich(records, nil)
+
+	assert.Nil(err)
+	assert.Equal(map[string]interface{}{
+		"SrcAddr":         "10.0.0.1",
+		"SrcPod":          "test-pod1",
+		"SrcNamespace":    "test-namespace",
+		"SrcHostIP":       "10.0.0.100",
+		"SrcWorkload":     "test-pod1",
+		"SrcWorkloadKind": "Pod",
+		"SrcNetwork":      "default",
+		"DstAddr":         "10.0.0.2",
+		"DstMac":          "0a:58:0a:f4:02:03",
+		"DstPod":          "test-pod2",
+		"DstNamespace":    "test-namespace",
+		"DstHostIP":       "10.0.0.100",
+		"DstWorkload":     "test-pod2",
+		"DstWorkloadKind": "Pod",
+		"DstNetwork":      "test-namespace/macvlan",
+	}, records)
+}
+
+func TestEnrichZones(t *testing.T) {
+	assert := assert.New(t)
+	r, informers := setupSimpleReader()
+	r.config.ZoneMetrics.Enabled = true
+	r.zones = topology.NewMetrics(&r.config.ZoneMetrics)
+
+	informers.MockPodInZone("test-pod1", "test-namespace", "10.0.0.1", "10.0.0.100", "node1", "eu-1a", "eu-1")
+	informers.MockService("test-service", "test-namespace", "10.0.0.2")
+
+	records := map[string]interface{}{
+		"SrcAddr": "10.0.0.1",
+		"DstAddr": "10.0.0.2",
+	}
+
+	err := r.enrich(records, nil)
+
+	assert.Nil(err)
+	assert.Equal(map[string]interface{}{
+		"SrcAddr":         "10.0.0.1",
+		"SrcPod":          "test-pod1",
+		"SrcNamespace":    "test-namespace",
+		"SrcHostIP":       "10.0.0.100",
+		"SrcWorkload":     "test-pod1",
+		"SrcWorkloadKind": "Pod",
+		"SrcNetwork":      "default",
+		"SrcZone":         "eu-1a",
+		"SrcRegion":       "eu-1",
+		"DstAddr":         "10.0.0.2",
+		"DstNamespace":    "test-namespace",
+		"DstWorkload":     "test-service",
+		"DstWorkloadKind": "Service",
+	}, records)
+}
+
+func TestEnrichNamespaceLabels(t *testing.T) {
+	assert := assert.New(t)
+	r, informers := setupSimpleReader()
+	r.config.NamespaceLabels = map[string]string{"team": "Team", "tier": "Tier"}
+
+	informers.MockPod("test-pod1", "test-namespace", "10.0.0.1", "10.0.0.100")
+	informers.MockService("test-service", "other-namespace", "10.0.0.2")
+	informers.MockNamespace("test-namespace", map[string]string{"team": "blue", "tier": "frontend", "env": "prod"})
+	informers.MockNamespace("other-namespace", map[string]string{"team": "red"})
+
+	records := map[string]interface{}{
+		"SrcAddr": "10.0.0.1",
+		"DstAddr": "10.0.0.2",
+	}
+
+	err := r.enrich(records, nil)
+
+	assert.Nil(err)
+	assert.Equal(map[string]interface{}{
+		"SrcAddr":         "10.0.0.1",
+		"SrcPod":          "test-pod1",
+		"SrcNamespace":    "test-namespace",
+		"SrcHostIP":       "10.0.0.100",
+		"SrcWorkload":     "test-pod1",
+		"SrcWorkloadKind": "Pod",
+		"SrcNetwork":      "default",
+		"SrcTeam":         "blue",
+		"SrcTier":         "frontend",
+		"DstAddr":         "10.0.0.2",
+		"DstNamespace":    "other-namespace",
+		"DstWorkload":     "test-service",
+		"DstWorkloadKind": "Service",
+		"DstTeam":         "red",
+	}, records)
+}
+
 func TestShutdown(t *testing.T) {
 	loki := export.NewEmptyLoki()
 	r, informers := setupSimpleReader()
@@ -191,3 +339,256 @@ func TestShutdown(t *testing.T) {
 	assert.Eventually(t, func() bool { return !spy.shutdownCalled }, time.Second, time.Millisecond)
 	assert.Eventually(t, func() bool { return !spy.nextCalled }, time.Second, time.Millisecond)
 }
+
+// committingDriver returns a single record and then an error. It annotates the order of the
+// processing events
+type committingDriver struct {
+	events []string
+}
+
+func (d *committingDriver) Next() (map[string]interface{}, error) {
+	if len(d.events) > 0 {
+		return nil, errors.New("no more records")
+	}
+	d.events = append(d.events, "next")
+	return map[string]interface{}{"SrcAddr": "10.0.0.1"}, nil
+}
+
+func (d *committingDriver) Commit() error {
+	d.events = append(d.events, "commit")
+	return nil
+}
+
+func (d *committingDriver) CommitInterval() time.Duration {
+	return 0
+}
+
+func (d *committingDriver) Shutdown() {}
+
+// eventsExporter buffers the records, and fails to deliver them if flushErr is set
+type eventsExporter struct {
+	driver   *committingDriver
+	flushErr error
+}
+
+func (e *eventsExporter) ProcessRecord(_ map[string]interface{}) error {
+	e.driver.events = append(e.driver.events, "export")
+	return nil
+}
+
+func (e *eventsExporter) Flush() error {
+	e.driver.events = append(e.driver.events, "flush")
+	return e.flushErr
+}
+
+func (e *eventsExporter) Close() error { return nil }
+
+func TestStart_CommitAfterExport(t *testing.T) {
+	r, informers := setupSimpleReader()
+	r.config.IPFields = map[string]string{"SrcAddr": "Src"}
+	informers.MockNoMatch("10.0.0.1")
+	driver := &committingDriver{}
+	r.format = driver
+
+	r.Start(context.TODO(), &eventsExporter{driver: driver})
+
+	assert.Equal(t, []string{"next", "export", "flush", "commit"}, driver.events)
+}
+
+// idleDriver returns a record, then reports that the input is idle, and then returns another
+// record before the end of the input
+type idleDriver struct {
+	*committingDriver
+	calls int
+}
+
+func (d *idleDriver) Next() (map[string]interface{}, error) {
+	d.calls++
+	switch d.calls {
+	case 1, 3:
+		d.events = append(d.events, "next")
+		return map[string]interface{}{"SrcAddr": "10.0.0.1"}, nil
+	case 2:
+		return nil, format.ErrIdle
+	}
+	return nil, io.EOF
+}
+
+func (d *idleDriver) CommitInterval() time.Duration {
+	return time.Hour
+}
+
+func TestStart_CommitWhenIdle(t *testing.T) {
+	// GIVEN an input that becomes idle after a record, before the commit interval has elapsed
+	r, informers := setupSimpleReader()
+	r.config.IPFields = map[string]string{"SrcAddr": "Src"}
+	informers.MockNoMatch("10.0.0.1")
+	driver := &idleDriver{committingDriver: &committingDriver{}}
+	r.format = driver
+
+	// WHEN the records are processed
+	r.Start(context.TODO(), &eventsExporter{driver: driver.committingDriver})
+
+	// THEN the first record is delivered and committed while the input is idle
+	// AND the reader keeps reading afterwards
+	assert.Equal(t, []string{"next", "export", "flush", "commit", "next", "export", "flush", "commit"},
+		driver.events)
+}
+
+func TestStart_NoCommitIfDeliveryFails(t *testing.T) {
+	// GIVEN an exporter that can't deliver the buffered records
+	r, informers := setupSimpleReader()
+	r.config.IPFields = map[string]string{"SrcAddr": "Src"}
+	informers.MockNoMatch("10.0.0.1")
+	driver := &committingDriver{}
+	r.format = driver
+
+	// WHEN a record is processed
+	r.Start(context.TODO(), &eventsExporter{driver: driver, flushErr: errors.New("loki is unavailable")})
+
+	// THEN the record is not committed, and the reader stops so it is read again after a restart
+	assert.Equal(t, []string{"next", "export", "flush"}, driver.events)
+	assert.Equal(t, health.Error, r.health.Status)
+}
+
+// sliceDriver returns the given records and then the end of the input
+type sliceDriver struct {
+	records []map[string]interface{}
+}
+
+func (d *sliceDriver) Next() (map[string]interface{}, error) {
+	if len(d.records) == 0 {
+		return nil, io.EOF
+	}
+	record := d.records[0]
+	d.records = d.records[1:]
+	return record, nil
+}
+
+func (d *sliceDriver) Shutdown() {}
+
+// failingExporter rejects the records from a given source address
+type failingExporter struct {
+	srcAddr string
+}
+
+func (e *failingExporter) ProcessRecord(record map[string]interface{}) error {
+	if record["SrcAddr"] == e.srcAddr {
+		return errors.New("rejected")
+	}
+	return nil
+}
+
+func (e *failingExporter) Close() error { return nil }
+
+func TestStart_DeadLetter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dead-letter")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	// GIVEN a reader with a dead-letter file
+	r, informers := setupSimpleReader()
+	r.config.IPFields = map[string]string{"SrcAddr": "Src"}
+	r.config.DeadLetter = &config.ExporterConfig{
+		Type: config.FileExporter,
+		File: config.FileConfig{Path: path.Join(dir, "dlq.log"), Format: config.JSONFlagName, MaxSize: 1024 * 1024},
+	}
+	dl, err := export.NewDeadLetter(r.config, r.health)
+	require.NoError(t, err)
+	r.SetDeadLetter(dl)
+	informers.MockPod("test-pod1", "test-namespace", "10.0.0.1", "10.0.0.100")
+	informers.MockNoMatch("10.0.0.2")
+	informers.MockNoMatch("10.0.0.3")
+	// AND a record whose enrichment fails, because of an unexpected lookup
+	r.format = &sliceDriver{records: []map[string]interface{}{
+		{"SrcAddr": "10.0.0.1"}, {"SrcAddr": "10.0.0.2"}, {"SrcAddr": "10.0.0.3"}, {"SrcAddr": "10.0.0.4"},
+	}}
+
+	// WHEN the records are processed by an exporter that rejects some of them
+	r.Start(context.TODO(), &failingExporter{srcAddr: "10.0.0.1"})
+	require.NoError(t, dl.Close())
+
+	// THEN the failed records are sent to the dead-letter file, with the stage where they failed
+	content, err := ioutil.ReadFile(path.Join(dir, "dlq.log"))
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	require.Len(t, lines, 2)
+	assert.Contains(t, lines[0], `"Stage":"export"`)
+	assert.Contains(t, lines[0], `"Error":"rejected"`)
+	assert.Contains(t, lines[1], `"Stage":"enrich"`)
+
+	// AND the original records, before being enriched, can be replayed
+	file, err := os.Open(path.Join(dir, "dlq.log"))
+	require.NoError(t, err)
+	defer file.Close()
+	in := deadletter.NewScanner(file)
+	record, err := in.Next()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"SrcAddr": "10.0.0.1"}, record)
+	record, err = in.Next()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"SrcAddr": "10.0.0.4"}, record)
+}
+
+func TestStart_DeadLetterAsyncExport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dead-letter")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	// GIVEN a Loki instance that rejects the pushed records
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/ready" {
+			rw.WriteHeader(http.StatusOK)
+			return
+		}
+		rw.WriteHeader(http.StatusBadRequest)
+		_, _ = rw.Write([]byte("entry out of order"))
+	}))
+	defer server.Close()
+	// AND a reader with a dead-letter file
+	r, informers := setupSimpleReader()
+	r.config.IPFields = map[string]string{"SrcAddr": "Src"}
+	r.config.DeadLetter = &config.ExporterConfig{
+		Type: config.FileExporter,
+		File: config.FileConfig{Path: path.Join(dir, "dlq.log"), Format: config.JSONFlagName, MaxSize: 1024 * 1024},
+	}
+	dl, err := export.NewDeadLetter(r.config, r.health)
+	require.NoError(t, err)
+	r.SetDeadLetter(dl)
+	informers.MockNoMatch("10.0.0.1")
+	driver := &committingDriver{}
+	r.format = driver
+	lokiExporter, err := export.NewLoki(&config.LokiConfig{
+		URL:            server.URL,
+		BatchWait:      time.Second,
+		BatchSize:      100 * 1024,
+		Timeout:        time.Second,
+		MinBackoff:     10 * time.Millisecond,
+		MaxBackoff:     50 * time.Millisecond,
+		MaxRetries:     1,
+		TimestampLabel: "TimeReceived",
+		TimestampScale: time.Second,
+	}, "loki", r.health)
+	require.NoError(t, err)
+
+	// WHEN the record is exported asynchronously to Loki
+	r.Start(context.TODO(), &lokiExporter)
+	require.NoError(t, lokiExporter.Close())
+	require.NoError(t, dl.Close())
+
+	// THEN the rejected record is sent to the dead-letter file at the export stage
+	content, err := ioutil.ReadFile(path.Join(dir, "dlq.log"))
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	require.Len(t, lines, 1)
+	assert.Contains(t, lines[0], `"Stage":"export"`)
+	assert.Contains(t, lines[0], `server returned HTTP status 400: entry out of order`)
+	// AND the record is committed, since it is kept by the dead-letter sink
+	assert.Equal(t, []string{"next", "commit"}, driver.events)
+
+	// AND the record can be replayed
+	file, err := os.Open(path.Join(dir, "dlq.log"))
+	require.NoError(t, err)
+	defer file.Close()
+	record, err := deadletter.NewScanner(file).Next()
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.1", record["SrcAddr"])
+}
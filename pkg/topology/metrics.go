@@ -0,0 +1,118 @@
+// Package topology aggregates the traffic between topology zones and workloads into
+// Prometheus metrics, e.g. to track the cost of inter-zone traffic
+package topology
+
+import (
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/netobserv/goflow2-kube-enricher/pkg/cardinality"
+	"github.com/netobserv/goflow2-kube-enricher/pkg/config"
+	"github.com/netobserv/goflow2-kube-enricher/pkg/fields"
+)
+
+const (
+	// FieldZone is the suffix of the record field containing the topology zone of an endpoint
+	FieldZone = "Zone"
+	// FieldRegion is the suffix of the record field containing the topology region of an endpoint
+	FieldRegion = "Region"
+
+	fieldNamespace = "Namespace"
+	fieldWorkload  = "Workload"
+	fieldHostIP    = "HostIP"
+	fieldBytes     = "Bytes"
+	fieldPackets   = "Packets"
+	unknown        = "unknown"
+)
+
+var labelNames = []string{
+	"src_region", "src_zone", "src_namespace", "src_workload",
+	"dst_region", "dst_zone", "dst_namespace", "dst_workload",
+	"cross_node",
+}
+
+// Metrics aggregates bytes and packets per source and destination zones and workloads
+type Metrics struct {
+	srcPrefix string
+	dstPrefix string
+	regions   *cardinality.Limiter
+	zones     *cardinality.Limiter
+	workloads *cardinality.Limiter
+	bytes     *prometheus.CounterVec
+	packets   *prometheus.CounterVec
+}
+
+func NewMetrics(cfg *config.ZoneMetricsConfig) *Metrics {
+	return &Metrics{
+		srcPrefix: cfg.SrcPrefix,
+		dstPrefix: cfg.DstPrefix,
+		regions:   cardinality.NewLimiter(cfg.MaxZones),
+		zones:     cardinality.NewLimiter(cfg.MaxZones),
+		workloads: cardinality.NewLimiter(cfg.MaxWorkloads),
+		bytes: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "zone_traffic_bytes",
+				Help: "Number of bytes sent between zones, per source and destination workloads.",
+			},
+			labelNames,
+		),
+		packets: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "zone_traffic_packets",
+				Help: "Number of packets sent between zones, per source and destination workloads.",
+			},
+			labelNames,
+		),
+	}
+}
+
+// Collectors returns the Prometheus collectors that need to be registered to expose the metrics
+func (m *Metrics) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{m.bytes, m.packets}
+}
+
+// Observe accounts the bytes and packets of an enriched record
+func (m *Metrics) Observe(record map[string]interface{}) {
+	labels := make([]string, 0, len(labelNames))
+	labels = m.appendEndpoint(labels, record, m.srcPrefix)
+	labels = m.appendEndpoint(labels, record, m.dstPrefix)
+	labels = append(labels, crossNode(record, m.srcPrefix, m.dstPrefix))
+	if bytes, ok := fields.Float64(record[fieldBytes]); ok {
+		m.bytes.WithLabelValues(labels...).Add(bytes)
+	}
+	if packets, ok := fields.Float64(record[fieldPackets]); ok {
+		m.packets.WithLabelValues(labels...).Add(packets)
+	}
+}
+
+func (m *Metrics) appendEndpoint(labels []string, record map[string]interface{}, prefix string) []string {
+	ns := stringField(record, prefix+fieldNamespace)
+	workload := stringField(record, prefix+fieldWorkload)
+	// the endpoints without namespace nor workload don't take any slot of the workloads limiter
+	if (ns != "" || workload != "") && m.workloads.Value(ns+"/"+workload) == cardinality.Overflow {
+		ns, workload = cardinality.Overflow, cardinality.Overflow
+	}
+	return append(labels,
+		m.regions.Value(stringField(record, prefix+FieldRegion)),
+		m.zones.Value(stringField(record, prefix+FieldZone)),
+		ns, workload)
+}
+
+// crossNode returns whether both endpoints are placed in different nodes, or "unknown" if
+// the node of any endpoint can't be determined
+func crossNode(record map[string]interface{}, srcPrefix, dstPrefix string) string {
+	srcHost := stringField(record, srcPrefix+fieldHostIP)
+	dstHost := stringField(record, dstPrefix+fieldHostIP)
+	if srcHost == "" || dstHost == "" {
+		return unknown
+	}
+	return strconv.FormatBool(srcHost != dstHost)
+}
+
+func stringField(record map[string]interface{}, key string) string {
+	if s, ok := record[key].(string); ok {
+		return s
+	}
+	return ""
+}
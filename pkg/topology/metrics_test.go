@@ -0,0 +1,53 @@
+package topology
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/netobserv/goflow2-kube-enricher/pkg/config"
+)
+
+func TestMetrics_Observe(t *testing.T) {
+	m := NewMetrics(&config.Default().ZoneMetrics)
+	flow := map[string]interface{}{
+		"Bytes": uint64(100), "Packets": uint64(2),
+		"SrcNamespace": "ns1", "SrcWorkload": "front", "SrcZone": "eu-1a", "SrcRegion": "eu-1", "SrcHostIP": "10.0.0.1",
+		"DstNamespace": "ns2", "DstWorkload": "back", "DstZone": "eu-1b", "DstRegion": "eu-1", "DstHostIP": "10.0.0.2",
+	}
+	m.Observe(flow)
+	m.Observe(flow)
+	// flows from JSON input are decoded as float64
+	m.Observe(map[string]interface{}{
+		"Bytes": float64(50), "Packets": float64(1),
+		"SrcNamespace": "ns1", "SrcWorkload": "front", "SrcZone": "eu-1a", "SrcRegion": "eu-1", "SrcHostIP": "10.0.0.1",
+		"DstNamespace": "ns1", "DstWorkload": "svc", "DstAddr": "10.96.0.1",
+	})
+
+	assert.EqualValues(t, 200, testutil.ToFloat64(m.bytes.WithLabelValues(
+		"eu-1", "eu-1a", "ns1", "front", "eu-1", "eu-1b", "ns2", "back", "true")))
+	assert.EqualValues(t, 4, testutil.ToFloat64(m.packets.WithLabelValues(
+		"eu-1", "eu-1a", "ns1", "front", "eu-1", "eu-1b", "ns2", "back", "true")))
+	assert.EqualValues(t, 50, testutil.ToFloat64(m.bytes.WithLabelValues(
+		"eu-1", "eu-1a", "ns1", "front", "", "", "ns1", "svc", "unknown")))
+}
+
+func TestMetrics_CardinalityLimits(t *testing.T) {
+	m := NewMetrics(&config.ZoneMetricsConfig{
+		SrcPrefix: "Src", DstPrefix: "Dst", MaxZones: 1, MaxWorkloads: 1,
+	})
+	m.Observe(map[string]interface{}{
+		"Bytes": 10, "SrcNamespace": "ns", "SrcWorkload": "a", "SrcZone": "z1",
+		"SrcHostIP": "10.0.0.1", "DstHostIP": "10.0.0.1",
+	})
+	m.Observe(map[string]interface{}{
+		"Bytes": 20, "SrcNamespace": "ns", "SrcWorkload": "b", "SrcZone": "z2",
+		"SrcHostIP": "10.0.0.1", "DstHostIP": "10.0.0.1",
+	})
+
+	assert.EqualValues(t, 10, testutil.ToFloat64(m.bytes.WithLabelValues(
+		"", "z1", "ns", "a", "", "", "", "", "false")))
+	assert.EqualValues(t, 20, testutil.ToFloat64(m.bytes.WithLabelValues(
+		"", "other", "other", "other", "", "", "", "", "false")))
+}
@@ -72,7 +72,7 @@ func StartKubeEnricher(clientset kubernetes.Interface, clock func() time.Time) (
 		BatchWait:      time.Second,
 		Timeout:        time.Second,
 		TimestampScale: time.Second,
-	})
+	}, "loki", health.NewReporter(health.Starting))
 	if err != nil {
 		fakeLoki.Close()
 		return TestKubeEnricher{}, errors.Wrap(err, "creating loki exporter")
@@ -87,14 +87,13 @@ func StartKubeEnricher(clientset kubernetes.Interface, clock func() time.Time) (
 	in := nfFormat.StartDriver(ctx, hostname, listenPort, false)
 	r := reader.NewReader(in, log.WithFields(nil),
 		&config.Config{
-			PrintInput:  true,
-			PrintOutput: true,
+			PrintInput: true,
 			IPFields: map[string]string{
 				"SrcAddr": "",
 			},
 		},
 		health.NewReporter(health.Starting),
-		clientset)
+		clientset, nil)
 	go r.Start(ctx, &loki)
 
 	conn, err := net.Dial("udp", fmt.Sprintf(":%d", listenPort))